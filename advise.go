@@ -0,0 +1,80 @@
+package boltdb
+
+import (
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// Advice is a hint to the OS about how the data file is accessed, see
+// Options.Madvise and Options.Fadvise.
+type Advice int
+
+const (
+	// AdviceDefault keeps the default behavior: the memory map is advised
+	// to be accessed randomly, and no advice is given for written pages.
+	AdviceDefault Advice = iota
+
+	// AdviceNormal gives no special treatment (MADV_NORMAL, POSIX_FADV_NORMAL).
+	AdviceNormal
+
+	// AdviceRandom expects random access, which disables read-ahead
+	// (MADV_RANDOM, POSIX_FADV_RANDOM).
+	AdviceRandom
+
+	// AdviceSequential expects sequential access, which makes read-ahead
+	// more aggressive, e.g. for scans (MADV_SEQUENTIAL, POSIX_FADV_SEQUENTIAL).
+	AdviceSequential
+
+	// AdviceWillNeed reads the pages ahead of time
+	// (MADV_WILLNEED, POSIX_FADV_WILLNEED).
+	AdviceWillNeed
+
+	// AdviceDontNeed drops the pages from memory until they're accessed
+	// again, e.g. in memory-constrained containers
+	// (MADV_DONTNEED, POSIX_FADV_DONTNEED).
+	AdviceDontNeed
+)
+
+// fadviseWritten gives the fadvise advice for the pages written by a commit.
+func (db *DB) fadviseWritten(pages common.Pages) error {
+	if db.fadvise == AdviceDefault || len(pages) == 0 {
+		return nil
+	}
+	runs := make([]pageRun, 0, len(pages))
+	for _, p := range pages {
+		runs = append(runs, pageRun{id: p.Id(), n: int(p.Overflow()) + 1})
+	}
+	return db.fadviseRuns(runs, db.fadvise)
+}
+
+// pageRun is a run of pages of the data file, see fadviseRuns.
+type pageRun struct {
+	id common.Pgid
+	n  int
+}
+
+// fadviseRuns gives advice a for runs of pages, merging adjacent runs into a
+// single range.
+func (db *DB) fadviseRuns(runs []pageRun, a Advice) error {
+	if db.inMemory || len(runs) == 0 {
+		return nil
+	}
+	slot := int64(db.pageSize)
+	if db.cipher != nil {
+		slot = int64(db.encryptedPageSize())
+	}
+
+	start, end := int64(-1), int64(-1)
+	for _, r := range runs {
+		off := int64(r.id) * slot
+		if off != end {
+			if start >= 0 {
+				if err := fadvise(db, start, end-start, a); err != nil {
+					return err
+				}
+			}
+			start = off
+		}
+		end = off + int64(r.n)*slot
+	}
+	return fadvise(db, start, end-start, a)
+}
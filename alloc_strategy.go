@@ -0,0 +1,107 @@
+package boltdb
+
+import (
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// AllocStrategy selects the span of free pages which an allocation reuses,
+// see Options.AllocStrategy. The strategies trade the time spent looking for
+// a span against the fragmentation they leave, see Stats.Freelist.
+type AllocStrategy int
+
+const (
+	// AllocDefault reuses a span of exactly the allocated size if there is
+	// one, or else any of the smallest larger spans, which are found by
+	// size class so that single pages don't split the spans which overflow
+	// pages need. It's the fastest strategy.
+	AllocDefault AllocStrategy = iota
+
+	// AllocFirstFit reuses the large enough span with the lowest address,
+	// which keeps the data at the start of the file so that it can be
+	// shrunk, see DB.Shrink.
+	AllocFirstFit
+
+	// AllocBestFit reuses the smallest large enough span, with the lowest
+	// address among spans of the same size, which keeps the large spans for
+	// large allocations.
+	AllocBestFit
+
+	// AllocNextFit reuses the first large enough span after the previous
+	// allocation, wrapping around at the end of the file, which spreads the
+	// allocations over the free spans.
+	AllocNextFit
+)
+
+// setAllocStrategy sets the allocate function of the freelist to s.
+func (f *freelist) setAllocStrategy(s AllocStrategy) {
+	switch s {
+	case AllocFirstFit:
+		f.allocate = f.firstFitAllocate
+	case AllocBestFit:
+		f.allocate = f.bestFitAllocate
+	case AllocNextFit:
+		f.allocate = f.nextFitAllocate
+	default:
+		f.allocate = f.hashmapAllocate
+	}
+}
+
+// firstFitAllocate allocates n pages from the large enough span with the
+// lowest address.
+func (f *freelist) firstFitAllocate(txid common.Txid, n int) common.Pgid {
+	if n == 0 {
+		return 0
+	}
+	start, _, _ := f.spanTree.firstFit(0, uint64(n))
+	return f.allocateSpan(txid, start, n)
+}
+
+// bestFitAllocate allocates n pages from the smallest large enough span.
+func (f *freelist) bestFitAllocate(txid common.Txid, n int) common.Pgid {
+	if n == 0 {
+		return 0
+	}
+	var start common.Pgid
+	for pid := range f.freemaps[f.smallestFit(uint64(n))] {
+		if start == 0 || pid < start {
+			start = pid
+		}
+	}
+	return f.allocateSpan(txid, start, n)
+}
+
+// nextFitAllocate allocates n pages from the first large enough span after
+// the previous allocation, or else from the lowest one.
+func (f *freelist) nextFitAllocate(txid common.Txid, n int) common.Pgid {
+	if n == 0 {
+		return 0
+	}
+	next, _, ok := f.spanTree.firstFit(f.nextFit, uint64(n))
+	if !ok {
+		next, _, _ = f.spanTree.firstFit(0, uint64(n))
+	}
+	if id := f.allocateSpan(txid, next, n); id != 0 {
+		f.nextFit = id + common.Pgid(n)
+		return id
+	}
+	return 0
+}
+
+// allocateSpan allocates the first n pages of the free span at start, or
+// returns 0 if start is 0.
+func (f *freelist) allocateSpan(txid common.Txid, start common.Pgid, n int) common.Pgid {
+	if start == 0 {
+		return 0
+	}
+	size, _ := f.spanTree.get(start)
+	f.delSpan(start, size)
+	if remain := size - uint64(n); remain > 0 {
+		f.addSpan(start+common.Pgid(n), remain)
+	}
+	f.allocs[start] = txid
+	f.touch(start, n)
+	for i := common.Pgid(0); i < common.Pgid(n); i++ {
+		delete(f.cache, start+i)
+	}
+	return start
+}
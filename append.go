@@ -0,0 +1,97 @@
+package boltdb
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// Append appends suffix to the value for a key in the bucket, or puts suffix
+// if the key doesn't exist, without the caller having to Get, copy and Put
+// the whole value. The TTL of a value put with PutWithTTL is kept.
+//
+// A value streamed with PutReader isn't read: suffix is written to new
+// chunks, and only the last chunk of the value is rewritten with it if the
+// value ends with a chunk which isn't full, so that the cost of appending
+// doesn't depend on the size of the value. Other values are stored in their
+// leaf, which is rewritten whole anyway. suffix is copied.
+// Returns an error if the key is a nested bucket, or like Put.
+func (b *Bucket) Append(key, suffix []byte) (err error) {
+	defer b.tx.catch(&err)
+	if b.tx.db == nil {
+		return errors.ErrTxClosed
+	} else if !b.Writable() {
+		return errors.ErrTxNotWritable
+	} else if len(key) == 0 {
+		return errors.ErrKeyRequired
+	} else if len(key) > MaxKeySize {
+		return errors.ErrKeyTooLarge
+	}
+
+	v, flags, ok := b.getRaw(key)
+	switch {
+	case !ok:
+		return b.Put(key, cloneBytes(suffix))
+	case (flags & common.BucketLeafFlag) != 0:
+		return errors.ErrIncompatibleValue
+	case (flags & common.BlobLeafFlag) != 0:
+		return b.appendBlob(key, v, suffix)
+	case (flags & common.ExpiringLeafFlag) == 0:
+		return b.Put(key, concatBytes(b.value(v, flags), suffix))
+	case expired(v, time.Now().UnixNano()):
+		return b.Put(key, cloneBytes(suffix))
+	default:
+		return b.putExpiring(key, concatBytes(v[expiryLen:], suffix), expiry(v))
+	}
+}
+
+// appendBlob appends suffix to a streamed value as new chunks. If the last
+// extent of the value fits in a single chunk, it's rewritten with suffix
+// instead of adding an extent, so that all the extents but the last one hold
+// at least a full chunk, however small the appends are.
+func (b *Bucket) appendBlob(key, ref, suffix []byte) error {
+	if len(suffix) == 0 {
+		return nil
+	}
+	tx := b.tx
+
+	// Allocating the chunks may remap the data file, so the key and the
+	// reference mustn't be in it.
+	key, ref = cloneBytes(key), cloneBytes(ref)
+	var r io.Reader = bytes.NewReader(suffix)
+	size := int64(len(suffix))
+	var old []byte
+	if _, n := blobRef(ref[len(ref)-blobRefSize:]); n <= tx.blobChunkCap() {
+		// The first new chunk holds the whole extent, which is read before
+		// the next chunk is allocated.
+		old = cloneBytes(ref[len(ref)-blobRefSize:])
+		ref = ref[:len(ref)-blobRefSize]
+		r = io.MultiReader(tx.blobReader(old), r)
+		size += n
+	}
+	eref, err := tx.writeBlob(r, size)
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		tx.freeBlob(old)
+	}
+	ref = append(ref, eref...)
+
+	// Unlike put, replace the reference without freeing the chunks it
+	// shares with the old one. The indexes don't see streamed values.
+	c := b.Cursor()
+	c.seek(key)
+	c.node().put(key, key, ref, 0, common.BlobLeafFlag)
+	b.recordChange(key, OpPut)
+	return tx.dirty(int(common.LeafPageElementSize) + len(key) + len(ref))
+}
+
+// concatBytes returns a new slice holding a followed by b.
+func concatBytes(a, b []byte) []byte {
+	v := make([]byte, 0, len(a)+len(b))
+	return append(append(v, a...), b...)
+}
@@ -0,0 +1,143 @@
+package boltdb_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestBucket_Append(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		_, err = b.CreateBucket([]byte("nested"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("plain"), []byte("foo")))
+		require.NoError(t, b.PutWithTTL([]byte("ttl"), []byte("foo"), time.Hour))
+
+		suffix := []byte("bar")
+		for _, key := range []string{"plain", "ttl", "missing"} {
+			require.NoError(t, b.Append([]byte(key), suffix))
+		}
+		suffix[0] = 'x'
+		require.Equal(t, []byte("foobar"), b.Get([]byte("plain")))
+		require.Equal(t, []byte("foobar"), b.Get([]byte("ttl")))
+		require.False(t, b.ExpiresAt([]byte("ttl")).IsZero())
+		require.Equal(t, []byte("bar"), b.Get([]byte("missing")))
+
+		require.ErrorIs(t, b.Append([]byte("nested"), suffix), berrors.ErrIncompatibleValue)
+		require.ErrorIs(t, b.Append(nil, suffix), berrors.ErrKeyRequired)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Compressed values are appended to uncompressed.
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("compressed"))
+		if err != nil {
+			return err
+		}
+		value := bytes.Repeat([]byte("compressible "), 100)
+		require.NoError(t, b.SetCompressionDict(value[:200]))
+		require.NoError(t, b.Put([]byte("key"), value))
+		require.NoError(t, b.Append([]byte("key"), value))
+		require.Equal(t, bytes.Repeat([]byte("compressible "), 200), b.Get([]byte("key")))
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		require.ErrorIs(t, tx.Bucket([]byte("widgets")).Append([]byte("plain"), []byte("bar")), berrors.ErrTxNotWritable)
+		return nil
+	})
+	require.NoError(t, err)
+	db.MustCheck()
+}
+
+func TestBucket_Append_Stream(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	want := largeValue(3<<20+100, 5)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("blobs"))
+		if err != nil {
+			return err
+		}
+		mustPutReader(t, b, "big", want)
+		mustPutReader(t, b, "empty", nil)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Appending to a large value only writes the new chunks.
+	var empty []byte
+	for i := 0; i < 20; i++ {
+		suffix := largeValue(1000+i*100000, byte(i))
+		err = db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("blobs"))
+			require.NoError(t, b.Append([]byte("big"), suffix))
+			require.NoError(t, b.Append([]byte("empty"), suffix[:10]))
+			if i == 0 {
+				stats := tx.Stats()
+				require.Less(t, stats.GetPageCount(), int64(10))
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		want = append(want, suffix...)
+		empty = append(empty, suffix[:10]...)
+		db.MustCheck()
+	}
+
+	check := func(t *testing.T, b *bolt.Bucket) {
+		r := b.GetReader([]byte("big"))
+		require.Equal(t, int64(len(want)), r.Size())
+		var buf bytes.Buffer
+		_, err := r.WriteTo(&buf)
+		require.NoError(t, err)
+		require.Equal(t, want, buf.Bytes())
+		require.Equal(t, empty, b.Get([]byte("empty")))
+	}
+	db.MustClose()
+	db.MustReopen()
+	err = db.Update(func(tx *bolt.Tx) error {
+		check(t, tx.Bucket([]byte("blobs")))
+
+		// Clones copy the value extent by extent.
+		require.NoError(t, tx.CloneBucket([]byte("blobs"), []byte("pages")))
+		require.NoError(t, tx.Bucket([]byte("blobs")).Put([]byte("changed"), []byte("value")))
+		require.NoError(t, tx.CloneBucket([]byte("blobs"), []byte("keys")))
+		check(t, tx.Bucket([]byte("pages")))
+		check(t, tx.Bucket([]byte("keys")))
+		return nil
+	})
+	require.NoError(t, err)
+	db.MustCheck()
+
+	compacted := btesting.MustCreateDB(t)
+	require.NoError(t, bolt.Compact(compacted.DB, db.DB, 0))
+	compacted.MustCheck()
+	err = compacted.View(func(tx *bolt.Tx) error {
+		check(t, tx.Bucket([]byte("pages")))
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The chunks of all the extents are freed.
+	for _, name := range []string{"blobs", "pages", "keys"} {
+		err = db.Update(func(tx *bolt.Tx) error {
+			return tx.DeleteBucket([]byte(name))
+		})
+		require.NoError(t, err, fmt.Sprintf("bucket %s", name))
+		db.MustCheck()
+	}
+}
@@ -0,0 +1,345 @@
+package boltdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// compactTxMaxSize limits the size of each transaction used to copy data into
+// the compacted file, so that compacting a large database doesn't hold the
+// whole dataset in memory at once.
+const compactTxMaxSize = 64 * 1024 * 1024
+
+// autoCompactor periodically checks the free page ratio of a database and
+// compacts it once the ratio goes above the configured threshold.
+type autoCompactor struct {
+	db        *DB
+	interval  time.Duration
+	freeRatio float64
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (db *DB) startAutoCompact(interval time.Duration, freeRatio float64) {
+	if interval <= 0 {
+		interval = common.DefaultAutoCompactInterval
+	}
+	if freeRatio <= 0 {
+		freeRatio = common.DefaultAutoCompactFreeRatio
+	}
+	ac := &autoCompactor{
+		db:        db,
+		interval:  interval,
+		freeRatio: freeRatio,
+		stop:      make(chan struct{}),
+	}
+	db.autoCompactor = ac
+
+	ac.wg.Add(1)
+	go ac.run()
+}
+
+// stopAutoCompact stops the background compactor and waits for any compaction
+// in progress to finish.
+func (db *DB) stopAutoCompact() {
+	if db.autoCompactor == nil {
+		return
+	}
+	close(db.autoCompactor.stop)
+	db.autoCompactor.wg.Wait()
+	db.autoCompactor = nil
+}
+
+func (ac *autoCompactor) run() {
+	defer ac.wg.Done()
+
+	ticker := time.NewTicker(ac.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ac.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if ac.db.freePageRatio() < ac.freeRatio {
+			continue
+		}
+		if err := ac.db.Compact(); err != nil {
+			ac.db.statlock.Lock()
+			ac.db.stats.CompactErrN++
+			ac.db.statlock.Unlock()
+		}
+	}
+}
+
+// freePageRatio returns the ratio of free and pending pages to the total
+// number of pages in the database, as of the last write transaction.
+func (db *DB) freePageRatio() float64 {
+	db.metalock.Lock()
+	db.mmaplock.RLock()
+	var total common.Pgid
+	if db.opened && db.mapped() {
+		total = db.meta().Pgid()
+	}
+	db.mmaplock.RUnlock()
+	db.metalock.Unlock()
+
+	db.statlock.RLock()
+	free := db.stats.FreePageN + db.stats.PendingPageN
+	db.statlock.RUnlock()
+
+	if total == 0 {
+		return 0
+	}
+	return float64(free) / float64(total)
+}
+
+// Compact rewrites the database into a compacted copy and transparently
+// replaces the data file with it, releasing the space held by free pages.
+//
+// Read transactions can continue while the compacted copy is being built,
+// write transactions block until the compaction finishes. The file is swapped
+// once all read transactions which were open before the swap have finished.
+func (db *DB) Compact() error {
+	if err := db.rewrite(false, nil, 0); err != nil {
+		return err
+	}
+
+	db.statlock.Lock()
+	db.stats.CompactN++
+	db.statlock.Unlock()
+	return nil
+}
+
+// Rekey re-encrypts the database with the given cipher, in the same way as
+// Compact rewrites it. Passing a nil cipher stores the database unencrypted.
+// Once Rekey returns, the database must be opened with the new cipher.
+func (db *DB) Rekey(c Cipher) error {
+	return db.rewrite(true, c, 0)
+}
+
+// Migrate rewrites the database with the given page size, in the same way as
+// Compact rewrites it, e.g. to convert a database created on a machine with a
+// different OS page size. All buckets, keys and sequences are preserved. The
+// page size must be a power of two between 1KB and 64KB.
+func (db *DB) Migrate(pageSize int) error {
+	if pageSize < minPageSize || pageSize > maxPageSize || pageSize&(pageSize-1) != 0 {
+		return berrors.ErrInvalidPageSize
+	}
+	return db.rewrite(false, nil, pageSize)
+}
+
+// rewrite copies the database into a new file and swaps it in place of the
+// data file. The new file is encrypted with c if rekey is set, or with the
+// current cipher otherwise, and uses pageSize if it's not 0, or the current
+// page size otherwise.
+func (db *DB) rewrite(rekey bool, c Cipher, pageSize int) error {
+	if db.readOnly {
+		return berrors.ErrDatabaseReadOnly
+	}
+	if db.inMemory {
+		return berrors.ErrInMemory
+	}
+
+	// Block writers for the duration of the compaction so that the copy
+	// doesn't miss any updates.
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	if !db.opened {
+		return berrors.ErrDatabaseNotOpen
+	}
+	if !rekey {
+		c = db.cipher
+	}
+	if pageSize == 0 {
+		pageSize = db.pageSize
+	}
+
+	// The log refers to pages of the current file, so it must be empty
+	// before the file is replaced.
+	if err := db.checkpoint(); err != nil {
+		return err
+	}
+	if db.groupCommit != nil {
+		if err := db.groupCommit.sync(db); err != nil {
+			return err
+		}
+	}
+
+	info, err := db.file.Stat()
+	if err != nil {
+		return fmt.Errorf("compact: file stat error: %w", err)
+	}
+
+	tmpPath := db.path + ".compact"
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("compact: remove stale file: %w", err)
+	}
+
+	dst, err := Open(tmpPath, info.Mode().Perm(), &Options{
+		PageSize:           pageSize,
+		NoSync:             true,
+		NoFreelistSync:     db.NoFreelistSync,
+		OpenFile:           db.openFile,
+		Encryption:         c,
+		PageChecksums:      db.pageChecksums,
+		PageTxids:          db.pageTxids,
+		FreelistSpans:      db.freelistSpans,
+		FreelistSegments:   db.freelistSegments,
+		AllocStrategy:      db.allocStrategy,
+		DeterministicAlloc: db.deterministicAlloc,
+	})
+	if err != nil {
+		return fmt.Errorf("compact: open destination: %w", err)
+	}
+	if err := db.compactInto(dst); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("compact: close destination: %w", err)
+	}
+
+	if err := db.swapFile(tmpPath, c, pageSize); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// compactInto copies all buckets into dst and carries the transaction id over,
+// so that transaction ids keep increasing across the compaction.
+func (db *DB) compactInto(dst *DB) error {
+	// Move the transaction id forward before copying anything, so that every
+	// page of dst is newer than the source for Tx.WriteDiffTo. The root is
+	// rewritten since it's otherwise kept from the initialization of dst.
+	tx, err := dst.Begin(true)
+	if err != nil {
+		return fmt.Errorf("compact: %w", err)
+	}
+	tx.meta.SetTxid(db.meta().Txid() + 1)
+	tx.root.node(tx.root.RootPage(), nil)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("compact: %w", err)
+	}
+
+	if err := Compact(dst, db, compactTxMaxSize); err != nil {
+		return fmt.Errorf("compact: %w", err)
+	}
+	if err := fdatasync(dst); err != nil {
+		return fmt.Errorf("compact: sync destination: %w", err)
+	}
+	return nil
+}
+
+// swapFile replaces the data file with the file at path, which is encrypted
+// with c and has pages of pageSize bytes, and remaps it. The caller must hold
+// the writer lock.
+func (db *DB) swapFile(path string, c Cipher, pageSize int) error {
+	// The free pages are read from the new file below.
+	db.stopFreelistRebuild()
+
+	// Prevent new read transactions and wait for existing ones to finish.
+	db.metalock.Lock()
+	db.mmaplock.Lock()
+	err := db.replaceFile(path, c)
+	if err == nil && pageSize != db.pageSize {
+		db.setPageSize(pageSize)
+	}
+	// The pages of earlier transactions are gone.
+	db.retained = nil
+	db.closeSnapshots()
+	db.statsCache.reset()
+	db.mmaplock.Unlock()
+	if err == nil {
+		// New read transactions are still blocked by the meta lock until
+		// the compacted file is mapped.
+		err = db.mmap(0)
+	}
+	db.metalock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	db.freelist = db.newFreelist()
+	if db.hasSyncedFreelist() {
+		db.freelist.read(db.page(db.meta().Freelist()))
+	} else {
+		db.freelist.readIDs(db.freepages())
+	}
+
+	db.statlock.Lock()
+	db.stats.FreePageN = db.freelist.free_count()
+	db.stats.PendingPageN = 0
+	db.stats.FreeAlloc = db.freelist.free_count() * db.pageSize
+	db.stats.FreelistInuse = db.freelist.size()
+	db.stats.Freelist = db.freelist.spanStats()
+	db.statlock.Unlock()
+	return nil
+}
+
+// replaceFile renames the file at path over the data file and switches the
+// file handle to it. The caller must hold the mmap lock.
+func (db *DB) replaceFile(path string, c Cipher) error {
+	f, err := db.openFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("compact: open compacted file: %w", err)
+	}
+
+	old := db.file
+	if runtime.GOOS == "windows" {
+		// Windows doesn't allow renaming over a file which is still
+		// mapped or open, so release the old file first.
+		if err := db.munmap(); err != nil {
+			_ = f.Close()
+			return err
+		}
+		_ = funlock(db)
+		_ = old.Close()
+		db.file = f
+		if err := os.Rename(path, db.path); err != nil {
+			return fmt.Errorf("compact: rename: %w", err)
+		}
+		if err := flock(context.Background(), db, true, 0); err != nil {
+			return err
+		}
+	} else {
+		// Lock the new file before it becomes visible at the database path.
+		db.file = f
+		if err := flock(context.Background(), db, true, 0); err != nil {
+			db.file = old
+			_ = f.Close()
+			return err
+		}
+		if err := os.Rename(path, db.path); err != nil {
+			_ = funlock(db)
+			db.file = old
+			_ = f.Close()
+			return fmt.Errorf("compact: rename: %w", err)
+		}
+		if err := db.munmap(); err != nil {
+			return err
+		}
+		// Closing the old descriptor also releases its lock.
+		_ = old.Close()
+	}
+	db.cipher = c
+	_ = db.closeDirect()
+	if err := db.openDirect(); err != nil {
+		return err
+	}
+	db.setWriteAt()
+	return nil
+}
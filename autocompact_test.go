@@ -0,0 +1,201 @@
+package boltdb_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+// fillAndDelete writes n keys with large values and deletes all but every
+// keep-th key, leaving most of the database free.
+func fillAndDelete(t *testing.T, db *btesting.DB, n, keep int) {
+	value := make([]byte, 1024)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%08d", i)), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for i := 0; i < n; i++ {
+			if i%keep == 0 {
+				continue
+			}
+			if err := b.Delete([]byte(fmt.Sprintf("%08d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestDB_Compact(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	fillAndDelete(t, db, 4000, 100)
+
+	var txidBefore int
+	err := db.View(func(tx *bolt.Tx) error {
+		txidBefore = tx.ID()
+		return nil
+	})
+	require.NoError(t, err)
+	sizeBefore := fileSize(db.Path())
+
+	// A read transaction opened before the compaction keeps working.
+	rtx, err := db.Begin(false)
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() {
+		done <- db.Compact()
+	}()
+	require.NotNil(t, rtx.Bucket([]byte("widgets")).Get([]byte("00000100")))
+	require.NoError(t, rtx.Rollback())
+	require.NoError(t, <-done)
+
+	require.Less(t, fileSize(db.Path()), sizeBefore)
+	require.Equal(t, 1, db.Stats().CompactN)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		require.Greater(t, tx.ID(), txidBefore)
+		b := tx.Bucket([]byte("widgets"))
+		require.NotNil(t, b)
+		require.Equal(t, 40, b.Stats().KeyN)
+		require.NotNil(t, b.Get([]byte("00000200")))
+		require.Nil(t, b.Get([]byte("00000201")))
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The database must stay writable and survive a reopen.
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar"))
+	})
+	require.NoError(t, err)
+	db.MustClose()
+	db.MustReopen()
+	err = db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, []byte("bar"), tx.Bucket([]byte("widgets")).Get([]byte("foo")))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestDB_Compact_ReadOnly(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	db.MustClose()
+	db.SetOptions(&bolt.Options{ReadOnly: true})
+	db.MustReopen()
+	require.ErrorIs(t, db.Compact(), bolt.ErrDatabaseReadOnly)
+}
+
+func TestDB_Migrate(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	putWidgets(t, db, 0, 1000)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("parent"))
+		if err != nil {
+			return err
+		}
+		if b, err = b.CreateBucket([]byte("child")); err != nil {
+			return err
+		}
+		return b.SetSequence(42)
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, db.Migrate(16384))
+	require.Equal(t, 16384, db.Info().PageSize)
+	requireWidgets(t, db.DB, 1000)
+	db.MustCheck()
+
+	// The database must stay writable and survive a reopen.
+	putWidgets(t, db, 1000, 1100)
+	db.MustClose()
+	db.MustReopen()
+	require.Equal(t, 16384, db.Info().PageSize)
+	requireWidgets(t, db.DB, 1100)
+	err = db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, uint64(42), tx.Bucket([]byte("parent")).Bucket([]byte("child")).Sequence())
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.ErrorIs(t, db.Migrate(3000), berrors.ErrInvalidPageSize)
+	require.ErrorIs(t, db.Migrate(512), berrors.ErrInvalidPageSize)
+}
+
+func TestDB_AutoCompact(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{
+		AutoCompact:          true,
+		AutoCompactInterval:  10 * time.Millisecond,
+		AutoCompactFreeRatio: 0.5,
+	})
+	fillAndDelete(t, db, 4000, 100)
+
+	require.Eventually(t, func() bool {
+		return db.Stats().CompactN > 0
+	}, 10*time.Second, 10*time.Millisecond)
+	require.Zero(t, db.Stats().CompactErrN)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, 40, tx.Bucket([]byte("widgets")).Stats().KeyN)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+// Ensure that OnFreePageThreshold is called after the commits which bring the
+// ratio of free pages above FreePageThreshold.
+func TestDB_FreePageThreshold(t *testing.T) {
+	var txids []int
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{
+		FreePageThreshold: 0.5,
+		OnFreePageThreshold: func(txid int, ratio float64) {
+			require.Greater(t, ratio, 0.5)
+			txids = append(txids, txid)
+		},
+	})
+	deleteBucket := func(name string) int {
+		var txid int
+		err := db.Update(func(tx *bolt.Tx) error {
+			txid = tx.ID()
+			return tx.DeleteBucket([]byte(name))
+		})
+		require.NoError(t, err)
+		return txid
+	}
+
+	putBigBucket(t, db, "a", 1000)
+	require.Empty(t, txids)
+	txid := deleteBucket("a")
+	require.Equal(t, []int{txid}, txids)
+
+	// It's not called again while the ratio stays above the threshold.
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("small"))
+		return err
+	})
+	require.NoError(t, err)
+	require.Len(t, txids, 1)
+
+	putBigBucket(t, db, "b", 1000)
+	require.Len(t, txids, 1)
+	txid2 := deleteBucket("b")
+	require.Equal(t, []int{txid, txid2}, txids)
+}
@@ -0,0 +1,196 @@
+package boltdb
+
+import (
+	"compress/flate"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	berrors "github.com/openkvlab/boltdb/errors"
+)
+
+// backupMagic marks the start of a compressed backup written by
+// WriteToCompressed.
+const backupMagic uint32 = 0xED0CBAC0
+
+const backupVersion uint32 = 1
+
+// backupHeaderSize is the size of the uncompressed header of a backup: magic
+// and version.
+const backupHeaderSize = 8
+
+// Codec compresses the backups written by Tx.WriteToCompressed.
+type Codec interface {
+	// NewWriter returns a writer compressing to w. Closing it flushes the
+	// compressed stream, but doesn't close w.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+
+	// NewReader returns a reader decompressing from r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// FlateCodec returns a Codec using the DEFLATE format at the given
+// compression level, see compress/flate.
+func FlateCodec(level int) Codec {
+	return flateCodec{level: level}
+}
+
+type flateCodec struct {
+	level int
+}
+
+func (c flateCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return flate.NewWriter(w, c.level)
+}
+
+func (c flateCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+// WriteToCompressed writes the entire database to w like WriteTo, compressed
+// with codec. Free pages aren't written at all. The backup can be restored
+// with RestoreCompressed, or opened directly with OpenCompressedBackup.
+// Pages of an encrypted database are written encrypted.
+//
+// It returns the number of compressed bytes written to w.
+func (tx *Tx) WriteToCompressed(w io.Writer, codec Codec) (n int64, err error) {
+	cw := &countingWriter{w: w}
+	var hdr [backupHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:], backupMagic)
+	binary.LittleEndian.PutUint32(hdr[4:], backupVersion)
+	if _, err := cw.Write(hdr[:]); err != nil {
+		return cw.n, err
+	}
+
+	zw, err := codec.NewWriter(cw)
+	if err != nil {
+		return cw.n, err
+	}
+	if _, err := tx.writeDiff(zw, 0, tx.changedPages); err != nil {
+		_ = zw.Close()
+		return cw.n, err
+	}
+	err = zw.Close()
+	return cw.n, err
+}
+
+// RestoreCompressed restores the database backed up by
+// Tx.WriteToCompressed from r to a new data file at path, with the given
+// mode. Free pages are left zeroed.
+func RestoreCompressed(path string, r io.Reader, codec Codec, mode os.FileMode) (err error) {
+	dr, zr, hdr, err := openBackup(r, codec)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(path)
+		}
+	}()
+
+	meta, err := dr.readBody(f, hdr)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(meta, 0); err != nil {
+		return err
+	}
+	if err := f.Truncate(hdr.size()); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// OpenCompressedBackup opens the database backed up by Tx.WriteToCompressed
+// from r, like OpenReader. The whole database is decompressed into memory.
+func OpenCompressedBackup(r io.Reader, codec Codec, options *Options) (*DB, error) {
+	dr, zr, hdr, err := openBackup(r, codec)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	if hdr.size() < 2*hdr.slotSize || hdr.size() > maxMapSize {
+		return nil, berrors.ErrInvalid
+	}
+	image := make(imageWriter, hdr.size())
+	meta, err := dr.readBody(image, hdr)
+	if err != nil {
+		return nil, err
+	}
+	copy(image, meta)
+	return openImage(image, options)
+}
+
+// OpenBackupFile opens the database written by Tx.WriteTo or Tx.CopyFile at
+// path in a lightweight read-only mode, e.g. for an analytics job reading a
+// backup: the file is memory-mapped without being locked, and the free pages
+// aren't loaded, so the backup must not be changed while it's open. Options
+// which only apply to writable databases, and ReadOnly, PreLoadFreelist and
+// Timeout, are ignored.
+func OpenBackupFile(path string, options *Options) (*DB, error) {
+	return open(context.Background(), path, 0, options, true)
+}
+
+// openBackup checks the header of a compressed backup, and returns a reader
+// of the diff it contains, the decompressing reader to close, and the header
+// of the diff.
+func openBackup(r io.Reader, codec Codec) (*diffReader, io.ReadCloser, diffHeader, error) {
+	var hdr [backupHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, nil, diffHeader{}, fmt.Errorf("read backup header: %w", err)
+	}
+	if binary.LittleEndian.Uint32(hdr[0:]) != backupMagic {
+		return nil, nil, diffHeader{}, berrors.ErrInvalid
+	} else if binary.LittleEndian.Uint32(hdr[4:]) != backupVersion {
+		return nil, nil, diffHeader{}, berrors.ErrVersionMismatch
+	}
+
+	zr, err := codec.NewReader(r)
+	if err != nil {
+		return nil, nil, diffHeader{}, err
+	}
+	dr := newDiffReader(zr)
+	dhdr, err := dr.readHeader()
+	if err == nil && dhdr.since != 0 {
+		err = berrors.ErrInvalid
+	}
+	if err != nil {
+		_ = zr.Close()
+		return nil, nil, diffHeader{}, err
+	}
+	return dr, zr, dhdr, nil
+}
+
+// countingWriter writes to w while counting bytes.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+// imageWriter writes pages to the image of a database.
+type imageWriter []byte
+
+func (w imageWriter) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(w)) {
+		return 0, berrors.ErrInvalid
+	}
+	return copy(w[off:], p), nil
+}
@@ -0,0 +1,121 @@
+package boltdb_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestTx_WriteToCompressed(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plain", true: "encrypted"}[encrypted], func(t *testing.T) {
+			var o *bolt.Options
+			if encrypted {
+				o = &bolt.Options{Encryption: mustAESGCMCipher(t, "0123456789abcdef")}
+			}
+			db := btesting.MustCreateDBWithOption(t, o)
+			putWidgets(t, db, 0, 100)
+
+			// Leave most of the database free.
+			err := db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucket([]byte("junk"))
+				if err != nil {
+					return err
+				}
+				for i := 0; i < 1000; i++ {
+					if err := b.Put([]byte(fmt.Sprintf("%04d", i)), bytes.Repeat([]byte{byte(i)}, 1024)); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			require.NoError(t, err)
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				return tx.DeleteBucket([]byte("junk"))
+			}))
+
+			var buf bytes.Buffer
+			var size int64
+			err = db.View(func(tx *bolt.Tx) error {
+				size = tx.Size()
+				n, err := tx.WriteToCompressed(&buf, bolt.FlateCodec(flate.BestSpeed))
+				require.Equal(t, int64(buf.Len()), n)
+				return err
+			})
+			require.NoError(t, err)
+			require.Less(t, int64(buf.Len()), size/10)
+
+			path := filepath.Join(t.TempDir(), "restored.db")
+			require.NoError(t, bolt.RestoreCompressed(path, bytes.NewReader(buf.Bytes()), bolt.FlateCodec(0), 0600))
+			rdb := btesting.MustOpenDBWithOption(t, path, o)
+			requireWidgets(t, rdb.DB, 100)
+			rdb.MustCheck()
+			putWidgets(t, rdb, 100, 110)
+			requireWidgets(t, rdb.DB, 110)
+
+			mdb, err := bolt.OpenCompressedBackup(bytes.NewReader(buf.Bytes()), bolt.FlateCodec(0), o)
+			require.NoError(t, err)
+			requireWidgets(t, mdb, 100)
+			require.NoError(t, mdb.Close())
+
+			// A truncated backup fails to restore.
+			truncated := bytes.NewReader(buf.Bytes()[:buf.Len()/2])
+			_, err = bolt.OpenCompressedBackup(truncated, bolt.FlateCodec(0), o)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestOpenCompressedBackup_ErrInvalid(t *testing.T) {
+	_, err := bolt.OpenCompressedBackup(bytes.NewReader(make([]byte, 64)), bolt.FlateCodec(0), nil)
+	require.ErrorIs(t, err, berrors.ErrInvalid)
+}
+
+func TestOpenBackupFile(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	putWidgets(t, db, 0, 100)
+	path := filepath.Join(t.TempDir(), "backup.db")
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	}))
+
+	bdb, err := bolt.OpenBackupFile(path, &bolt.Options{PreLoadFreelist: true})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, bdb.Close()) }()
+	require.True(t, bdb.IsReadOnly())
+	require.Zero(t, bdb.Stats().FreePageN)
+	requireWidgets(t, bdb, 100)
+
+	err = bdb.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("widgets")).Cursor()
+		var n int
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			require.Equal(t, fmt.Sprintf("%04d", n), string(k))
+			require.Equal(t, fmt.Sprintf("value-%d", n), string(v))
+			n++
+		}
+		require.Equal(t, 100, n)
+		return nil
+	})
+	require.NoError(t, err)
+	_, err = bdb.Begin(true)
+	require.ErrorIs(t, err, berrors.ErrDatabaseReadOnly)
+
+	// The backup isn't locked.
+	wdb, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Millisecond})
+	require.NoError(t, err)
+	require.NoError(t, wdb.Close())
+
+	_, err = bolt.OpenBackupFile(filepath.Join(t.TempDir(), "missing.db"), nil)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
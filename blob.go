@@ -0,0 +1,373 @@
+package boltdb
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+	"unsafe"
+
+	"github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+const (
+	// blobRefSize is the size of each extent of the value stored in the leaf
+	// for a value streamed with PutReader: the id of the first chunk of the
+	// extent and its size. A value has one extent, and one more for each
+	// Append which doesn't rewrite its last extent.
+	blobRefSize = 16
+
+	// blobChunkSize is the size of the chunks of a streamed value. Each
+	// chunk is written out as soon as it's filled.
+	blobChunkSize = 1 << 20
+
+	// blobNextSize is the size of the id of the next chunk, which follows
+	// the page header of a chunk.
+	blobNextSize = 8
+)
+
+// PutReader sets the value for a key in the bucket like Put, reading the
+// value of the given size from r. The value is stored in chunks of its own
+// pages, which are written to the data file as they're read, so that a large
+// value never has to be held in memory. Use GetReader to read it back the
+// same way, and Append to extend it. Get and cursors return a copy of the
+// whole value.
+//
+// The indexes of the bucket see a nil value for the key, see Index.Keys.
+// Returns an error if r returns less than size bytes, or like Put.
+func (b *Bucket) PutReader(key []byte, r io.Reader, size int64) (err error) {
+	defer b.tx.catch(&err)
+	if b.tx.db == nil {
+		return errors.ErrTxClosed
+	} else if !b.Writable() {
+		return errors.ErrTxNotWritable
+	} else if len(key) == 0 {
+		return errors.ErrKeyRequired
+	} else if len(key) > MaxKeySize {
+		return errors.ErrKeyTooLarge
+	} else if size < 0 {
+		return errors.ErrInvalidValueSize
+	}
+	if _, _, flags, ok := b.seekBucketValue(key); ok && (flags&common.BucketLeafFlag) != 0 {
+		return errors.ErrIncompatibleValue
+	}
+
+	// Allocating the chunks may remap the data file, so the key mustn't be
+	// in it.
+	key = cloneBytes(key)
+	ref, err := b.tx.writeBlob(r, size)
+	if err != nil {
+		return err
+	}
+	return b.putBlob(key, ref)
+}
+
+// putBlob puts the reference of a streamed value, and frees its chunks if
+// that fails.
+func (b *Bucket) putBlob(key, ref []byte) error {
+	b.ext.SetHasBlobs()
+	if err := b.put(key, ref, common.BlobLeafFlag); err != nil {
+		b.tx.freeBlob(ref)
+		return err
+	}
+	return nil
+}
+
+// GetReader returns a reader of the value for a key in the bucket, which
+// reads a value put with PutReader chunk by chunk, without copying it whole.
+// Returns nil if the key does not exist or if the key is a nested bucket.
+// The reader is only valid for the life of the transaction.
+func (b *Bucket) GetReader(key []byte) *ValueReader {
+	defer b.tx.catch(nil)
+	v, flags, ok := b.getRaw(key)
+	if !ok || (flags&common.BucketLeafFlag) != 0 {
+		return nil
+	}
+	if (flags & common.ExpiringLeafFlag) != 0 {
+		if expired(v, time.Now().UnixNano()) {
+			return nil
+		}
+		v = v[expiryLen:]
+	}
+	if (flags & common.BlobLeafFlag) != 0 {
+		return b.tx.blobReader(v)
+	}
+	v = b.value(v, flags)
+	return &ValueReader{tx: b.tx, size: int64(len(v)), buf: v}
+}
+
+// ValueReader reads a value of a bucket, see Bucket.GetReader. The slices
+// passed to the writer by WriteTo are only valid for the life of the
+// transaction.
+type ValueReader struct {
+	tx   *Tx
+	size int64
+
+	// buf holds the rest of the current chunk, next is the id of the next
+	// chunk, left is the number of bytes of the extent after buf and
+	// extents holds the extents after the current one.
+	buf     []byte
+	next    common.Pgid
+	left    int64
+	extents []byte
+}
+
+// Size returns the size of the value.
+func (r *ValueReader) Size() int64 {
+	return r.size
+}
+
+// Read reads the next bytes of the value.
+func (r *ValueReader) Read(p []byte) (n int, err error) {
+	defer r.tx.catch(&err)
+	if r.tx.db == nil {
+		return 0, errors.ErrTxClosed
+	}
+	for len(r.buf) == 0 {
+		if r.done() {
+			return 0, io.EOF
+		}
+		r.nextChunk()
+	}
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// WriteTo writes the rest of the value to w, passing it the chunks of the
+// value as they're stored.
+func (r *ValueReader) WriteTo(w io.Writer) (n int64, err error) {
+	defer r.tx.catch(&err)
+	if r.tx.db == nil {
+		return 0, errors.ErrTxClosed
+	}
+	for {
+		if len(r.buf) > 0 {
+			m, err := w.Write(r.buf)
+			n += int64(m)
+			r.buf = r.buf[m:]
+			if err != nil {
+				return n, err
+			}
+		}
+		if r.done() {
+			return n, nil
+		}
+		r.nextChunk()
+	}
+}
+
+// done returns whether the reader is past the last chunk of the value.
+func (r *ValueReader) done() bool {
+	return r.left == 0 && len(r.extents) == 0
+}
+
+// nextChunk moves the reader to the next chunk of a streamed value.
+func (r *ValueReader) nextChunk() {
+	for r.left == 0 {
+		r.next, r.left = blobRef(r.extents)
+		r.extents = r.extents[blobRefSize:]
+	}
+	p := r.tx.page(r.next)
+	common.Assert(p.IsBlobPage(), "page %d: not a blob page: %s", p.Id(), p.Typ())
+	data := r.tx.blobData(p)
+	if int64(len(data)) > r.left {
+		data = data[:r.left]
+	}
+	r.buf = data
+	r.next = blobNext(p)
+	r.left -= int64(len(data))
+}
+
+// blobReader returns a reader of a streamed value from its reference.
+func (tx *Tx) blobReader(ref []byte) *ValueReader {
+	first, left := blobRef(ref)
+	return &ValueReader{tx: tx, size: blobSize(ref), next: first, left: left, extents: ref[blobRefSize:]}
+}
+
+// readBlob returns a copy of a streamed value.
+func (tx *Tx) readBlob(ref []byte) []byte {
+	r := tx.blobReader(ref)
+	v := make([]byte, r.Size())
+	for n := 0; n < len(v); {
+		if len(r.buf) == 0 {
+			r.nextChunk()
+		}
+		m := copy(v[n:], r.buf)
+		r.buf = r.buf[m:]
+		n += m
+	}
+	return v
+}
+
+// writeBlob writes a value of the given size read from r to a chain of
+// chunks, and returns its reference. A chunk is written out as soon as the
+// next one is allocated, so that only one chunk is held in memory.
+func (tx *Tx) writeBlob(r io.Reader, size int64) ([]byte, error) {
+	// The chunks are written out before commit, so they may be freed by the
+	// transaction which allocated them.
+	tx.db.freelist.spilled = tx.meta.Txid()
+
+	ref := make([]byte, blobRefSize)
+	binary.LittleEndian.PutUint64(ref[8:], uint64(size))
+	var prev *common.Page
+	var written int64
+	for left := size; left > 0; {
+		p, err := tx.allocate(tx.blobChunkPages(left))
+		if err != nil {
+			if prev != nil {
+				tx.freeChunks(ref, written, prev)
+			}
+			return nil, err
+		}
+		p.SetFlags(common.BlobPageFlag)
+		if prev == nil {
+			binary.LittleEndian.PutUint64(ref, uint64(p.Id()))
+		} else {
+			setBlobNext(prev, p.Id())
+			if err := tx.writeChunk(prev); err != nil {
+				return nil, err
+			}
+			written = size - left
+		}
+
+		data := tx.blobData(p)
+		if int64(len(data)) > left {
+			data = data[:left]
+		}
+		if _, err := io.ReadFull(r, data); err != nil {
+			tx.freeChunks(ref, written, p)
+			return nil, err
+		}
+		left -= int64(len(data))
+		prev = p
+	}
+	if prev != nil {
+		if err := tx.writeChunk(prev); err != nil {
+			return nil, err
+		}
+	}
+	return ref, nil
+}
+
+// freeChunks frees the chunks of a value which couldn't be written: the ones
+// holding the first written bytes, which were written out, and the last one,
+// which wasn't.
+func (tx *Tx) freeChunks(ref []byte, written int64, last *common.Page) {
+	delete(tx.pages, last.Id())
+	tx.db.freelist.free(tx.meta.Txid(), last)
+	if written > 0 {
+		binary.LittleEndian.PutUint64(ref[8:], uint64(written))
+		tx.freeBlob(ref)
+	}
+}
+
+// writeChunk writes out a chunk of a streamed value.
+func (tx *Tx) writeChunk(p *common.Page) error {
+	delete(tx.pages, p.Id())
+	if err := tx.writeOut(common.Pages{p}); err != nil {
+		// The changes can't be committed once part of them is lost.
+		tx.err = err
+		return err
+	}
+	return nil
+}
+
+// copyBlob copies a streamed value of src extent by extent, and returns the
+// reference of the copy. The chunks of each extent of the copy have the same
+// sizes as the source ones in the same database, so that the reader never
+// holds a chunk when the data file is remapped.
+func (tx *Tx) copyBlob(src *Tx, ref []byte) ([]byte, error) {
+	var refs []byte
+	for ; len(ref) > 0; ref = ref[blobRefSize:] {
+		r := src.blobReader(ref[:blobRefSize])
+		eref, err := tx.writeBlob(r, r.Size())
+		if err != nil {
+			tx.freeBlob(refs)
+			return nil, err
+		}
+		refs = append(refs, eref...)
+	}
+	return refs, nil
+}
+
+// freeBlob frees the chunks of a streamed value.
+func (tx *Tx) freeBlob(ref []byte) {
+	tx.forEachBlobPage(ref, func(p *common.Page) {
+		tx.db.freelist.free(tx.meta.Txid(), p)
+	})
+}
+
+// forEachBlobPage calls fn for each chunk of a streamed value.
+func (tx *Tx) forEachBlobPage(ref []byte, fn func(*common.Page)) {
+	for ; len(ref) > 0; ref = ref[blobRefSize:] {
+		id, left := blobRef(ref)
+		for left > 0 {
+			p := tx.page(id)
+			common.Assert(p.IsBlobPage(), "page %d: not a blob page: %s", p.Id(), p.Typ())
+			fn(p)
+			left -= int64(len(tx.blobData(p)))
+			id = blobNext(p)
+		}
+	}
+}
+
+// blobValue returns a copy of a streamed value, or the value itself if it's
+// stored in the leaf.
+func (tx *Tx) blobValue(v []byte, flags uint32) []byte {
+	if (flags & common.BlobLeafFlag) != 0 {
+		return tx.readBlob(v)
+	}
+	return v
+}
+
+// blobChunkPages returns the number of pages of the chunk which holds the
+// next left bytes of a streamed value. The chunks only depend on the size of
+// the value, the page size and the page trailer, see copyPages.
+func (tx *Tx) blobChunkPages(left int64) int {
+	pageSize := int64(tx.db.pageSize)
+	n := (left + int64(tx.blobOverhead()) + pageSize - 1) / pageSize
+	return int(min(n, max(1, blobChunkSize/pageSize)))
+}
+
+// blobChunkCap returns the number of bytes of data of the largest chunks.
+func (tx *Tx) blobChunkCap() int64 {
+	pageSize := int64(tx.db.pageSize)
+	return max(1, blobChunkSize/pageSize)*pageSize - int64(tx.blobOverhead())
+}
+
+// blobOverhead returns the number of bytes of a chunk which don't hold data.
+func (tx *Tx) blobOverhead() int {
+	return int(common.PageHeaderSize) + blobNextSize + tx.db.pageTrailerSize()
+}
+
+// blobData returns the data of a chunk, up to its trailer.
+func (tx *Tx) blobData(p *common.Page) []byte {
+	n := (int(p.Overflow())+1)*tx.db.pageSize - tx.blobOverhead()
+	return common.UnsafeByteSlice(unsafe.Pointer(p), common.PageHeaderSize+blobNextSize, 0, n)
+}
+
+// blobRef returns the id of the first chunk and the size of the first extent
+// of a streamed value.
+func blobRef(ref []byte) (common.Pgid, int64) {
+	return common.Pgid(binary.LittleEndian.Uint64(ref)), int64(binary.LittleEndian.Uint64(ref[8:]))
+}
+
+// blobSize returns the size of a streamed value.
+func blobSize(ref []byte) int64 {
+	var size int64
+	for ; len(ref) > 0; ref = ref[blobRefSize:] {
+		_, n := blobRef(ref)
+		size += n
+	}
+	return size
+}
+
+// blobNext returns the id of the chunk after p.
+func blobNext(p *common.Page) common.Pgid {
+	return *(*common.Pgid)(common.UnsafeAdd(unsafe.Pointer(p), common.PageHeaderSize))
+}
+
+func setBlobNext(p *common.Page, id common.Pgid) {
+	*(*common.Pgid)(common.UnsafeAdd(unsafe.Pointer(p), common.PageHeaderSize)) = id
+}
@@ -0,0 +1,273 @@
+package boltdb_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+// largeValue returns a value of n bytes which differs for each seed.
+func largeValue(n int, seed byte) []byte {
+	v := make([]byte, n)
+	for i := range v {
+		v[i] = byte(i*7) + seed
+	}
+	return v
+}
+
+// mustPutReader streams value into b under key.
+func mustPutReader(t *testing.T, b *bolt.Bucket, key string, value []byte) {
+	require.NoError(t, b.PutReader([]byte(key), bytes.NewReader(value), int64(len(value))))
+}
+
+func TestBucket_PutReader(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options bolt.Options
+	}{
+		{name: "default"},
+		{name: "max dirty bytes", options: bolt.Options{MaxDirtyBytes: 64 * 1024}},
+		{name: "checksums", options: bolt.Options{PageChecksums: true, PageTxids: true}},
+		{name: "encrypted", options: bolt.Options{Encryption: mustAESGCMCipher(t, "0123456789abcdef")}},
+		{name: "wal", options: bolt.Options{WAL: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &tc.options)
+			big := largeValue(3<<20+123, 1)
+			err := db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucket([]byte("blobs"))
+				if err != nil {
+					return err
+				}
+				mustPutReader(t, b, "big", big)
+				mustPutReader(t, b, "empty", nil)
+				mustPutReader(t, b, "small", []byte("value"))
+				require.NoError(t, b.Put([]byte("plain"), []byte("plain value")))
+				_, err = b.CreateBucket([]byte("nested"))
+				require.NoError(t, err)
+
+				// The value can be read back by the same transaction.
+				require.Equal(t, big, b.Get([]byte("big")))
+				r := b.GetReader([]byte("big"))
+				require.Equal(t, int64(len(big)), r.Size())
+				v, err := io.ReadAll(r)
+				require.NoError(t, err)
+				require.Equal(t, big, v)
+				return nil
+			})
+			require.NoError(t, err)
+			db.MustCheck()
+
+			db.MustClose()
+			db.MustReopen()
+			err = db.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("blobs"))
+				require.Equal(t, 5, b.Count())
+
+				var buf bytes.Buffer
+				r := b.GetReader([]byte("big"))
+				n, err := r.WriteTo(&buf)
+				require.NoError(t, err)
+				require.Equal(t, int64(len(big)), n)
+				require.Equal(t, big, buf.Bytes())
+
+				v, err := io.ReadAll(b.GetReader([]byte("empty")))
+				require.NoError(t, err)
+				require.Empty(t, v)
+				require.Equal(t, []byte("value"), b.Get([]byte("small")))
+				v, err = io.ReadAll(b.GetReader([]byte("plain")))
+				require.NoError(t, err)
+				require.Equal(t, []byte("plain value"), v)
+				require.Nil(t, b.GetReader([]byte("missing")))
+				require.Nil(t, b.GetReader([]byte("nested")))
+
+				// Cursors return copies of the streamed values.
+				k, v := b.Cursor().First()
+				require.Equal(t, []byte("big"), k)
+				require.Equal(t, big, v)
+				k, v = b.Cursor().Seek([]byte("s"))
+				require.Equal(t, []byte("small"), k)
+				require.Equal(t, []byte("value"), v)
+				return nil
+			})
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestBucket_PutReader_Free(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	value := largeValue(1<<20+5000, 2)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("blobs"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 6; i++ {
+			mustPutReader(t, b, fmt.Sprintf("%d", i), value)
+		}
+
+		// A value streamed by the same transaction can be replaced.
+		mustPutReader(t, b, "0", []byte("replaced"))
+		child, err := b.CreateBucket([]byte("child"))
+		if err != nil {
+			return err
+		}
+		mustPutReader(t, child, "value", value)
+		return nil
+	})
+	require.NoError(t, err)
+	db.MustCheck()
+
+	// The chunks of the replaced and deleted values are freed.
+	for _, fn := range []func(b *bolt.Bucket) error{
+		func(b *bolt.Bucket) error { return b.Put([]byte("1"), []byte("replaced")) },
+		func(b *bolt.Bucket) error { return b.Delete([]byte("2")) },
+		func(b *bolt.Bucket) error {
+			c := b.Cursor()
+			c.Seek([]byte("3"))
+			return c.Delete()
+		},
+		func(b *bolt.Bucket) error { return b.DeleteRange([]byte("4"), []byte("6")) },
+		func(b *bolt.Bucket) error { return b.DeleteBucket([]byte("child")) },
+	} {
+		err := db.Update(func(tx *bolt.Tx) error {
+			return fn(tx.Bucket([]byte("blobs")))
+		})
+		require.NoError(t, err)
+		db.MustCheck()
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("blobs"))
+	})
+	require.NoError(t, err)
+	db.MustCheck()
+}
+
+func TestBucket_PutReader_Errors(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	value := largeValue(3<<20, 3)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("blobs"))
+		if err != nil {
+			return err
+		}
+		_, err = b.CreateBucket([]byte("nested"))
+		require.NoError(t, err)
+
+		require.ErrorIs(t, b.PutReader(nil, bytes.NewReader(value), 1), berrors.ErrKeyRequired)
+		require.ErrorIs(t, b.PutReader([]byte("key"), bytes.NewReader(value), -1), berrors.ErrInvalidValueSize)
+		require.ErrorIs(t, b.PutReader([]byte("nested"), bytes.NewReader(value), 1), berrors.ErrIncompatibleValue)
+
+		// A short reader leaves nothing behind.
+		err = b.PutReader([]byte("short"), bytes.NewReader(value[:2<<20]), int64(len(value)))
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		require.Nil(t, b.Get([]byte("short")))
+		err = b.PutReader([]byte("short"), bytes.NewReader(value[:10]), int64(len(value)))
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		return nil
+	})
+	require.NoError(t, err)
+	db.MustCheck()
+
+	// A rolled back value leaves nothing behind either.
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	mustPutReader(t, tx.Bucket([]byte("blobs")), "rolled back", value)
+	require.NoError(t, tx.Rollback())
+	db.MustCheck()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		mustPutReader(t, tx.Bucket([]byte("blobs")), "key", value)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The reader can't be used once the transaction is closed.
+	tx, err = db.Begin(false)
+	require.NoError(t, err)
+	b := tx.Bucket([]byte("blobs"))
+	require.ErrorIs(t, b.PutReader([]byte("key"), bytes.NewReader(value), 1), berrors.ErrTxNotWritable)
+	r := b.GetReader([]byte("key"))
+	_, err = r.Read(make([]byte, 10))
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+	_, err = io.ReadAll(r)
+	require.ErrorIs(t, err, berrors.ErrTxClosed)
+}
+
+func TestBucket_PutReader_Copy(t *testing.T) {
+	src := btesting.MustCreateDB(t)
+	big := largeValue(2<<20+77, 4)
+	err := src.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("blobs"))
+		if err != nil {
+			return err
+		}
+		child, err := b.CreateBucket([]byte("child"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			if err := child.Put([]byte(fmt.Sprintf("%03d", i)), []byte("value")); err != nil {
+				return err
+			}
+		}
+		mustPutReader(t, b, "big", big)
+		mustPutReader(t, child, "big", big)
+		return nil
+	})
+	require.NoError(t, err)
+
+	check := func(t *testing.T, b *bolt.Bucket) {
+		require.Equal(t, big, b.Get([]byte("big")))
+		require.Equal(t, big, b.Bucket([]byte("child")).Get([]byte("big")))
+	}
+
+	// Clones have chunks of their own, whether the pages or the keys are
+	// copied.
+	err = src.Update(func(tx *bolt.Tx) error {
+		require.NoError(t, tx.CloneBucket([]byte("blobs"), []byte("pages")))
+		require.NoError(t, tx.Bucket([]byte("blobs")).Put([]byte("changed"), []byte("value")))
+		require.NoError(t, tx.CloneBucket([]byte("blobs"), []byte("keys")))
+		check(t, tx.Bucket([]byte("pages")))
+		check(t, tx.Bucket([]byte("keys")))
+		return nil
+	})
+	require.NoError(t, err)
+	src.MustCheck()
+	err = src.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("blobs"))
+	})
+	require.NoError(t, err)
+	src.MustCheck()
+
+	dst := btesting.MustCreateDBWithOption(t, &bolt.Options{PageChecksums: true, PageTxids: true})
+	err = dst.Update(func(dtx *bolt.Tx) error {
+		return src.View(func(stx *bolt.Tx) error {
+			return stx.CopyBucketTo([]byte("pages"), dtx)
+		})
+	})
+	require.NoError(t, err)
+	dst.MustCheck()
+
+	compacted := btesting.MustCreateDB(t)
+	require.NoError(t, bolt.Compact(compacted.DB, src.DB, 0))
+	compacted.MustCheck()
+
+	for _, db := range []*btesting.DB{dst, compacted} {
+		err = db.View(func(tx *bolt.Tx) error {
+			check(t, tx.Bucket([]byte("pages")))
+			return nil
+		})
+		require.NoError(t, err)
+	}
+}
@@ -1,10 +1,48 @@
 package boltdb
 
 import (
+	"fmt"
 	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
 // fdatasync flushes written data to a file descriptor.
 func fdatasync(db *DB) error {
+	if db.inMemory {
+		return nil
+	}
+	switch db.syncMode {
+	case SyncFsync, SyncFullFsync, SyncBarrierFsync:
+		return db.file.Sync()
+	case SyncFileRange:
+		flags := unix.SYNC_FILE_RANGE_WAIT_BEFORE | unix.SYNC_FILE_RANGE_WRITE | unix.SYNC_FILE_RANGE_WAIT_AFTER
+		if err := unix.SyncFileRange(int(db.file.Fd()), 0, 0, flags); err != syscall.ENOSYS {
+			return err
+		}
+	}
 	return syscall.Fdatasync(int(db.file.Fd()))
 }
+
+// fadvise gives advice a for n bytes of the data file at offset off.
+func fadvise(db *DB, off, n int64, a Advice) error {
+	var advice int
+	switch a {
+	case AdviceNormal:
+		advice = unix.FADV_NORMAL
+	case AdviceRandom:
+		advice = unix.FADV_RANDOM
+	case AdviceSequential:
+		advice = unix.FADV_SEQUENTIAL
+	case AdviceWillNeed:
+		advice = unix.FADV_WILLNEED
+	case AdviceDontNeed:
+		advice = unix.FADV_DONTNEED
+	default:
+		return nil
+	}
+	if err := unix.Fadvise(int(db.file.Fd()), off, n, advice); err != nil && err != syscall.ENOSYS {
+		return fmt.Errorf("fadvise: %s", err)
+	}
+	return nil
+}
@@ -5,12 +5,48 @@ import (
 )
 
 func msync(db *DB) error {
+	if chunks := db.chunks.Load(); chunks != nil {
+		return msyncChunks(db, *chunks)
+	}
 	return unix.Msync(db.data[:db.datasz], unix.MS_INVALIDATE)
 }
 
+// msyncChunks invalidates the chunks and page run mappings of a data file
+// which is mapped in chunks.
+func msyncChunks(db *DB, chunks [][]byte) error {
+	db.spanlock.Lock()
+	defer db.spanlock.Unlock()
+	for _, b := range chunks {
+		if err := unix.Msync(b, unix.MS_INVALIDATE); err != nil {
+			return err
+		}
+	}
+	for _, s := range db.spans {
+		if err := unix.Msync(s.ref, unix.MS_INVALIDATE); err != nil {
+			return err
+		}
+	}
+	for _, s := range db.retired {
+		if err := unix.Msync(s.ref, unix.MS_INVALIDATE); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func fdatasync(db *DB) error {
-	if db.data != nil {
+	if db.inMemory {
+		return nil
+	}
+	// Encrypted databases and the ones read with pread aren't
+	// memory-mapped.
+	if db.data != nil && db.cipher == nil && db.pageCache == nil {
 		return msync(db)
 	}
 	return db.file.Sync()
 }
+
+// fadvise is a no-op, posix_fadvise is only used on Linux.
+func fadvise(db *DB, off, n int64, a Advice) error {
+	return nil
+}
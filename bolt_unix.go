@@ -4,6 +4,7 @@
 package boltdb
 
 import (
+	"context"
 	"fmt"
 	"syscall"
 	"time"
@@ -15,7 +16,7 @@ import (
 )
 
 // flock acquires an advisory lock on a file descriptor.
-func flock(db *DB, exclusive bool, timeout time.Duration) error {
+func flock(ctx context.Context, db *DB, exclusive bool, timeout time.Duration) error {
 	var t time.Time
 	if timeout != 0 {
 		t = time.Now()
@@ -41,8 +42,12 @@ func flock(db *DB, exclusive bool, timeout time.Duration) error {
 			return errors.ErrTimeout
 		}
 
-		// Wait for a bit and try again.
-		time.Sleep(flockRetryTimeout)
+		// Wait for a bit and try again, unless the context is done.
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(flockRetryTimeout):
+		}
 	}
 }
 
@@ -53,19 +58,11 @@ func funlock(db *DB) error {
 
 // mmap memory maps a DB's data file.
 func mmap(db *DB, sz int) error {
-	// Map the data file to memory.
-	b, err := unix.Mmap(int(db.file.Fd()), 0, sz, syscall.PROT_READ, syscall.MAP_SHARED|db.MmapFlags)
+	b, err := mmapRegion(db, 0, sz)
 	if err != nil {
 		return err
 	}
 
-	// Advise the kernel that the mmap is accessed randomly.
-	err = unix.Madvise(b, syscall.MADV_RANDOM)
-	if err != nil && err != syscall.ENOSYS {
-		// Ignore not implemented error in kernel because it still works.
-		return fmt.Errorf("madvise: %s", err)
-	}
-
 	// Save the original byte slice and convert to a byte array pointer.
 	db.dataref = b
 	db.data = (*[maxMapSize]byte)(unsafe.Pointer(&b[0]))
@@ -87,3 +84,31 @@ func munmap(db *DB) error {
 	db.datasz = 0
 	return err
 }
+
+// mmapRegion memory maps sz bytes of a DB's data file at offset off.
+func mmapRegion(db *DB, off int64, sz int) ([]byte, error) {
+	// Map the data file to memory.
+	b, err := unix.Mmap(int(db.file.Fd()), off, sz, syscall.PROT_READ, syscall.MAP_SHARED|db.MmapFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	// Advise the kernel how the mmap is accessed, randomly by default.
+	err = unix.Madvise(b, madviseFlag(db.madvise))
+	if err != nil && err != syscall.ENOSYS {
+		// Ignore not implemented error in kernel because it still works.
+		_ = unix.Munmap(b)
+		return nil, fmt.Errorf("madvise: %s", err)
+	}
+	if err := madviseHugePages(db, b); err != nil {
+		_ = unix.Munmap(b)
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// munmapRegion unmaps a region mapped by mmapRegion.
+func munmapRegion(b []byte) error {
+	return unix.Munmap(b)
+}
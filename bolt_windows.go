@@ -1,6 +1,7 @@
 package boltdb
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"syscall"
@@ -14,11 +15,19 @@ import (
 
 // fdatasync flushes written data to a file descriptor.
 func fdatasync(db *DB) error {
+	if db.inMemory {
+		return nil
+	}
 	return db.file.Sync()
 }
 
+// fadvise is a no-op, posix_fadvise is only used on Linux.
+func fadvise(db *DB, off, n int64, a Advice) error {
+	return nil
+}
+
 // flock acquires an advisory lock on a file descriptor.
-func flock(db *DB, exclusive bool, timeout time.Duration) error {
+func flock(ctx context.Context, db *DB, exclusive bool, timeout time.Duration) error {
 	var t time.Time
 	if timeout != 0 {
 		t = time.Now()
@@ -47,8 +56,12 @@ func flock(db *DB, exclusive bool, timeout time.Duration) error {
 			return errors.ErrTimeout
 		}
 
-		// Wait for a bit and try again.
-		time.Sleep(flockRetryTimeout)
+		// Wait for a bit and try again, unless the context is done.
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(flockRetryTimeout):
+		}
 	}
 }
 
@@ -117,3 +130,58 @@ func munmap(db *DB) error {
 	db.datasz = 0
 	return err1
 }
+
+// mmapRegion memory maps sz bytes of a DB's data file at offset off. In
+// read-only mode, the region is cut off at the end of the file.
+func mmapRegion(db *DB, off int64, sz int) ([]byte, error) {
+	end := off + int64(sz)
+	info, err := db.file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("file stat error: %w", err)
+	}
+
+	var sizelo, sizehi uint32
+	if db.readOnly {
+		if end > info.Size() {
+			end = info.Size()
+			sz = int(end - off)
+		}
+	} else {
+		// Grow the database to the end of the region.
+		if info.Size() < end {
+			if err := db.file.Truncate(end); err != nil {
+				return nil, fmt.Errorf("truncate: %s", err)
+			}
+		}
+		sizehi = uint32(end >> 32)
+		sizelo = uint32(end)
+	}
+
+	// Open a file mapping handle.
+	h, errno := syscall.CreateFileMapping(syscall.Handle(db.file.Fd()), nil, syscall.PAGE_READONLY, sizehi, sizelo, nil)
+	if h == 0 {
+		return nil, os.NewSyscallError("CreateFileMapping", errno)
+	}
+
+	// Create the memory map.
+	addr, errno := syscall.MapViewOfFile(h, syscall.FILE_MAP_READ, uint32(off>>32), uint32(off), uintptr(sz))
+	if addr == 0 {
+		_ = syscall.CloseHandle(h)
+		return nil, os.NewSyscallError("MapViewOfFile", errno)
+	}
+
+	// Close mapping handle.
+	if err := syscall.CloseHandle(syscall.Handle(h)); err != nil {
+		return nil, os.NewSyscallError("CloseHandle", err)
+	}
+
+	return unsafe.Slice((*byte)(unsafe.Pointer(addr)), sz), nil
+}
+
+// munmapRegion unmaps a region mapped by mmapRegion.
+func munmapRegion(b []byte) error {
+	if err := syscall.UnmapViewOfFile(uintptr(unsafe.Pointer(&b[0]))); err != nil {
+		return os.NewSyscallError("UnmapViewOfFile", err)
+	}
+	return nil
+}
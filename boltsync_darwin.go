@@ -0,0 +1,31 @@
+package boltdb
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// fdatasync flushes written data to a file descriptor.
+func fdatasync(db *DB) error {
+	if db.inMemory {
+		return nil
+	}
+	fd := int(db.file.Fd())
+	switch db.syncMode {
+	case SyncFdatasync, SyncFsync, SyncFileRange:
+		return syscall.Fsync(fd)
+	case SyncBarrierFsync:
+		if _, err := unix.FcntlInt(uintptr(fd), unix.F_BARRIERFSYNC, 0); err == nil {
+			return nil
+		}
+	}
+	// File.Sync uses F_FULLFSYNC, falling back to fsync if the file system
+	// doesn't support it.
+	return db.file.Sync()
+}
+
+// fadvise is a no-op, posix_fadvise is only used on Linux.
+func fadvise(db *DB, off, n int64, a Advice) error {
+	return nil
+}
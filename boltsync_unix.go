@@ -1,9 +1,17 @@
-//go:build !windows && !plan9 && !linux && !openbsd
-// +build !windows,!plan9,!linux,!openbsd
+//go:build !windows && !plan9 && !linux && !openbsd && !darwin
+// +build !windows,!plan9,!linux,!openbsd,!darwin
 
 package boltdb
 
 // fdatasync flushes written data to a file descriptor.
 func fdatasync(db *DB) error {
+	if db.inMemory {
+		return nil
+	}
 	return db.file.Sync()
 }
+
+// fadvise is a no-op, posix_fadvise is only used on Linux.
+func fadvise(db *DB, off, n int64, a Advice) error {
+	return nil
+}
@@ -2,7 +2,11 @@ package boltdb
 
 import (
 	"bytes"
+	"compress/flate"
 	"fmt"
+	"math"
+	"sort"
+	"time"
 	"unsafe"
 
 	"github.com/openkvlab/boltdb/errors"
@@ -15,6 +19,14 @@ const (
 
 	// MaxValueSize is the maximum length of a value, in bytes.
 	MaxValueSize = (1 << 31) - 2
+
+	// MaxBucketMetaSize is the maximum length of the metadata of a bucket,
+	// in bytes.
+	MaxBucketMetaSize = 1024
+
+	// MaxCompressionDictSize is the maximum length of the compression
+	// dictionary of a bucket, in bytes: the window of DEFLATE.
+	MaxCompressionDictSize = 32 << 10
 )
 
 const (
@@ -31,15 +43,23 @@ type Bucket struct {
 	*common.InBucket
 	tx       *Tx                   // the associated transaction
 	buckets  map[string]*Bucket    // subbucket cache
+	ext      common.InBucketExt    // extension of the bucket header
+	dict     []byte                // compression dictionary after the extension
+	meta     []byte                // user metadata after the dictionary
+	deflater *flate.Writer         // compressor using dict, see compress
 	page     *common.Page          // inline page reference
 	rootNode *node                 // materialized node for the root page.
 	nodes    map[common.Pgid]*node // node cache
+	indexes  []*Index              // indexes of a top-level bucket
+	parent   *Bucket               // parent of a bucket of a write tx
+	name     string                // name of the bucket in its parent
 
 	// Sets the threshold for filling nodes when they split. By default,
 	// the bucket will fill to 50% but it can be useful to increase this
 	// amount if you know that your write workloads are mostly append-only.
 	//
-	// This is non-persisted across transactions so it must be set in every Tx.
+	// This is non-persisted across transactions so it must be set in every Tx,
+	// unless it's persisted with SetFillPercent.
 	FillPercent float64
 }
 
@@ -82,10 +102,49 @@ func (b *Bucket) Cursor() *Cursor {
 	}
 }
 
+// PrefixCursor creates a cursor bound to the keys which start with prefix,
+// see Cursor.SeekPrefix. It isn't positioned until First, Last or Seek is
+// called.
+func (b *Bucket) PrefixCursor(prefix []byte) *Cursor {
+	c := b.Cursor()
+	c.bound(prefix, prefixEnd(prefix))
+	return c
+}
+
+// RangeCursor creates a cursor bound to the keys in [start, end): First,
+// Last, Next, Prev and Seek only return the keys of the range, and a nil key
+// once they run out of them, so that a scan can't leave it. A nil start
+// begins at the first key and a nil end ends after the last one. It isn't
+// positioned until First, Last or Seek is called.
+func (b *Bucket) RangeCursor(start, end []byte) *Cursor {
+	c := b.Cursor()
+	c.bound(start, end)
+	return c
+}
+
+// SeekToIndex creates a cursor at the i-th key of the bucket, counting from
+// zero, and returns it with the key and its value, or a nil key if the bucket
+// has at most i keys, e.g. to jump to a page of results. The branches don't
+// record the number of keys below them, so the leaves before the key are
+// walked, but only their number of elements is read, see Cursor.Skip.
+// The returned key and value are only valid for the life of the transaction.
+func (b *Bucket) SeekToIndex(i int) (c *Cursor, key []byte, value []byte) {
+	defer b.tx.catch(nil)
+	c = b.Cursor()
+	if i < 0 {
+		return c, nil, nil
+	}
+	c.first()
+	k, v, flags := c.skip(i)
+	key, value = c.result(c.skipExpired(k, v, flags, c.next))
+	return c, key, value
+}
+
 // Bucket retrieves a nested bucket by name.
 // Returns nil if the bucket does not exist.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (b *Bucket) Bucket(name []byte) *Bucket {
+	defer b.tx.catch(nil)
 	if b.buckets != nil {
 		if child := b.buckets[string(name)]; child != nil {
 			return child
@@ -103,6 +162,8 @@ func (b *Bucket) Bucket(name []byte) *Bucket {
 
 	// Otherwise create a bucket and cache it.
 	var child = b.openBucket(v)
+	b.openIndexes(name, child)
+	b.setParent(name, child)
 	if b.buckets != nil {
 		b.buckets[string(name)] = child
 	}
@@ -133,6 +194,22 @@ func (b *Bucket) openBucket(value []byte) *Bucket {
 	} else {
 		child.InBucket = (*common.InBucket)(unsafe.Pointer(&value[0]))
 	}
+	child.ext = common.LoadBucketExt(value)
+	if fillPercent := child.ext.FillPercent(); fillPercent != 0 {
+		child.FillPercent = fillPercent
+	}
+	if dict := common.LoadBucketDict(value); dict != nil {
+		if b.tx.writable {
+			dict = cloneBytes(dict)
+		}
+		child.dict = dict
+	}
+	if meta := common.LoadBucketMeta(value); meta != nil {
+		if b.tx.writable {
+			meta = cloneBytes(meta)
+		}
+		child.meta = meta
+	}
 
 	// Save a reference to the inline page if the bucket is inline.
 	if child.RootPage() == 0 {
@@ -145,7 +222,8 @@ func (b *Bucket) openBucket(value []byte) *Bucket {
 // CreateBucket creates a new bucket at the given key and returns the new bucket.
 // Returns an error if the key already exists, if the bucket name is blank, or if the bucket name is too long.
 // The bucket instance is only valid for the lifetime of the transaction.
-func (b *Bucket) CreateBucket(key []byte) (*Bucket, error) {
+func (b *Bucket) CreateBucket(key []byte) (_ *Bucket, err error) {
+	defer b.tx.catch(&err)
 	if b.tx.db == nil {
 		return nil, errors.ErrTxClosed
 	} else if !b.tx.writable {
@@ -176,9 +254,13 @@ func (b *Bucket) CreateBucket(key []byte) (*Bucket, error) {
 		rootNode:    &node{isLeaf: true},
 		FillPercent: DefaultFillPercent,
 	}
+	bucket.ext.SetVersion(uint64(b.tx.meta.Txid()))
+	bucket.ext.SetCount(0)
 	var value = bucket.write()
 
 	c.node().put(newKey, newKey, value, 0, common.BucketLeafFlag)
+	b.addCount(1)
+	b.recordChange(newKey, OpCreateBucket)
 
 	// Since subbuckets are not allowed on inline buckets, we need to
 	// dereference the inline page, if it exists. This will cause the bucket
@@ -191,7 +273,8 @@ func (b *Bucket) CreateBucket(key []byte) (*Bucket, error) {
 // CreateBucketIfNotExists creates a new bucket if it doesn't already exist and returns a reference to it.
 // Returns an error if the bucket name is blank, or if the bucket name is too long.
 // The bucket instance is only valid for the lifetime of the transaction.
-func (b *Bucket) CreateBucketIfNotExists(key []byte) (*Bucket, error) {
+func (b *Bucket) CreateBucketIfNotExists(key []byte) (_ *Bucket, err error) {
+	defer b.tx.catch(&err)
 	if b.tx.db == nil {
 		return nil, errors.ErrTxClosed
 	} else if !b.tx.writable {
@@ -214,6 +297,8 @@ func (b *Bucket) CreateBucketIfNotExists(key []byte) (*Bucket, error) {
 	if bytes.Equal(key, k) {
 		if (flags & common.BucketLeafFlag) != 0 {
 			var child = b.openBucket(v)
+			b.openIndexes(key, child)
+			b.setParent(key, child)
 			if b.buckets != nil {
 				b.buckets[string(key)] = child
 			}
@@ -229,6 +314,8 @@ func (b *Bucket) CreateBucketIfNotExists(key []byte) (*Bucket, error) {
 		rootNode:    &node{isLeaf: true},
 		FillPercent: DefaultFillPercent,
 	}
+	bucket.ext.SetVersion(uint64(b.tx.meta.Txid()))
+	bucket.ext.SetCount(0)
 	var value = bucket.write()
 
 	// Insert into node.
@@ -236,6 +323,8 @@ func (b *Bucket) CreateBucketIfNotExists(key []byte) (*Bucket, error) {
 	// it from being marked as leaking, and accordingly cannot be allocated on stack.
 	newKey := cloneBytes(key)
 	c.node().put(newKey, newKey, value, 0, common.BucketLeafFlag)
+	b.addCount(1)
+	b.recordChange(newKey, OpCreateBucket)
 
 	// Since subbuckets are not allowed on inline buckets, we need to
 	// dereference the inline page, if it exists. This will cause the bucket
@@ -246,8 +335,13 @@ func (b *Bucket) CreateBucketIfNotExists(key []byte) (*Bucket, error) {
 }
 
 // DeleteBucket deletes a bucket at the given key.
+// The pages of a bucket which spans several leaves and wasn't changed by the
+// transaction are freed on commit a few thousand pages at a time, by this
+// transaction and the next write transactions, so that deleting a large bucket
+// doesn't stall the writer.
 // Returns an error if the bucket does not exist, or if the key represents a non-bucket value.
-func (b *Bucket) DeleteBucket(key []byte) error {
+func (b *Bucket) DeleteBucket(key []byte) (err error) {
+	defer b.tx.catch(&err)
 	if b.tx.db == nil {
 		return errors.ErrTxClosed
 	} else if !b.Writable() {
@@ -265,30 +359,104 @@ func (b *Bucket) DeleteBucket(key []byte) error {
 		return errors.ErrIncompatibleValue
 	}
 
-	// Recursively delete all child buckets.
+	// Delete the indexes of the bucket.
+	if b == &b.tx.root {
+		if err := b.tx.dropIndexes(key); err != nil {
+			return err
+		}
+	}
+
+	// Recursively delete all child buckets and release all bucket pages to
+	// freelist, unless the next transactions free them.
 	child := b.Bucket(key)
-	err := child.ForEachBucket(func(k []byte) error {
-		if err := child.DeleteBucket(k); err != nil {
-			return fmt.Errorf("delete bucket: %s", err)
+	if !b.tx.deferBucket(child) {
+		err = child.ForEachBucket(func(k []byte) error {
+			if err := child.DeleteBucket(k); err != nil {
+				return fmt.Errorf("delete bucket: %s", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
-		return nil
-	})
-	if err != nil {
-		return err
+
+		child.freeBlobs()
+		child.nodes = nil
+		child.rootNode = nil
+		child.free()
 	}
 
 	// Remove cached copy.
 	delete(b.buckets, string(key))
 
-	// Release all bucket pages to freelist.
-	child.nodes = nil
-	child.rootNode = nil
-	child.free()
-
 	// Delete the node if we have a matching key.
 	c.node().del(key)
+	b.addCount(-1)
+	b.recordChange(key, OpDeleteBucket)
 
-	return nil
+	return b.tx.dirty(0)
+}
+
+// RenameBucket renames the nested bucket oldName to newName. Only the entry
+// of the bucket in its parent is rewritten, its keys and nested buckets stay
+// where they are.
+//
+// Renaming a top-level bucket deletes its indexes like DeleteBucket, and the
+// indexes declared for newName aren't updated, see Tx.RebuildIndex.
+// Returns an error if oldName doesn't exist or isn't a bucket, if newName is
+// blank or exists, or if the bucket was created from a read-only transaction.
+func (b *Bucket) RenameBucket(oldName, newName []byte) (err error) {
+	defer b.tx.catch(&err)
+	if b.tx.db == nil {
+		return errors.ErrTxClosed
+	} else if !b.Writable() {
+		return errors.ErrTxNotWritable
+	} else if len(newName) == 0 {
+		return errors.ErrBucketNameRequired
+	}
+
+	_, v, flags, ok := b.seekBucketValue(oldName)
+	if !ok {
+		return errors.ErrBucketNotFound
+	} else if (flags & common.BucketLeafFlag) == 0 {
+		return errors.ErrIncompatibleValue
+	}
+	if _, _, nflags, ok := b.seekBucketValue(newName); ok {
+		if (nflags & common.BucketLeafFlag) != 0 {
+			return errors.ErrBucketExists
+		}
+		return errors.ErrIncompatibleValue
+	}
+
+	if b == &b.tx.root {
+		if err := b.tx.dropIndexes(oldName); err != nil {
+			return err
+		}
+		// The dirty pages may have been written out meanwhile.
+		_, v, _, _ = b.seekBucketValue(oldName)
+	}
+
+	// Keep the cached bucket, so that its changes are written under the new
+	// name on commit.
+	value := cloneBytes(v)
+	child := b.Bucket(oldName)
+	delete(b.buckets, string(oldName))
+	child.indexes = nil
+	b.openIndexes(newName, child)
+	b.buckets[string(newName)] = child
+	child.name = string(newName)
+
+	// Move the entry of the bucket.
+	c := b.Cursor()
+	c.seek(oldName)
+	c.node().del(oldName)
+	newKey := cloneBytes(newName)
+	c.seek(newKey)
+	c.node().put(newKey, newKey, value, 0, common.BucketLeafFlag)
+	b.recordChange(oldName, OpDeleteBucket)
+	b.recordChange(newKey, OpCreateBucket)
+
+	return b.tx.dirty(0)
 }
 
 // Get retrieves the value for a key in the bucket.
@@ -296,6 +464,7 @@ func (b *Bucket) DeleteBucket(key []byte) error {
 // The returned value is only valid for the life of the transaction.
 // The returned memory is owned by boltdb and must never be modified; writing to this memory might corrupt the database.
 func (b *Bucket) Get(key []byte) []byte {
+	defer b.tx.catch(nil)
 	k, v, flags := b.Cursor().seek(key)
 
 	// Return nil if this is a bucket.
@@ -307,14 +476,67 @@ func (b *Bucket) Get(key []byte) []byte {
 	if !bytes.Equal(key, k) {
 		return nil
 	}
-	return v
+
+	// Return nil if the value expired.
+	if (flags & common.ExpiringLeafFlag) != 0 {
+		if expired(v, time.Now().UnixNano()) {
+			return nil
+		}
+		return v[expiryLen:]
+	}
+	return b.value(v, flags)
+}
+
+// GetMany retrieves the values for keys like Get, and returns them in the
+// order of keys. The keys are looked up in sorted order, so that the lookups
+// of nearby keys share the traversal of the branch pages above them, which
+// is faster than calling Get for each of them.
+func (b *Bucket) GetMany(keys [][]byte) [][]byte {
+	defer b.tx.catch(nil)
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return bytes.Compare(keys[order[i]], keys[order[j]]) < 0 })
+
+	values := make([][]byte, len(keys))
+	now := time.Now().UnixNano()
+	c := b.Cursor()
+	for n, i := range order {
+		var k, v []byte
+		var flags uint32
+		if n == 0 {
+			k, v, flags = c.seek(keys[i])
+		} else {
+			k, v, flags = c.seekAfter(keys[i])
+		}
+		if !bytes.Equal(keys[i], k) || (flags&common.BucketLeafFlag) != 0 {
+			continue
+		}
+		if (flags & common.ExpiringLeafFlag) != 0 {
+			if expired(v, now) {
+				continue
+			}
+			v = v[expiryLen:]
+		}
+		values[i] = b.value(v, flags)
+	}
+	return values
 }
 
 // Put sets the value for a key in the bucket.
 // If the key exist then its previous value will be overwritten.
 // Supplied value must remain valid for the life of the transaction.
+// If the bucket has a compression dictionary then the value may be stored compressed, see SetCompressionDict.
 // Returns an error if the bucket was created from a read-only transaction, if the key is blank, if the key is too large, or if the value is too large.
-func (b *Bucket) Put(key []byte, value []byte) error {
+// Returns an error if writing out the dirty pages fails, see Options.MaxDirtyBytes, in which case the transaction can't be committed.
+func (b *Bucket) Put(key []byte, value []byte) (err error) {
+	return b.put(key, value, 0)
+}
+
+// put sets the value for a key with the given leaf flags.
+func (b *Bucket) put(key []byte, value []byte, flags uint32) (err error) {
+	defer b.tx.catch(&err)
 	if b.tx.db == nil {
 		return errors.ErrTxClosed
 	} else if !b.Writable() {
@@ -326,6 +548,11 @@ func (b *Bucket) Put(key []byte, value []byte) error {
 	} else if int64(len(value)) > MaxValueSize {
 		return errors.ErrValueTooLarge
 	}
+	if len(b.indexes) > 0 {
+		if err := b.indexPut(key, value, flags); err != nil {
+			return err
+		}
+	}
 
 	// Tip: Use a new variable `newKey` instead of reusing the existing `key` to prevent
 	// it from being marked as leaking, and accordingly cannot be allocated on stack.
@@ -333,31 +560,52 @@ func (b *Bucket) Put(key []byte, value []byte) error {
 
 	// Move cursor to correct position.
 	c := b.Cursor()
-	k, _, flags := c.seek(newKey)
+	k, v, kflags := c.seek(newKey)
 
 	// Return an error if there is an existing key with a bucket value.
-	if bytes.Equal(newKey, k) && (flags&common.BucketLeafFlag) != 0 {
+	if bytes.Equal(newKey, k) && (kflags&common.BucketLeafFlag) != 0 {
 		return errors.ErrIncompatibleValue
 	}
 
-	c.node().put(newKey, newKey, value, 0, 0)
+	// Free the chunks of a streamed value which is replaced.
+	if bytes.Equal(newKey, k) && (kflags&common.BlobLeafFlag) != 0 {
+		b.tx.freeBlob(v)
+	}
+
+	if flags == 0 && b.compressible(value) {
+		value, flags = b.compress(value)
+	}
 
-	return nil
+	c.node().put(newKey, newKey, value, 0, flags)
+	if !bytes.Equal(newKey, k) {
+		b.addCount(1)
+	}
+	b.recordChange(newKey, OpPut)
+
+	return b.tx.dirty(int(common.LeafPageElementSize) + len(key) + len(value))
 }
 
 // Delete removes a key from the bucket.
 // If the key does not exist then nothing is done and a nil error is returned.
 // Returns an error if the bucket was created from a read-only transaction.
-func (b *Bucket) Delete(key []byte) error {
+// Returns an error if writing out the dirty pages fails, see Options.MaxDirtyBytes, in which case the transaction can't be committed.
+func (b *Bucket) Delete(key []byte) (err error) {
+	defer b.tx.catch(&err)
 	if b.tx.db == nil {
 		return errors.ErrTxClosed
 	} else if !b.Writable() {
 		return errors.ErrTxNotWritable
 	}
 
+	if len(b.indexes) > 0 {
+		if err := b.indexDelete(key); err != nil {
+			return err
+		}
+	}
+
 	// Move cursor to correct position.
 	c := b.Cursor()
-	k, _, flags := c.seek(key)
+	k, v, flags := c.seek(key)
 
 	// Return nil if the key doesn't exist.
 	if !bytes.Equal(key, k) {
@@ -368,20 +616,78 @@ func (b *Bucket) Delete(key []byte) error {
 	if (flags & common.BucketLeafFlag) != 0 {
 		return errors.ErrIncompatibleValue
 	}
+	if (flags & common.BlobLeafFlag) != 0 {
+		b.tx.freeBlob(v)
+	}
 
 	// Delete the node if we have a matching key.
 	c.node().del(key)
+	b.addCount(-1)
+	b.recordChange(key, OpDelete)
 
 	return nil
 }
 
+// Version returns the id of the last transaction which changed the bucket or
+// one of its nested buckets, so that an application can detect whether the
+// bucket changed since it read it, e.g. to retry an optimistic update. The
+// changes of a write transaction only change the version once it commits or
+// writes out its dirty pages, see Options.MaxDirtyBytes.
+// Buckets which weren't changed since they were written by a version which
+// didn't record it return 0.
+func (b *Bucket) Version() uint64 {
+	return b.ext.Version()
+}
+
+// Count returns the number of keys in the bucket, including the keys of its
+// nested buckets and the keys which expired but weren't reaped yet, but not
+// the keys within its nested buckets. The count is kept in the bucket header,
+// so it takes constant time, except for buckets which weren't changed since
+// they were written by a version which didn't count them. Those are counted
+// by walking their pages, and their count is kept from their next change on.
+func (b *Bucket) Count() int {
+	if n, ok := b.ext.Count(); ok {
+		return int(n)
+	}
+	return b.countKeys()
+}
+
+// addCount updates the number of keys of the bucket after delta keys were
+// added. Buckets which weren't counted yet are counted instead.
+func (b *Bucket) addCount(delta int) {
+	// The root bucket has no header.
+	if b == &b.tx.root {
+		return
+	}
+	if n, ok := b.ext.Count(); ok {
+		b.ext.SetCount(uint64(int(n) + delta))
+	} else {
+		b.ext.SetCount(uint64(b.countKeys()))
+	}
+}
+
+// countKeys counts the keys of the bucket by walking its leaves. Unlike
+// forEachPageNode, it looks at the root node of inline buckets if it exists.
+func (b *Bucket) countKeys() int {
+	var n int
+	b._forEachPageNode(b.RootPage(), 0, func(p *common.Page, nd *node, _ int) {
+		if p != nil && p.IsLeafPage() {
+			n += int(p.Count())
+		} else if nd != nil && nd.isLeaf {
+			n += len(nd.inodes)
+		}
+	})
+	return n
+}
+
 // Sequence returns the current integer for the bucket without incrementing it.
 func (b *Bucket) Sequence() uint64 {
 	return b.InSequence()
 }
 
 // SetSequence updates the sequence number for the bucket.
-func (b *Bucket) SetSequence(v uint64) error {
+func (b *Bucket) SetSequence(v uint64) (err error) {
+	defer b.tx.catch(&err)
 	if b.tx.db == nil {
 		return errors.ErrTxClosed
 	} else if !b.Writable() {
@@ -399,8 +705,96 @@ func (b *Bucket) SetSequence(v uint64) error {
 	return nil
 }
 
+// Meta returns the metadata of the bucket, or nil if it has none. The
+// returned value is only valid for the life of the transaction and must not
+// be modified.
+func (b *Bucket) Meta() []byte {
+	return b.meta
+}
+
+// SetMeta sets the metadata of the bucket, e.g. the version of its schema or
+// the codec of its values. It's stored in the bucket header, apart from the
+// keys of the bucket. A nil or empty meta removes the metadata.
+// Returns an error if the meta is larger than MaxBucketMetaSize.
+func (b *Bucket) SetMeta(meta []byte) (err error) {
+	defer b.tx.catch(&err)
+	if b.tx.db == nil {
+		return errors.ErrTxClosed
+	} else if !b.Writable() {
+		return errors.ErrTxNotWritable
+	} else if len(meta) > MaxBucketMetaSize {
+		return errors.ErrBucketMetaTooLarge
+	}
+
+	// Materialize the root node if it hasn't been already so that the
+	// bucket will be saved during commit.
+	if b.rootNode == nil {
+		_ = b.node(b.RootPage(), nil)
+	}
+
+	if len(meta) == 0 {
+		b.meta = nil
+	} else {
+		b.meta = cloneBytes(meta)
+	}
+	b.ext.SetMetaSize(len(b.meta))
+	return nil
+}
+
+// SetFillPercent sets FillPercent and persists it in the bucket header, so
+// that the bucket has it whenever it's opened, e.g. 1.0 for a bucket whose
+// keys are only appended. It's clamped like FillPercent and stored to a
+// precision of 0.001. A fillPercent of 0 removes the persisted value, and
+// sets FillPercent to DefaultFillPercent.
+func (b *Bucket) SetFillPercent(fillPercent float64) (err error) {
+	defer b.tx.catch(&err)
+	if b.tx.db == nil {
+		return errors.ErrTxClosed
+	} else if !b.Writable() {
+		return errors.ErrTxNotWritable
+	}
+
+	// Materialize the root node if it hasn't been already so that the
+	// bucket will be saved during commit.
+	if b.rootNode == nil {
+		_ = b.node(b.RootPage(), nil)
+	}
+
+	if fillPercent == 0 {
+		b.ext.SetFillPercent(0)
+		b.FillPercent = DefaultFillPercent
+		return nil
+	} else if fillPercent < minFillPercent {
+		fillPercent = minFillPercent
+	} else if fillPercent > maxFillPercent {
+		fillPercent = maxFillPercent
+	}
+	b.ext.SetFillPercent(fillPercent)
+	b.FillPercent = b.ext.FillPercent()
+	return nil
+}
+
+// copySettings copies the sequence, metadata, compression dictionary and
+// persisted fill percent of src to the bucket.
+func (b *Bucket) copySettings(src *Bucket) error {
+	if err := b.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	if err := b.SetMeta(src.Meta()); err != nil {
+		return err
+	}
+	if err := b.SetCompressionDict(src.CompressionDict()); err != nil {
+		return err
+	}
+	if fillPercent := src.ext.FillPercent(); fillPercent != 0 {
+		return b.SetFillPercent(fillPercent)
+	}
+	return nil
+}
+
 // NextSequence returns an autoincrementing integer for the bucket.
-func (b *Bucket) NextSequence() (uint64, error) {
+func (b *Bucket) NextSequence() (_ uint64, err error) {
+	defer b.tx.catch(&err)
 	if b.tx.db == nil {
 		return 0, errors.ErrTxClosed
 	} else if !b.Writable() {
@@ -418,6 +812,34 @@ func (b *Bucket) NextSequence() (uint64, error) {
 	return b.Sequence(), nil
 }
 
+// NextSequenceN reserves the next n values of the sequence of the bucket at
+// once and returns the first of them, so that the values first to first+n-1
+// can be handed out without changing the bucket for each of them.
+// Returns an error if n is 0, or if the sequence would overflow.
+func (b *Bucket) NextSequenceN(n uint64) (_ uint64, err error) {
+	defer b.tx.catch(&err)
+	if b.tx.db == nil {
+		return 0, errors.ErrTxClosed
+	} else if !b.Writable() {
+		return 0, errors.ErrTxNotWritable
+	} else if n == 0 {
+		return 0, errors.ErrInvalidSequenceCount
+	} else if b.Sequence() > math.MaxUint64-n {
+		return 0, errors.ErrSequenceOverflow
+	}
+
+	// Materialize the root node if it hasn't been already so that the
+	// bucket will be saved during commit.
+	if b.rootNode == nil {
+		_ = b.node(b.RootPage(), nil)
+	}
+
+	// Advance the sequence and return the first reserved value.
+	first := b.Sequence() + 1
+	b.SetInSequence(b.Sequence() + n)
+	return first, nil
+}
+
 // ForEach executes a function for each key/value pair in a bucket.
 // Because ForEach uses a Cursor, the iteration over keys is in lexicographical order.
 // If the provided function returns an error then the iteration is stopped and
@@ -436,6 +858,91 @@ func (b *Bucket) ForEach(fn func(k, v []byte) error) error {
 	return nil
 }
 
+// ForEachReverse executes a function for each key/value pair in a bucket, in
+// reverse lexicographical order. If the provided function returns an error
+// then the iteration is stopped and the error is returned to the caller. The
+// provided function must not modify the bucket; this will result in undefined
+// behavior.
+func (b *Bucket) ForEachReverse(fn func(k, v []byte) error) error {
+	if b.tx.db == nil {
+		return errors.ErrTxClosed
+	}
+	c := b.Cursor()
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEachKey executes a function for each key in a bucket, in
+// lexicographical order, like ForEach but without reading the values, see
+// Cursor.SetKeysOnly. If the provided function returns an error then the
+// iteration is stopped and the error is returned to the caller. The provided
+// function must not modify the bucket; this will result in undefined
+// behavior.
+func (b *Bucket) ForEachKey(fn func(k []byte) error) error {
+	if b.tx.db == nil {
+		return errors.ErrTxClosed
+	}
+	c := b.Cursor()
+	c.SetKeysOnly(true)
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		if err := fn(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEachPrefix executes a function for each key/value pair in a bucket whose
+// key starts with prefix, in lexicographical order. The iteration stops at
+// the first key without the prefix. If the provided function returns
+// ErrStopIteration then the iteration is stopped and nil is returned; any
+// other error is returned to the caller. The provided function must not
+// modify the bucket; this will result in undefined behavior.
+func (b *Bucket) ForEachPrefix(prefix []byte, fn func(k, v []byte) error) error {
+	if b.tx.db == nil {
+		return errors.ErrTxClosed
+	}
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if err := fn(k, v); err == errors.ErrStopIteration {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEachRange executes a function for each key/value pair in a bucket whose
+// key is in [start, end), in lexicographical order, or in reverse order if
+// reverse is set. A nil start begins at the first key and a nil end ends
+// after the last one. If the provided function returns ErrStopIteration then
+// the iteration is stopped and nil is returned; any other error is returned
+// to the caller. The provided function must not modify the bucket; this will
+// result in undefined behavior.
+func (b *Bucket) ForEachRange(start, end []byte, reverse bool, fn func(k, v []byte) error) error {
+	if b.tx.db == nil {
+		return errors.ErrTxClosed
+	}
+	c := b.RangeCursor(start, end)
+	first, next := c.First, c.Next
+	if reverse {
+		first, next = c.Last, c.Prev
+	}
+	for k, v := first(); k != nil; k, v = next() {
+		if err := fn(k, v); err == errors.ErrStopIteration {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (b *Bucket) ForEachBucket(fn func(k []byte) error) error {
 	if b.tx.db == nil {
 		return errors.ErrTxClosed
@@ -451,9 +958,41 @@ func (b *Bucket) ForEachBucket(fn func(k []byte) error) error {
 	return nil
 }
 
-// Stats returns stats on a bucket.
+// ForEachBucketReverse executes a function for each nested bucket of a
+// bucket, like ForEachBucket but in reverse lexicographical order.
+func (b *Bucket) ForEachBucketReverse(fn func(k []byte) error) error {
+	if b.tx.db == nil {
+		return errors.ErrTxClosed
+	}
+	c := b.Cursor()
+	for k, _, flags := c.seekLast(); k != nil; k, _, flags = c.prev() {
+		if flags&common.BucketLeafFlag != 0 {
+			if err := fn(k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Stats returns stats on a bucket. In read-only transactions, the stats of
+// the nested buckets which weren't changed since an earlier call are taken
+// from a cache of the database instead of walking their pages again. The
+// cache is kept in memory, not in the data file. See StatsBreakdown for the
+// stats of each nested bucket.
 func (b *Bucket) Stats() BucketStats {
+	defer b.tx.catch(nil)
+	return b.cachedStats()
+}
+
+// stats walks the pages of the bucket to compute its stats, and returns the
+// stats of its nested buckets by name if breakdown is set.
+func (b *Bucket) stats(breakdown bool) (BucketStats, map[string]BucketStats) {
 	var s, subStats BucketStats
+	var children map[string]BucketStats
+	if breakdown {
+		children = make(map[string]BucketStats)
+	}
 	pageSize := b.tx.db.pageSize
 	s.BucketN += 1
 	if b.RootPage() == 0 {
@@ -495,8 +1034,13 @@ func (b *Bucket) Stats() BucketStats {
 					e := p.LeafPageElement(i)
 					if (e.Flags() & common.BucketLeafFlag) != 0 {
 						// For any bucket element, open the element value
-						// and recursively call Stats on the contained bucket.
-						subStats.Add(b.openBucket(e.Value()).Stats())
+						// and recursively get the stats of the contained
+						// bucket.
+						cs := b.openBucket(e.Value()).cachedStats()
+						subStats.Add(cs)
+						if breakdown {
+							children[string(e.Key())] = cs
+						}
 					}
 				}
 			}
@@ -530,7 +1074,7 @@ func (b *Bucket) Stats() BucketStats {
 	s.Depth += subStats.Depth
 	// Add the stats for all sub-buckets
 	s.Add(subStats)
-	return s
+	return s, children
 }
 
 // forEachPage iterates over every page in a bucket, including inline pages.
@@ -579,32 +1123,53 @@ func (b *Bucket) _forEachPageNode(pgId common.Pgid, depth int, fn func(*common.P
 	}
 }
 
+// childNames returns the names of the cached child buckets in the order they
+// are rebalanced and spilled, which is sorted with Options.DeterministicAlloc
+// since it decides the pages they're written on.
+func (b *Bucket) childNames() []string {
+	names := make([]string, 0, len(b.buckets))
+	for name := range b.buckets {
+		names = append(names, name)
+	}
+	if b.tx.db.deterministicAlloc {
+		sort.Strings(names)
+	}
+	return names
+}
+
 // spill writes all the nodes for this bucket to dirty pages.
 func (b *Bucket) spill() error {
 	// Spill all child buckets first.
-	for name, child := range b.buckets {
+	for _, name := range b.childNames() {
+		child := b.buckets[name]
 		// If the child bucket is small enough and it has no child buckets then
 		// write it inline into the parent bucket's page. Otherwise spill it
 		// like a normal bucket and make the parent value a pointer to the page.
 		var value []byte
 		if child.inlineable() {
 			child.free()
+			child.ext.SetVersion(uint64(b.tx.meta.Txid()))
 			value = child.write()
 		} else {
 			if err := child.spill(); err != nil {
 				return err
 			}
+			if child.rootNode != nil {
+				child.ext.SetVersion(uint64(b.tx.meta.Txid()))
+			}
 
 			// Update the child bucket header in this bucket.
-			value = make([]byte, unsafe.Sizeof(common.InBucket{}))
+			value = make([]byte, common.BucketHeaderSize+child.ext.Size())
 			var bucket = (*common.InBucket)(unsafe.Pointer(&value[0]))
 			*bucket = *child.InBucket
+			child.writeExt(value)
 		}
 
 		// Skip writing the bucket if there are no materialized nodes.
 		if child.rootNode == nil {
 			continue
 		}
+		b.ext.LowerNextExpiry(child.ext.NextExpiry())
 
 		// Update parent node.
 		var c = b.Cursor()
@@ -639,7 +1204,8 @@ func (b *Bucket) spill() error {
 }
 
 // inlineable returns true if a bucket is small enough to be written inline
-// and if it contains no subbuckets. Otherwise, returns false.
+// and if it contains no subbuckets or streamed values. Otherwise, returns
+// false.
 func (b *Bucket) inlineable() bool {
 	var n = b.rootNode
 
@@ -648,13 +1214,13 @@ func (b *Bucket) inlineable() bool {
 		return false
 	}
 
-	// Bucket is not inlineable if it contains subbuckets or if it goes beyond
-	// our threshold for inline bucket size.
+	// Bucket is not inlineable if it contains subbuckets or streamed values,
+	// or if it goes beyond our threshold for inline bucket size.
 	var size = common.PageHeaderSize
 	for _, inode := range n.inodes {
 		size += common.LeafPageElementSize + uintptr(len(inode.Key())) + uintptr(len(inode.Value()))
 
-		if inode.Flags()&common.BucketLeafFlag != 0 {
+		if inode.Flags()&(common.BucketLeafFlag|common.BlobLeafFlag) != 0 {
 			return false
 		} else if size > b.maxInlineBucketSize() {
 			return false
@@ -666,6 +1232,9 @@ func (b *Bucket) inlineable() bool {
 
 // Returns the maximum total size of a bucket to make it a candidate for inlining.
 func (b *Bucket) maxInlineBucketSize() uintptr {
+	if n := b.tx.db.maxInlineBucketSize; n > 0 {
+		return uintptr(min(n, b.tx.db.pageSize))
+	}
 	return uintptr(b.tx.db.pageSize / 4)
 }
 
@@ -673,7 +1242,7 @@ func (b *Bucket) maxInlineBucketSize() uintptr {
 func (b *Bucket) write() []byte {
 	// Allocate the appropriate size.
 	var n = b.rootNode
-	var value = make([]byte, common.BucketHeaderSize+n.size())
+	var value = make([]byte, common.BucketHeaderSize+n.size()+b.ext.Size())
 
 	// Write a bucket header.
 	var bucket = (*common.InBucket)(unsafe.Pointer(&value[0]))
@@ -683,16 +1252,45 @@ func (b *Bucket) write() []byte {
 	var p = (*common.Page)(unsafe.Pointer(&value[common.BucketHeaderSize]))
 	n.write(p)
 
+	// Write the header extension after the page.
+	b.writeExt(value)
+
 	return value
 }
 
+// writeExt writes the header extension, the compression dictionary and the
+// user metadata of the bucket to the end of value.
+func (b *Bucket) writeExt(value []byte) {
+	b.ext.Write(value)
+	copy(value[len(value)-len(b.meta)-len(b.dict):], b.dict)
+	copy(value[len(value)-len(b.meta):], b.meta)
+}
+
 // rebalance attempts to balance all nodes.
 func (b *Bucket) rebalance() {
-	for _, n := range b.nodes {
-		n.rebalance()
+	if !b.tx.db.deterministicAlloc {
+		for _, n := range b.nodes {
+			n.rebalance()
+		}
+		for _, child := range b.buckets {
+			child.rebalance()
+		}
+		return
 	}
-	for _, child := range b.buckets {
-		child.rebalance()
+
+	// The nodes which are merged depend on the order.
+	ids := make(common.Pgids, 0, len(b.nodes))
+	for id := range b.nodes {
+		ids = append(ids, id)
+	}
+	sort.Sort(ids)
+	for _, id := range ids {
+		if n := b.nodes[id]; n != nil {
+			n.rebalance()
+		}
+	}
+	for _, name := range b.childNames() {
+		b.buckets[name].rebalance()
 	}
 }
 
@@ -722,6 +1320,9 @@ func (b *Bucket) node(pgId common.Pgid, parent *node) *node {
 	// Read the page into the node and cache it.
 	n.read(p)
 	b.nodes[pgId] = n
+	if b.tx.db.maxDirtyBytes > 0 {
+		b.tx.dirtyBytes += n.size()
+	}
 
 	// Update statistics.
 	b.tx.stats.IncNodeCount(1)
@@ -746,6 +1347,20 @@ func (b *Bucket) free() {
 	b.SetRootPage(0)
 }
 
+// freeBlobs frees the chunks of the values streamed into the bucket, see
+// PutReader. The buckets which never had one aren't scanned.
+func (b *Bucket) freeBlobs() {
+	if !b.ext.HasBlobs() {
+		return
+	}
+	c := b.Cursor()
+	for k, v, flags := c.first(); k != nil; k, v, flags = c.next() {
+		if (flags & common.BlobLeafFlag) != 0 {
+			b.tx.freeBlob(v)
+		}
+	}
+}
+
 // dereference removes all references to the old mmap.
 func (b *Bucket) dereference() {
 	if b.rootNode != nil {
@@ -6,12 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"math/rand"
 	"os"
 	"strconv"
 	"strings"
 	"testing"
 	"testing/quick"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -122,6 +124,60 @@ func TestBucket_Get_Capacity(t *testing.T) {
 	}
 }
 
+func TestBucket_GetMany(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 20000; i += 2 {
+			if err := b.Put([]byte(fmt.Sprintf("%05d", i)), []byte(fmt.Sprintf("value%d", i))); err != nil {
+				return err
+			}
+		}
+		if _, err := b.CreateBucket([]byte("00003")); err != nil {
+			return err
+		}
+		return b.PutWithTTL([]byte("00005"), []byte("ttl"), time.Hour)
+	})
+	require.NoError(t, err)
+
+	getMany := func(b *bolt.Bucket) {
+		rng := rand.New(rand.NewSource(1))
+		keys := [][]byte{[]byte("00003"), []byte("00005"), []byte("zzz"), nil, []byte("00004"), []byte("00004")}
+		for i := 0; i < 2000; i++ {
+			keys = append(keys, []byte(fmt.Sprintf("%05d", rng.Intn(21000))))
+		}
+		values := b.GetMany(keys)
+		require.Len(t, values, len(keys))
+		for i, k := range keys {
+			require.Equal(t, b.Get(k), values[i], "key %q", k)
+		}
+		require.Nil(t, values[0])
+		require.Equal(t, []byte("ttl"), values[1])
+		require.Equal(t, []byte("value4"), values[5])
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		getMany(tx.Bucket([]byte("widgets")))
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Nodes changed by the transaction are looked up as well.
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for i := 1; i < 20000; i += 50 {
+			if err := b.Put([]byte(fmt.Sprintf("%05d", i)), []byte("changed")); err != nil {
+				return err
+			}
+		}
+		getMany(b)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
 // Ensure that a bucket can write a key/value.
 func TestBucket_Put(t *testing.T) {
 	db := btesting.MustCreateDB(t)
@@ -747,6 +803,91 @@ func TestBucket_DeleteBucket_Large(t *testing.T) {
 	}
 }
 
+// Ensure that a bucket can be renamed without copying its keys.
+func TestBucket_RenameBucket(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{Indexes: byColor})
+	mustFillTenants(t, db)
+
+	var root uint64
+	var want []string
+	err := db.Update(func(tx *bolt.Tx) error {
+		tenants := tx.Bucket([]byte("tenants"))
+		large := tenants.Bucket([]byte("large"))
+		root = uint64(large.Root())
+		want = dumpBucket(t, large)
+
+		require.NoError(t, tenants.RenameBucket([]byte("large"), []byte("huge")))
+		require.Nil(t, tenants.Bucket([]byte("large")))
+		huge := tenants.Bucket([]byte("huge"))
+		require.Equal(t, root, uint64(huge.Root()))
+		require.Equal(t, want, dumpBucket(t, huge))
+		require.Equal(t, 2, tenants.Count())
+
+		// The changes made before the rename are kept.
+		small := tenants.Bucket([]byte("small"))
+		require.NoError(t, small.Put([]byte("baz"), []byte("qux")))
+		require.NoError(t, tenants.RenameBucket([]byte("small"), []byte("tiny")))
+		require.NoError(t, small.Put([]byte("quux"), []byte("corge")))
+
+		require.NoError(t, tx.RenameBucket([]byte("tenants"), []byte("customers")))
+		return nil
+	})
+	require.NoError(t, err)
+	db.MustCheck()
+
+	db.MustClose()
+	db.MustReopen()
+	err = db.View(func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket([]byte("tenants")))
+		customers := tx.Bucket([]byte("customers"))
+		huge := customers.Bucket([]byte("huge"))
+		require.Equal(t, root, uint64(huge.Root()))
+		require.Equal(t, want, dumpBucket(t, huge))
+		require.Equal(t, []string{"sequence=0 count=3", "baz=qux", "foo=bar", "quux=corge"}, dumpBucket(t, customers.Bucket([]byte("tiny"))))
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Renaming an indexed bucket deletes its indexes.
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("a"), []byte("red/1")))
+		require.NotNil(t, tx.Bucket([]byte("widgets-by-color")))
+		require.NoError(t, tx.RenameBucket([]byte("widgets"), []byte("woojits")))
+		require.Nil(t, tx.Bucket([]byte("widgets-by-color")))
+		require.NoError(t, tx.Bucket([]byte("woojits")).Put([]byte("b"), []byte("red/2")))
+		require.Nil(t, tx.Bucket([]byte("widgets-by-color")))
+		return nil
+	})
+	require.NoError(t, err)
+	db.MustCheck()
+}
+
+// Ensure that renaming a bucket returns the expected errors.
+func TestBucket_RenameBucket_Errors(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	mustFillTenants(t, db)
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		tenants := tx.Bucket([]byte("tenants"))
+		require.ErrorIs(t, tx.RenameBucket([]byte("missing"), []byte("other")), berrors.ErrBucketNotFound)
+		require.ErrorIs(t, tx.RenameBucket([]byte("tenants"), nil), berrors.ErrBucketNameRequired)
+		require.ErrorIs(t, tenants.RenameBucket([]byte("large"), []byte("small")), berrors.ErrBucketExists)
+		require.NoError(t, tenants.Put([]byte("key"), []byte("value")))
+		require.ErrorIs(t, tenants.RenameBucket([]byte("key"), []byte("other")), berrors.ErrIncompatibleValue)
+		require.ErrorIs(t, tenants.RenameBucket([]byte("small"), []byte("key")), berrors.ErrIncompatibleValue)
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		require.ErrorIs(t, tx.RenameBucket([]byte("tenants"), []byte("other")), berrors.ErrTxNotWritable)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
 // Ensure that a simple value retrieved via Bucket() returns a nil.
 func TestBucket_Bucket_IncompatibleValue(t *testing.T) {
 	db := btesting.MustCreateDB(t)
@@ -812,6 +953,156 @@ func TestBucket_DeleteBucket_IncompatibleValue(t *testing.T) {
 }
 
 // Ensure bucket can set and update its sequence number.
+// Ensure that the version of a bucket is the id of the last transaction which
+// changed it or its nested buckets.
+func TestBucket_Version(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	version := func(names ...string) uint64 {
+		var v uint64
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(names[0]))
+			for _, name := range names[1:] {
+				b = b.Bucket([]byte(name))
+			}
+			v = b.Version()
+			return nil
+		})
+		require.NoError(t, err)
+		return v
+	}
+
+	var created uint64
+	err := db.Update(func(tx *bolt.Tx) error {
+		created = uint64(tx.ID())
+		widgets, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		_, err = widgets.CreateBucket([]byte("child"))
+		require.NoError(t, err)
+		_, err = tx.CreateBucket([]byte("woojits"))
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, created, version("widgets"))
+	require.Equal(t, created, version("widgets", "child"))
+	require.Equal(t, created, version("woojits"))
+
+	// Changing a nested bucket changes its parents, but not its siblings.
+	var changed uint64
+	err = db.Update(func(tx *bolt.Tx) error {
+		changed = uint64(tx.ID())
+		b := tx.Bucket([]byte("widgets")).Bucket([]byte("child"))
+		require.NoError(t, b.Put([]byte("foo"), []byte("bar")))
+		require.Equal(t, created, b.Version())
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, changed, version("widgets"))
+	require.Equal(t, changed, version("widgets", "child"))
+	require.Equal(t, created, version("woojits"))
+
+	// The sequence is part of the bucket.
+	var sequenced uint64
+	err = db.Update(func(tx *bolt.Tx) error {
+		sequenced = uint64(tx.ID())
+		_, err := tx.Bucket([]byte("woojits")).NextSequence()
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, sequenced, version("woojits"))
+
+	// Reading a bucket in a write transaction doesn't change it.
+	err = db.Update(func(tx *bolt.Tx) error {
+		require.Equal(t, []byte("bar"), tx.Bucket([]byte("widgets")).Bucket([]byte("child")).Get([]byte("foo")))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, changed, version("widgets"))
+	require.Equal(t, sequenced, version("woojits"))
+
+	db.MustClose()
+	db.MustReopen()
+	require.Equal(t, changed, version("widgets", "child"))
+	require.Equal(t, sequenced, version("woojits"))
+	db.MustCheck()
+}
+
+func TestBucket_Count(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{MaxDirtyBytes: 32 * 1024})
+	r := rand.New(rand.NewSource(42))
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%05d", i)) }
+
+	requireCount := func(b *bolt.Bucket) {
+		var n int
+		require.NoError(t, b.ForEach(func(_, _ []byte) error {
+			n++
+			return nil
+		}))
+		require.Equal(t, n, b.Count())
+	}
+
+	for round := 0; round < 20; round++ {
+		err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			require.NoError(t, err)
+			for i := 0; i < 500; i++ {
+				k := key(r.Intn(5000))
+				switch r.Intn(10) {
+				case 0:
+					_, _ = b.CreateBucket(k)
+				case 1:
+					_ = b.DeleteBucket(k)
+				case 2, 3:
+					_ = b.Delete(k)
+				case 4:
+					c := b.Cursor()
+					if k, v := c.Seek(k); k != nil && v != nil {
+						require.NoError(t, c.Delete())
+					}
+				default:
+					_ = b.Put(k, make([]byte, 100))
+				}
+			}
+			requireCount(b)
+
+			from := r.Intn(5000)
+			_ = b.DeleteRange(key(from), key(from+r.Intn(200)))
+			requireCount(b)
+
+			var keys, values [][]byte
+			for i := 5000 + round*10; i < 5000+round*10+10; i++ {
+				keys = append(keys, key(i))
+				values = append(values, []byte("value"))
+			}
+			require.NoError(t, b.PutSorted(keys, values))
+			requireCount(b)
+			return nil
+		})
+		require.NoError(t, err)
+	}
+
+	// The changes of a rolled back transaction aren't counted.
+	var count int
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		count = b.Count()
+		require.NoError(t, b.Put([]byte("new"), []byte("value")))
+		require.Equal(t, count+1, b.Count())
+		return errors.New("rollback")
+	})
+	require.Error(t, err)
+
+	db.MustClose()
+	db.MustReopen()
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, count, b.Count())
+		requireCount(b)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
 func TestBucket_Sequence(t *testing.T) {
 	db := btesting.MustCreateDB(t)
 
@@ -852,6 +1143,139 @@ func TestBucket_Sequence(t *testing.T) {
 	}
 }
 
+// Ensure that the metadata of a bucket is kept apart from its keys.
+func TestBucket_Meta(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		inline, err := tx.CreateBucket([]byte("inline"))
+		require.NoError(t, err)
+		require.Nil(t, inline.Meta())
+		require.NoError(t, inline.SetMeta([]byte("schema=1")))
+		require.NoError(t, inline.Put([]byte("foo"), []byte("bar")))
+		require.Equal(t, []byte("schema=1"), inline.Meta())
+
+		large, err := tx.CreateBucket([]byte("large"))
+		require.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, large.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value")))
+		}
+		nested, err := large.CreateBucket([]byte("nested"))
+		require.NoError(t, err)
+		require.NoError(t, nested.SetMeta([]byte("codec=json")))
+		require.NoError(t, large.SetMeta(make([]byte, bolt.MaxBucketMetaSize)))
+		require.ErrorIs(t, large.SetMeta(make([]byte, bolt.MaxBucketMetaSize+1)), berrors.ErrBucketMetaTooLarge)
+		return nil
+	})
+	require.NoError(t, err)
+	db.MustCheck()
+
+	// The metadata is kept when the bucket changes.
+	err = db.Update(func(tx *bolt.Tx) error {
+		large := tx.Bucket([]byte("large"))
+		require.Len(t, large.Meta(), bolt.MaxBucketMetaSize)
+		require.NoError(t, large.SetMeta([]byte("schema=2")))
+		for i := 1000; i < 2000; i++ {
+			require.NoError(t, large.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value")))
+		}
+		require.NoError(t, tx.Bucket([]byte("inline")).Put([]byte("baz"), []byte("qux")))
+		return nil
+	})
+	require.NoError(t, err)
+
+	db.MustClose()
+	db.MustReopen()
+	err = db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, []byte("schema=1"), tx.Bucket([]byte("inline")).Meta())
+		require.Equal(t, 2, tx.Bucket([]byte("inline")).Count())
+		large := tx.Bucket([]byte("large"))
+		require.Equal(t, []byte("schema=2"), large.Meta())
+		require.Equal(t, 2001, large.Count())
+		require.Equal(t, []byte("codec=json"), large.Bucket([]byte("nested")).Meta())
+		require.ErrorIs(t, large.SetMeta(nil), berrors.ErrTxNotWritable)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The metadata is copied with the bucket.
+	dst := btesting.MustCreateDB(t)
+	require.NoError(t, bolt.Compact(dst.DB, db.DB, 0))
+	err = dst.Update(func(tx *bolt.Tx) error {
+		require.NoError(t, tx.CloneBucket([]byte("large"), []byte("copy")))
+		require.Equal(t, []byte("codec=json"), tx.Bucket([]byte("copy")).Bucket([]byte("nested")).Meta())
+		large := tx.Bucket([]byte("large"))
+		require.Equal(t, []byte("schema=2"), large.Meta())
+
+		// An empty metadata removes it.
+		require.NoError(t, large.SetMeta(nil))
+		require.Nil(t, large.Meta())
+		return nil
+	})
+	require.NoError(t, err)
+	dst.MustCheck()
+	err = dst.View(func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket([]byte("large")).Meta())
+		require.Equal(t, []byte("schema=2"), tx.Bucket([]byte("copy")).Meta())
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+// Ensure that the fill percent set with SetFillPercent is persisted.
+func TestBucket_SetFillPercent(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	put := func(from, to int) {
+		err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			require.NoError(t, err)
+			for i := from; i < to; i++ {
+				require.NoError(t, b.Put([]byte(fmt.Sprintf("%06d", i)), make([]byte, 100)))
+			}
+			return nil
+		})
+		require.NoError(t, err)
+	}
+	put(0, 1)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.NoError(t, b.SetFillPercent(2))
+		require.Equal(t, 1.0, b.FillPercent)
+		require.NoError(t, b.SetFillPercent(0.05))
+		require.Equal(t, 0.1, b.FillPercent)
+		require.NoError(t, b.SetFillPercent(0.95))
+		return nil
+	})
+	require.NoError(t, err)
+
+	db.MustClose()
+	db.MustReopen()
+	put(1, 10000)
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, 0.95, b.FillPercent)
+		stats := b.Stats()
+		require.Greater(t, float64(stats.LeafInuse)/float64(stats.LeafAlloc), 0.85)
+		require.ErrorIs(t, b.SetFillPercent(1), berrors.ErrTxNotWritable)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// A fill percent of 0 removes the persisted one.
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.NoError(t, b.SetFillPercent(0))
+		require.Equal(t, bolt.DefaultFillPercent, b.FillPercent)
+		return nil
+	})
+	require.NoError(t, err)
+	err = db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, bolt.DefaultFillPercent, tx.Bucket([]byte("widgets")).FillPercent)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
 // Ensure that a bucket can return an autoincrementing sequence.
 func TestBucket_NextSequence(t *testing.T) {
 	db := btesting.MustCreateDB(t)
@@ -953,6 +1377,57 @@ func TestBucket_NextSequence_ReadOnly(t *testing.T) {
 	}
 }
 
+// Ensure that a block of sequence values can be reserved at once.
+func TestBucket_NextSequenceN(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+
+		first, err := b.NextSequenceN(100)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), first)
+		require.Equal(t, uint64(100), b.Sequence())
+
+		seq, err := b.NextSequence()
+		require.NoError(t, err)
+		require.Equal(t, uint64(101), seq)
+
+		_, err = b.NextSequenceN(0)
+		require.ErrorIs(t, err, berrors.ErrInvalidSequenceCount)
+		return nil
+	})
+	require.NoError(t, err)
+
+	db.MustClose()
+	db.MustReopen()
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		first, err := b.NextSequenceN(10)
+		require.NoError(t, err)
+		require.Equal(t, uint64(102), first)
+		require.Equal(t, uint64(111), b.Sequence())
+
+		require.NoError(t, b.SetSequence(math.MaxUint64-10))
+		first, err = b.NextSequenceN(10)
+		require.NoError(t, err)
+		require.Equal(t, uint64(math.MaxUint64-9), first)
+		_, err = b.NextSequenceN(1)
+		require.ErrorIs(t, err, berrors.ErrSequenceOverflow)
+		require.Equal(t, uint64(math.MaxUint64), b.Sequence())
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		_, err := tx.Bucket([]byte("widgets")).NextSequenceN(1)
+		require.ErrorIs(t, err, berrors.ErrTxNotWritable)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
 // Ensure that retrieving the next sequence for a bucket on a closed database return an error.
 func TestBucket_NextSequence_Closed(t *testing.T) {
 	db := btesting.MustCreateDB(t)
@@ -1066,6 +1541,115 @@ func TestBucket_ForEachBucket(t *testing.T) {
 	assert.NoErrorf(t, err, "db.View failed")
 }
 
+// Ensure that ForEachKey and a keys-only cursor return the keys without
+// their values.
+func TestBucket_ForEachKey(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("a"), []byte("1")))
+		mustPutReader(t, b, "b", largeValue(1<<20, 1))
+		_, err = b.CreateBucket([]byte("c"))
+		require.NoError(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		var keys []string
+		require.NoError(t, b.ForEachKey(func(k []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		}))
+		require.Equal(t, []string{"a", "b", "c"}, keys)
+
+		c := b.Cursor()
+		c.SetKeysOnly(true)
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			require.Nil(t, v)
+		}
+		c.SetKeysOnly(false)
+		_, v := c.Seek([]byte("b"))
+		require.Equal(t, largeValue(1<<20, 1), v)
+
+		stop := errors.New("stop")
+		require.Equal(t, stop, b.ForEachKey(func(k []byte) error {
+			return stop
+		}))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+// Ensure that ForEachReverse and ForEachBucketReverse iterate from the last
+// key to the first one.
+func TestBucket_ForEachReverse(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	verifyReads := func(b *bolt.Bucket) {
+		var keys []string
+		var values [][]byte
+		err := b.ForEachReverse(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			values = append(values, v)
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"zsubbucket", "foo", "csubbucket", "baz", "bar"}, keys)
+		require.Equal(t, [][]byte{nil, []byte("0000"), nil, []byte("0001"), []byte("0002")}, values)
+
+		keys = nil
+		err = b.ForEachBucketReverse(func(k []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"zsubbucket", "csubbucket"}, keys)
+
+		// An error stops the iteration.
+		keys = nil
+		stop := errors.New("stop")
+		err = b.ForEachReverse(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			if len(keys) == 2 {
+				return stop
+			}
+			return nil
+		})
+		require.Equal(t, stop, err)
+		require.Equal(t, []string{"zsubbucket", "foo"}, keys)
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("foo"), []byte("0000")))
+		_, err = b.CreateBucket([]byte("zsubbucket"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("baz"), []byte("0001")))
+		require.NoError(t, b.Put([]byte("bar"), []byte("0002")))
+		_, err = b.CreateBucket([]byte("csubbucket"))
+		require.NoError(t, err)
+		verifyReads(b)
+
+		empty, err := tx.CreateBucket([]byte("empty"))
+		require.NoError(t, err)
+		require.NoError(t, empty.ForEachReverse(func(k, v []byte) error {
+			t.Fatal("unexpected key")
+			return nil
+		}))
+		return nil
+	})
+	require.NoError(t, err)
+	err = db.View(func(tx *bolt.Tx) error {
+		verifyReads(tx.Bucket([]byte("widgets")))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
 func TestBucket_ForEachBucket_NoBuckets(t *testing.T) {
 	db := btesting.MustCreateDB(t)
 
@@ -1141,6 +1725,117 @@ func TestBucket_ForEach_ShortCircuit(t *testing.T) {
 	}
 }
 
+// Ensure that looping over a prefix stops at the first key without it.
+func TestBucket_ForEachPrefix(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for _, k := range []string{"a", "user/1", "user/2", "user/3", "userx", "z"} {
+			require.NoError(t, b.Put([]byte(k), []byte("v-"+k)))
+		}
+		_, err = b.CreateBucket([]byte("user/4"))
+		require.NoError(t, err)
+
+		var keys []string
+		require.NoError(t, b.ForEachPrefix([]byte("user/"), func(k, v []byte) error {
+			keys = append(keys, string(k)+"="+string(v))
+			return nil
+		}))
+		require.Equal(t, []string{"user/1=v-user/1", "user/2=v-user/2", "user/3=v-user/3", "user/4="}, keys)
+
+		// ErrStopIteration stops without an error.
+		keys = nil
+		require.NoError(t, b.ForEachPrefix([]byte("user/"), func(k, v []byte) error {
+			keys = append(keys, string(k))
+			if len(keys) == 2 {
+				return berrors.ErrStopIteration
+			}
+			return nil
+		}))
+		require.Equal(t, []string{"user/1", "user/2"}, keys)
+
+		// Other errors are returned.
+		marker := errors.New("marker")
+		require.ErrorIs(t, b.ForEachPrefix([]byte("user/"), func(k, v []byte) error {
+			return marker
+		}), marker)
+
+		// An empty prefix matches every key, a missing one none.
+		var n int
+		require.NoError(t, b.ForEachPrefix(nil, func(k, v []byte) error {
+			n++
+			return nil
+		}))
+		require.Equal(t, 7, n)
+		require.NoError(t, b.ForEachPrefix([]byte("missing"), func(k, v []byte) error {
+			t.Fatalf("unexpected key: %s", k)
+			return nil
+		}))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+// Ensure that looping over a range visits its keys in both directions.
+func TestBucket_ForEachRange(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for _, k := range []string{"b", "c", "d", "e"} {
+			require.NoError(t, b.Put([]byte(k), []byte("v-"+k)))
+		}
+
+		forEachRange := func(start, end string, reverse bool) []string {
+			var s, e []byte
+			if start != "" {
+				s = []byte(start)
+			}
+			if end != "" {
+				e = []byte(end)
+			}
+			var keys []string
+			require.NoError(t, b.ForEachRange(s, e, reverse, func(k, v []byte) error {
+				require.Equal(t, "v-"+string(k), string(v))
+				keys = append(keys, string(k))
+				return nil
+			}))
+			return keys
+		}
+		for _, tc := range []struct {
+			start, end string
+			want       []string
+		}{
+			{"", "", []string{"b", "c", "d", "e"}},
+			{"c", "e", []string{"c", "d"}},
+			{"a", "cc", []string{"b", "c"}},
+			{"cc", "", []string{"d", "e"}},
+			{"", "c", []string{"b"}},
+			{"c", "c", nil},
+			{"f", "", nil},
+			{"", "a", nil},
+		} {
+			require.Equal(t, tc.want, forEachRange(tc.start, tc.end, false), "%q-%q", tc.start, tc.end)
+			var reversed []string
+			for i := len(tc.want) - 1; i >= 0; i-- {
+				reversed = append(reversed, tc.want[i])
+			}
+			require.Equal(t, reversed, forEachRange(tc.start, tc.end, true), "%q-%q reversed", tc.start, tc.end)
+		}
+
+		// ErrStopIteration stops without an error.
+		var keys []string
+		require.NoError(t, b.ForEachRange(nil, nil, true, func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return berrors.ErrStopIteration
+		}))
+		require.Equal(t, []string{"e"}, keys)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
 // Ensure that looping over a bucket on a closed database returns an error.
 func TestBucket_ForEach_Closed(t *testing.T) {
 	db := btesting.MustCreateDB(t)
@@ -1522,6 +2217,55 @@ func TestBucket_Stats_EmptyBucket(t *testing.T) {
 	}
 }
 
+// Ensure Options.MaxInlineBucketSize sets the size up to which buckets are
+// inlined.
+func TestBucket_MaxInlineBucketSize(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		max     int
+		size    int
+		inlined bool
+	}{
+		{name: "default", size: 2000, inlined: false},
+		{name: "raised", max: 3000, size: 2000, inlined: true},
+		{name: "capped", max: 1 << 20, size: 5000, inlined: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096, MaxInlineBucketSize: tc.max})
+			err := db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucket([]byte("widgets"))
+				if err != nil {
+					return err
+				}
+				for i := 0; i < 10; i++ {
+					nested, err := b.CreateBucket([]byte(fmt.Sprintf("%02d", i)))
+					if err != nil {
+						return err
+					}
+					if err := nested.Put([]byte("key"), make([]byte, tc.size)); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			require.NoError(t, err)
+			db.MustCheck()
+
+			err = db.View(func(tx *bolt.Tx) error {
+				stats := tx.Bucket([]byte("widgets")).Stats()
+				if tc.inlined {
+					require.Equal(t, 10, stats.InlineBucketN)
+				} else {
+					require.Equal(t, 0, stats.InlineBucketN)
+				}
+				require.Equal(t, make([]byte, tc.size), tx.Bucket([]byte("widgets")).Bucket([]byte("07")).Get([]byte("key")))
+				return nil
+			})
+			require.NoError(t, err)
+		})
+	}
+}
+
 // Ensure a bucket can calculate stats.
 func TestBucket_Stats_Nested(t *testing.T) {
 	db := btesting.MustCreateDB(t)
@@ -1586,12 +2330,13 @@ func TestBucket_Stats_Nested(t *testing.T) {
 		foo := 16            // foo (pghdr)
 		foo += 101 * 16      // foo leaf elements
 		foo += 100*2 + 100*2 // foo leaf key/values
-		foo += 3 + 16        // foo -> bar key/value
+		foo += 3 + 16 + 40   // foo -> bar key/value (header and extension)
 
 		bar := 16      // bar (pghdr)
 		bar += 11 * 16 // bar leaf elements
 		bar += 10 + 10 // bar leaf key/values
 		bar += 3 + 16  // bar -> baz key/value
+		bar += 40      // bar -> baz header extension, after the inline page
 
 		baz := 16      // baz (inline) (pghdr)
 		baz += 10 * 16 // baz leaf elements
@@ -1623,6 +2368,88 @@ func TestBucket_Stats_Nested(t *testing.T) {
 	}
 }
 
+// Ensure a bucket can break its stats down by nested bucket, and that the
+// cached stats of a changed bucket are computed again.
+func TestBucket_StatsBreakdown(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	put := func(b *bolt.Bucket, n int, value string) {
+		for i := 0; i < n; i++ {
+			require.NoError(t, b.Put([]byte(fmt.Sprintf("%04d", i)), []byte(value)))
+		}
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		put(b, 50, "value")
+		for _, name := range []string{"a", "b"} {
+			child, err := b.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			put(child, 1000, "value")
+		}
+		inline, err := b.CreateBucket([]byte("inline"))
+		if err != nil {
+			return err
+		}
+		put(inline, 2, "value")
+		return nil
+	})
+	require.NoError(t, err)
+
+	breakdown := func() (bolt.BucketStats, map[string]bolt.BucketStats) {
+		var total bolt.BucketStats
+		var children map[string]bolt.BucketStats
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			total, children = b.StatsBreakdown()
+			require.Equal(t, total, b.Stats())
+			for name, s := range children {
+				require.Equal(t, b.Bucket([]byte(name)).Stats(), s)
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		return total, children
+	}
+
+	total, children := breakdown()
+	require.Len(t, children, 3)
+	keyN, bucketN := 53, 1
+	for _, s := range children {
+		keyN += s.KeyN
+		bucketN += s.BucketN
+	}
+	require.Equal(t, keyN, total.KeyN)
+	require.Equal(t, bucketN, total.BucketN)
+	require.Equal(t, 1000, children["a"].KeyN)
+	require.Equal(t, 1, children["inline"].InlineBucketN)
+
+	// Only the stats of the changed bucket change.
+	err = db.Update(func(tx *bolt.Tx) error {
+		put(tx.Bucket([]byte("widgets")).Bucket([]byte("a")), 2000, "changed value")
+		return nil
+	})
+	require.NoError(t, err)
+	total2, children2 := breakdown()
+	require.Equal(t, 2000, children2["a"].KeyN)
+	require.Greater(t, children2["a"].LeafInuse, children["a"].LeafInuse)
+	require.Equal(t, children["b"], children2["b"])
+	require.Equal(t, children["inline"], children2["inline"])
+	require.Equal(t, total.KeyN+1000, total2.KeyN)
+
+	// A deleted key changes the stats of its bucket once committed.
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Bucket([]byte("b")).Delete([]byte("0000"))
+	})
+	require.NoError(t, err)
+	_, children3 := breakdown()
+	require.Equal(t, 999, children3["b"].KeyN)
+	require.Equal(t, children2["a"], children3["a"])
+}
+
 // Ensure a large bucket can calculate stats.
 func TestBucket_Stats_Large(t *testing.T) {
 	if testing.Short() {
@@ -0,0 +1,85 @@
+package boltdb
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// ConflictError is returned by Bucket.PutIf when the current value of the
+// key isn't the expected one. It matches errors.ErrConflict.
+type ConflictError struct {
+	// Key is the key which was put.
+	Key []byte
+
+	// Current is a copy of the current value of the key, or nil if the key
+	// doesn't exist.
+	Current []byte
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("value conflict on key %q", e.Key)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return errors.ErrConflict
+}
+
+// PutIf sets the value for a key in the bucket like Put, only if its current
+// value, as returned by Get, is expectedOld, or if the key doesn't exist and
+// expectedOld is nil. Otherwise, it returns a *ConflictError holding the
+// current value, so that the caller can retry, e.g. in a function passed to
+// DB.Batch, without reading and checking the value itself.
+// Returns an error if the key is a nested bucket, or like Put.
+func (b *Bucket) PutIf(key, newValue, expectedOld []byte) (err error) {
+	defer b.tx.catch(&err)
+	if b.tx.db == nil {
+		return errors.ErrTxClosed
+	} else if !b.Writable() {
+		return errors.ErrTxNotWritable
+	}
+
+	v, flags, ok := b.getRaw(key)
+	if ok && (flags&common.BucketLeafFlag) != 0 {
+		return errors.ErrIncompatibleValue
+	}
+	if ok && (flags&common.ExpiringLeafFlag) != 0 {
+		if expired(v, time.Now().UnixNano()) {
+			ok = false
+		} else {
+			v = v[expiryLen:]
+		}
+	}
+
+	switch {
+	case !ok && expectedOld == nil:
+	case !ok:
+		return &ConflictError{Key: cloneBytes(key)}
+	case expectedOld == nil || !b.valueEqual(v, flags, expectedOld):
+		return &ConflictError{Key: cloneBytes(key), Current: cloneBytes(b.value(v, flags))}
+	}
+	return b.Put(key, newValue)
+}
+
+// valueEqual returns whether a stored value is equal to expected. Streamed
+// values are compared chunk by chunk, without copying them.
+func (b *Bucket) valueEqual(v []byte, flags uint32, expected []byte) bool {
+	if (flags & common.BlobLeafFlag) == 0 {
+		return bytes.Equal(b.value(v, flags), expected)
+	}
+	r := b.tx.blobReader(v)
+	if r.Size() != int64(len(expected)) {
+		return false
+	}
+	for !r.done() {
+		r.nextChunk()
+		if !bytes.HasPrefix(expected, r.buf) {
+			return false
+		}
+		expected = expected[len(r.buf):]
+	}
+	return true
+}
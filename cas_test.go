@@ -0,0 +1,125 @@
+package boltdb_test
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestBucket_PutIf(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		_, err = b.CreateBucket([]byte("nested"))
+		require.NoError(t, err)
+
+		// A nil expected value requires the key not to exist.
+		require.NoError(t, b.PutIf([]byte("key"), []byte("v1"), nil))
+		err = b.PutIf([]byte("key"), []byte("v2"), nil)
+		require.ErrorIs(t, err, berrors.ErrConflict)
+		var conflict *bolt.ConflictError
+		require.True(t, errors.As(err, &conflict))
+		require.Equal(t, []byte("key"), conflict.Key)
+		require.Equal(t, []byte("v1"), conflict.Current)
+
+		require.NoError(t, b.PutIf([]byte("key"), []byte("v2"), []byte("v1")))
+		err = b.PutIf([]byte("key"), []byte("v3"), []byte("v1"))
+		require.True(t, errors.As(err, &conflict))
+		require.Equal(t, []byte("v2"), conflict.Current)
+		require.Equal(t, []byte("v2"), b.Get([]byte("key")))
+
+		err = b.PutIf([]byte("missing"), []byte("v1"), []byte("v0"))
+		require.True(t, errors.As(err, &conflict))
+		require.Nil(t, conflict.Current)
+
+		// Expired keys don't exist.
+		require.NoError(t, b.PutWithTTL([]byte("ttl"), []byte("v1"), time.Millisecond))
+		time.Sleep(5 * time.Millisecond)
+		require.ErrorIs(t, b.PutIf([]byte("ttl"), []byte("v2"), []byte("v1")), berrors.ErrConflict)
+		require.NoError(t, b.PutIf([]byte("ttl"), []byte("v2"), nil))
+
+		// Streamed values are compared too.
+		value := largeValue(3<<20, 6)
+		mustPutReader(t, b, "big", value)
+		require.ErrorIs(t, b.PutIf([]byte("big"), []byte("v1"), value[1:]), berrors.ErrConflict)
+		other := append([]byte(nil), value...)
+		other[len(other)-1]++
+		require.ErrorIs(t, b.PutIf([]byte("big"), []byte("v1"), other), berrors.ErrConflict)
+		require.NoError(t, b.PutIf([]byte("big"), []byte("v1"), value))
+		require.Equal(t, []byte("v1"), b.Get([]byte("big")))
+
+		require.ErrorIs(t, b.PutIf([]byte("nested"), []byte("v1"), nil), berrors.ErrIncompatibleValue)
+		return nil
+	})
+	require.NoError(t, err)
+	db.MustCheck()
+}
+
+// Ensure PutIf makes concurrent read-modify-write cycles through Batch safe.
+func TestBucket_PutIf_Batch(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("counter"), []byte("0"))
+	})
+	require.NoError(t, err)
+
+	const workers, increments = 10, 10
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var current []byte
+			err := db.View(func(tx *bolt.Tx) error {
+				current = append([]byte(nil), tx.Bucket([]byte("widgets")).Get([]byte("counter"))...)
+				return nil
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+			for n := 0; n < increments; {
+				v, err := strconv.Atoi(string(current))
+				if !assert.NoError(t, err) {
+					return
+				}
+				next := []byte(strconv.Itoa(v + 1))
+				err = db.Batch(func(tx *bolt.Tx) error {
+					return tx.Bucket([]byte("widgets")).PutIf([]byte("counter"), next, current)
+				})
+				var conflict *bolt.ConflictError
+				if errors.As(err, &conflict) {
+					current = conflict.Current
+					continue
+				}
+				if !assert.NoError(t, err) {
+					return
+				}
+				current = next
+				n++
+			}
+		}()
+	}
+	wg.Wait()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, []byte(strconv.Itoa(workers*increments)), tx.Bucket([]byte("widgets")).Get([]byte("counter")))
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,50 @@
+package boltdb
+
+import (
+	"github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// CloneBucket copies the top-level bucket name to a new top-level bucket
+// newName, see Bucket.CloneBucket.
+func (tx *Tx) CloneBucket(name, newName []byte) error {
+	return tx.root.CloneBucket(name, newName)
+}
+
+// CloneBucket copies the nested bucket name of the bucket, with its keys,
+// sequence and nested buckets, to a new nested bucket newName of the same
+// bucket, e.g. to take a snapshot of it before changing it. Like
+// CopyBucketTo, the pages of the bucket are copied as they are rather than
+// putting the keys one by one, unless the bucket was changed by the
+// transaction. The pages aren't shared by the copies, since the freelist
+// doesn't count the references to a page.
+//
+// The indexes declared for newName, if it's a top-level bucket, aren't
+// updated, see Tx.RebuildIndex.
+// Returns an error if the bucket doesn't exist, if newName exists, or if the
+// bucket was created from a read-only transaction.
+func (b *Bucket) CloneBucket(name, newName []byte) (err error) {
+	defer b.tx.catch(&err)
+	if b.tx.db == nil {
+		return errors.ErrTxClosed
+	} else if !b.tx.writable {
+		return errors.ErrTxNotWritable
+	} else if len(newName) == 0 {
+		return errors.ErrBucketNameRequired
+	}
+
+	_, v, flags, ok := b.seekBucketValue(name)
+	if !ok {
+		return errors.ErrBucketNotFound
+	} else if (flags & common.BucketLeafFlag) == 0 {
+		return errors.ErrIncompatibleValue
+	}
+	if _, _, nflags, ok := b.seekBucketValue(newName); ok {
+		if (nflags & common.BucketLeafFlag) != 0 {
+			return errors.ErrBucketExists
+		}
+		return errors.ErrIncompatibleValue
+	}
+
+	return b.copyBucket(name, v, b, newName)
+}
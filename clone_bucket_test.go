@@ -0,0 +1,92 @@
+package boltdb_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestBucket_CloneBucket(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options bolt.Options
+	}{
+		{name: "default"},
+		{name: "max dirty bytes", options: bolt.Options{MaxDirtyBytes: 64 * 1024}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &tc.options)
+			mustFillTenants(t, db)
+
+			var want []string
+			err := db.Update(func(tx *bolt.Tx) error {
+				tenants := tx.Bucket([]byte("tenants"))
+				want = dumpBucket(t, tenants.Bucket([]byte("large")))
+				require.NoError(t, tenants.CloneBucket([]byte("large"), []byte("large-copy")))
+				require.Equal(t, want, dumpBucket(t, tenants.Bucket([]byte("large-copy"))))
+				require.Equal(t, 3, tenants.Count())
+
+				// The source was changed, so its keys are copied one by one.
+				require.NoError(t, tenants.Bucket([]byte("small")).Put([]byte("baz"), []byte("qux")))
+				require.NoError(t, tx.CloneBucket([]byte("tenants"), []byte("tenants-copy")))
+				require.Equal(t, dumpBucket(t, tenants), dumpBucket(t, tx.Bucket([]byte("tenants-copy"))))
+				return nil
+			})
+			require.NoError(t, err)
+			db.MustCheck()
+
+			// The copies are independent of each other.
+			err = db.Update(func(tx *bolt.Tx) error {
+				tenants := tx.Bucket([]byte("tenants"))
+				large := tenants.Bucket([]byte("large-copy"))
+				for i := 0; i < 5000; i += 2 {
+					if err := large.Delete([]byte(fmt.Sprintf("%05d", i))); err != nil {
+						return err
+					}
+				}
+				return large.Bucket([]byte("nested")).Put([]byte("new"), []byte("value"))
+			})
+			require.NoError(t, err)
+			db.MustCheck()
+
+			db.MustClose()
+			db.MustReopen()
+			err = db.View(func(tx *bolt.Tx) error {
+				tenants := tx.Bucket([]byte("tenants"))
+				require.Equal(t, want, dumpBucket(t, tenants.Bucket([]byte("large"))))
+				require.Equal(t, want, dumpBucket(t, tx.Bucket([]byte("tenants-copy")).Bucket([]byte("large"))))
+				require.Equal(t, 2504, tenants.Bucket([]byte("large-copy")).Count())
+				return nil
+			})
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestBucket_CloneBucket_Errors(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	mustFillTenants(t, db)
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		tenants := tx.Bucket([]byte("tenants"))
+		require.ErrorIs(t, tx.CloneBucket([]byte("missing"), []byte("copy")), berrors.ErrBucketNotFound)
+		require.ErrorIs(t, tx.CloneBucket([]byte("tenants"), nil), berrors.ErrBucketNameRequired)
+		require.ErrorIs(t, tenants.CloneBucket([]byte("large"), []byte("small")), berrors.ErrBucketExists)
+		require.ErrorIs(t, tenants.Bucket([]byte("small")).CloneBucket([]byte("foo"), []byte("bar")), berrors.ErrIncompatibleValue)
+		require.NoError(t, tenants.Put([]byte("key"), []byte("value")))
+		require.ErrorIs(t, tenants.CloneBucket([]byte("small"), []byte("key")), berrors.ErrIncompatibleValue)
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		require.ErrorIs(t, tx.CloneBucket([]byte("tenants"), []byte("copy")), berrors.ErrTxNotWritable)
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -141,6 +141,8 @@ func (m *Main) Run(args ...string) error {
 		return newPageCommand(m).Run(args[1:]...)
 	case "pages":
 		return newPagesCommand(m).Run(args[1:]...)
+	case "sizes":
+		return newSizesCommand(m).Run(args[1:]...)
 	case "stats":
 		return newStatsCommand(m).Run(args[1:]...)
 	default:
@@ -172,6 +174,7 @@ The commands are:
     page        print one or more pages in human readable format
     pages       print list of pages with their types
     page-item   print the key and value of a page item.
+    sizes       print the distribution of the key and value sizes of buckets
     stats       iterate over all pages and generate usage stats
     surgery     perform surgery on boltdb database
 
@@ -896,6 +899,111 @@ Print a list of buckets.
 `, "\n")
 }
 
+// sizesCommand represents the "sizes" command execution.
+type sizesCommand struct {
+	baseCommand
+}
+
+// newSizesCommand returns a sizesCommand.
+func newSizesCommand(m *Main) *sizesCommand {
+	c := &sizesCommand{}
+	c.baseCommand = m.baseCommand
+	return c
+}
+
+// Run executes the command.
+func (cmd *sizesCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	}
+
+	// Require database path.
+	path, buckets := fs.Arg(0), fs.Args()
+	if path == "" {
+		return ErrPathRequired
+	} else if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrFileNotFound
+	}
+	buckets = buckets[1:]
+
+	// Open database.
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		// Print the sizes of each top-level bucket without a bucket path.
+		if len(buckets) == 0 {
+			return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				cmd.printSizeStats(string(name), b.SizeStats())
+				return nil
+			})
+		}
+
+		// Find bucket.
+		b := tx.Bucket([]byte(buckets[0]))
+		if b == nil {
+			return berrors.ErrBucketNotFound
+		}
+		for _, bucket := range buckets[1:] {
+			b = b.Bucket([]byte(bucket))
+			if b == nil {
+				return berrors.ErrBucketNotFound
+			}
+		}
+		cmd.printSizeStats(strings.Join(buckets, "/"), b.SizeStats())
+		return nil
+	})
+}
+
+// printSizeStats prints the size stats of a bucket.
+func (cmd *sizesCommand) printSizeStats(name string, s bolt.SizeStats) {
+	fmt.Fprintf(cmd.Stdout, "Sizes of bucket %s\n", name)
+	cmd.printHistogram("Key sizes", &s.KeySizes)
+	cmd.printHistogram("Value sizes", &s.ValueSizes)
+	cmd.printHistogram("Stored value sizes", &s.StoredValueSizes)
+	fmt.Fprintf(cmd.Stdout, "Number of pages of streamed values: %d\n", s.BlobPageN)
+	cmd.printHistogram("Overflow pages per leaf page", &s.LeafOverflow)
+	fmt.Fprintln(cmd.Stdout)
+}
+
+// printHistogram prints the summary and the non-empty buckets of a histogram.
+func (cmd *sizesCommand) printHistogram(title string, h *bolt.SizeHistogram) {
+	fmt.Fprintln(cmd.Stdout, title)
+	fmt.Fprintf(cmd.Stdout, "\tcount %d, total %d, min %d, mean %.1f, p50 %d, p99 %d, max %d\n",
+		h.N, h.Total, h.Min, h.Mean(), h.Percentile(50), h.Percentile(99), h.Max)
+	for i, n := range h.Counts {
+		if n == 0 {
+			continue
+		}
+		var lo int64
+		if i > 0 {
+			lo = bolt.SizeHistogramBound(i-1) + 1
+		}
+		fmt.Fprintf(cmd.Stdout, "\t%d - %d: %d\n", lo, bolt.SizeHistogramBound(i), n)
+	}
+}
+
+// Usage returns the help message.
+func (cmd *sizesCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt sizes PATH [BUCKET...]
+
+Sizes prints the distribution of the sizes of the keys and values of the
+given (sub)bucket, or of each top-level bucket if none is given, along with
+the number of overflow pages of its leaf pages. The sizes are counted by
+powers of two. The keys and values of nested buckets aren't included.
+`, "\n")
+}
+
 // keysCommand represents the "keys" command execution.
 type keysCommand struct {
 	baseCommand
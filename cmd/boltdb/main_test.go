@@ -385,6 +385,49 @@ func TestKeysCommand_Run(t *testing.T) {
 	}
 }
 
+// Ensure the "sizes" command prints the distribution of the sizes of a bucket.
+func TestSizesCommand_Run(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("foo"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 10; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("key-%d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		_, err = b.CreateBucket([]byte("nested"))
+		return err
+	})
+	require.NoError(t, err)
+	db.Close()
+
+	defer requireDBNoChange(t, dbData(t, db.Path()), db.Path())
+
+	m := NewMain()
+	require.NoError(t, m.Run("sizes", db.Path(), "foo"))
+	exp := "Sizes of bucket foo\n" +
+		"Key sizes\n" +
+		"\tcount 10, total 50, min 5, mean 5.0, p50 5, p99 5, max 5\n" +
+		"\t4 - 7: 10\n" +
+		"Value sizes\n" +
+		"\tcount 10, total 1000, min 100, mean 100.0, p50 100, p99 100, max 100\n" +
+		"\t64 - 127: 10\n" +
+		"Stored value sizes\n" +
+		"\tcount 10, total 1000, min 100, mean 100.0, p50 100, p99 100, max 100\n" +
+		"\t64 - 127: 10\n" +
+		"Number of pages of streamed values: 0\n" +
+		"Overflow pages per leaf page\n" +
+		"\tcount 1, total 0, min 0, mean 0.0, p50 0, p99 0, max 0\n" +
+		"\t0 - 0: 1\n\n"
+	require.Equal(t, exp, m.Stdout.String())
+
+	m = NewMain()
+	require.Error(t, m.Run("sizes", db.Path(), "bar"))
+}
+
 // Ensure the "get" command can print the value of a key in a bucket.
 func TestGetCommand_Run(t *testing.T) {
 	testCases := []struct {
@@ -127,7 +127,14 @@ func (cmd *pageCommand) printPage(path string, pageID uint64, formatValue string
 	case "branch":
 		err = cmd.PrintBranch(cmd.Stdout, buf)
 	case "freelist":
-		err = cmd.PrintFreelist(cmd.Stdout, buf)
+		var m *common.Meta
+		if m, err = readMetaPage(path); err == nil {
+			if m.HasFreelistSegments() && p.Id() == m.Freelist() {
+				err = cmd.PrintFreelistSegments(cmd.Stdout, buf)
+			} else {
+				err = cmd.PrintFreelist(cmd.Stdout, buf, m.HasFreelistSpans())
+			}
+		}
 	}
 	if err != nil {
 		return 0, err
@@ -207,8 +214,9 @@ func (cmd *pageCommand) PrintBranch(w io.Writer, buf []byte) error {
 	return nil
 }
 
-// PrintFreelist prints the data for a freelist page.
-func (cmd *pageCommand) PrintFreelist(w io.Writer, buf []byte) error {
+// PrintFreelist prints the data for a freelist page, which stores spans of
+// free pages if spans is set.
+func (cmd *pageCommand) PrintFreelist(w io.Writer, buf []byte, spans bool) error {
 	p := common.LoadPage(buf)
 
 	// Print number of items.
@@ -218,6 +226,16 @@ func (cmd *pageCommand) PrintFreelist(w io.Writer, buf []byte) error {
 
 	fmt.Fprintf(w, "\n")
 
+	// Print each span in the freelist.
+	if spans {
+		spans := p.FreelistPagePairs()
+		for i := 0; i < len(spans); i += 2 {
+			fmt.Fprintf(w, "%d-%d\n", spans[i], spans[i]+spans[i+1]-1)
+		}
+		fmt.Fprintf(w, "\n")
+		return nil
+	}
+
 	// Print each page in the freelist.
 	ids := p.FreelistPageIds()
 	for _, ids := range ids {
@@ -227,6 +245,27 @@ func (cmd *pageCommand) PrintFreelist(w io.Writer, buf []byte) error {
 	return nil
 }
 
+// PrintFreelistSegments prints the data for a freelist page which lists the
+// pages of the segments of the freelist.
+func (cmd *pageCommand) PrintFreelistSegments(w io.Writer, buf []byte) error {
+	p := common.LoadPage(buf)
+
+	// Print number of segments.
+	_, cnt := p.FreelistPageCount()
+	fmt.Fprintf(w, "Segment Count: %d\n", cnt)
+	fmt.Fprintf(w, "Overflow: %d\n", p.Overflow())
+
+	fmt.Fprintf(w, "\n")
+
+	// Print the page of each segment.
+	segments := p.FreelistPagePairs()
+	for i := 0; i < len(segments); i += 2 {
+		fmt.Fprintf(w, "segment %d: page %d\n", segments[i], segments[i+1])
+	}
+	fmt.Fprintf(w, "\n")
+	return nil
+}
+
 // PrintPage prints a given page as hexadecimal.
 func (cmd *pageCommand) PrintPage(w io.Writer, r io.ReaderAt, pageID int, pageSize int) error {
 	const bytesPerLineN = 16
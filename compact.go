@@ -1,5 +1,11 @@
 package boltdb
 
+import (
+	"time"
+
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
 // Compact will create a copy of the source DB and in the destination DB. This may
 // reclaim space that the source database no longer has use for. txMaxSize can be
 // used to limit the transactions size of this process and may trigger intermittent
@@ -18,7 +24,7 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 		}
 	}()
 
-	if err := walk(src, func(keys [][]byte, k, v []byte, seq uint64) error {
+	if err := walk(src, func(keys [][]byte, k, v []byte, src *Bucket, expires int64) error {
 		// On each key/value, check if we have exceeded tx size.
 		sz := int64(len(k) + len(v))
 		if size+sz > txMaxSize && txMaxSize != 0 {
@@ -43,10 +49,7 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 			if err != nil {
 				return err
 			}
-			if err := bkt.SetSequence(seq); err != nil {
-				return err
-			}
-			return nil
+			return bkt.copySettings(src)
 		}
 
 		// Create buckets on subsequent levels, if necessary.
@@ -66,13 +69,13 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 			if err != nil {
 				return err
 			}
-			if err := bkt.SetSequence(seq); err != nil {
-				return err
-			}
-			return nil
+			return bkt.copySettings(src)
 		}
 
-		// Otherwise treat it as a key/value pair.
+		// Otherwise treat it as a key/value pair, keeping its TTL.
+		if expires != 0 {
+			return b.putExpiring(k, v, expires)
+		}
 		return b.Put(k, v)
 	}); err != nil {
 		return err
@@ -84,21 +87,22 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 
 // walkFunc is the type of the function called for keys (buckets and "normal"
 // values) discovered by Walk. keys is the list of keys to descend to the bucket
-// owning the discovered key/value pair k/v.
-type walkFunc func(keys [][]byte, k, v []byte, seq uint64) error
+// owning the discovered key/value pair k/v. b is the bucket k if v is nil.
+// expires is the expiry of a value put with a TTL, in Unix nanoseconds, or 0.
+type walkFunc func(keys [][]byte, k, v []byte, b *Bucket, expires int64) error
 
 // walk walks recursively the bolt database db, calling walkFn for each key it finds.
 func walk(db *DB, walkFn walkFunc) error {
 	return db.View(func(tx *Tx) error {
 		return tx.ForEach(func(name []byte, b *Bucket) error {
-			return walkBucket(b, nil, name, nil, b.Sequence(), walkFn)
+			return walkBucket(b, nil, name, nil, 0, walkFn)
 		})
 	})
 }
 
-func walkBucket(b *Bucket, keypath [][]byte, k, v []byte, seq uint64, fn walkFunc) error {
+func walkBucket(b *Bucket, keypath [][]byte, k, v []byte, expires int64, fn walkFunc) error {
 	// Execute callback.
-	if err := fn(keypath, k, v, seq); err != nil {
+	if err := fn(keypath, k, v, b, expires); err != nil {
 		return err
 	}
 
@@ -107,13 +111,26 @@ func walkBucket(b *Bucket, keypath [][]byte, k, v []byte, seq uint64, fn walkFun
 		return nil
 	}
 
-	// Iterate over each child key/value.
+	// Iterate over each child key/value, skipping the expired ones.
 	keypath = append(keypath, k)
-	return b.ForEach(func(k, v []byte) error {
-		if v == nil {
-			bkt := b.Bucket(k)
-			return walkBucket(bkt, keypath, k, nil, bkt.Sequence(), fn)
+	now := time.Now().UnixNano()
+	c := b.Cursor()
+	for k, v, flags := c.first(); k != nil; k, v, flags = c.next() {
+		var err error
+		switch {
+		case (flags & common.BucketLeafFlag) != 0:
+			err = walkBucket(b.Bucket(k), keypath, k, nil, 0, fn)
+		case (flags & common.ExpiringLeafFlag) != 0:
+			if expired(v, now) {
+				continue
+			}
+			err = walkBucket(b, keypath, k, v[expiryLen:], expiry(v), fn)
+		default:
+			err = walkBucket(b, keypath, k, b.value(v, flags), 0, fn)
 		}
-		return walkBucket(b, keypath, k, v, b.Sequence(), fn)
-	})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,314 @@
+package boltdb
+
+import (
+	"bytes"
+	"compress/flate"
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+const (
+	// recompressBatchSize is the number of values SetCompressionDict reads
+	// before compressing them again.
+	recompressBatchSize = 1000
+
+	// dictKmerSize is the length of the substrings TrainCompressionDict
+	// counts across the samples.
+	dictKmerSize = 8
+
+	// dictSegmentSize is the length of the segments of the samples which
+	// TrainCompressionDict picks for the dictionary.
+	dictSegmentSize = 64
+)
+
+// inflaters pools the decompressors of the compressed values.
+var inflaters sync.Pool
+
+// CompressionDict returns the compression dictionary of the bucket, or nil if
+// it has none. The returned value is only valid for the life of the
+// transaction and must not be modified.
+func (b *Bucket) CompressionDict() []byte {
+	return b.dict
+}
+
+// SetCompressionDict sets the compression dictionary of the bucket, e.g. one
+// returned by TrainCompressionDict. Once a bucket has a dictionary, the
+// values put with Put are compressed with DEFLATE using it, and stored
+// compressed if that makes them smaller, which pays off for many small and
+// similar values, like JSON documents, which compress poorly on their own.
+// Values put with a TTL or streamed with PutReader aren't compressed. Get,
+// cursors and indexes see the uncompressed values, which are copies.
+//
+// The dictionary is stored in the bucket header, so it's read whenever the
+// bucket is opened and should stay small. The values compressed with the
+// previous dictionary are compressed again, which rewrites them. A nil or
+// empty dict removes the dictionary and stores the values uncompressed,
+// except the large ones with Options.CompressLargeValues.
+// Returns an error if dict is larger than MaxCompressionDictSize.
+func (b *Bucket) SetCompressionDict(dict []byte) (err error) {
+	defer b.tx.catch(&err)
+	if b.tx.db == nil {
+		return errors.ErrTxClosed
+	} else if !b.Writable() {
+		return errors.ErrTxNotWritable
+	} else if len(dict) > MaxCompressionDictSize {
+		return errors.ErrCompressionDictTooLarge
+	}
+	if bytes.Equal(dict, b.dict) {
+		return nil
+	}
+
+	// Materialize the root node if it hasn't been already so that the
+	// bucket will be saved during commit.
+	if b.rootNode == nil {
+		_ = b.node(b.RootPage(), nil)
+	}
+
+	old := b.dict
+	if len(dict) == 0 {
+		b.dict = nil
+	} else {
+		b.dict = cloneBytes(dict)
+	}
+	b.deflater = nil
+	b.ext.SetDictSize(len(b.dict))
+	if !b.ext.HasCompressed() {
+		return nil
+	}
+	b.ext.SetHasCompressed(false)
+	return b.recompress(old)
+}
+
+// recompress compresses the values compressed with the old dictionary again
+// with the dictionary of the bucket. The values are read in batches, since
+// writing them may write out the dirty pages under the cursor. They're
+// written without updating the indexes, as they don't change.
+func (b *Bucket) recompress(old []byte) error {
+	var keys, values [][]byte
+	var next []byte
+	for {
+		keys, values = keys[:0], values[:0]
+		c := b.Cursor()
+		var k, v []byte
+		var flags uint32
+		if next == nil {
+			k, v, flags = c.first()
+		} else {
+			k, v, flags = c.seek(next)
+			if ref := &c.stack[len(c.stack)-1]; ref.index >= ref.count() {
+				k, v, flags = c.next()
+			}
+		}
+		for ; k != nil && len(keys) < recompressBatchSize; k, v, flags = c.next() {
+			if (flags & common.CompressedLeafFlag) != 0 {
+				keys = append(keys, cloneBytes(k))
+				values = append(values, decompress(v, old))
+			}
+		}
+		next = cloneBytes(k)
+
+		for i, key := range keys {
+			value, flags := values[i], uint32(0)
+			if b.compressible(value) {
+				value, flags = b.compress(value)
+			}
+			c := b.Cursor()
+			c.seek(key)
+			c.node().put(key, key, value, 0, flags)
+			if err := b.tx.dirty(int(common.LeafPageElementSize) + len(key) + len(value)); err != nil {
+				return err
+			}
+		}
+		if k == nil {
+			return nil
+		}
+	}
+}
+
+// compressible returns whether a value put in the bucket is compressed: all
+// of them once the bucket has a dictionary, or the ones of at least a page
+// with Options.CompressLargeValues.
+func (b *Bucket) compressible(value []byte) bool {
+	return b.dict != nil || (b.tx.db.compressLargeValues && len(value) >= b.tx.db.pageSize)
+}
+
+// compress compresses a value with the dictionary of the bucket, if any, and
+// returns the value to store with its leaf flags: the value itself if
+// compressing it doesn't make it smaller.
+func (b *Bucket) compress(value []byte) ([]byte, uint32) {
+	var buf bytes.Buffer
+	buf.Grow(binary.MaxVarintLen64 + len(value))
+	var hdr [binary.MaxVarintLen64]byte
+	buf.Write(hdr[:binary.PutUvarint(hdr[:], uint64(len(value)))])
+
+	// The compressor keeps its dictionary when it's reset.
+	if b.deflater == nil {
+		w, err := flate.NewWriterDict(&buf, flate.DefaultCompression, b.dict)
+		common.Assert(err == nil, "new compressor: %v", err)
+		b.deflater = w
+	} else {
+		b.deflater.Reset(&buf)
+	}
+	// Writing to a bytes.Buffer doesn't fail.
+	_, _ = b.deflater.Write(value)
+	_ = b.deflater.Close()
+
+	if buf.Len() >= len(value) {
+		return value, 0
+	}
+	b.ext.SetHasCompressed(true)
+	return buf.Bytes(), common.CompressedLeafFlag
+}
+
+// value returns a stored value as it was put: uncompressed, or copied from
+// its chunks if it was streamed, or the value itself otherwise.
+func (b *Bucket) value(v []byte, flags uint32) []byte {
+	if (flags & common.CompressedLeafFlag) != 0 {
+		return decompress(v, b.dict)
+	}
+	return b.tx.blobValue(v, flags)
+}
+
+// decompress returns the uncompressed copy of a value compressed with dict.
+func decompress(v []byte, dict []byte) []byte {
+	n, m := binary.Uvarint(v)
+	common.Assert(m > 0 && n <= MaxValueSize, "invalid compressed value")
+	src := bytes.NewReader(v[m:])
+	r, _ := inflaters.Get().(io.ReadCloser)
+	if r == nil {
+		r = flate.NewReaderDict(src, dict)
+	} else if err := r.(flate.Resetter).Reset(src, dict); err != nil {
+		panic(err)
+	}
+	defer inflaters.Put(r)
+
+	value := make([]byte, n)
+	_, err := io.ReadFull(r, value)
+	common.Assert(err == nil, "invalid compressed value: %v", err)
+	return value
+}
+
+// TrainCompressionDict returns a compression dictionary of at most size
+// bytes for values like the samples, see Bucket.SetCompressionDict. It's
+// made of the segments of the samples which hold the most substrings shared
+// by several samples, the most common last, where DEFLATE refers to them
+// most cheaply. A size which isn't positive or which is larger than
+// MaxCompressionDictSize means MaxCompressionDictSize. Returns nil if the
+// samples have nothing in common.
+func TrainCompressionDict(samples [][]byte, size int) []byte {
+	if size <= 0 || size > MaxCompressionDictSize {
+		size = MaxCompressionDictSize
+	}
+
+	// Count the samples each substring occurs in.
+	freq := make(map[string]int)
+	seen := make(map[string]bool)
+	for _, s := range samples {
+		clear(seen)
+		for i := 0; i+dictKmerSize <= len(s); i++ {
+			if kmer := string(s[i : i+dictKmerSize]); !seen[kmer] {
+				seen[kmer] = true
+				freq[kmer]++
+			}
+		}
+	}
+	for kmer, n := range freq {
+		if n < 2 {
+			delete(freq, kmer)
+		}
+	}
+
+	// Score the segment at each offset of the samples by the frequency of
+	// its substrings, then pick the best segments greedily. Picking a
+	// segment covers its substrings, which lowers the score of the segments
+	// overlapping it, so a segment's score is computed again once it comes
+	// out on top, and it's only picked if it stays there.
+	segs := &dictSegments{}
+	for i, s := range samples {
+		for off := 0; off+dictKmerSize <= len(s); off++ {
+			if score := segmentScore(freq, s, off); score > 0 {
+				segs.items = append(segs.items, dictSegment{sample: i, off: off, score: score})
+			}
+		}
+	}
+	heap.Init(segs)
+	var picked [][]byte
+	var n int
+	for n < size && segs.Len() > 0 {
+		seg := &segs.items[0]
+		s := samples[seg.sample]
+		if score := segmentScore(freq, s, seg.off); score != seg.score {
+			if seg.score = score; score == 0 {
+				heap.Pop(segs)
+			} else {
+				heap.Fix(segs, 0)
+			}
+			continue
+		}
+		end := min(seg.off+dictSegmentSize, len(s))
+		for i := seg.off; i+dictKmerSize <= end; i++ {
+			delete(freq, string(s[i:i+dictKmerSize]))
+		}
+		picked = append(picked, s[seg.off:end])
+		n += end - seg.off
+		heap.Pop(segs)
+	}
+	if len(picked) == 0 {
+		return nil
+	}
+
+	dict := make([]byte, 0, n)
+	for i := len(picked) - 1; i >= 0; i-- {
+		dict = append(dict, picked[i]...)
+	}
+	return dict[max(0, len(dict)-size):]
+}
+
+// segmentScore returns the sum of the frequencies of the substrings of the
+// segment of s at off which aren't covered yet.
+func segmentScore(freq map[string]int, s []byte, off int) int {
+	end := min(off+dictSegmentSize, len(s))
+	var score int
+	for i := off; i+dictKmerSize <= end; i++ {
+		score += freq[string(s[i:i+dictKmerSize])]
+	}
+	return score
+}
+
+// dictSegment is a candidate segment of TrainCompressionDict.
+type dictSegment struct {
+	sample, off, score int
+}
+
+// dictSegments is a max-heap of candidate segments by score, the earliest
+// first among equal scores so that training is deterministic.
+type dictSegments struct {
+	items []dictSegment
+}
+
+func (h *dictSegments) Len() int { return len(h.items) }
+
+func (h *dictSegments) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if a.score != b.score {
+		return a.score > b.score
+	} else if a.sample != b.sample {
+		return a.sample < b.sample
+	}
+	return a.off < b.off
+}
+
+func (h *dictSegments) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *dictSegments) Push(x any) { h.items = append(h.items, x.(dictSegment)) }
+
+func (h *dictSegments) Pop() any {
+	x := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return x
+}
@@ -0,0 +1,228 @@
+package boltdb_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+// jsonValue returns a small JSON document which shares most of its text with
+// the others.
+func jsonValue(i int) []byte {
+	return []byte(fmt.Sprintf(`{"id":%d,"name":"user-%d","email":"user%d@example.com","active":true,"roles":["reader","writer"],"created":"2024-01-%02dT00:00:00Z"}`, i, i, i, i%28+1))
+}
+
+func TestBucket_SetCompressionDict(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	var samples [][]byte
+	for i := 0; i < 100; i++ {
+		samples = append(samples, jsonValue(i))
+	}
+	dict := bolt.TrainCompressionDict(samples, 1024)
+	require.NotEmpty(t, dict)
+	require.LessOrEqual(t, len(dict), 1024)
+	require.Nil(t, bolt.TrainCompressionDict([][]byte{[]byte("nothing"), []byte("in common")}, 0))
+
+	const n = 2000
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"plain", "compressed"} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			if name == "compressed" {
+				require.NoError(t, b.SetCompressionDict(dict))
+			}
+			for i := 0; i < n; i++ {
+				if err := b.Put([]byte(fmt.Sprintf("%05d", i)), jsonValue(i)); err != nil {
+					return err
+				}
+			}
+			require.NoError(t, b.Put([]byte("short"), []byte("x")))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	db.MustCheck()
+
+	check := func(t *testing.T, b *bolt.Bucket) {
+		require.Equal(t, jsonValue(7), b.Get([]byte("00007")))
+		require.Equal(t, []byte("x"), b.Get([]byte("short")))
+		var i int
+		c := b.Cursor()
+		for k, v := c.First(); k != nil && string(k) != "short"; k, v = c.Next() {
+			require.Equal(t, jsonValue(i), v)
+			i++
+		}
+		require.Equal(t, n, i)
+	}
+
+	db.MustClose()
+	db.MustReopen()
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("compressed"))
+		require.Equal(t, dict, b.CompressionDict())
+		check(t, b)
+
+		// The values take less than half the space.
+		plain := tx.Bucket([]byte("plain")).Stats()
+		require.Nil(t, tx.Bucket([]byte("plain")).CompressionDict())
+		require.Less(t, b.Stats().LeafInuse*2, plain.LeafInuse)
+
+		require.ErrorIs(t, b.SetCompressionDict(nil), berrors.ErrTxNotWritable)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The values are compressed again when the dictionary changes, or
+	// stored uncompressed when it's removed.
+	for _, d := range [][]byte{bolt.TrainCompressionDict(samples[50:], 512), nil} {
+		err = db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("compressed"))
+			require.NoError(t, b.SetCompressionDict(d))
+			check(t, b)
+			return nil
+		})
+		require.NoError(t, err)
+		db.MustCheck()
+		err = db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("compressed"))
+			require.Equal(t, d, b.CompressionDict())
+			check(t, b)
+			return nil
+		})
+		require.NoError(t, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("compressed"))
+		require.ErrorIs(t, b.SetCompressionDict(make([]byte, bolt.MaxCompressionDictSize+1)), berrors.ErrCompressionDictTooLarge)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBucket_SetCompressionDict_Copy(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{Indexes: byColor})
+	value := func(color string, i int) []byte {
+		return []byte(color + "/" + strings.Repeat(fmt.Sprintf("size %d, ", i%3), 10))
+	}
+	dict := []byte(strings.Repeat("size 0, size 1, size 2, ", 4))
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		require.NoError(t, b.SetCompressionDict(dict))
+		for i := 0; i < 100; i++ {
+			color := []string{"red", "blue"}[i%2]
+			if err := b.Put([]byte(fmt.Sprintf("%03d", i)), value(color, i)); err != nil {
+				return err
+			}
+		}
+		nested, err := b.CreateBucket([]byte("nested"))
+		if err != nil {
+			return err
+		}
+		require.NoError(t, nested.SetCompressionDict(dict))
+		return nested.Put([]byte("key"), value("green", 1))
+	})
+	require.NoError(t, err)
+
+	// Indexes see the uncompressed values.
+	err = db.View(func(tx *bolt.Tx) error {
+		require.Len(t, lookup(t, tx.Bucket([]byte("widgets")), "widgets-by-color", "red"), 50)
+		return nil
+	})
+	require.NoError(t, err)
+
+	check := func(t *testing.T, b *bolt.Bucket) {
+		require.Equal(t, dict, b.CompressionDict())
+		require.Equal(t, value("blue", 7), b.Get([]byte("007")))
+		nested := b.Bucket([]byte("nested"))
+		require.Equal(t, dict, nested.CompressionDict())
+		require.Equal(t, value("green", 1), nested.Get([]byte("key")))
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		require.NoError(t, tx.CloneBucket([]byte("widgets"), []byte("clone")))
+		check(t, tx.Bucket([]byte("clone")))
+		return nil
+	})
+	require.NoError(t, err)
+	db.MustCheck()
+
+	compacted := btesting.MustCreateDB(t)
+	require.NoError(t, bolt.Compact(compacted.DB, db.DB, 0))
+	compacted.MustCheck()
+	err = compacted.View(func(tx *bolt.Tx) error {
+		check(t, tx.Bucket([]byte("widgets")))
+		check(t, tx.Bucket([]byte("clone")))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBucket_CompressLargeValues(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{CompressLargeValues: true})
+	var large []byte
+	for i := 0; len(large) < 1<<20; i++ {
+		large = append(large, jsonValue(i)...)
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"large", "small"} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			for i := 0; i < 100; i++ {
+				v := jsonValue(i)
+				if name == "large" {
+					v = large
+				}
+				if err := b.Put([]byte(fmt.Sprintf("%05d", i)), v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	db.MustCheck()
+
+	// The large values are read without the option, and the new ones are
+	// stored uncompressed.
+	db.MustClose()
+	db.SetOptions(&bolt.Options{})
+	db.MustReopen()
+	pages := len(large) / db.Info().PageSize
+	var compressed bolt.BucketStats
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("large"))
+		require.Equal(t, large, b.Get([]byte("00042")))
+
+		// Each value takes less than a quarter of its pages uncompressed.
+		compressed = b.Stats()
+		require.Less(t, compressed.LeafOverflowN/100*4, pages)
+		small := tx.Bucket([]byte("small"))
+		require.Equal(t, jsonValue(42), small.Get([]byte("00042")))
+		require.Zero(t, small.Stats().LeafOverflowN)
+
+		return b.Put([]byte("plain"), large)
+	})
+	require.NoError(t, err)
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("large"))
+		require.Equal(t, large, b.Get([]byte("plain")))
+		require.GreaterOrEqual(t, b.Stats().LeafOverflowN, compressed.LeafOverflowN+pages-1)
+		return nil
+	})
+	require.NoError(t, err)
+}
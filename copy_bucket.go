@@ -0,0 +1,286 @@
+package boltdb
+
+import (
+	"bytes"
+	"time"
+	"unsafe"
+
+	"github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// CopyBucketTo copies the top-level bucket name, with its keys, sequence and
+// nested buckets, to a new top-level bucket of the same name in dst, see
+// Bucket.CopyBucketTo.
+func (tx *Tx) CopyBucketTo(name []byte, dst *Tx) error {
+	return tx.root.CopyBucketTo(name, &dst.root)
+}
+
+// MoveBucketTo moves the top-level bucket name to dst, see
+// Bucket.MoveBucketTo.
+func (tx *Tx) MoveBucketTo(name []byte, dst *Tx) error {
+	return tx.root.MoveBucketTo(name, &dst.root)
+}
+
+// CopyBucketTo copies the nested bucket name of the bucket, with its keys,
+// sequence and nested buckets, to a new nested bucket of the same name in
+// dst, which must belong to a write transaction of another database. The
+// pages of the bucket are copied as they are, with their page ids rewritten,
+// rather than putting the keys one by one, so that a large bucket can be
+// moved to a file of its own quickly. The pages may be copied between
+// databases with different page sizes, checksums or encryption. If the
+// bucket was changed by its transaction, its keys are copied one by one
+// instead.
+//
+// The indexes declared for a top-level bucket of dst aren't updated, see
+// Tx.RebuildIndex.
+// Returns an error if the bucket doesn't exist, if the key exists in dst, or
+// if dst belongs to a read-only transaction or to the same database.
+func (b *Bucket) CopyBucketTo(name []byte, dst *Bucket) (err error) {
+	defer b.tx.catch(&err)
+	if b.tx.db == nil || dst.tx.db == nil {
+		return errors.ErrTxClosed
+	} else if !dst.Writable() {
+		return errors.ErrTxNotWritable
+	} else if b.tx.db == dst.tx.db {
+		return errors.ErrSameDatabase
+	}
+
+	_, v, flags, ok := b.seekBucketValue(name)
+	if !ok {
+		return errors.ErrBucketNotFound
+	} else if (flags & common.BucketLeafFlag) == 0 {
+		return errors.ErrIncompatibleValue
+	}
+	if _, _, dflags, ok := dst.seekBucketValue(name); ok {
+		if (dflags & common.BucketLeafFlag) != 0 {
+			return errors.ErrBucketExists
+		}
+		return errors.ErrIncompatibleValue
+	}
+
+	return b.copyBucket(name, v, dst, name)
+}
+
+// MoveBucketTo copies the nested bucket name of the bucket to dst like
+// CopyBucketTo, and then deletes it, e.g. to split a database into several
+// files. The bucket is only gone once both transactions commit, so dst should
+// commit first.
+// Returns an error like CopyBucketTo, or if the bucket was created from a
+// read-only transaction.
+func (b *Bucket) MoveBucketTo(name []byte, dst *Bucket) error {
+	if b.tx.db == nil {
+		return errors.ErrTxClosed
+	} else if !b.Writable() {
+		return errors.ErrTxNotWritable
+	}
+	if err := b.CopyBucketTo(name, dst); err != nil {
+		return err
+	}
+	return b.DeleteBucket(name)
+}
+
+// copyBucket copies the nested bucket name of the bucket, whose value is v,
+// to a new nested bucket newName of dst, which mustn't exist yet.
+func (b *Bucket) copyBucket(name, v []byte, dst *Bucket, newName []byte) error {
+	src := b.Bucket(name)
+	if src.changed() {
+		// If both buckets belong to the same transaction, writing out its
+		// dirty pages would drop the nodes under the cursor of src.
+		dst.tx.spillHeld++
+		defer func() { dst.tx.spillHeld-- }()
+		child, err := dst.CreateBucket(newName)
+		if err != nil {
+			return err
+		}
+		return src.copyKeys(child)
+	}
+
+	// Copy the header, the inline page, if any, and the extension of the
+	// bucket, with the root page of the copy. The value may be in a page
+	// which is written out meanwhile.
+	v = cloneBytes(v)
+	inBucket := *common.LoadBucket(v)
+	off := common.BucketHeaderSize
+	if inBucket.RootPage() == 0 {
+		off += inBucket.InlinePageSize(v)
+	} else {
+		root, err := src.copyPages(inBucket.RootPage(), dst.tx)
+		if err != nil {
+			return err
+		}
+		inBucket.SetRootPage(root)
+	}
+	ext := common.LoadBucketExt(v)
+	ext.SetVersion(uint64(dst.tx.meta.Txid()))
+	if _, ok := ext.Count(); !ok {
+		ext.SetCount(uint64(src.countKeys()))
+	}
+	value := make([]byte, off+ext.Size())
+	copy(value, v[:off])
+	copy(value, unsafe.Slice((*byte)(unsafe.Pointer(&inBucket)), common.BucketHeaderSize))
+	ext.Write(value)
+	// Copy the compression dictionary and metadata after the extension.
+	n := ext.Size() - common.BucketExtSize
+	copy(value[len(value)-n:], v[len(v)-n:])
+
+	// The dirty pages of dst may have been written out meanwhile, so look
+	// up the key again.
+	newKey := cloneBytes(newName)
+	c := dst.Cursor()
+	c.seek(newKey)
+	c.node().put(newKey, newKey, value, 0, common.BucketLeafFlag)
+	dst.addCount(1)
+	dst.recordChange(newKey, OpCreateBucket)
+
+	// Like CreateBucket, stop using the inline page of dst.
+	dst.page = nil
+
+	return dst.tx.dirty(0)
+}
+
+// seekBucketValue returns the key, value and flags of name, and whether it
+// exists.
+func (b *Bucket) seekBucketValue(name []byte) ([]byte, []byte, uint32, bool) {
+	k, v, flags := b.Cursor().seek(name)
+	if !bytes.Equal(name, k) {
+		return nil, nil, 0, false
+	}
+	return k, v, flags, true
+}
+
+// changed returns whether the bucket or its cached nested buckets have
+// changes which weren't written to their pages yet.
+func (b *Bucket) changed() bool {
+	if b.rootNode != nil || len(b.nodes) > 0 {
+		return true
+	}
+	for _, child := range b.buckets {
+		if child.changed() {
+			return true
+		}
+	}
+	return false
+}
+
+// copyPages copies the subtree of a page of the bucket, including the pages
+// of its nested buckets, to pages allocated by tx, and returns the id of the
+// copy. The children are copied first, so that the pages which are done may
+// be written out.
+func (b *Bucket) copyPages(id common.Pgid, tx *Tx) (common.Pgid, error) {
+	p := b.tx.page(id)
+
+	// Find the children and the nested buckets, and the used size.
+	ids := make([]common.Pgid, p.Count())
+	nested := make([]*Bucket, p.Count())
+	refs := make([][]byte, p.Count())
+	size := int(common.PageHeaderSize)
+	for i := uint16(0); i < p.Count(); i++ {
+		var end int
+		if p.IsBranchPage() {
+			e := p.BranchPageElement(i)
+			end = int(uintptr(unsafe.Pointer(e))-uintptr(unsafe.Pointer(p))) + int(e.Pos()+e.Ksize())
+			ids[i] = e.Pgid()
+		} else {
+			e := p.LeafPageElement(i)
+			end = int(uintptr(unsafe.Pointer(e))-uintptr(unsafe.Pointer(p))) + int(e.Pos()+e.Ksize()+e.Vsize())
+			if e.IsBucketEntry() && e.Bucket().RootPage() != 0 {
+				nested[i] = b.openBucket(e.Value())
+			} else if e.IsBlobEntry() {
+				refs[i] = cloneBytes(e.Value())
+			}
+		}
+		if end > size {
+			size = end
+		}
+	}
+
+	// Copy them first.
+	for i := range ids {
+		var err error
+		if ids[i] != 0 {
+			ids[i], err = b.copyPages(ids[i], tx)
+		} else if nested[i] != nil {
+			ids[i], err = nested[i].copyPages(nested[i].RootPage(), tx)
+		} else if refs[i] != nil {
+			refs[i], err = tx.copyBlob(b.tx, refs[i])
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Copy the page and point it to the copies.
+	count := (size + tx.db.pageTrailerSize() + tx.db.pageSize - 1) / tx.db.pageSize
+	np, err := tx.allocate(count)
+	if err != nil {
+		return 0, err
+	}
+
+	// Allocating pages in the same transaction may have remapped the data
+	// file.
+	p = b.tx.page(id)
+	dst := unsafe.Slice((*byte)(unsafe.Pointer(np)), size)
+	copy(dst[common.PageHeaderSize:], unsafe.Slice((*byte)(unsafe.Pointer(p)), size)[common.PageHeaderSize:])
+	np.SetFlags(p.Flags())
+	np.SetCount(p.Count())
+	for i := uint16(0); i < p.Count(); i++ {
+		if p.IsBranchPage() {
+			np.BranchPageElement(i).SetPgid(ids[i])
+		} else if refs[i] != nil {
+			copy(np.LeafPageElement(i).Value(), refs[i])
+		} else if ids[i] != 0 {
+			v := np.LeafPageElement(i).Value()
+			inBucket := *common.LoadBucket(v)
+			inBucket.SetRootPage(ids[i])
+			copy(v, unsafe.Slice((*byte)(unsafe.Pointer(&inBucket)), common.BucketHeaderSize))
+		}
+	}
+
+	// The page may be written out and released by dirty.
+	newID := np.Id()
+	if err := tx.dirty(count * tx.db.pageSize); err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// copyKeys copies the keys, settings and nested buckets of the bucket to dst
+// one by one.
+func (b *Bucket) copyKeys(dst *Bucket) error {
+	if err := dst.copySettings(b); err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	c := b.Cursor()
+	for k, v, flags := c.first(); k != nil; k, v, flags = c.next() {
+		var err error
+		switch {
+		case (flags & common.BucketLeafFlag) != 0:
+			var child *Bucket
+			if child, err = dst.CreateBucket(k); err == nil {
+				err = b.Bucket(k).copyKeys(child)
+			}
+		case (flags & common.ExpiringLeafFlag) != 0:
+			if !expired(v, now) {
+				err = dst.putExpiring(k, v[expiryLen:], expiry(v))
+			}
+		case (flags & common.BlobLeafFlag) != 0:
+			// Writing the value may remap the data file under the cursor, so
+			// it's moved back to the key.
+			key := cloneBytes(k)
+			var ref []byte
+			if ref, err = dst.tx.copyBlob(b.tx, cloneBytes(v)); err == nil {
+				if err = dst.putBlob(key, ref); err == nil {
+					c.seek(key)
+				}
+			}
+		default:
+			err = dst.Put(k, b.value(v, flags))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package boltdb_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+// dumpBucket returns the keys, values, sequences and nested buckets of a
+// bucket.
+func dumpBucket(t testing.TB, b *bolt.Bucket) []string {
+	out := []string{fmt.Sprintf("sequence=%d count=%d", b.Sequence(), b.Count())}
+	require.NoError(t, b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			for _, line := range dumpBucket(t, b.Bucket(k)) {
+				out = append(out, string(k)+"/"+line)
+			}
+			return nil
+		}
+		out = append(out, string(k)+"="+string(v))
+		return nil
+	}))
+	return out
+}
+
+func mustFillTenants(t testing.TB, db *btesting.DB) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		tenants, err := tx.CreateBucket([]byte("tenants"))
+		if err != nil {
+			return err
+		}
+		large, err := tenants.CreateBucket([]byte("large"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 5000; i++ {
+			if err := large.Put([]byte(fmt.Sprintf("%05d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+				return err
+			}
+		}
+		if err := large.Put([]byte("big"), make([]byte, 20000)); err != nil {
+			return err
+		}
+		if err := large.PutWithTTL([]byte("ttl"), []byte("value"), time.Hour); err != nil {
+			return err
+		}
+		if err := large.SetSequence(42); err != nil {
+			return err
+		}
+		for _, name := range []string{"inline", "nested"} {
+			child, err := large.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			n := 10
+			if name == "nested" {
+				n = 1000
+			}
+			for i := 0; i < n; i++ {
+				if err := child.Put([]byte(fmt.Sprintf("%04d", i)), []byte(name)); err != nil {
+					return err
+				}
+			}
+		}
+		small, err := tenants.CreateBucket([]byte("small"))
+		if err != nil {
+			return err
+		}
+		return small.Put([]byte("foo"), []byte("bar"))
+	})
+	require.NoError(t, err)
+}
+
+func TestBucket_CopyBucketTo(t *testing.T) {
+	src := btesting.MustCreateDB(t)
+	mustFillTenants(t, src)
+
+	var want map[string][]string
+	err := src.View(func(tx *bolt.Tx) error {
+		tenants := tx.Bucket([]byte("tenants"))
+		want = map[string][]string{
+			"large": dumpBucket(t, tenants.Bucket([]byte("large"))),
+			"small": dumpBucket(t, tenants.Bucket([]byte("small"))),
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name    string
+		options bolt.Options
+	}{
+		{name: "default"},
+		{name: "page size", options: bolt.Options{PageSize: 16384}},
+		{name: "checksums", options: bolt.Options{PageChecksums: true, PageTxids: true}},
+		{name: "encrypted", options: bolt.Options{Encryption: mustAESGCMCipher(t, "0123456789abcdef")}},
+		{name: "max dirty bytes", options: bolt.Options{MaxDirtyBytes: 64 * 1024}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := btesting.MustCreateDBWithOption(t, &tc.options)
+			err := dst.Update(func(dtx *bolt.Tx) error {
+				tenants, err := dtx.CreateBucket([]byte("tenants"))
+				if err != nil {
+					return err
+				}
+				return src.View(func(stx *bolt.Tx) error {
+					for name := range want {
+						if err := stx.Bucket([]byte("tenants")).CopyBucketTo([]byte(name), tenants); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			})
+			require.NoError(t, err)
+			dst.MustCheck()
+
+			dst.MustClose()
+			dst.MustReopen()
+			err = dst.View(func(tx *bolt.Tx) error {
+				tenants := tx.Bucket([]byte("tenants"))
+				for name, lines := range want {
+					require.Equal(t, lines, dumpBucket(t, tenants.Bucket([]byte(name))))
+				}
+				require.WithinDuration(t, time.Now().Add(time.Hour), tenants.Bucket([]byte("large")).ExpiresAt([]byte("ttl")), time.Minute)
+				return nil
+			})
+			require.NoError(t, err)
+
+			// The copy can be changed.
+			err = dst.Update(func(tx *bolt.Tx) error {
+				large := tx.Bucket([]byte("tenants")).Bucket([]byte("large"))
+				for i := 0; i < 5000; i += 2 {
+					if err := large.Delete([]byte(fmt.Sprintf("%05d", i))); err != nil {
+						return err
+					}
+				}
+				return large.Bucket([]byte("nested")).Put([]byte("new"), []byte("value"))
+			})
+			require.NoError(t, err)
+			dst.MustCheck()
+		})
+	}
+}
+
+func TestTx_MoveBucketTo(t *testing.T) {
+	src := btesting.MustCreateDB(t)
+	mustFillTenants(t, src)
+	dst := btesting.MustCreateDB(t)
+
+	var want []string
+	err := src.Update(func(stx *bolt.Tx) error {
+		// Changes of the transaction are copied key by key.
+		small := stx.Bucket([]byte("tenants")).Bucket([]byte("small"))
+		require.NoError(t, small.Put([]byte("baz"), []byte("qux")))
+		want = dumpBucket(t, stx.Bucket([]byte("tenants")))
+
+		return dst.Update(func(dtx *bolt.Tx) error {
+			return stx.MoveBucketTo([]byte("tenants"), dtx)
+		})
+	})
+	require.NoError(t, err)
+	src.MustCheck()
+	dst.MustCheck()
+
+	err = src.View(func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket([]byte("tenants")))
+		return nil
+	})
+	require.NoError(t, err)
+	err = dst.View(func(tx *bolt.Tx) error {
+		require.Equal(t, want, dumpBucket(t, tx.Bucket([]byte("tenants"))))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBucket_CopyBucketTo_Errors(t *testing.T) {
+	src := btesting.MustCreateDB(t)
+	mustFillTenants(t, src)
+	dst := btesting.MustCreateDB(t)
+
+	err := src.Update(func(stx *bolt.Tx) error {
+		other, err := stx.CreateBucket([]byte("other"))
+		require.NoError(t, err)
+		require.ErrorIs(t, stx.CopyBucketTo([]byte("tenants"), stx), berrors.ErrSameDatabase)
+		require.ErrorIs(t, stx.Bucket([]byte("tenants")).CopyBucketTo([]byte("small"), other), berrors.ErrSameDatabase)
+
+		err = dst.View(func(dtx *bolt.Tx) error {
+			require.ErrorIs(t, stx.CopyBucketTo([]byte("tenants"), dtx), berrors.ErrTxNotWritable)
+			return nil
+		})
+		require.NoError(t, err)
+
+		return dst.Update(func(dtx *bolt.Tx) error {
+			require.ErrorIs(t, stx.CopyBucketTo([]byte("missing"), dtx), berrors.ErrBucketNotFound)
+			x, err := dtx.CreateBucket([]byte("x"))
+			require.NoError(t, err)
+			require.ErrorIs(t, stx.Bucket([]byte("tenants")).Bucket([]byte("small")).CopyBucketTo([]byte("foo"), x), berrors.ErrIncompatibleValue)
+			require.NoError(t, stx.CopyBucketTo([]byte("other"), dtx))
+			require.ErrorIs(t, stx.CopyBucketTo([]byte("other"), dtx), berrors.ErrBucketExists)
+			return nil
+		})
+	})
+	require.NoError(t, err)
+}
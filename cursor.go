@@ -18,10 +18,33 @@ import (
 //
 // Changing data while traversing with a cursor may cause it to be invalidated
 // and return unexpected keys and/or values. You must reposition your cursor
-// after mutating data.
+// after mutating data, except after deleting a key with Delete, which Next and
+// Prev move from.
 type Cursor struct {
 	bucket *Bucket
 	stack  []elemRef
+
+	// start and end bound the keys the cursor returns to [start, end), see
+	// Bucket.RangeCursor and SeekPrefix. A nil bound is unbounded.
+	start []byte
+	end   []byte
+
+	// readAhead is the number of bytes to read ahead, see SetReadAhead.
+	// The cursor last moved backward if reverse is set.
+	readAhead       int
+	reverse         bool
+	readAheadLeaf   common.Pgid
+	readAheadBranch common.Pgid
+	readAheadIndex  int
+
+	// deleted is the key deleted by Delete, which Next and Prev move from
+	// instead of the stack, nil once the cursor moved.
+	deleted []byte
+
+	stats CursorStats
+
+	// keysOnly is set when the cursor returns no values, see SetKeysOnly.
+	keysOnly bool
 }
 
 // Bucket returns the bucket that this cursor was created from.
@@ -33,17 +56,19 @@ func (c *Cursor) Bucket() *Bucket {
 // If the bucket is empty then a nil key and value are returned.
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) First() (key []byte, value []byte) {
+	defer c.bucket.tx.catch(nil)
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
-	k, v, flags := c.first()
-	if (flags & uint32(common.BucketLeafFlag)) != 0 {
-		return k, nil
+	c.reverse, c.deleted = false, nil
+	if c.start != nil {
+		return c.result(c.seekNext(c.start))
 	}
-	return k, v
+	k, v, flags := c.first()
+	return c.result(c.skipExpired(k, v, flags, c.next))
 }
 
 func (c *Cursor) first() (key []byte, value []byte, flags uint32) {
 	c.stack = c.stack[:0]
-	p, n := c.bucket.pageNode(c.bucket.RootPage())
+	p, n := c.pageNode(c.bucket.RootPage())
 	c.stack = append(c.stack, elemRef{page: p, node: n, index: 0})
 	c.goToFirstElementOnTheStack()
 
@@ -64,9 +89,26 @@ func (c *Cursor) first() (key []byte, value []byte, flags uint32) {
 // If the bucket is empty then a nil key and value are returned.
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Last() (key []byte, value []byte) {
+	defer c.bucket.tx.catch(nil)
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	c.reverse, c.deleted = true, nil
+	var k, v []byte
+	var flags uint32
+	if c.end != nil {
+		// Move before the first key after the range.
+		c.seek(c.end)
+		k, v, flags = c.prev()
+	} else {
+		k, v, flags = c.seekLast()
+	}
+	return c.result(c.skipExpired(k, v, flags, c.prev))
+}
+
+// seekLast moves the cursor to the last item in the bucket and returns its
+// raw key, value and flags.
+func (c *Cursor) seekLast() (key []byte, value []byte, flags uint32) {
 	c.stack = c.stack[:0]
-	p, n := c.bucket.pageNode(c.bucket.RootPage())
+	p, n := c.pageNode(c.bucket.RootPage())
 	ref := elemRef{page: p, node: n}
 	ref.index = ref.count() - 1
 	c.stack = append(c.stack, ref)
@@ -79,38 +121,31 @@ func (c *Cursor) Last() (key []byte, value []byte) {
 	}
 
 	if len(c.stack) == 0 {
-		return nil, nil
-	}
-
-	k, v, flags := c.keyValue()
-	if (flags & uint32(common.BucketLeafFlag)) != 0 {
-		return k, nil
+		return nil, nil, 0
 	}
-	return k, v
+	return c.keyValue()
 }
 
 // Next moves the cursor to the next item in the bucket and returns its key and value.
 // If the cursor is at the end of the bucket then a nil key and value are returned.
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Next() (key []byte, value []byte) {
+	defer c.bucket.tx.catch(nil)
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
-	k, v, flags := c.next()
-	if (flags & uint32(common.BucketLeafFlag)) != 0 {
-		return k, nil
-	}
-	return k, v
+	c.reverse = false
+	k, v, flags := c.step()
+	return c.result(c.skipExpired(k, v, flags, c.next))
 }
 
 // Prev moves the cursor to the previous item in the bucket and returns its key and value.
 // If the cursor is at the beginning of the bucket then a nil key and value are returned.
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Prev() (key []byte, value []byte) {
+	defer c.bucket.tx.catch(nil)
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
-	k, v, flags := c.prev()
-	if (flags & uint32(common.BucketLeafFlag)) != 0 {
-		return k, nil
-	}
-	return k, v
+	c.reverse = true
+	k, v, flags := c.step()
+	return c.result(c.skipExpired(k, v, flags, c.prev))
 }
 
 // Seek moves the cursor to a given key using a b-tree search and returns it.
@@ -118,42 +153,246 @@ func (c *Cursor) Prev() (key []byte, value []byte) {
 // follow, a nil key is returned.
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Seek(seek []byte) (key []byte, value []byte) {
+	defer c.bucket.tx.catch(nil)
+	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	c.reverse, c.deleted = false, nil
+	if c.start != nil && bytes.Compare(seek, c.start) < 0 {
+		seek = c.start
+	}
+	return c.result(c.seekNext(seek))
+}
+
+// SeekGE moves the cursor to the first key which is greater than or equal
+// to seek, the ceiling of seek, and returns it like Seek.
+// The returned key and value are only valid for the life of the transaction.
+func (c *Cursor) SeekGE(seek []byte) (key []byte, value []byte) {
+	return c.Seek(seek)
+}
+
+// SeekLE moves the cursor to the last key which is less than or equal to
+// seek, the floor of seek, and returns it, or a nil key if all the keys are
+// greater.
+// The returned key and value are only valid for the life of the transaction.
+func (c *Cursor) SeekLE(seek []byte) (key []byte, value []byte) {
+	defer c.bucket.tx.catch(nil)
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	if c.end != nil && bytes.Compare(seek, c.end) >= 0 {
+		return c.Last()
+	}
+	c.reverse, c.deleted = true, nil
+	k, v, flags := c.seek(seek)
+	if k == nil || !bytes.Equal(k, seek) {
+		// Move before the first key after seek.
+		k, v, flags = c.prev()
+	}
+	return c.result(c.skipExpired(k, v, flags, c.prev))
+}
+
+// Skip moves the cursor n keys forward, or backward if n is negative, and
+// returns the key it reaches, or a nil key if there aren't enough keys left.
+// The leaves it skips entirely are skipped by their number of elements,
+// without reading their keys and values. Like Count, the expired keys which
+// weren't reaped yet are counted.
+// The returned key and value are only valid for the life of the transaction.
+func (c *Cursor) Skip(n int) (key []byte, value []byte) {
+	defer c.bucket.tx.catch(nil)
+	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	if len(c.stack) == 0 {
+		return nil, nil
+	}
+	step := c.next
+	if c.reverse = n < 0; c.reverse {
+		step = c.prev
+	}
+	if c.deleted != nil {
+		// Count the key after or before the deleted one as the first.
+		c.step()
+		if n > 0 {
+			n--
+		} else if n < 0 {
+			n++
+		}
+	}
+	k, v, flags := c.skip(n)
+	return c.result(c.skipExpired(k, v, flags, step))
+}
+
+// skip moves the cursor n elements forward, or backward if n is negative,
+// and returns the element it reaches.
+func (c *Cursor) skip(n int) ([]byte, []byte, uint32) {
+	for n != 0 {
+		ref := &c.stack[len(c.stack)-1]
+		var k []byte
+		if n > 0 {
+			left := ref.count() - 1 - ref.index
+			if n <= left {
+				ref.index += n
+				break
+			} else if left > 0 {
+				ref.index += left
+				n -= left
+			}
+			k, _, _ = c.next()
+			n--
+		} else {
+			if -n <= ref.index {
+				ref.index += n
+				break
+			}
+			n += ref.index
+			ref.index = 0
+			k, _, _ = c.prev()
+			n++
+		}
+		if k == nil {
+			return nil, nil, 0
+		}
+	}
+	return c.keyValue()
+}
+
+// SeekPrefix binds the cursor to prefix and moves it to the first key which
+// starts with it: First, Last, Next, Prev and Seek then only return the keys
+// with the prefix, and a nil key once they run out of them. A nil prefix
+// unbinds the cursor.
+// The returned key and value are only valid for the life of the transaction.
+func (c *Cursor) SeekPrefix(prefix []byte) (key []byte, value []byte) {
+	c.bound(prefix, prefixEnd(prefix))
+	return c.First()
+}
+
+// bound bounds the keys the cursor returns to [start, end).
+func (c *Cursor) bound(start, end []byte) {
+	c.start, c.end = nil, nil
+	if start != nil {
+		c.start = cloneBytes(start)
+	}
+	if end != nil {
+		c.end = cloneBytes(end)
+	}
+}
+
+// result returns the key and value of an element for the public methods, or
+// a nil key if it's out of the bounds of the cursor.
+func (c *Cursor) result(k, v []byte, flags uint32) ([]byte, []byte) {
+	if c.readAhead > 0 {
+		c.advise()
+	}
+	if k == nil || (c.start != nil && bytes.Compare(k, c.start) < 0) || (c.end != nil && bytes.Compare(k, c.end) >= 0) {
+		return nil, nil
+	} else if c.keysOnly || (flags&uint32(common.BucketLeafFlag)) != 0 {
+		return k, nil
+	}
+	return k, c.bucket.value(v, flags)
+}
+
+// SetKeysOnly makes the cursor return nil values, without copying the
+// streamed values nor decompressing the compressed ones, e.g. to count or
+// list the keys of a bucket with large values. The values are still read
+// from the leaves to skip the expired keys.
+func (c *Cursor) SetKeysOnly(keysOnly bool) {
+	c.keysOnly = keysOnly
+}
+
+// seekNext moves the cursor to the first key which isn't expired starting at
+// seek, and returns it.
+func (c *Cursor) seekNext(seek []byte) ([]byte, []byte, uint32) {
+	k, v, flags := c.seekFrom(seek)
+	return c.skipExpired(k, v, flags, c.next)
+}
 
+// seekFrom moves the cursor to the first element starting at seek, and
+// returns it.
+func (c *Cursor) seekFrom(seek []byte) ([]byte, []byte, uint32) {
 	k, v, flags := c.seek(seek)
 
 	// If we ended up after the last element of a page then move to the next one.
 	if ref := &c.stack[len(c.stack)-1]; ref.index >= ref.count() {
 		k, v, flags = c.next()
 	}
+	return k, v, flags
+}
 
-	if k == nil {
-		return nil, nil
-	} else if (flags & uint32(common.BucketLeafFlag)) != 0 {
-		return k, nil
+// prefixEnd returns the smallest key greater than all the keys starting with
+// prefix, or nil if there's none.
+func prefixEnd(prefix []byte) []byte {
+	if prefix == nil {
+		return nil
 	}
-	return k, v
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := cloneBytes(prefix[:i+1])
+			end[i]++
+			return end
+		}
+	}
+	return nil
 }
 
 // Delete removes the current key/value under the cursor from the bucket.
+// Next and Prev then move to the key after or before the deleted one, so
+// that a loop can delete keys as it iterates over them.
 // Delete fails if current key/value is a bucket or if the transaction is not writable.
-func (c *Cursor) Delete() error {
+func (c *Cursor) Delete() (err error) {
+	defer c.bucket.tx.catch(&err)
 	if c.bucket.tx.db == nil {
 		return errors.ErrTxClosed
 	} else if !c.bucket.Writable() {
 		return errors.ErrTxNotWritable
 	}
 
-	key, _, flags := c.keyValue()
+	key, value, flags := c.keyValue()
 	// Return an error if current value is a bucket.
 	if (flags & common.BucketLeafFlag) != 0 {
 		return errors.ErrIncompatibleValue
 	}
+	if key != nil && len(c.bucket.indexes) > 0 {
+		if err := c.bucket.updateIndexes(key, c.bucket.indexedValue(value, flags), true, nil, false); err != nil {
+			return err
+		}
+	}
+	if (flags & common.BlobLeafFlag) != 0 {
+		c.bucket.tx.freeBlob(value)
+	}
 	c.node().del(key)
+	if key != nil {
+		c.bucket.addCount(-1)
+		c.bucket.recordChange(key, OpDelete)
+		c.deleted = cloneBytes(key)
+	}
 
 	return nil
 }
 
+// DeleteAndNext removes the current key/value under the cursor from the
+// bucket like Delete, and moves the cursor to the next key, which it
+// returns with its value, or a nil key if it was the last one.
+// The returned key and value are only valid for the life of the transaction.
+func (c *Cursor) DeleteAndNext() (key []byte, value []byte, err error) {
+	if err := c.Delete(); err != nil {
+		return nil, nil, err
+	}
+	key, value = c.Next()
+	return key, value, nil
+}
+
+// step moves the cursor to the next element in its direction, or to the
+// element after or before the key deleted by Delete, and returns it.
+func (c *Cursor) step() ([]byte, []byte, uint32) {
+	deleted := c.deleted
+	c.deleted = nil
+	switch {
+	case deleted == nil && c.reverse:
+		return c.prev()
+	case deleted == nil:
+		return c.next()
+	case c.reverse:
+		c.seek(deleted)
+		return c.prev()
+	}
+	return c.seekFrom(deleted)
+}
+
 // seek moves the cursor to a given key and returns it.
 // If the key does not exist then the next key is used.
 func (c *Cursor) seek(seek []byte) (key []byte, value []byte, flags uint32) {
@@ -165,6 +404,28 @@ func (c *Cursor) seek(seek []byte) (key []byte, value []byte, flags uint32) {
 	return c.keyValue()
 }
 
+// seekAfter moves the cursor to a key like seek, when it's positioned at a
+// key which isn't greater. Only the levels below the deepest branch which
+// still covers the key are searched again, so that the lookups of sorted
+// keys share the traversal of their common ancestors.
+func (c *Cursor) seekAfter(key []byte) (k []byte, value []byte, flags uint32) {
+	for i := 0; i < len(c.stack)-1; i++ {
+		ref := &c.stack[i]
+		if ref.index+1 >= ref.count() || bytes.Compare(ref.branchKey(ref.index+1), key) > 0 {
+			continue
+		}
+		c.stack = c.stack[:i+1]
+		if ref.node != nil {
+			c.searchNode(key, ref.node)
+		} else {
+			c.searchPage(key, ref.page)
+		}
+		return c.keyValue()
+	}
+	c.nsearch(key)
+	return c.keyValue()
+}
+
 // first moves the cursor to the first leaf element under the last page in the stack.
 func (c *Cursor) goToFirstElementOnTheStack() {
 	for {
@@ -181,7 +442,7 @@ func (c *Cursor) goToFirstElementOnTheStack() {
 		} else {
 			pgId = ref.page.BranchPageElement(uint16(ref.index)).Pgid()
 		}
-		p, n := c.bucket.pageNode(pgId)
+		p, n := c.pageNode(pgId)
 		c.stack = append(c.stack, elemRef{page: p, node: n, index: 0})
 	}
 }
@@ -202,7 +463,7 @@ func (c *Cursor) last() {
 		} else {
 			pgId = ref.page.BranchPageElement(uint16(ref.index)).Pgid()
 		}
-		p, n := c.bucket.pageNode(pgId)
+		p, n := c.pageNode(pgId)
 
 		var nextRef = elemRef{page: p, node: n}
 		nextRef.index = nextRef.count() - 1
@@ -281,7 +542,7 @@ func (c *Cursor) prev() (key []byte, value []byte, flags uint32) {
 
 // search recursively performs a binary search against a given page/node until it finds a given key.
 func (c *Cursor) search(key []byte, pgId common.Pgid) {
-	p, n := c.bucket.pageNode(pgId)
+	p, n := c.pageNode(pgId)
 	if p != nil && !p.IsBranchPage() && !p.IsLeafPage() {
 		panic(fmt.Sprintf("invalid page type: %d: %x", p.Id(), p.Flags()))
 	}
@@ -395,6 +656,12 @@ func (c *Cursor) node() *node {
 		return ref.node
 	}
 
+	// Count the nodes materialized on the way.
+	nodeCount := c.bucket.tx.stats.GetNodeCount()
+	defer func() {
+		c.stats.NodeN += int(c.bucket.tx.stats.GetNodeCount() - nodeCount)
+	}()
+
 	// Start from root and traverse down the hierarchy.
 	var n = c.stack[0].node
 	if n == nil {
@@ -423,6 +690,30 @@ func (r *elemRef) isLeaf() bool {
 	return r.page.IsLeafPage()
 }
 
+// branchKey returns the key of an element of a branch page/node.
+func (r *elemRef) branchKey(index int) []byte {
+	if r.node != nil {
+		return r.node.inodes[index].Key()
+	}
+	return r.page.BranchPageElement(uint16(index)).Key()
+}
+
+// childPgid returns the page id of an element of a branch page/node.
+func (r *elemRef) childPgid(index int) common.Pgid {
+	if r.node != nil {
+		return r.node.inodes[index].Pgid()
+	}
+	return r.page.BranchPageElement(uint16(index)).Pgid()
+}
+
+// leafKey returns the key of an element of a leaf page/node.
+func (r *elemRef) leafKey(index int) []byte {
+	if r.node != nil {
+		return r.node.inodes[index].Key()
+	}
+	return r.page.LeafPageElement(uint16(index)).Key()
+}
+
 // count returns the number of inodes or page elements.
 func (r *elemRef) count() int {
 	if r.node != nil {
@@ -0,0 +1,42 @@
+package boltdb
+
+import (
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// CursorStats records the work done by a cursor, see Cursor.Stats.
+type CursorStats struct {
+	BranchPageN int // number of branch pages or nodes visited
+	LeafPageN   int // number of leaf pages or nodes visited
+
+	// BytesTouched is the size of the pages visited, including their
+	// overflow pages. The nodes of a write transaction and inline buckets
+	// aren't counted.
+	BytesTouched int64
+
+	// NodeN is the number of nodes materialized to change the bucket, e.g.
+	// by Delete.
+	NodeN int
+}
+
+// Stats returns the work done by the cursor since it was created, e.g. to
+// compare the cost of access patterns. A page is counted each time the
+// cursor moves onto it.
+func (c *Cursor) Stats() CursorStats {
+	return c.stats
+}
+
+// pageNode returns the page or node of the bucket at id like
+// Bucket.pageNode, and counts it in the stats of the cursor.
+func (c *Cursor) pageNode(id common.Pgid) (*common.Page, *node) {
+	p, n := c.bucket.pageNode(id)
+	if (n != nil && n.isLeaf) || (p != nil && p.IsLeafPage()) {
+		c.stats.LeafPageN++
+	} else {
+		c.stats.BranchPageN++
+	}
+	if p != nil && c.bucket.RootPage() != 0 {
+		c.stats.BytesTouched += int64(p.Overflow()+1) * int64(c.bucket.tx.db.pageSize)
+	}
+	return p, n
+}
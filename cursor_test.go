@@ -875,6 +875,537 @@ func TestCursor_QuickCheck_BucketsOnly_Reverse(t *testing.T) {
 	}
 }
 
+// Ensure that a cursor bound to a prefix only returns the keys with it.
+func TestCursor_SeekPrefix(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	prefixes := []string{"a/", "b/", "b0", "\xff"}
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for _, prefix := range prefixes {
+			for i := 0; i < 500; i++ {
+				if err := b.Put([]byte(fmt.Sprintf("%s%04d", prefix, i)), []byte("value")); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for _, prefix := range []string{"b/", "\xff"} {
+			c := b.Cursor()
+			var keys []string
+			for k, _ := c.SeekPrefix([]byte(prefix)); k != nil; k, _ = c.Next() {
+				keys = append(keys, string(k))
+			}
+			require.Len(t, keys, 500)
+			require.Equal(t, prefix+"0000", keys[0])
+			require.Equal(t, prefix+"0499", keys[499])
+
+			k, _ := c.Last()
+			require.Equal(t, prefix+"0499", string(k))
+			var n int
+			for ; k != nil; k, _ = c.Prev() {
+				n++
+			}
+			require.Equal(t, 500, n)
+
+			// Seek stays within the prefix.
+			k, _ = c.Seek([]byte("a/"))
+			require.Equal(t, prefix+"0000", string(k))
+			k, _ = c.Seek([]byte(prefix + "0250"))
+			require.Equal(t, prefix+"0250", string(k))
+			k, _ = c.Seek([]byte(prefix + "1"))
+			require.Nil(t, k)
+		}
+
+		c := b.PrefixCursor([]byte("b"))
+		k, _ := c.First()
+		require.Equal(t, "b/0000", string(k))
+		k, _ = c.Last()
+		require.Equal(t, "b00499", string(k))
+
+		// No key starts with the prefix.
+		k, v := b.PrefixCursor([]byte("c")).Last()
+		require.Nil(t, k)
+		require.Nil(t, v)
+		k, _ = c.SeekPrefix([]byte("a0"))
+		require.Nil(t, k)
+
+		// A nil prefix unbinds the cursor.
+		k, _ = c.SeekPrefix(nil)
+		require.Equal(t, "a/0000", string(k))
+		k, _ = c.Last()
+		require.Equal(t, "\xff0499", string(k))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+// Ensure that a range cursor only returns the keys of its range.
+func TestBucket_RangeCursor(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 2000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	keys := func(c *bolt.Cursor, reverse bool) []string {
+		var keys []string
+		first, next := c.First, c.Next
+		if reverse {
+			first, next = c.Last, c.Prev
+		}
+		for k, _ := first(); k != nil; k, _ = next() {
+			keys = append(keys, string(k))
+		}
+		return keys
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		c := b.RangeCursor([]byte("0500"), []byte("1500"))
+		got := keys(c, false)
+		require.Len(t, got, 1000)
+		require.Equal(t, "0500", got[0])
+		require.Equal(t, "1499", got[999])
+		got = keys(c, true)
+		require.Len(t, got, 1000)
+		require.Equal(t, "1499", got[0])
+		require.Equal(t, "0500", got[999])
+
+		// Seek is clamped to the range.
+		k, _ := c.Seek([]byte("0000"))
+		require.Equal(t, "0500", string(k))
+		k, _ = c.Seek([]byte("1000"))
+		require.Equal(t, "1000", string(k))
+		k, _ = c.Seek([]byte("1500"))
+		require.Nil(t, k)
+
+		// Nil bounds are unbounded.
+		require.Len(t, keys(b.RangeCursor(nil, []byte("0010")), false), 10)
+		require.Len(t, keys(b.RangeCursor([]byte("1990"), nil), true), 10)
+		require.Len(t, keys(b.RangeCursor(nil, nil), false), 2000)
+		require.Empty(t, keys(b.RangeCursor([]byte("1"), []byte("0")), false))
+		require.Empty(t, keys(b.RangeCursor([]byte("1"), []byte("0")), true))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+// Ensure that a cursor resumes from its token in a later transaction.
+func TestCursor_Token(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%03d", i)), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Read pages of 10 keys of a range, each in its own transaction.
+	var token []byte
+	var keys []string
+	for {
+		err = db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			var c *bolt.Cursor
+			var k []byte
+			if token == nil {
+				c = b.RangeCursor([]byte("020"), []byte("065"))
+				k, _ = c.First()
+			} else {
+				var err error
+				c, k, _, err = b.CursorAt(token)
+				if err != nil {
+					return err
+				}
+			}
+			for i := 0; i < 10 && k != nil; i++ {
+				keys = append(keys, string(k))
+				k, _ = c.Next()
+			}
+			token = nil
+			if k != nil {
+				token = c.Token()
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		if token == nil {
+			break
+		}
+
+		// Delete the key the next page starts at.
+		if len(keys) == 20 {
+			err = db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket([]byte("widgets")).Delete([]byte("040"))
+			})
+			require.NoError(t, err)
+		}
+	}
+	require.Len(t, keys, 44)
+	require.Equal(t, "020", keys[0])
+	require.Equal(t, "041", keys[20])
+	require.Equal(t, "064", keys[43])
+
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Nil(t, b.Cursor().Token())
+		for _, token := range [][]byte{nil, {2, 0, 0}, {1, 4, 0}, {1, 0, 5, 'a'}, {1, 0, 0, 0}} {
+			_, _, _, err := b.CursorAt(token)
+			require.ErrorIs(t, err, errors.ErrInvalidCursorToken)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+// Ensure that Skip and SeekToIndex move the cursor by a number of keys.
+func TestCursor_Skip(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	const n = 5000
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), []byte(fmt.Sprint(i))); err != nil {
+				return err
+			}
+		}
+		_, err = tx.CreateBucket([]byte("empty"))
+		return err
+	})
+	require.NoError(t, err)
+
+	check := func(t *testing.T, b *bolt.Bucket) {
+		for _, i := range []int{0, 1, 100, 2500, n - 1} {
+			_, k, v := b.SeekToIndex(i)
+			require.Equal(t, fmt.Sprintf("%04d", i), string(k))
+			require.Equal(t, fmt.Sprint(i), string(v))
+		}
+		_, k, _ := b.SeekToIndex(n)
+		require.Nil(t, k)
+		_, k, _ = b.SeekToIndex(-1)
+		require.Nil(t, k)
+
+		c, k, _ := b.SeekToIndex(1000)
+		require.Equal(t, "1000", string(k))
+		k, _ = c.Skip(0)
+		require.Equal(t, "1000", string(k))
+		k, _ = c.Skip(1234)
+		require.Equal(t, "2234", string(k))
+		k, _ = c.Skip(-2000)
+		require.Equal(t, "0234", string(k))
+		k, _ = c.Next()
+		require.Equal(t, "0235", string(k))
+		k, _ = c.Skip(-235)
+		require.Equal(t, "0000", string(k))
+		k, _ = c.Skip(-1)
+		require.Nil(t, k)
+
+		k, _ = c.Seek([]byte("4990"))
+		require.Equal(t, "4990", string(k))
+		k, _ = c.Skip(10)
+		require.Nil(t, k)
+
+		// A bounded cursor stops at its bounds.
+		c = b.RangeCursor([]byte("0100"), []byte("0200"))
+		c.First()
+		k, _ = c.Skip(99)
+		require.Equal(t, "0199", string(k))
+		c.First()
+		k, _ = c.Skip(100)
+		require.Nil(t, k)
+
+		k, _ = b.Cursor().Skip(1)
+		require.Nil(t, k)
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		check(t, tx.Bucket([]byte("widgets")))
+		_, k, _ := tx.Bucket([]byte("empty")).SeekToIndex(0)
+		require.Nil(t, k)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Nodes changed by a write transaction are skipped as well.
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.NoError(t, b.Put([]byte("2000"), []byte("2000")))
+		check(t, b)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+// Ensure that a cursor reading ahead returns the same keys and values.
+func TestCursor_SetReadAhead(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 500)); err != nil {
+				return err
+			}
+		}
+		mustPutReader(t, b, "blob", largeValue(1<<20, 1))
+		return nil
+	})
+	require.NoError(t, err)
+
+	check := func(t *testing.T, b *bolt.Bucket) {
+		c := b.Cursor()
+		c.SetReadAhead(1 << 20)
+		var n int
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			require.Equal(t, b.Get(k), v)
+			n++
+		}
+		require.Equal(t, 1001, n)
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			n--
+		}
+		require.Zero(t, n)
+		k, _ := c.Skip(-500)
+		require.Nil(t, k)
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		check(t, tx.Bucket([]byte("widgets")))
+		return nil
+	})
+	require.NoError(t, err)
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.NoError(t, b.Put([]byte("0500"), []byte("changed")))
+		check(t, b)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+// Ensure that Next and Prev move from a key deleted by the cursor, without
+// skipping or repeating keys.
+func TestCursor_DeleteAndNext(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	const n = 2000
+	remaining := func(b *bolt.Bucket) []string {
+		var keys []string
+		require.NoError(t, b.ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		}))
+		return keys
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		// Delete the even keys moving forward.
+		b := tx.Bucket([]byte("widgets"))
+		c := b.Cursor()
+		var seen int
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			seen++
+			if (k[3]-'0')%2 == 0 {
+				require.NoError(t, c.Delete())
+			}
+		}
+		require.Equal(t, n, seen)
+		keys := remaining(b)
+		require.Len(t, keys, n/2)
+		require.Equal(t, "0001", keys[0])
+
+		// Delete the keys ending with 1 moving backward.
+		seen = 0
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			seen++
+			if k[3] == '1' {
+				require.NoError(t, c.Delete())
+			}
+		}
+		require.Equal(t, n/2, seen)
+		keys = remaining(b)
+		require.Len(t, keys, n*2/5)
+		require.Equal(t, "0003", keys[0])
+
+		// A token taken after deleting resumes at the next key.
+		k, _ := c.Seek([]byte("0003"))
+		require.Equal(t, "0003", string(k))
+		require.NoError(t, c.Delete())
+		_, k, _, err := b.CursorAt(c.Token())
+		require.NoError(t, err)
+		require.Equal(t, "0005", string(k))
+
+		// Delete all the keys.
+		c = b.Cursor()
+		var deleted int
+		for k, _ := c.First(); k != nil; deleted++ {
+			k, _, err = c.DeleteAndNext()
+			require.NoError(t, err)
+		}
+		require.Equal(t, n*2/5-1, deleted)
+		require.Empty(t, remaining(b))
+		return nil
+	})
+	require.NoError(t, err)
+	db.MustCheck()
+}
+
+// Ensure that a cursor counts the pages it visits.
+func TestCursor_Stats(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		s := b.Stats()
+		c := b.Cursor()
+		require.Equal(t, bolt.CursorStats{}, c.Stats())
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		}
+		stats := c.Stats()
+		require.Equal(t, s.LeafPageN, stats.LeafPageN)
+		require.Equal(t, s.BranchPageN, stats.BranchPageN)
+		require.Equal(t, int64(s.LeafPageN+s.LeafOverflowN+s.BranchPageN+s.BranchOverflowN)*4096, stats.BytesTouched)
+		require.Zero(t, stats.NodeN)
+
+		// A seek visits a page per level.
+		c = b.Cursor()
+		c.Seek([]byte("0500"))
+		require.Equal(t, bolt.CursorStats{BranchPageN: s.Depth - 1, LeafPageN: 1, BytesTouched: int64(s.Depth) * 4096}, c.Stats())
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("widgets")).Cursor()
+		c.Seek([]byte("0500"))
+		require.NoError(t, c.Delete())
+		require.Greater(t, c.Stats().NodeN, 1)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+// Ensure that SeekGE and SeekLE return the ceiling and the floor of a key.
+func TestCursor_SeekGE_SeekLE(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		// Even keys only, across several pages.
+		for i := 0; i < 2000; i += 2 {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		_, err = tx.CreateBucket([]byte("empty"))
+		return err
+	})
+	require.NoError(t, err)
+
+	check := func(t *testing.T, b *bolt.Bucket) {
+		c := b.Cursor()
+		for _, tc := range []struct{ seek, ge, le string }{
+			{"", "0000", ""},
+			{"0000", "0000", "0000"},
+			{"0001", "0002", "0000"},
+			{"0999", "1000", "0998"},
+			{"1000", "1000", "1000"},
+			{"1998", "1998", "1998"},
+			{"1999", "", "1998"},
+			{"9", "", "1998"},
+		} {
+			k, _ := c.SeekGE([]byte(tc.seek))
+			require.Equal(t, tc.ge, string(k), "SeekGE(%q)", tc.seek)
+			k, _ = c.SeekLE([]byte(tc.seek))
+			require.Equal(t, tc.le, string(k), "SeekLE(%q)", tc.seek)
+		}
+
+		// The cursor moves on from the floor.
+		k, _ := c.SeekLE([]byte("0501"))
+		require.Equal(t, "0500", string(k))
+		k, _ = c.Next()
+		require.Equal(t, "0502", string(k))
+		k, _ = c.SeekLE([]byte("0501"))
+		require.Equal(t, "0500", string(k))
+		k, _ = c.Prev()
+		require.Equal(t, "0498", string(k))
+
+		// The floor stays within the bounds of the cursor.
+		c = b.RangeCursor([]byte("0100"), []byte("0200"))
+		k, _ = c.SeekLE([]byte("0500"))
+		require.Equal(t, "0198", string(k))
+		k, _ = c.SeekLE([]byte("0099"))
+		require.Nil(t, k)
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		check(t, tx.Bucket([]byte("widgets")))
+		k, _ := tx.Bucket([]byte("empty")).Cursor().SeekLE([]byte("a"))
+		require.Nil(t, k)
+		return nil
+	})
+	require.NoError(t, err)
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.NoError(t, b.Put([]byte("0500"), []byte("changed")))
+		check(t, b)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
 func ExampleCursor() {
 	// Open the database.
 	db, err := bolt.Open(tempfile(), 0600, nil)
@@ -0,0 +1,87 @@
+package boltdb
+
+import (
+	"encoding/binary"
+
+	"github.com/openkvlab/boltdb/errors"
+)
+
+// cursorTokenVersion is the version of the format of the tokens returned by
+// Cursor.Token.
+const cursorTokenVersion = 1
+
+const (
+	cursorTokenStart = 1 << iota // the cursor has a start bound
+	cursorTokenEnd               // the cursor has an end bound
+)
+
+// Token returns an opaque token of the key the cursor is positioned at, the
+// last one it returned, and of its bounds, from which Bucket.CursorAt
+// creates a cursor at the same position in a later transaction, e.g. to
+// resume a paginated scan without keeping the transaction open. To resume
+// after the last key of a page, call Next and take the token of the key it
+// returns. Returns nil if the cursor isn't positioned yet.
+func (c *Cursor) Token() []byte {
+	if len(c.stack) == 0 {
+		return nil
+	}
+	k, _, _ := c.keyValue()
+	if c.deleted != nil {
+		// Resume at the key after the deleted one.
+		k = c.deleted
+	}
+	if k == nil {
+		return nil
+	}
+	token := []byte{cursorTokenVersion, 0}
+	token = binary.AppendUvarint(token, uint64(len(k)))
+	token = append(token, k...)
+	for i, bound := range [][]byte{c.start, c.end} {
+		if bound == nil {
+			continue
+		}
+		token[1] |= 1 << i
+		token = binary.AppendUvarint(token, uint64(len(bound)))
+		token = append(token, bound...)
+	}
+	return token
+}
+
+// CursorAt creates a cursor from a token returned by Cursor.Token, with the
+// same bounds, and moves it to the key of the token, or to the next key if
+// it was deleted since. It returns the key and value like Seek, or
+// ErrInvalidCursorToken if the token can't be decoded.
+// The returned key and value are only valid for the life of the transaction.
+func (b *Bucket) CursorAt(token []byte) (c *Cursor, key []byte, value []byte, err error) {
+	if b.tx.db == nil {
+		return nil, nil, nil, errors.ErrTxClosed
+	}
+	if len(token) < 2 || token[0] != cursorTokenVersion || token[1]&^(cursorTokenStart|cursorTokenEnd) != 0 {
+		return nil, nil, nil, errors.ErrInvalidCursorToken
+	}
+	flags, buf := token[1], token[2:]
+	next := func() ([]byte, bool) {
+		n, sz := binary.Uvarint(buf)
+		if sz <= 0 || n > uint64(len(buf)-sz) {
+			return nil, false
+		}
+		v := buf[sz : sz+int(n)]
+		buf = buf[sz+int(n):]
+		return v, true
+	}
+	k, ok := next()
+	var bounds [2][]byte
+	for i := range bounds {
+		if ok && flags&(1<<i) != 0 {
+			bounds[i], ok = next()
+		}
+	}
+	if !ok || len(buf) != 0 {
+		return nil, nil, nil, errors.ErrInvalidCursorToken
+	}
+
+	c = b.Cursor()
+	c.bound(bounds[0], bounds[1])
+	key, value = c.Seek(k)
+	return c, key, value, nil
+}
@@ -1,12 +1,15 @@
 package boltdb
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -17,6 +20,23 @@ import (
 // The time elapsed between consecutive file locking attempts.
 const flockRetryTimeout = 50 * time.Millisecond
 
+// The range of page sizes accepted by DB.Migrate.
+const (
+	minPageSize = 1024
+	maxPageSize = 64 * 1024
+)
+
+// The stages of opening a database reported to Options.OpenProgress.
+const (
+	OpenStageRecover  = "recover"  // replay of the write-ahead log, in bytes of the log
+	OpenStageMmap     = "mmap"     // mapping, or decrypting, the data file, in bytes
+	OpenStageFreelist = "freelist" // loading the free pages, in pages of the database
+)
+
+// MemoryPath is the path which opens a new database in memory, without a data
+// file, e.g. for tests or caches. Its content is lost when it's closed.
+const MemoryPath = ":memory:"
+
 // DB represents a collection of buckets persisted to a file on disk.
 // All data access is performed through transactions which can be obtained through the DB.
 // All the functions on DB will return a ErrDatabaseNotOpen if accessed before Open() is called.
@@ -83,6 +103,14 @@ type DB struct {
 	// Do not change concurrently with calls to Batch.
 	MaxBatchDelay time.Duration
 
+	// AdaptiveBatch makes Batch pick the size and delay of each batch from
+	// the observed sync latency and number of concurrent calls, within
+	// MaxBatchSize and MaxBatchDelay. Batches wait less for more calls when
+	// syncs are fast or when there are few concurrent calls.
+	//
+	// Do not change concurrently with calls to Batch.
+	AdaptiveBatch bool
+
 	// AllocSize is the amount of space allocated when the database
 	// needs to create new pages. This is done to amortize the cost
 	// of truncate() and fsync() when growing the data file.
@@ -113,18 +141,199 @@ type DB struct {
 	freelist     *freelist
 	freelistLoad sync.Once
 
-	pagePool sync.Pool
+	// freelistRebuild reconstructs the free pages in the background when
+	// the freelist isn't synced, see startFreelistRebuild. It's cleared once
+	// they're added to the freelist.
+	freelistRebuild atomic.Pointer[freelistRebuild]
+
+	// freelistErr is set when the freelist read by Open is corrupted, see
+	// loadFreelist. The freelist is then empty until DB.RepairFreelist.
+	freelistErr atomic.Pointer[error]
+
+	pagePools pagePools
+
+	batchMu    sync.Mutex
+	batch      *batch
+	batchTuner batchTuner
+
+	autoCompactor *autoCompactor
+	reaper        *reaper
+
+	// freePageThreshold and onFreePageThreshold are set from the options,
+	// aboveFreePageThreshold records whether the ratio of free pages was
+	// above the threshold after the last commit. It's guarded by rwlock.
+	freePageThreshold      float64
+	onFreePageThreshold    func(txid int, ratio float64)
+	aboveFreePageThreshold bool
+
+	// indexes maps the name of each indexed top-level bucket to its
+	// indexes, see Options.Indexes.
+	indexes map[string][]*Index
+
+	// cipher encrypts pages before they are written to the data file.
+	// When set, data holds a decrypted copy of the file instead of an mmap.
+	cipher Cipher
+
+	// pageChecksums is set when every page except the meta pages ends with
+	// a checksum of its content. It's read from the meta page on each mmap.
+	pageChecksums bool
+
+	// pageTxids is set when every page except the meta pages stores the id
+	// of the transaction which wrote it. It's read from the meta page on
+	// each mmap.
+	pageTxids bool
+
+	// freelistSpans makes the commits write the freelist page as spans of
+	// free pages, see Options.FreelistSpans.
+	freelistSpans bool
+
+	// freelistSegments makes the commits write the freelist as segments which
+	// are only written when they change, see Options.FreelistSegments.
+	freelistSegments bool
+
+	// allocStrategy selects the free pages reused by the allocations, see
+	// Options.AllocStrategy.
+	allocStrategy AllocStrategy
+
+	// deterministicAlloc makes the allocations independent of the map
+	// iteration order, see Options.DeterministicAlloc.
+	deterministicAlloc bool
+
+	// wal is the write-ahead log when WAL commit mode is enabled.
+	wal *wal
+
+	// inMemory is set when there's no data file, and the content of the
+	// database is held in image instead. See MemoryPath and OpenReader.
+	inMemory bool
+	image    []byte
+
+	// groupCommit lets write transactions share fsyncs when
+	// Options.GroupCommitInterval is set.
+	groupCommit *groupCommit
+
+	// madvise and fadvise are the advice for the memory map and for the
+	// pages written by commits, see Options.Madvise and Options.Fadvise.
+	madvise Advice
+	fadvise Advice
+
+	// directIO is Options.DirectIO, directFile the descriptor of the data
+	// file opened with O_DIRECT for the writes, if it's in effect.
+	directIO   bool
+	directFile *os.File
+
+	// hugePages is Options.HugePages.
+	hugePages bool
+
+	// syncMode is Options.SyncMode.
+	syncMode SyncMode
+
+	// openProgress is Options.OpenProgress while the database is opened,
+	// and nil afterwards.
+	openProgress func(stage string, done, total int64)
+
+	// retained holds the meta of the transactions kept for DB.BeginAt, oldest
+	// first. It's protected by metalock.
+	retainTxs int
+	retained  []common.Meta
+
+	// reuseDelay is Options.ReuseDelay.
+	reuseDelay int
+
+	// maxDirtyBytes is Options.MaxDirtyBytes.
+	maxDirtyBytes int
+
+	// maxInlineBucketSize is Options.MaxInlineBucketSize.
+	maxInlineBucketSize int
+
+	// compressLargeValues is Options.CompressLargeValues.
+	compressLargeValues bool
+
+	// snapshots holds the open snapshots. It's protected by metalock.
+	snapshots map[*Snapshot]struct{}
+
+	// statsCache holds the stats of the unchanged buckets, see Bucket.Stats.
+	statsCache statsCache
+
+	// recovery describes the meta page the database was opened with.
+	recovery RecoveryInfo
+
+	// metaCopies is Options.MetaCopies.
+	metaCopies int
+
+	// strictErrors is set by Options.StrictErrors.
+	strictErrors bool
+
+	// verifyTouchedPages is set by Options.VerifyTouchedPages.
+	verifyTouchedPages bool
+
+	// growth sets the size of the memory map and of the data file when the
+	// database grows, or nil for the default policy. See Options.GrowthPolicy.
+	growth GrowthPolicy
+
+	// maxSize is the limit of the size of the data file in bytes, or 0 if
+	// it's unlimited. See Options.MaxSize.
+	maxSize int
+
+	// chunkSize is the size of each mapping when the data file is mapped
+	// in chunks, see Options.MmapChunkSize.
+	chunkSize int
+
+	// chunks holds the mapped chunks of the data file in chunked mode.
+	// Chunks are only appended while the file is mapped, and the writer
+	// doesn't wait for readers to do so, so it's accessed atomically.
+	chunks atomic.Pointer[[][]byte]
+
+	// noMmap is Options.NoMmap, pageCacheSize Options.PageCacheSize.
+	// pageCache holds the pages read with pread in that mode, it's only
+	// replaced under mmaplock.
+	noMmap         bool
+	pageCacheSize  int
+	pageCache      *pageCache
+	pageCacheStats pageCacheStats
 
-	batchMu sync.Mutex
-	batch   *batch
+	// spans holds the mappings of page runs which cross a chunk boundary,
+	// by the id of their first page, and retired the ones replaced by a
+	// longer one in spans. spanseq numbers the transactions as they begin
+	// and spantxs holds the numbers of the open ones, see trackSpans.
+	spanlock sync.Mutex
+	spans    map[common.Pgid]span
+	retired  []span
+	spanseq  uint64
+	spantxs  map[uint64]struct{}
 
 	rwlock   sync.Mutex   // Allows only one writer at a time.
 	metalock sync.Mutex   // Protects meta page access.
 	mmaplock sync.RWMutex // Protects mmap access during remapping.
 	statlock sync.RWMutex // Protects stats access.
 
+	// oldestReadTxStart is when the oldest open read transaction started,
+	// protected by statlock.
+	oldestReadTxStart time.Time
+	watchdog          *readTxWatchdog
+
+	hooklock sync.RWMutex // Protects commitHooks.
+
+	// commitHooks are called after every write transaction commits, see
+	// OnCommit.
+	commitHooks []func(txid uint64, stats TxStats)
+
+	watchlock sync.Mutex // Protects watchers and watchPending.
+
+	// watchers are the subscriptions of Watch, and watchCount their number,
+	// read without the lock when recording the changes.
+	watchers   map[*watcher]struct{}
+	watchCount atomic.Int32
+
+	// watchPending are the changes of the committed transactions which
+	// aren't durable yet, in the order of the transactions.
+	watchPending []watchedChanges
+
 	ops struct {
 		writeAt func(b []byte, off int64) (n int, err error)
+
+		// writev writes buffers back to back with a single vectored
+		// write, or is nil if the writes aren't vectored.
+		writev func(bufs [][]byte, off int64) (n int, err error)
 	}
 
 	// Read only mode.
@@ -151,7 +360,21 @@ func (db *DB) String() string {
 // If the file does not exist then it will be created automatically with a given file mode.
 // Passing in nil options will cause Bolt to open the database with the default options.
 // Note: For read/write transactions, ensure the owner has write permission on the created/opened database file, e.g. 0600
+// Passing MemoryPath opens a new database in memory instead, see MemoryPath.
 func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
+	return OpenContext(context.Background(), path, mode, options)
+}
+
+// OpenContext is like Open, but stops waiting for the file lock and returns
+// ctx.Err() once ctx is done. Options.Timeout still applies.
+func OpenContext(ctx context.Context, path string, mode os.FileMode, options *Options) (*DB, error) {
+	return open(ctx, path, mode, options, false)
+}
+
+// open opens the database at path. A backup is opened read-only without
+// locking the file, replaying the write-ahead log or loading the free pages,
+// see OpenBackupFile.
+func open(ctx context.Context, path string, mode os.FileMode, options *Options, backup bool) (*DB, error) {
 	db := &DB{
 		opened: true,
 	}
@@ -165,6 +388,43 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 	db.NoFreelistSync = options.NoFreelistSync
 	db.PreLoadFreelist = options.PreLoadFreelist
 	db.Mlock = options.Mlock
+	db.cipher = options.Encryption
+	db.pageChecksums = options.PageChecksums
+	db.pageTxids = options.PageTxids
+	db.freelistSpans = options.FreelistSpans
+	db.freelistSegments = options.FreelistSegments
+	db.allocStrategy = options.AllocStrategy
+	db.deterministicAlloc = options.DeterministicAlloc
+	db.freePageThreshold = options.FreePageThreshold
+	db.onFreePageThreshold = options.OnFreePageThreshold
+	db.maxSize = options.MaxSize
+	db.growth = options.GrowthPolicy
+	db.strictErrors = options.StrictErrors
+	db.verifyTouchedPages = options.VerifyTouchedPages
+	db.retainTxs = options.RetainTxs
+	db.reuseDelay = max(options.ReuseDelay, 0)
+	db.maxDirtyBytes = options.MaxDirtyBytes
+	db.maxInlineBucketSize = options.MaxInlineBucketSize
+	db.compressLargeValues = options.CompressLargeValues
+	db.madvise = options.Madvise
+	db.fadvise = options.Fadvise
+	db.directIO = options.DirectIO
+	db.hugePages = options.HugePages
+	db.syncMode = options.SyncMode
+	db.metaCopies = max(options.MetaCopies, 0)
+	db.noMmap = options.NoMmap
+	db.pageCacheSize = options.PageCacheSize
+	if db.pageCacheSize <= 0 {
+		db.pageCacheSize = defaultPageCacheSize
+	}
+	if db.noMmap {
+		db.Mlock = false
+	}
+	indexes, err := newIndexes(options.Indexes)
+	if err != nil {
+		return nil, err
+	}
+	db.indexes = indexes
 
 	// Set default values for later DB operations.
 	db.MaxBatchSize = common.DefaultMaxBatchSize
@@ -172,7 +432,11 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 	db.AllocSize = common.DefaultAllocSize
 
 	flag := os.O_RDWR
-	if options.ReadOnly {
+	if backup {
+		flag = os.O_RDONLY
+		db.readOnly = true
+		db.PreLoadFreelist = false
+	} else if options.ReadOnly {
 		flag = os.O_RDONLY
 		db.readOnly = true
 	} else {
@@ -186,8 +450,11 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 		db.openFile = os.OpenFile
 	}
 
+	if path == MemoryPath {
+		return db.openMemory(options)
+	}
+
 	// Open data file and separate sync handler for metadata writes.
-	var err error
 	if db.file, err = db.openFile(path, flag, mode); err != nil {
 		_ = db.close()
 		return nil, err
@@ -201,23 +468,43 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 	// if !options.ReadOnly.
 	// The database file is locked using the shared lock (more than one process may
 	// hold a lock at the same time) otherwise (options.ReadOnly is set).
-	if err := flock(db, !db.readOnly, options.Timeout); err != nil {
+	if !backup {
+		if err := flock(ctx, db, !db.readOnly, options.Timeout); err != nil {
+			_ = db.close()
+			return nil, err
+		}
+	}
+
+	if err := db.openDirect(); err != nil {
 		_ = db.close()
 		return nil, err
 	}
 
 	// Default values for test hooks
-	db.ops.writeAt = db.file.WriteAt
+	db.setWriteAt()
 
 	if db.pageSize = options.PageSize; db.pageSize == 0 {
 		// Set the default page size to the OS page size.
 		db.pageSize = common.DefaultPageSize
 	}
 
+	db.openProgress = options.OpenProgress
+
+	// Replay the write-ahead log of a process which crashed in WAL mode.
+	if !backup {
+		if err := db.recoverWAL(); err != nil {
+			_ = db.close()
+			return nil, err
+		}
+	}
+
 	// Initialize the database if it doesn't exist.
 	if info, err := db.file.Stat(); err != nil {
 		_ = db.close()
 		return nil, err
+	} else if info.Size() == 0 && backup {
+		_ = db.close()
+		return nil, berrors.ErrInvalid
 	} else if info.Size() == 0 {
 		// Initialize new files with meta pages.
 		if err := db.init(); err != nil {
@@ -226,6 +513,14 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 			return nil, err
 		}
 	} else {
+		// Restore the meta pages from a copy if they're both corrupted.
+		if db.metaCopies > 0 && !db.readOnly && db.cipher == nil {
+			if err := db.restoreMetaCopy(); err != nil {
+				_ = db.close()
+				return nil, err
+			}
+		}
+
 		// try to get the page size from the metadata pages
 		if pgSize, err := db.getPageSize(); err == nil {
 			db.pageSize = pgSize
@@ -235,21 +530,45 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 		}
 	}
 
-	// Initialize page pool.
-	db.pagePool = sync.Pool{
-		New: func() interface{} {
-			return make([]byte, db.pageSize)
-		},
+	if options.MmapChunkSize > 0 {
+		db.chunkSize = db.roundChunkSize(options.MmapChunkSize)
 	}
 
 	// Memory map the data file.
+	var mapped int64
+	if db.openProgress != nil {
+		if sz, err := db.fileSize(); err == nil {
+			mapped = int64(sz)
+		}
+		db.reportOpenProgress(OpenStageMmap, 0, mapped)
+	}
 	if err := db.mmap(options.InitialMmapSize); err != nil {
 		_ = db.close()
 		return nil, err
 	}
+	db.reportOpenProgress(OpenStageMmap, mapped, mapped)
+
+	if err := db.checkRecovery(options.OnMetaRollback); err != nil {
+		_ = db.close()
+		return nil, err
+	}
 
-	if db.PreLoadFreelist {
+	if !db.readOnly && db.NoFreelistSync && !db.hasSyncedFreelist() {
+		// Transactions may begin while the free pages are reconstructed.
+		db.freelistLoad.Do(db.startFreelistRebuild)
+	} else if db.PreLoadFreelist {
+		pages := int64(db.meta().Pgid())
+		db.reportOpenProgress(OpenStageFreelist, 0, pages)
 		db.loadFreelist()
+		if options.VerifyFreelist {
+			db.verifyFreelist()
+		}
+		db.reportOpenProgress(OpenStageFreelist, pages, pages)
+	}
+	db.openProgress = nil
+
+	if options.MaxReadTxDuration > 0 {
+		db.startReadTxWatchdog(options)
 	}
 
 	if db.readOnly {
@@ -269,6 +588,25 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 		}
 	}
 
+	if options.WAL {
+		if err := db.openWAL(options.WALCheckpointSize); err != nil {
+			_ = db.close()
+			return nil, err
+		}
+	}
+
+	if options.GroupCommitInterval > 0 && !options.WAL {
+		db.startGroupCommit(options.GroupCommitInterval)
+	}
+
+	if options.AutoCompact {
+		db.startAutoCompact(options.AutoCompactInterval, options.AutoCompactFreeRatio)
+	}
+
+	if options.ReapInterval > 0 {
+		db.startReaper(options.ReapInterval)
+	}
+
 	// Mark the database as opened and return.
 	return db, nil
 }
@@ -277,6 +615,10 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 // to read the first meta page firstly. If the first page is invalid,
 // then it tries to read the second page using the default page size.
 func (db *DB) getPageSize() (int, error) {
+	if db.cipher != nil {
+		return db.getEncryptedPageSize()
+	}
+
 	var (
 		meta0CanRead, meta1CanRead bool
 	)
@@ -316,7 +658,11 @@ func (db *DB) getPageSize() (int, error) {
 func (db *DB) getPageSizeFromFirstMeta() (int, bool, error) {
 	var buf [0x1000]byte
 	var metaCanRead bool
-	if bw, err := db.file.ReadAt(buf[:], 0); err == nil && bw == len(buf) {
+	r, _, err := db.fileReader()
+	if err != nil {
+		return 0, metaCanRead, err
+	}
+	if bw, err := r.ReadAt(buf[:], 0); err == nil && bw == len(buf) {
 		metaCanRead = true
 		if m := db.pageInBuffer(buf[:], 0).Meta(); m.Validate() == nil {
 			return int(m.PageSize()), metaCanRead, nil
@@ -333,10 +679,9 @@ func (db *DB) getPageSizeFromSecondMeta() (int, bool, error) {
 	)
 
 	// get the db file size
-	if info, err := db.file.Stat(); err != nil {
+	r, fileSize, err := db.fileReader()
+	if err != nil {
 		return 0, metaCanRead, err
-	} else {
-		fileSize = info.Size()
 	}
 
 	// We need to read the second meta page, so we should skip the first page;
@@ -351,7 +696,7 @@ func (db *DB) getPageSizeFromSecondMeta() (int, bool, error) {
 		if pos >= fileSize-1024 {
 			break
 		}
-		bw, err := db.file.ReadAt(buf[:], pos)
+		bw, err := r.ReadAt(buf[:], pos)
 		if (err == nil && bw == len(buf)) || (err == io.EOF && int64(bw) == (fileSize-pos)) {
 			metaCanRead = true
 			if m := db.pageInBuffer(buf[:], 0).Meta(); m.Validate() == nil {
@@ -368,28 +713,80 @@ func (db *DB) getPageSizeFromSecondMeta() (int, bool, error) {
 // concurrent accesses being made to the freelist.
 func (db *DB) loadFreelist() {
 	db.freelistLoad.Do(func() {
-		db.freelist = newFreelist()
+		db.freelist = db.newFreelist()
 		if !db.hasSyncedFreelist() {
 			// Reconstruct free list by scanning the DB.
 			db.freelist.readIDs(db.freepages())
 		} else {
-			// Read free list from freelist page.
-			db.freelist.read(db.page(db.meta().Freelist()))
+			// Read free list from freelist page, unless it's corrupted.
+			p := db.page(db.meta().Freelist())
+			ids := db.freelist.pageIDs(p)
+			if err := validateFreeIDs(ids, freelistPages(db.meta(), db.page), db.meta().Pgid()); err != nil {
+				db.freelistErr.Store(&err)
+			} else {
+				db.freelist.readIDs(ids)
+				db.freelist.markClean()
+			}
 		}
 		db.stats.FreePageN = db.freelist.free_count()
+		db.stats.Freelist = db.freelist.spanStats()
 	})
 }
 
+// newFreelist returns an empty freelist which reads the freelist page of the
+// current meta page and allocates with the strategy of the database.
+func (db *DB) newFreelist() *freelist {
+	f := newFreelist()
+	f.spans = db.meta().HasFreelistSpans()
+	f.segments = db.meta().HasFreelistSegments()
+	f.segmentIDs = common.FreelistSegmentIDs(db.pageSize)
+	f.page = db.page
+	f.reuseDelay = common.Txid(db.reuseDelay)
+	s := db.allocStrategy
+	if s == AllocDefault && db.deterministicAlloc {
+		// Like the default strategy, the best fit reuses a span of exactly the
+		// allocated size if there is one, but always the lowest one.
+		s = AllocBestFit
+	}
+	f.setAllocStrategy(s)
+	return f
+}
+
+// reportOpenProgress calls Options.OpenProgress while the database is opened.
+func (db *DB) reportOpenProgress(stage string, done, total int64) {
+	if db.openProgress != nil {
+		db.openProgress(stage, done, total)
+	}
+}
+
+// setPageSize switches the database to pages of pageSize bytes once the data
+// file has been replaced. The caller must hold the writer and mmap locks.
+func (db *DB) setPageSize(pageSize int) {
+	db.pageSize = pageSize
+	// Buffers of the old page size must not be reused.
+	db.pagePools = pagePools{}
+	if db.chunkSize > 0 {
+		db.chunkSize = db.roundChunkSize(db.chunkSize)
+	}
+}
+
 func (db *DB) hasSyncedFreelist() bool {
 	return db.meta().Freelist() != common.PgidNoFreelist
 }
 
 func (db *DB) fileSize() (int, error) {
+	if db.inMemory {
+		return len(db.image), nil
+	}
 	info, err := db.file.Stat()
 	if err != nil {
 		return 0, fmt.Errorf("file stat error: %w", err)
 	}
 	sz := int(info.Size())
+	if db.cipher != nil {
+		// Report the size of the decrypted data.
+		sz = sz / db.encryptedPageSize() * db.pageSize
+	}
 	if sz < db.pageSize*2 {
 		return 0, fmt.Errorf("file size too small %d", sz)
 	}
@@ -399,6 +796,13 @@ func (db *DB) fileSize() (int, error) {
 // mmap opens the underlying memory-mapped file and initializes the meta references.
 // minsz is the minimum size that the new mmap can be.
 func (db *DB) mmap(minsz int) (err error) {
+	if db.noMmap && !db.inMemory {
+		return db.mmapPread(minsz)
+	}
+	if db.chunkSize > 0 && db.cipher == nil {
+		return db.mmapChunks(minsz)
+	}
+
 	db.mmaplock.Lock()
 	defer db.mmaplock.Unlock()
 
@@ -415,8 +819,13 @@ func (db *DB) mmap(minsz int) (err error) {
 	if err != nil {
 		return err
 	}
+	// Don't map past the size limit, since the file is extended to the size
+	// of the mapping on Windows.
+	if limit := db.maxDataSize(); limit > 0 && size > limit {
+		size = max(limit, fileSize, minsz)
+	}
 
-	if db.Mlock {
+	if db.Mlock && db.cipher == nil {
 		// Unlock db memory
 		if err := db.munlock(fileSize); err != nil {
 			return err
@@ -426,6 +835,7 @@ func (db *DB) mmap(minsz int) (err error) {
 	// Dereference all mmap references before unmapping.
 	if db.rwtx != nil {
 		db.rwtx.root.dereference()
+		db.rwtx.remapped = true
 	}
 
 	// Unmap existing data before continuing.
@@ -433,10 +843,18 @@ func (db *DB) mmap(minsz int) (err error) {
 		return err
 	}
 
-	// Memory-map the data file as a byte slice.
-	// gofail: var mapError string
-	// return errors.New(mapError)
-	if err = mmap(db, size); err != nil {
+	if db.inMemory {
+		db.mmapMemory(size)
+	} else if db.cipher != nil {
+		// Encrypted pages can't be mapped, so decrypt them into memory.
+		err = db.mmapEncrypted(size)
+	} else {
+		// Memory-map the data file as a byte slice.
+		// gofail: var mapError string
+		// return errors.New(mapError)
+		err = mmap(db, size)
+	}
+	if err != nil {
 		return err
 	}
 
@@ -450,13 +868,19 @@ func (db *DB) mmap(minsz int) (err error) {
 		}
 	}()
 
-	if db.Mlock {
+	if db.Mlock && db.cipher == nil {
 		// Don't allow swapping of data file
 		if err := db.mlock(fileSize); err != nil {
 			return err
 		}
 	}
 
+	return db.loadMeta()
+}
+
+// loadMeta saves references to the meta pages of the mapped data file and
+// validates them.
+func (db *DB) loadMeta() error {
 	// Save references to the meta pages.
 	db.meta0 = db.page(0).Meta()
 	db.meta1 = db.page(1).Meta()
@@ -469,6 +893,8 @@ func (db *DB) mmap(minsz int) (err error) {
 	if err0 != nil && err1 != nil {
 		return err0
 	}
+	db.pageChecksums = db.meta().HasPageChecksums()
+	db.pageTxids = db.meta().HasPageTxids()
 
 	return nil
 }
@@ -486,6 +912,25 @@ func (db *DB) invalidate() {
 func (db *DB) munmap() error {
 	defer db.invalidate()
 
+	if db.chunks.Load() != nil {
+		if err := db.munmapChunks(); err != nil {
+			return fmt.Errorf("unmap error: " + err.Error())
+		}
+		return nil
+	}
+
+	if db.pageCache != nil {
+		// The pages were read, not mapped.
+		db.resetPageCache()
+		return nil
+	}
+
+	if db.cipher != nil || db.inMemory {
+		// The decrypted data or the image isn't mapped, it's released by
+		// invalidate.
+		return nil
+	}
+
 	// gofail: var unmapError string
 	// return errors.New(unmapError)
 	if err := munmap(db); err != nil {
@@ -496,9 +941,17 @@ func (db *DB) munmap() error {
 }
 
 // mmapSize determines the appropriate size for the mmap given the current size
-// of the database. The minimum size is 32KB and doubles until it reaches 1GB.
+// of the database. The minimum size is 32KB and doubles until it reaches 1GB,
+// unless the growth policy is set.
 // Returns an error if the new mmap size is greater than the max allowed.
 func (db *DB) mmapSize(size int) (int, error) {
+	if db.growth != nil {
+		if size > maxMapSize {
+			return 0, fmt.Errorf("mmap too large")
+		}
+		return db.growthSize(size), nil
+	}
+
 	// Double the size from 32KB until 1GB.
 	for i := uint(15); i <= 30; i++ {
 		if size <= 1<<i {
@@ -563,7 +1016,7 @@ func (db *DB) mrelock(fileSizeFrom, fileSizeTo int) error {
 // init creates a new database file and initializes its meta pages.
 func (db *DB) init() error {
 	// Create two meta pages on a buffer.
-	buf := make([]byte, db.pageSize*4)
+	buf := db.pageBuffer(db.pageSize * 4)
 	for i := 0; i < 2; i++ {
 		p := db.pageInBuffer(buf, common.Pgid(i))
 		p.SetId(common.Pgid(i))
@@ -572,8 +1025,14 @@ func (db *DB) init() error {
 		// Initialize the meta page.
 		m := p.Meta()
 		m.SetMagic(common.Magic)
-		m.SetVersion(common.Version)
 		m.SetPageSize(uint32(db.pageSize))
+		if db.pageChecksums {
+			m.SetFlags(m.Flags() | common.MetaFlagPageChecksums)
+		}
+		if db.pageTxids {
+			m.SetFlags(m.Flags() | common.MetaFlagPageTxids)
+		}
+		m.SetVersion(common.VersionOf(m.Flags()))
 		m.SetFreelist(2)
 		m.SetRootBucket(common.NewInBucket(3, 0))
 		m.SetPgid(4)
@@ -593,6 +1052,11 @@ func (db *DB) init() error {
 	p.SetFlags(common.LeafPageFlag)
 	p.SetCount(0)
 
+	if db.pageChecksums {
+		db.pageInBuffer(buf, 2).SetChecksum(db.pageSize)
+		db.pageInBuffer(buf, 3).SetChecksum(db.pageSize)
+	}
+
 	// Write the buffer to our data file.
 	if _, err := db.ops.writeAt(buf, 0); err != nil {
 		return err
@@ -608,6 +1072,13 @@ func (db *DB) init() error {
 // It will block waiting for any open transactions to finish
 // before closing the database and returning.
 func (db *DB) Close() error {
+	// Stop the background compactor before taking any locks, since a
+	// compaction in progress holds the writer lock.
+	db.stopAutoCompact()
+	db.stopReaper()
+	db.stopReadTxWatchdog()
+	db.stopFreelistRebuild()
+
 	db.rwlock.Lock()
 	defer db.rwlock.Unlock()
 
@@ -626,19 +1097,40 @@ func (db *DB) close() error {
 	}
 
 	db.opened = false
+	db.closeSnapshots()
+	db.statsCache.reset()
+	db.closeWatchers()
 
 	db.freelist = nil
 
+	var errs []error
+	// Sync the meta pages of transactions still waiting for a group commit.
+	if db.groupCommit != nil {
+		if err := db.groupCommit.close(db); err != nil {
+			errs = append(errs, fmt.Errorf("group commit sync: %w", err))
+		}
+	}
+
+	// Checkpoint and remove the write-ahead log.
+	if db.wal != nil {
+		if err := db.closeWAL(); err != nil {
+			errs = append(errs, fmt.Errorf("close wal: %w", err))
+		}
+	}
+
 	// Clear ops.
 	db.ops.writeAt = nil
+	db.ops.writev = nil
 
-	var errs []error
 	// Close the mmap.
 	if err := db.munmap(); err != nil {
 		errs = append(errs, err)
 	}
 
 	// Close file handles.
+	if err := db.closeDirect(); err != nil {
+		errs = append(errs, fmt.Errorf("direct file close: %w", err))
+	}
 	if db.file != nil {
 		// No need to unlock read-only file.
 		if !db.readOnly {
@@ -656,6 +1148,7 @@ func (db *DB) close() error {
 	}
 
 	db.path = ""
+	db.image = nil
 
 	if len(errs) > 0 {
 		return errs[0]
@@ -682,21 +1175,75 @@ func (db *DB) close() error {
 // else the database will not reclaim old pages.
 func (db *DB) Begin(writable bool) (*Tx, error) {
 	if writable {
-		return db.beginRWTx()
+		return db.beginRWTx(context.Background())
+	}
+	return db.beginTx(context.Background())
+}
+
+// BeginWithTimeout starts a new transaction like Begin, but returns
+// ErrTimeout if the locks can't be obtained within d, e.g. when a write
+// transaction waits for a long-running update to finish. When d is 0 it
+// waits indefinitely.
+func (db *DB) BeginWithTimeout(writable bool, d time.Duration) (*Tx, error) {
+	ctx := context.Background()
+	if d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
+	var tx *Tx
+	var err error
+	if writable {
+		tx, err = db.beginRWTx(ctx)
+	} else {
+		tx, err = db.beginTx(ctx)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, berrors.ErrTimeout
 	}
-	return db.beginTx()
+	return tx, err
+}
+
+// BeginAt starts a read-only transaction which sees the database as of the
+// committed transaction txid, which must be the latest one or one of the
+// Options.RetainTxs transactions committed before it since the database was
+// opened. Otherwise ErrTxNotRetained is returned.
+//
+// This allows paginating through a consistent view across requests without
+// keeping a read transaction open between them.
+func (db *DB) BeginAt(txid int) (*Tx, error) {
+	return db.beginTxAt(context.Background(), func() (*common.Meta, error) {
+		if m := db.retainedMeta(common.Txid(txid)); m != nil {
+			return m, nil
+		}
+		return nil, berrors.ErrTxNotRetained
+	})
+}
+
+func (db *DB) beginTx(ctx context.Context) (*Tx, error) {
+	return db.beginTxAt(ctx, func() (*common.Meta, error) {
+		return db.meta(), nil
+	})
 }
 
-func (db *DB) beginTx() (*Tx, error) {
+// beginTxAt starts a read-only transaction at the committed transaction of
+// the meta returned by at, which is called with metalock held.
+func (db *DB) beginTxAt(ctx context.Context, at func() (*common.Meta, error)) (*Tx, error) {
 	// Lock the meta pages while we initialize the transaction. We obtain
 	// the meta lock before the mmap lock because that's the order that the
 	// write transaction will obtain them.
-	db.metalock.Lock()
+	if err := lockContext(ctx, &db.metalock); err != nil {
+		return nil, err
+	}
 
 	// Obtain a read-only lock on the mmap. When the mmap is remapped it will
 	// obtain a write lock so all transactions must finish before it can be
 	// remapped.
-	db.mmaplock.RLock()
+	if err := lockContext(ctx, db.mmaplock.RLocker()); err != nil {
+		db.metalock.Unlock()
+		return nil, err
+	}
 
 	// Exit if the database is not open yet.
 	if !db.opened {
@@ -706,19 +1253,28 @@ func (db *DB) beginTx() (*Tx, error) {
 	}
 
 	// Exit if the database is not correctly mapped.
-	if db.data == nil {
+	if !db.mapped() {
 		db.mmaplock.RUnlock()
 		db.metalock.Unlock()
 		return nil, berrors.ErrInvalidMapping
 	}
 
+	meta, err := at()
+	if err != nil {
+		db.mmaplock.RUnlock()
+		db.metalock.Unlock()
+		return nil, err
+	}
+
 	// Create a transaction associated with the database.
-	t := &Tx{}
-	t.init(db)
+	t := &Tx{started: time.Now()}
+	t.initAt(db, meta)
+	db.trackSpans(t)
 
 	// Keep track of transaction until it closes.
 	db.txs = append(db.txs, t)
 	n := len(db.txs)
+	txid := int(t.meta.Txid())
 
 	// Unlock the meta pages.
 	db.metalock.Unlock()
@@ -727,12 +1283,15 @@ func (db *DB) beginTx() (*Tx, error) {
 	db.statlock.Lock()
 	db.stats.TxN++
 	db.stats.OpenTxN = n
+	if n == 1 {
+		db.setOldestReadTx(txid, t.started)
+	}
 	db.statlock.Unlock()
 
 	return t, nil
 }
 
-func (db *DB) beginRWTx() (*Tx, error) {
+func (db *DB) beginRWTx(ctx context.Context) (*Tx, error) {
 	// If the database was opened with Options.ReadOnly, return an error.
 	if db.readOnly {
 		return nil, berrors.ErrDatabaseReadOnly
@@ -740,7 +1299,9 @@ func (db *DB) beginRWTx() (*Tx, error) {
 
 	// Obtain writer lock. This is released by the transaction when it closes.
 	// This enforces only one writer transaction at a time.
-	db.rwlock.Lock()
+	if err := lockContext(ctx, &db.rwlock); err != nil {
+		return nil, err
+	}
 
 	// Once we have the writer lock then we can lock the meta pages so that
 	// we can set up the transaction.
@@ -754,7 +1315,7 @@ func (db *DB) beginRWTx() (*Tx, error) {
 	}
 
 	// Exit if the database is not correctly mapped.
-	if db.data == nil {
+	if !db.mapped() {
 		db.rwlock.Unlock()
 		return nil, berrors.ErrInvalidMapping
 	}
@@ -762,11 +1323,50 @@ func (db *DB) beginRWTx() (*Tx, error) {
 	// Create a transaction associated with the database.
 	t := &Tx{writable: true}
 	t.init(db)
+	db.trackSpans(t)
 	db.rwtx = t
-	db.freelist.release(db.readonlyTxids())
+	db.retainTx()
+	rtxids := append(db.readonlyTxids(), db.retainedTxids()...)
+	rtxids = append(rtxids, db.snapshotTxids()...)
+	if db.groupCommit != nil {
+		// Until the last meta page is synced, a crash would bring back the
+		// last synced one, so its pages must be kept like a reader's.
+		if txid, ok := db.groupCommit.unsynced(); ok {
+			rtxids = append(rtxids, txid)
+		}
+	}
+	db.freelist.release(t.meta.Txid(), rtxids)
 	return t, nil
 }
 
+// lockContext acquires l, or returns ctx.Err() if ctx is done first. If the
+// wait is abandoned, l is released as soon as it's eventually acquired.
+func lockContext(ctx context.Context, l sync.Locker) error {
+	if ctx.Done() == nil {
+		l.Lock()
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		l.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-locked
+			l.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
 // readonlyTxids return all the readonly Txids, which doesn't have duplicated txid.
 func (db *DB) readonlyTxids() []common.Txid {
 	var (
@@ -785,6 +1385,8 @@ func (db *DB) readonlyTxids() []common.Txid {
 
 // removeTx removes a transaction from the database.
 func (db *DB) removeTx(tx *Tx) {
+	db.releaseSpans(tx)
+
 	// Release the read lock on the mmap.
 	db.mmaplock.RUnlock()
 
@@ -802,6 +1404,7 @@ func (db *DB) removeTx(tx *Tx) {
 		}
 	}
 	n := len(db.txs)
+	oldestID, oldestStart := db.oldestReadTx()
 
 	// Unlock the meta pages.
 	db.metalock.Unlock()
@@ -809,6 +1412,7 @@ func (db *DB) removeTx(tx *Tx) {
 	// Merge statistics.
 	db.statlock.Lock()
 	db.stats.OpenTxN = n
+	db.setOldestReadTx(oldestID, oldestStart)
 	db.stats.TxStats.add(&tx.stats)
 	db.statlock.Unlock()
 }
@@ -821,7 +1425,14 @@ func (db *DB) removeTx(tx *Tx) {
 //
 // Attempting to manually commit or rollback within the function will cause a panic.
 func (db *DB) Update(fn func(*Tx) error) error {
-	t, err := db.Begin(true)
+	return db.UpdateContext(context.Background(), fn)
+}
+
+// UpdateContext is like Update, but stops waiting for the writer lock and
+// returns ctx.Err() once ctx is done. If ctx is done by the time fn returns,
+// the transaction is rolled back instead of committed.
+func (db *DB) UpdateContext(ctx context.Context, fn func(*Tx) error) error {
+	t, err := db.beginRWTx(ctx)
 	if err != nil {
 		return err
 	}
@@ -839,6 +1450,9 @@ func (db *DB) Update(fn func(*Tx) error) error {
 	// If an error is returned from the function then rollback and return error.
 	err = fn(t)
 	t.managed = false
+	if err == nil {
+		err = ctx.Err()
+	}
 	if err != nil {
 		_ = t.Rollback()
 		return err
@@ -852,11 +1466,22 @@ func (db *DB) Update(fn func(*Tx) error) error {
 //
 // Attempting to manually rollback within the function will cause a panic.
 func (db *DB) View(fn func(*Tx) error) error {
-	t, err := db.Begin(false)
+	return db.ViewContext(context.Background(), fn)
+}
+
+// ViewContext is like View, but stops waiting for the transaction to start,
+// e.g. while the database is being remapped, and returns ctx.Err() once ctx
+// is done.
+func (db *DB) ViewContext(ctx context.Context, fn func(*Tx) error) error {
+	t, err := db.beginTx(ctx)
 	if err != nil {
 		return err
 	}
+	return t.view(fn)
+}
 
+// view executes fn within the read-only transaction t, and closes it.
+func (t *Tx) view(fn func(*Tx) error) (err error) {
 	// Make sure the transaction rolls back in the event of a panic.
 	defer func() {
 		if t.db != nil {
@@ -870,6 +1495,9 @@ func (db *DB) View(fn func(*Tx) error) error {
 	// If an error is returned from the function then pass it through.
 	err = fn(t)
 	t.managed = false
+	if err == nil {
+		err = t.err
+	}
 	if err != nil {
 		_ = t.Rollback()
 		return err
@@ -878,6 +1506,17 @@ func (db *DB) View(fn func(*Tx) error) error {
 	return t.Rollback()
 }
 
+// OnCommit registers f to be called after every write transaction commits,
+// including the ones of Update and Batch, e.g. to invalidate caches or to
+// trigger replication. f is called with the id and the statistics of the
+// transaction once its changes are durable, after the writer lock has been
+// released and after the handlers registered with Tx.OnCommit.
+func (db *DB) OnCommit(f func(txid uint64, stats TxStats)) {
+	db.hooklock.Lock()
+	defer db.hooklock.Unlock()
+	db.commitHooks = append(db.commitHooks, f)
+}
+
 // Batch calls fn as part of a batch. It behaves similar to Update,
 // except:
 //
@@ -892,22 +1531,61 @@ func (db *DB) View(fn func(*Tx) error) error {
 // caller.
 //
 // The maximum batch size and delay can be adjusted with DB.MaxBatchSize
-// and DB.MaxBatchDelay, respectively.
+// and DB.MaxBatchDelay, respectively, or adapted to the workload with
+// DB.AdaptiveBatch.
 //
 // Batch is only useful when there are multiple goroutines calling it.
 func (db *DB) Batch(fn func(*Tx) error) error {
+	return db.BatchWithOptions(fn, nil)
+}
+
+// BatchOptions overrides the batching parameters for a call to
+// DB.BatchWithOptions. Zero fields use the parameters of the DB.
+type BatchOptions struct {
+	// MaxBatchSize, if >0, runs the batch as soon as it holds this many
+	// calls, including this one.
+	MaxBatchSize int
+
+	// MaxBatchDelay, if >0, runs the batch at most this long after this
+	// call joined it, e.g. for latency sensitive calls.
+	MaxBatchDelay time.Duration
+}
+
+// BatchWithOptions calls fn as part of a batch like Batch. The options only
+// apply to this call, but they also make the batch it joins run earlier.
+func (db *DB) BatchWithOptions(fn func(*Tx) error, o *BatchOptions) error {
 	errCh := make(chan error, 1)
 
 	db.batchMu.Lock()
-	if (db.batch == nil) || (db.batch != nil && len(db.batch.calls) >= db.MaxBatchSize) {
+	size, delay := db.MaxBatchSize, db.MaxBatchDelay
+	if db.AdaptiveBatch {
+		size, delay = db.batchTuner.limits(size, delay)
+	}
+	if o != nil {
+		if o.MaxBatchSize > 0 {
+			size = o.MaxBatchSize
+		}
+		if o.MaxBatchDelay > 0 {
+			delay = o.MaxBatchDelay
+		}
+	}
+	if (db.batch == nil) || (db.batch != nil && len(db.batch.calls) >= db.batch.size) {
 		// There is no existing batch, or the existing batch is full; start a new one.
 		db.batch = &batch{
-			db: db,
+			db:       db,
+			size:     size,
+			deadline: time.Now().Add(delay),
+		}
+		db.batch.timer = time.AfterFunc(delay, db.batch.trigger)
+	} else {
+		db.batch.size = min(db.batch.size, size)
+		if deadline := time.Now().Add(delay); deadline.Before(db.batch.deadline) {
+			db.batch.deadline = deadline
+			db.batch.timer.Reset(delay)
 		}
-		db.batch.timer = time.AfterFunc(db.MaxBatchDelay, db.batch.trigger)
 	}
 	db.batch.calls = append(db.batch.calls, call{fn: fn, err: errCh})
-	if len(db.batch.calls) >= db.MaxBatchSize {
+	if len(db.batch.calls) >= db.batch.size {
 		// wake up batch, it's ready to run
 		go db.batch.trigger()
 	}
@@ -926,10 +1604,12 @@ type call struct {
 }
 
 type batch struct {
-	db    *DB
-	timer *time.Timer
-	start sync.Once
-	calls []call
+	db       *DB
+	timer    *time.Timer
+	start    sync.Once
+	calls    []call
+	size     int       // number of calls which triggers the batch
+	deadline time.Time // time at which the timer triggers the batch
 }
 
 // trigger runs the batch if it hasn't already been run.
@@ -949,10 +1629,15 @@ func (b *batch) run() {
 	}
 	b.db.batchMu.Unlock()
 
+	n := len(b.calls)
+	var committed bool
+	var syncTime time.Duration
 retry:
 	for len(b.calls) > 0 {
 		var failIdx = -1
+		var btx *Tx
 		err := b.db.Update(func(tx *Tx) error {
+			btx = tx
 			for i, c := range b.calls {
 				if err := safelyCall(c.fn, tx); err != nil {
 					failIdx = i
@@ -977,8 +1662,59 @@ retry:
 		for _, c := range b.calls {
 			c.err <- err
 		}
+		if err == nil {
+			committed, syncTime = true, btx.stats.GetSyncTime()
+		}
 		break retry
 	}
+
+	if committed {
+		b.db.batchMu.Lock()
+		b.db.batchTuner.observe(n, syncTime)
+		b.db.batchMu.Unlock()
+	}
+}
+
+// batchTunerWeight is the weight of the last batch in the moving averages
+// of batchTuner.
+const batchTunerWeight = 0.2
+
+// batchTuner adapts the size and delay of batches to the workload, see
+// DB.AdaptiveBatch.
+type batchTuner struct {
+	calls   float64       // moving average of the number of calls per batch
+	latency time.Duration // moving average of the sync time per batch
+}
+
+// observe accounts for a batch of the given number of calls, which spent
+// latency syncing.
+func (t *batchTuner) observe(calls int, latency time.Duration) {
+	if t.calls == 0 {
+		t.calls, t.latency = float64(calls), latency
+		return
+	}
+	t.calls += batchTunerWeight * (float64(calls) - t.calls)
+	t.latency += time.Duration(batchTunerWeight * float64(latency-t.latency))
+}
+
+// limits returns the size and delay of the next batch, up to maxSize and
+// maxDelay.
+func (t *batchTuner) limits(maxSize int, maxDelay time.Duration) (int, time.Duration) {
+	if t.calls == 0 {
+		return maxSize, maxDelay
+	}
+
+	// Waiting for more calls is only worth it while it costs less than the
+	// sync it saves, and even less so when few calls share a batch.
+	delay := min(maxDelay, t.latency)
+	if t.calls < 1.5 {
+		delay /= 4
+	}
+
+	// Leave room for the batch to grow when more calls are coming in, but
+	// don't wait for the timer once the usual number of calls has joined.
+	size := min(maxSize, max(2, int(math.Ceil(t.calls*1.5))))
+	return size, delay
 }
 
 // trySolo is a special sentinel error value used for signaling that a
@@ -1013,23 +1749,50 @@ func safelyCall(fn func(*Tx) error, tx *Tx) (err error) {
 func (db *DB) Sync() error { return fdatasync(db) }
 
 // Stats retrieves ongoing performance stats for the database.
-// This is only updated when a transaction closes.
+// This is only updated when a transaction closes. It waits for the free
+// pages of a database opened with NoFreelistSync to be reconstructed.
 func (db *DB) Stats() Stats {
+	db.waitFreelistRebuild()
 	db.statlock.RLock()
 	defer db.statlock.RUnlock()
-	return db.stats
+	s := db.stats
+	s.FreePageN += db.rebuiltFreePageN()
+	if !db.oldestReadTxStart.IsZero() {
+		s.OldestReadTxAge = time.Since(db.oldestReadTxStart)
+	}
+	s.PageCacheHitN = int(db.pageCacheStats.hitN.Load())
+	s.PageCacheMissN = int(db.pageCacheStats.missN.Load())
+	s.PageCacheEvictN = int(db.pageCacheStats.evictN.Load())
+	s.PageCacheSize = int(db.pageCacheStats.size.Load())
+	return s
 }
 
 // This is for internal access to the raw data bytes from the C cursor, use
-// carefully, or not at all.
+// carefully, or not at all. Info.Data is 0 with Options.NoMmap, since the data
+// file isn't mapped.
 func (db *DB) Info() *Info {
-	common.Assert(db.data != nil, "database file isn't correctly mapped")
+	common.Assert(db.mapped(), "database file isn't correctly mapped")
+	if db.data == nil {
+		return &Info{PageSize: db.pageSize}
+	}
 	return &Info{uintptr(unsafe.Pointer(&db.data[0])), db.pageSize}
 }
 
+// mapped returns whether the data file is mapped, or read into the page cache
+// with Options.NoMmap.
+func (db *DB) mapped() bool {
+	return db.data != nil || db.pageCache != nil
+}
+
 // page retrieves a page reference from the mmap based on the current page size.
 func (db *DB) page(id common.Pgid) *common.Page {
+	if db.pageCache != nil {
+		return db.cachedPage(id)
+	}
 	pos := id * common.Pgid(db.pageSize)
+	if chunks := db.chunks.Load(); chunks != nil {
+		return db.chunkedPage(*chunks, id, int64(pos))
+	}
 	return (*common.Page)(unsafe.Pointer(&db.data[pos]))
 }
 
@@ -1038,6 +1801,18 @@ func (db *DB) pageInBuffer(b []byte, id common.Pgid) *common.Page {
 	return (*common.Page)(unsafe.Pointer(&b[id*common.Pgid(db.pageSize)]))
 }
 
+// pageTrailerSize returns the number of bytes reserved at the end of each page.
+func (db *DB) pageTrailerSize() int {
+	var n int
+	if db.pageTxids {
+		n += common.PageTxidSize
+	}
+	if db.pageChecksums {
+		n += common.PageChecksumSize
+	}
+	return n
+}
+
 // meta retrieves the current meta page reference.
 func (db *DB) meta() *common.Meta {
 	// We have to return the meta with the highest txid which doesn't fail
@@ -1065,23 +1840,27 @@ func (db *DB) meta() *common.Meta {
 // allocate returns a contiguous block of memory starting at a given page.
 func (db *DB) allocate(txid common.Txid, count int) (*common.Page, error) {
 	// Allocate a temporary buffer for the page.
-	var buf []byte
-	if count == 1 {
-		buf = db.pagePool.Get().([]byte)
-	} else {
-		buf = make([]byte, count*db.pageSize)
-	}
+	buf := db.getPageBuffer(count)
 	p := (*common.Page)(unsafe.Pointer(&buf[0]))
 	p.SetOverflow(uint32(count - 1))
 
 	// Use pages from the freelist if they are available.
+	db.installFreelist()
 	p.SetId(db.freelist.allocate(txid, count))
 	if p.Id() != 0 {
 		return p, nil
 	}
+	if db.freelist.free_count() >= count {
+		db.rwtx.stats.IncAllocFallback(1)
+	}
 
-	// Resize mmap() if we're at the end.
+	// Fail the transaction rather than grow the file past its limit.
 	p.SetId(db.rwtx.meta.Pgid())
+	if limit := db.maxDataSize(); limit > 0 && int(p.Id()+common.Pgid(count))*db.pageSize > limit {
+		return nil, berrors.ErrQuotaExceeded
+	}
+
+	// Resize mmap() if we're at the end.
 	var minsz = int((p.Id()+common.Pgid(count))+1) * db.pageSize
 	if minsz >= db.datasz {
 		if err := db.mmap(minsz); err != nil {
@@ -1109,26 +1888,43 @@ func (db *DB) grow(sz int) error {
 
 	// If the data is smaller than the alloc size then only allocate what's needed.
 	// Once it goes over the allocation size then allocate in chunks.
-	if db.datasz <= db.AllocSize {
+	if db.growth != nil {
+		sz = db.growthSize(sz)
+	} else if db.datasz <= db.AllocSize {
 		sz = db.datasz
 	} else {
 		sz += db.AllocSize
 	}
 
+	// Never allocate past the size limit, allocate only lets the pages up
+	// to the limit be used.
+	if limit := db.maxDataSize(); limit > 0 && sz > limit {
+		if sz = limit; sz <= fileSize {
+			return nil
+		}
+	}
+
 	// Truncate and fsync to ensure file size metadata is flushed.
 	// https://github.com/boltdb/bolt/issues/284
 	if !db.NoGrowSync && !db.readOnly {
 		if runtime.GOOS != "windows" {
 			// gofail: var resizeFileError string
 			// return errors.New(resizeFileError)
-			if err := db.file.Truncate(int64(sz)); err != nil {
+			physSz := int64(sz)
+			if db.cipher != nil {
+				physSz = int64(sz/db.pageSize) * int64(db.encryptedPageSize())
+			}
+			if err := db.file.Truncate(physSz); err != nil {
 				return fmt.Errorf("file resize error: %s", err)
 			}
 		}
 		if err := db.file.Sync(); err != nil {
 			return fmt.Errorf("file sync error: %s", err)
 		}
-		if db.Mlock {
+		if db.rwtx != nil {
+			db.rwtx.grown += int64(sz - fileSize)
+		}
+		if db.Mlock && db.cipher == nil {
 			// unlock old file and lock new one
 			if err := db.mrelock(fileSize, sz); err != nil {
 				return fmt.Errorf("mlock/munlock error: %s", err)
@@ -1139,12 +1935,125 @@ func (db *DB) grow(sz int) error {
 	return nil
 }
 
+// maxDataSize returns the size limit of the data in bytes, rounded down to a
+// whole number of pages, or 0 if it's unlimited. For encrypted databases the
+// limit applies to the encrypted file.
+func (db *DB) maxDataSize() int {
+	if db.maxSize <= 0 {
+		return 0
+	}
+	if db.cipher != nil {
+		return db.maxSize / db.encryptedPageSize() * db.pageSize
+	}
+	return db.maxSize / db.pageSize * db.pageSize
+}
+
+// Shrink truncates the free pages at the end of the data file, which
+// otherwise never shrinks when data is deleted. It commits a write
+// transaction which moves the high water mark below the trailing free pages,
+// then truncates the file, but never below the pages which are still visible
+// to open read transactions. It returns the number of bytes the file shrank by.
+//
+// Free pages in the middle of the file aren't reclaimed, see Compact.
+func (db *DB) Shrink() (int64, error) {
+	var shrunk int64
+	// The freelist page written by the previous commit is often the last
+	// page of the file, and it's only free once a later transaction starts,
+	// so a second transaction trims the free pages behind it.
+	for i := 0; i < 2; i++ {
+		tx, err := db.Begin(true)
+		if err != nil {
+			return shrunk, err
+		}
+		var n int64
+		tx.shrunk = &n
+		err = tx.Commit()
+		shrunk += n
+		if err != nil {
+			return shrunk, err
+		}
+	}
+	return shrunk, nil
+}
+
+// shrinkFile truncates the data file after page pgid, or after the last page
+// of an open read transaction if it's higher. The caller must hold the writer
+// lock, and the meta page with the lower high water mark must be written.
+func (db *DB) shrinkFile(pgid common.Pgid) (int64, error) {
+	if db.inMemory {
+		return 0, nil
+	}
+
+	// Read transactions may copy the file up to their own high water mark,
+	// e.g. with Tx.WriteTo.
+	db.metalock.Lock()
+	for _, t := range db.txs {
+		if t.meta.Pgid() > pgid {
+			pgid = t.meta.Pgid()
+		}
+	}
+	db.metalock.Unlock()
+
+	info, err := db.file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("file stat error: %w", err)
+	}
+	sz := int64(pgid) * int64(db.pageSize)
+	if db.cipher != nil {
+		sz = int64(pgid) * int64(db.encryptedPageSize())
+	}
+	if sz >= info.Size() {
+		return 0, nil
+	}
+
+	// Make sure that the meta page with the lower high water mark is durable
+	// before the pages above it are gone.
+	if db.groupCommit != nil {
+		if err := db.groupCommit.sync(db); err != nil {
+			return 0, err
+		}
+	}
+
+	if runtime.GOOS == "windows" && db.cipher == nil {
+		// Windows doesn't allow truncating a file which is mapped, so
+		// remap it like swapFile does.
+		db.metalock.Lock()
+		db.mmaplock.Lock()
+		err = db.munmap()
+		if err == nil {
+			err = db.file.Truncate(sz)
+		}
+		db.mmaplock.Unlock()
+		if err == nil {
+			err = db.mmap(0)
+		}
+		db.metalock.Unlock()
+	} else {
+		err = db.file.Truncate(sz)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("shrink: %w", err)
+	}
+	if !db.NoSync || common.IgnoreNoSync {
+		if err := db.file.Sync(); err != nil {
+			return 0, fmt.Errorf("file sync error: %s", err)
+		}
+	}
+
+	// The file may not shrink as much on Windows, where it's extended to
+	// the size of the mmap.
+	if info2, err := db.file.Stat(); err == nil && info2.Size() < info.Size() {
+		return info.Size() - info2.Size(), nil
+	}
+	return 0, nil
+}
+
 func (db *DB) IsReadOnly() bool {
 	return db.readOnly
 }
 
 func (db *DB) freepages() []common.Pgid {
-	tx, err := db.beginTx()
+	tx, err := db.beginTx(context.Background())
 	defer func() {
 		err = tx.Rollback()
 		if err != nil {
@@ -1154,27 +2063,53 @@ func (db *DB) freepages() []common.Pgid {
 	if err != nil {
 		panic("freepages: failed to open read only tx")
 	}
+	fids, err := db.unreachablePages(context.Background(), tx)
+	if err != nil {
+		panic(fmt.Sprintf("freepages: %v", err))
+	}
+	return fids
+}
 
-	reachable := make(map[common.Pgid]*common.Page)
-	nofreed := make(map[common.Pgid]bool)
+// unreachablePages returns the pages below the high water mark of tx which
+// aren't reachable from its meta page, i.e. its free pages if the freelist
+// isn't synced. It stops with ctx.Err() once ctx is done.
+func (db *DB) unreachablePages(ctx context.Context, tx *Tx) ([]common.Pgid, error) {
 	ech := make(chan error)
 	go func() {
 		for e := range ech {
 			panic(fmt.Sprintf("freepages: failed to get all reachable pages (%v)", e))
 		}
 	}()
-	tx.checkBucket(&tx.root, reachable, nofreed, HexKVStringer(), ech)
+	c := &checker{
+		tx:         tx,
+		kvStringer: HexKVStringer(),
+		ctx:        ctx,
+		ch:         ech,
+		freed:      make(map[common.Pgid]bool),
+		reachable:  make(map[common.Pgid]*common.Page),
+	}
+	for _, p := range tx.freelistPages() {
+		for i := uint32(0); i <= p.Overflow(); i++ {
+			c.reachable[p.Id()+common.Pgid(i)] = p
+		}
+	}
+	c.checkDeferred()
+	c.checkBucket(&tx.root)
 	close(ech)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	reachable := c.reachable
 
 	// TODO: If check bucket reported any corruptions (ech) we shouldn't proceed to freeing the pages.
 
 	var fids []common.Pgid
-	for i := common.Pgid(2); i < db.meta().Pgid(); i++ {
+	for i := common.Pgid(2); i < tx.meta.Pgid(); i++ {
 		if _, ok := reachable[i]; !ok {
 			fids = append(fids, i)
 		}
 	}
-	return fids
+	return fids, nil
 }
 
 // Options represents the options that can be set when opening a database.
@@ -1188,6 +2123,9 @@ type Options struct {
 
 	// Do not sync freelist to disk. This improves the database write performance
 	// under normal operation, but requires a full database re-sync during recovery.
+	// When the database is opened for writing, the free pages are reconstructed
+	// in the background: transactions can begin before it finishes, and only
+	// the write transactions which allocate pages wait for it.
 	NoFreelistSync bool
 
 	// PreLoadFreelist sets whether to load the free pages when opening
@@ -1228,6 +2166,302 @@ type Options struct {
 	// It prevents potential page faults, however
 	// used memory can't be reclaimed. (UNIX only)
 	Mlock bool
+
+	// AutoCompact enables a background compactor which rewrites the database
+	// into a compacted file (see DB.Compact) whenever the ratio of free pages
+	// goes above AutoCompactFreeRatio. It has no effect in read-only mode.
+	AutoCompact bool
+
+	// AutoCompactInterval is how often the background compactor checks the
+	// free page ratio. If <=0, DefaultAutoCompactInterval is used.
+	AutoCompactInterval time.Duration
+
+	// AutoCompactFreeRatio is the ratio of free pages to total pages above
+	// which the background compactor compacts the database.
+	// If <=0, DefaultAutoCompactFreeRatio is used.
+	AutoCompactFreeRatio float64
+
+	// FreePageThreshold is the ratio of free and pending pages to total
+	// pages above which OnFreePageThreshold is called after a commit, e.g.
+	// to schedule a compaction. If <=0, it's never called.
+	FreePageThreshold float64
+
+	// OnFreePageThreshold is called with the id of the transaction and the
+	// ratio of free pages after the commit which brought the ratio above
+	// FreePageThreshold, once the locks are released. It isn't called again
+	// until a commit brought the ratio back to or below the threshold.
+	OnFreePageThreshold func(txid int, ratio float64)
+
+	// ReapInterval enables a background reaper which deletes the expired
+	// keys, see Bucket.PutWithTTL, every interval, in write transactions of
+	// at most 10000 keys. If <=0, expired keys are only hidden until
+	// Tx.ReapExpired deletes them. It has no effect in read-only mode.
+	ReapInterval time.Duration
+
+	// Encryption encrypts every page with the given cipher before it's
+	// written to disk, and decrypts it when the database is loaded. Each
+	// page is stored with its own nonce and authentication tag, see Cipher.
+	// The whole database is held in memory instead of being memory-mapped,
+	// unless NoMmap is set to decrypt the pages as they're read, and Mlock
+	// has no effect. See NewAESGCMCipher for the default cipher.
+	//
+	// An encrypted database must always be opened with the same cipher and
+	// key; use DB.Rekey to re-encrypt it with a different one.
+	Encryption Cipher
+
+	// PageChecksums stores a CRC32C at the end of every page, which is
+	// verified whenever the page is read and by Tx.Check. It only applies
+	// when a new database file is created; existing files keep the format
+	// they were created with. Older versions of the package can't open them.
+	PageChecksums bool
+
+	// PageTxids stores the id of the writing transaction at the end of every
+	// page, which Tx.WriteDiffTo uses to find the pages changed since a given
+	// transaction. It only applies when a new database file is created, and
+	// older versions of the package can't open such files.
+	PageTxids bool
+
+	// VerifyFreelist makes Open check that none of the free pages is
+	// reachable from the tree, which reads the whole tree. The freelist is
+	// always checked for duplicate and out of bounds pages. A corrupted
+	// freelist is ignored, so that its pages are not reused, and reported by
+	// Tx.Check until DB.RepairFreelist rebuilds it.
+	VerifyFreelist bool
+
+	// AllocStrategy selects the span of free pages reused by each page
+	// allocation. The default strategy is the fastest one, the others may
+	// leave fewer or larger free spans depending on the workload.
+	AllocStrategy AllocStrategy
+
+	// DeterministicAlloc makes the pages reused by the allocations only
+	// depend on the operations, so that the same operations on the same
+	// database produce byte-identical files, e.g. for golden file tests. The
+	// default strategy picks any of the free spans of the right size, it's
+	// replaced by AllocBestFit. Encrypted databases are never identical.
+	DeterministicAlloc bool
+
+	// FreelistSpans makes the commits write the freelist page as spans of
+	// contiguous free pages instead of every free page id, which is much
+	// smaller in large fragmented databases. Databases written with it can
+	// be opened without it, and the next commit writes the page ids again,
+	// but older versions of the package refuse to open them until then.
+	FreelistSpans bool
+
+	// FreelistSegments makes the commits write the free page ids as segments
+	// of consecutive page ids on pages of their own, and only write the
+	// segments which changed, instead of the whole freelist. The freelist
+	// page only lists the pages of the segments. It reduces the pages
+	// written by each commit in databases with many free pages. It takes
+	// precedence over FreelistSpans, and like it, databases written with it
+	// can be opened without it, but older versions of the package refuse to
+	// open them until the next commit without it.
+	FreelistSegments bool
+
+	// WAL enables write-ahead-log commit mode. Instead of syncing the data
+	// file twice per commit, for the pages and then for the meta page, the
+	// dirty pages and the meta page are appended to a log file next to the
+	// data file, and only the log is synced. The data file is synced when the
+	// log is checkpointed, see DB.Checkpoint.
+	//
+	// A log left behind by a crashed process is replayed on the next open
+	// in read-write mode, whether or not WAL is set.
+	WAL bool
+
+	// WALCheckpointSize is the size of the write-ahead log in bytes above
+	// which it's checkpointed by the next commit.
+	// If <=0, DefaultWALCheckpointSize is used.
+	WALCheckpointSize int
+
+	// GroupCommitInterval enables group commit when >0. Write transactions
+	// return from Commit once their meta page is synced, but they release
+	// the writer lock before that, so that the sync can be shared with the
+	// transactions which commit after them. A transaction waits at most
+	// GroupCommitInterval for another writer before syncing by itself.
+	// Read transactions may see a commit before its meta page is synced.
+	//
+	// Unlike DB.Batch, every transaction still commits on its own, so the
+	// caller doesn't need to tolerate retries. It has no effect in WAL mode.
+	GroupCommitInterval time.Duration
+
+	// MmapChunkSize maps the data file in chunks of the given size in bytes,
+	// rounded up to a multiple of the page size and of 64KB, instead of as a
+	// single contiguous region. Growing the database then maps new chunks
+	// while keeping the existing ones, so it doesn't wait for read
+	// transactions to finish, and the size of the database isn't limited by
+	// the largest contiguous range of free address space. Page runs which
+	// cross a chunk boundary are mapped separately when they're first read.
+	//
+	// If <=0, the data file is mapped as a single region. It has no effect
+	// on encrypted databases.
+	MmapChunkSize int
+
+	// MaxSize limits the size of the data file in bytes. A write transaction
+	// which needs to grow the database past the limit fails with
+	// ErrQuotaExceeded and is rolled back, pages which are freed by deletes
+	// are still reused. If <=0, the size is unlimited.
+	MaxSize int
+
+	// GrowthPolicy replaces the default growth of the database, where the
+	// memory map doubles in size up to 1GB and then grows by 1GB, and the
+	// data file grows by DB.AllocSize, e.g. with FixedGrowth or
+	// PercentGrowth. It sets both the size of the memory map and the size
+	// the data file is extended to. Growing the memory map waits for read
+	// transactions to finish, so small increments make writers block more
+	// often. If nil, the default policy is used.
+	GrowthPolicy GrowthPolicy
+
+	// Madvise is the madvise advice for the memory map of the data file,
+	// e.g. AdviceSequential for scan-heavy workloads. By default the map is
+	// advised to be accessed randomly. (UNIX only)
+	Madvise Advice
+
+	// Fadvise is the posix_fadvise advice given for the pages written by
+	// each commit once they're synced, e.g. AdviceDontNeed to drop them from
+	// the page cache. By default no advice is given. (Linux only)
+	Fadvise Advice
+
+	// DirectIO makes the commits write the pages with direct I/O (O_DIRECT),
+	// bypassing the page cache, so that the written pages aren't cached
+	// twice when the data file is also memory-mapped. The pages are read
+	// through the memory map as usual. Open fails if the file system
+	// doesn't support direct I/O. It has no effect on encrypted and
+	// in-memory databases. (Linux only)
+	DirectIO bool
+
+	// HugePages asks the kernel to back the memory map of the data file with
+	// transparent huge pages (MADV_HUGEPAGE), which reduces the TLB misses
+	// of scans over multi-GB databases. It's ignored by kernels without
+	// transparent huge pages for files. MAP_HUGETLB can't map regular files,
+	// but a data file on hugetlbfs is mapped with huge pages anyway. Windows
+	// only supports large pages for memory which isn't backed by a file.
+	// (Linux only)
+	HugePages bool
+
+	// SyncMode chooses the system call which flushes the data file to disk
+	// on commit, trading durability on power loss for commit latency. See
+	// SyncMode for the modes of each platform. The default is the one of
+	// the platform.
+	SyncMode SyncMode
+
+	// NoMmap reads the pages with pread into a page cache of PageCacheSize
+	// bytes instead of memory-mapping the data file, for file systems and
+	// platforms where mmap is unavailable or slow, or to bound the memory
+	// used for the data file. The least recently used pages are evicted
+	// once the cache is full, but the pages referenced by open transactions
+	// stay in memory until they're closed. Reads which miss the cache are
+	// much slower than with a memory map. Mlock has no effect. It has no
+	// effect on in-memory databases.
+	//
+	// With Encryption, each page is decrypted and authenticated when it's
+	// read instead of decrypting the whole database on Open, so a tampered
+	// page is only detected when it's read, like a page failing its checksum.
+	NoMmap bool
+
+	// PageCacheSize is the size of the page cache in bytes when NoMmap is
+	// set, which trades memory for read latency. The hits and misses are
+	// counted in Stats to tune it. If <=0, it's 64MB.
+	PageCacheSize int
+
+	// MaxReadTxDuration enables a watchdog which looks for read
+	// transactions open for longer than the given duration, since they keep
+	// the pages freed after them from being reused and make the file grow.
+	// Such transactions are counted in Stats.LongReadTxN and reported to
+	// OnLongReadTx. If <=0, the watchdog is disabled.
+	MaxReadTxDuration time.Duration
+
+	// OnLongReadTx is called by the watchdog with the id and the age of each
+	// read transaction which is open for longer than MaxReadTxDuration, e.g.
+	// to log it. It's called once per transaction.
+	OnLongReadTx func(txid int, age time.Duration)
+
+	// RetainTxs is the number of committed transactions before the latest
+	// one which can still be opened with DB.BeginAt. Their pages aren't
+	// reused until newer transactions push them out, so the database may
+	// grow. If 0, only the latest transaction can be opened.
+	RetainTxs int
+
+	// ReuseDelay is the number of write transactions which must be committed
+	// after the one which freed a page before the page is reused, on top of
+	// the pages kept for the open read transactions, so that the images of
+	// the pages of the last ReuseDelay transactions are still in the data
+	// file, e.g. for crash forensics or incremental backup tools. The
+	// database grows by the pages freed meanwhile. The delay starts over when
+	// the database is opened, since the pending pages are persisted as free.
+	// If 0, freed pages are reused as soon as no read transaction uses them.
+	ReuseDelay int
+
+	// MaxDirtyBytes bounds the memory held by the changes of a write
+	// transaction. Once Bucket.Put and Bucket.Delete have changed more than
+	// this many bytes, the dirty pages are written out to free pages of the
+	// data file, which no committed transaction refers to, and dropped from
+	// memory. The pages they replace can't be reused before the transaction
+	// commits, so the database may grow. If 0, dirty pages are only written
+	// on commit.
+	MaxDirtyBytes int
+
+	// MaxInlineBucketSize is the size up to which the nested buckets without
+	// nested buckets of their own are stored inline in their parent instead
+	// of on pages of their own. Raising it saves pages for many tiny nested
+	// buckets, at the cost of larger leaves in their parents. It's capped at
+	// the page size. Buckets are inlined again or moved out once they change.
+	// If 0, it's a quarter of the page size.
+	MaxInlineBucketSize int
+
+	// CompressLargeValues compresses the values of at least a page put with
+	// Put with DEFLATE, with the compression dictionary of their bucket if
+	// it has one, see Bucket.SetCompressionDict. They're stored compressed
+	// if that makes them smaller, so that they only take the overflow pages
+	// of their compressed form, and flagged as such, so that the database
+	// can be opened without it. Get, cursors and indexes see the
+	// uncompressed values, which are copies. Values put with a TTL or
+	// streamed with PutReader aren't compressed.
+	CompressLargeValues bool
+
+	// OpenProgress is called while Open replays the write-ahead log, maps the
+	// data file and loads the free pages, which may take a while for large
+	// databases. stage is one of OpenStageRecover, OpenStageMmap and
+	// OpenStageFreelist, and each stage is reported at least when it starts,
+	// with done set to 0, and when it finishes, with done equal to total.
+	OpenProgress func(stage string, done, total int64)
+
+	// StrictErrors makes the transactions return ErrCorrupted instead of
+	// panicking when they run into a corrupted page, e.g. an invalid page
+	// type or a page which is freed twice, so that a server can fail a
+	// single request instead of crashing. The error is returned by the Tx,
+	// Bucket or Cursor method which read the page, or, for methods which
+	// don't return an error such as Bucket.Get, by Commit, View and Update.
+	// A transaction which returned ErrCorrupted can't be committed.
+	StrictErrors bool
+
+	// VerifyTouchedPages makes the write transactions record the pages they
+	// read, and check them and the nodes they changed with
+	// Tx.VerifyTouchedPages before they write anything on commit. It catches
+	// corruption before it spreads, at a cost bounded by the size of the
+	// transaction rather than of the database like StrictMode.
+	VerifyTouchedPages bool
+
+	// OnMetaRollback is called by Open when the latest meta page failed
+	// validation and the database is opened at the previous transaction
+	// instead, which silently drops the last commit, or when both meta pages
+	// failed validation and were restored from a copy, see MetaCopies.
+	// Returning an error aborts Open with that error. See DB.RecoveryInfo.
+	OnMetaRollback func(info RecoveryInfo) error
+
+	// MetaCopies writes a copy of the meta page of each commit onto one of
+	// the given number of pages at the end of the file, round-robin. When
+	// both meta pages at the start of the file are corrupted, Open restores
+	// the latest valid copy, which is found by scanning the file, instead of
+	// failing with ErrInvalid. The copies are beyond the data, so the ones
+	// of the last transactions may be overwritten when the database grows
+	// until the next commits write them at the new end of the file. It has
+	// no effect on read-only, encrypted and in-memory databases.
+	MetaCopies int
+
+	// Indexes declares secondary indexes of top-level buckets, which are
+	// updated in the same transaction as the keys of the bucket, see Index.
+	// Open fails if two indexes have the same name or if an index is named
+	// like an indexed bucket.
+	Indexes []Index
 }
 
 // DefaultOptions represent the options used if nil options are passed into Open().
@@ -1251,9 +2485,31 @@ type Stats struct {
 	FreeAlloc     int // total bytes allocated in free pages
 	FreelistInuse int // total bytes used by the freelist
 
+	Freelist FreelistStats // fragmentation of the free pages
+
 	// Transaction stats
 	TxN     int // total number of started read transactions
 	OpenTxN int // number of currently open read transactions
+
+	// Oldest open read transaction, which keeps the pages freed after it
+	// from being reused. Both are 0 when no read transaction is open.
+	OldestReadTxID  int           // id of the oldest read transaction
+	OldestReadTxAge time.Duration // time since it started
+	LongReadTxN     int           // total number of read transactions open longer than Options.MaxReadTxDuration
+
+	// Compaction stats
+	CompactN    int // total number of online compactions
+	CompactErrN int // total number of failed background compactions
+
+	// WAL stats
+	WALCheckpointN int // total number of write-ahead log checkpoints
+
+	// Page cache stats, see Options.NoMmap. A page is counted with its
+	// overflow pages. They're 0 if the data file is memory-mapped.
+	PageCacheHitN   int // total number of pages read from the page cache
+	PageCacheMissN  int // total number of pages read from the data file
+	PageCacheEvictN int // total number of pages evicted from the page cache
+	PageCacheSize   int // bytes currently held by the page cache
 }
 
 // Sub calculates and returns the difference between two sets of database stats.
@@ -1268,12 +2524,34 @@ func (s *Stats) Sub(other *Stats) Stats {
 	diff.PendingPageN = s.PendingPageN
 	diff.FreeAlloc = s.FreeAlloc
 	diff.FreelistInuse = s.FreelistInuse
+	diff.Freelist = s.Freelist
 	diff.TxN = s.TxN - other.TxN
+	diff.CompactN = s.CompactN - other.CompactN
+	diff.WALCheckpointN = s.WALCheckpointN - other.WALCheckpointN
+	diff.CompactErrN = s.CompactErrN - other.CompactErrN
+	diff.OldestReadTxID = s.OldestReadTxID
+	diff.OldestReadTxAge = s.OldestReadTxAge
+	diff.LongReadTxN = s.LongReadTxN - other.LongReadTxN
+	diff.PageCacheHitN = s.PageCacheHitN - other.PageCacheHitN
+	diff.PageCacheMissN = s.PageCacheMissN - other.PageCacheMissN
+	diff.PageCacheEvictN = s.PageCacheEvictN - other.PageCacheEvictN
+	diff.PageCacheSize = s.PageCacheSize
 	diff.TxStats = s.TxStats.Sub(&other.TxStats)
 	return diff
 }
 
+// FreelistStats describes the fragmentation of the free pages into spans of
+// contiguous pages, which are only reused by allocations of up to their
+// size. A high fragmentation means that the free pages can't be reused by
+// large values, and that compaction would shrink the database.
+type FreelistStats struct {
+	SpanN         int     // number of spans of contiguous free pages
+	MaxSpan       int     // number of pages of the largest span
+	AvgSpan       float64 // average number of pages of a span
+	Fragmentation float64 // 1 - MaxSpan/free pages: 0 if they're contiguous, close to 1 if they're scattered
+}
+
 type Info struct {
-	Data     uintptr
+	Data     uintptr // the address of the mapped data file, or 0 if it isn't mapped
 	PageSize int
 }
@@ -2,6 +2,7 @@ package boltdb_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -106,6 +107,17 @@ func TestOpen_MultipleGoroutines(t *testing.T) {
 	}
 }
 
+// Ensure that OpenContext gives up waiting for the file lock once the context
+// is done.
+func TestOpenContext_FileLock(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err := bolt.OpenContext(ctx, db.Path(), 0600, nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
 // Ensure that opening a database with a blank path returns an error.
 func TestOpen_ErrPathRequired(t *testing.T) {
 	_, err := bolt.Open("", 0600, nil)
@@ -164,11 +176,12 @@ func TestOpen_ErrVersionMismatch(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Rewrite meta pages.
+	// Rewrite meta pages. The next version is the one of the files with
+	// format flags.
 	meta0 := (*meta)(unsafe.Pointer(&buf[pageHeaderSize]))
-	meta0.version++
+	meta0.version += 2
 	meta1 := (*meta)(unsafe.Pointer(&buf[pageSize+pageHeaderSize]))
-	meta1.version++
+	meta1.version += 2
 	if err := os.WriteFile(path, buf, 0666); err != nil {
 		t.Fatal(err)
 	}
@@ -391,6 +404,139 @@ func TestOpen_Size_Large(t *testing.T) {
 	}
 }
 
+// Ensure that Open reports the progress of each stage.
+func TestOpen_Progress(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{WAL: true})
+	putWidgets(t, db, 0, 10)
+	require.NoError(t, db.Checkpoint())
+	data, err := os.ReadFile(db.Path())
+	require.NoError(t, err)
+	// Leave a log to replay behind, like a crashed process.
+	putWidgets(t, db, 10, 50)
+	log, err := os.ReadFile(db.Path() + "-wal")
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "db")
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	require.NoError(t, os.WriteFile(path+"-wal", log, 0600))
+
+	type progress struct {
+		stage       string
+		done, total int64
+	}
+	var reports []progress
+	rdb := btesting.MustOpenDBWithOption(t, path, &bolt.Options{
+		OpenProgress: func(stage string, done, total int64) {
+			reports = append(reports, progress{stage, done, total})
+		},
+	})
+	requireWidgets(t, rdb.DB, 50)
+
+	var stages []string
+	for i, r := range reports {
+		require.LessOrEqual(t, r.done, r.total)
+		if i == 0 || reports[i-1].stage != r.stage {
+			require.Zero(t, r.done, "stage %s doesn't start at 0", r.stage)
+			stages = append(stages, r.stage)
+		}
+		if i == len(reports)-1 || reports[i+1].stage != r.stage {
+			require.Equal(t, r.total, r.done, "stage %s doesn't finish", r.stage)
+		}
+	}
+	require.Equal(t, []string{bolt.OpenStageRecover, bolt.OpenStageMmap, bolt.OpenStageFreelist}, stages)
+	require.Equal(t, int64(len(log)), reports[0].total)
+}
+
+// Ensure that Open reports when it falls back to the previous meta page, and
+// that OnMetaRollback can refuse to open the database.
+func TestOpen_MetaRollback(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	putWidgets(t, db, 0, 10)
+	db.MustClose()
+	db.MustReopen()
+	info := db.RecoveryInfo()
+	require.False(t, info.RolledBack)
+	require.NoError(t, info.Err)
+	path := db.Path()
+	db.MustClose()
+
+	// Corrupt the latest meta page.
+	buf, err := os.ReadFile(path)
+	require.NoError(t, err)
+	m := (*meta)(unsafe.Pointer(&buf[info.Meta*pageSize+pageHeaderSize]))
+	m.pgid++
+	require.NoError(t, os.WriteFile(path, buf, 0666))
+
+	errRefused := errors.New("refused")
+	var called bool
+	_, err = bolt.Open(path, 0600, &bolt.Options{OnMetaRollback: func(got bolt.RecoveryInfo) error {
+		called = true
+		require.True(t, got.RolledBack)
+		require.Equal(t, 1-info.Meta, got.Meta)
+		require.Equal(t, info.Txid-1, got.Txid)
+		require.ErrorIs(t, got.Err, berrors.ErrChecksum)
+		return errRefused
+	}})
+	require.ErrorIs(t, err, errRefused)
+	require.True(t, called)
+
+	// Without a callback the database is opened at the previous transaction.
+	rdb, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	require.NoError(t, err)
+	require.True(t, rdb.RecoveryInfo().RolledBack)
+	require.Equal(t, info.Txid-1, rdb.RecoveryInfo().Txid)
+	require.NoError(t, rdb.Close())
+}
+
+// Ensure that Open restores the latest meta copy when both meta pages are
+// corrupted.
+func TestOpen_MetaCopies(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{MetaCopies: 3})
+	putWidgets(t, db, 0, 10)
+	putBigBucket(t, db, "big", 500)
+	putWidgets(t, db, 10, 20)
+	db.MustClose()
+	db.MustReopen()
+	txid := db.RecoveryInfo().Txid
+	require.False(t, db.RecoveryInfo().MetaCopy)
+	path := db.Path()
+	db.MustClose()
+
+	// Wipe both meta pages.
+	buf, err := os.ReadFile(path)
+	require.NoError(t, err)
+	clear(buf[:2*pageSize])
+	require.NoError(t, os.WriteFile(path, buf, 0666))
+
+	_, err = bolt.Open(path, 0600, nil)
+	require.ErrorIs(t, err, berrors.ErrInvalid)
+
+	var called bool
+	db.SetOptions(&bolt.Options{MetaCopies: 3, OnMetaRollback: func(got bolt.RecoveryInfo) error {
+		called = true
+		require.True(t, got.MetaCopy)
+		require.Equal(t, txid, got.Txid)
+		return nil
+	}})
+	db.MustReopen()
+	require.True(t, called)
+	err = db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, 500, tx.Bucket([]byte("big")).Stats().KeyN)
+		require.Equal(t, 20, tx.Bucket([]byte("widgets")).Stats().KeyN)
+		return nil
+	})
+	require.NoError(t, err)
+	db.MustCheck()
+
+	// The database keeps working once restored.
+	putWidgets(t, db, 20, 30)
+	db.MustClose()
+	db.SetOptions(nil)
+	db.MustReopen()
+	require.False(t, db.RecoveryInfo().MetaCopy)
+	db.MustCheck()
+}
+
 // Ensure that a re-opened database is consistent.
 func TestOpen_Check(t *testing.T) {
 	path := tempfile()
@@ -844,6 +990,172 @@ func TestDB_Update_Closed(t *testing.T) {
 	}
 }
 
+// Ensure that UpdateContext gives up waiting for the writer lock once the
+// context is done, and that the writer lock is still usable afterwards.
+func TestDB_UpdateContext_WriterLock(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = db.UpdateContext(ctx, func(tx *bolt.Tx) error {
+		t.Fatal("UpdateContext shouldn't run while another writer is open")
+		return nil
+	})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.NoError(t, tx.Rollback())
+	err = db.UpdateContext(context.Background(), func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	})
+	require.NoError(t, err)
+}
+
+// Ensure that BeginWithTimeout returns ErrTimeout while another writer holds
+// the writer lock.
+func TestDB_BeginWithTimeout(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+
+	_, err = db.BeginWithTimeout(true, 100*time.Millisecond)
+	require.ErrorIs(t, err, berrors.ErrTimeout)
+
+	// Read transactions don't wait for the writer.
+	rtx, err := db.BeginWithTimeout(false, 100*time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, rtx.Rollback())
+
+	require.NoError(t, tx.Rollback())
+	tx, err = db.BeginWithTimeout(true, 100*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, tx.Writable())
+	require.NoError(t, tx.Rollback())
+}
+
+// Ensure that a snapshot keeps its view while the database is written and
+// remapped, and that it can be used from other goroutines.
+func TestDB_Snapshot(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	putWidgets(t, db, 0, 10)
+
+	s, err := db.Snapshot()
+	require.NoError(t, err)
+
+	// Overwrite the widgets and grow the database well past its mapping.
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for i := 0; i < 10; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), []byte("overwritten")); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < 5000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("big%04d", i)), make([]byte, 1000)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	// Later transactions would reuse the pages freed above.
+	putWidgets(t, db, 0, 10)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.View(func(tx *bolt.Tx) error {
+			require.Equal(t, s.ID(), tx.ID())
+			b := tx.Bucket([]byte("widgets"))
+			require.Equal(t, 10, b.Stats().KeyN)
+			require.Equal(t, []byte("value-9"), b.Get([]byte("0009")))
+			return nil
+		})
+	}()
+	require.NoError(t, <-done)
+
+	var buf bytes.Buffer
+	_, err = s.WriteTo(&buf)
+	require.NoError(t, err)
+	rdb, err := bolt.OpenReader(bytes.NewReader(buf.Bytes()), nil)
+	require.NoError(t, err)
+	requireWidgets(t, rdb, 10)
+	require.NoError(t, rdb.Close())
+
+	require.NoError(t, s.Close())
+	require.NoError(t, s.Close())
+	err = s.View(func(tx *bolt.Tx) error { return nil })
+	require.ErrorIs(t, err, berrors.ErrSnapshotClosed)
+	db.MustCheck()
+
+	// Compacting the database closes the snapshots.
+	s, err = db.Snapshot()
+	require.NoError(t, err)
+	require.NoError(t, db.Compact())
+	err = s.View(func(tx *bolt.Tx) error { return nil })
+	require.ErrorIs(t, err, berrors.ErrSnapshotClosed)
+}
+
+// Ensure that commit hooks are called after every write transaction which
+// commits.
+func TestDB_OnCommit(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	var txids []uint64
+	var writes int64
+	db.OnCommit(func(txid uint64, stats bolt.TxStats) {
+		txids = append(txids, txid)
+		writes += stats.GetWrite()
+	})
+
+	var want []uint64
+	for i := 0; i < 3; i++ {
+		err := db.Update(func(tx *bolt.Tx) error {
+			want = append(want, uint64(tx.ID()))
+			_, err := tx.CreateBucket([]byte(fmt.Sprintf("widgets%d", i)))
+			return err
+		})
+		require.NoError(t, err)
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		return errors.New("rollback this commit")
+	})
+	require.Error(t, err)
+
+	require.Equal(t, want, txids)
+	require.Greater(t, writes, int64(0))
+}
+
+// Ensure that UpdateContext rolls back the transaction if the context is
+// done by the time the function returns.
+func TestDB_UpdateContext_Canceled(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := db.UpdateContext(ctx, func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		cancel()
+		return err
+	})
+	require.ErrorIs(t, err, context.Canceled)
+
+	err = db.ViewContext(context.Background(), func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket([]byte("widgets")))
+		return nil
+	})
+	require.NoError(t, err)
+
+	// A transaction isn't started at all once the context is done.
+	err = db.ViewContext(ctx, func(tx *bolt.Tx) error {
+		t.Fatal("ViewContext shouldn't run with a canceled context")
+		return nil
+	})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
 // Ensure a panic occurs while trying to commit a managed transaction.
 func TestDB_Update_ManualCommit(t *testing.T) {
 	db := btesting.MustCreateDB(t)
@@ -1040,6 +1352,281 @@ func TestDB_View_Panic(t *testing.T) {
 	}
 }
 
+// putBigBucket creates a bucket with n values of 1KB.
+func putBigBucket(t *testing.T, db *btesting.DB, name string, n int) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte(name))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%08d", i)), make([]byte, 1024)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+// Ensure that Shrink truncates the free pages at the end of the file, but
+// not the pages visible to an open read transaction.
+func TestDB_Shrink(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	putBigBucket(t, db, "kept", 100)
+	putBigBucket(t, db, "deleted", 5000)
+	sizeBefore := fileSize(db.Path())
+
+	rtx, err := db.Begin(false)
+	require.NoError(t, err)
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("deleted"))
+	})
+	require.NoError(t, err)
+
+	// The pages are still visible to the read transaction.
+	_, err = db.Shrink()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, fileSize(db.Path()), rtx.Size())
+	require.NoError(t, rtx.Rollback())
+
+	shrunk, err := db.Shrink()
+	require.NoError(t, err)
+	require.Greater(t, shrunk, int64(0))
+	sizeAfter := fileSize(db.Path())
+	require.Less(t, sizeAfter, sizeBefore/4)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, sizeAfter, tx.Size())
+		require.Equal(t, 100, tx.Bucket([]byte("kept")).Stats().KeyN)
+		return nil
+	})
+	require.NoError(t, err)
+	db.MustCheck()
+
+	// The database keeps working after it's reopened and grows again.
+	db.MustClose()
+	db.MustReopen()
+	putBigBucket(t, db, "deleted", 1000)
+	db.MustCheck()
+}
+
+// Ensure that the same operations produce byte-identical files with
+// DeterministicAlloc.
+func TestDB_DeterministicAlloc(t *testing.T) {
+	for _, o := range []*bolt.Options{
+		{DeterministicAlloc: true},
+		{DeterministicAlloc: true, FreelistSegments: true},
+	} {
+		run := func() []byte {
+			path := filepath.Join(t.TempDir(), "db")
+			db, err := bolt.Open(path, 0600, o)
+			require.NoError(t, err)
+			r := rand.New(rand.NewSource(0))
+			for i := 0; i < 50; i++ {
+				err := db.Update(func(tx *bolt.Tx) error {
+					b, err := tx.CreateBucketIfNotExists([]byte(fmt.Sprintf("bucket%d", r.Intn(5))))
+					if err != nil {
+						return err
+					}
+					for j := 0; j < 100; j++ {
+						k := []byte(fmt.Sprintf("%04d", r.Intn(1000)))
+						if r.Intn(3) == 0 {
+							if err := b.Delete(k); err != nil {
+								return err
+							}
+						} else if err := b.Put(k, make([]byte, r.Intn(3*db.Info().PageSize))); err != nil {
+							return err
+						}
+					}
+					if r.Intn(10) == 0 {
+						return tx.DeleteBucket([]byte(fmt.Sprintf("bucket%d", r.Intn(5))))
+					}
+					return nil
+				})
+				if err != nil && !errors.Is(err, berrors.ErrBucketNotFound) {
+					require.NoError(t, err)
+				}
+			}
+			require.NoError(t, db.Close())
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			return data
+		}
+		require.True(t, bytes.Equal(run(), run()))
+	}
+}
+
+// Ensure that a write transaction which grows the database past MaxSize
+// fails, and that freed pages can still be reused.
+func TestDB_MaxSize(t *testing.T) {
+	const maxSize = 1024 * 1024
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{MaxSize: maxSize})
+	putBigBucket(t, db, "small", 300)
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("big"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 2000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%08d", i)), make([]byte, 1024)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, berrors.ErrQuotaExceeded)
+	require.LessOrEqual(t, fileSize(db.Path()), int64(maxSize))
+	err = db.View(func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket([]byte("big")))
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("small"))
+	})
+	require.NoError(t, err)
+	putBigBucket(t, db, "reused", 300)
+	require.LessOrEqual(t, fileSize(db.Path()), int64(maxSize))
+	db.MustCheck()
+}
+
+// Ensure that the database works with every madvise and fadvise advice.
+func TestOpen_Advice(t *testing.T) {
+	for _, a := range []bolt.Advice{bolt.AdviceNormal, bolt.AdviceRandom, bolt.AdviceSequential, bolt.AdviceWillNeed, bolt.AdviceDontNeed} {
+		t.Run(fmt.Sprint(a), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{Madvise: a, Fadvise: a})
+			putBigBucket(t, db, "widgets", 500)
+			db.MustClose()
+			db.MustReopen()
+			err := db.View(func(tx *bolt.Tx) error {
+				require.Equal(t, 500, tx.Bucket([]byte("widgets")).Stats().KeyN)
+				return nil
+			})
+			require.NoError(t, err)
+			db.MustCheck()
+		})
+	}
+}
+
+// Ensure that a database mapped with huge pages can be written and read,
+// including with a chunked map.
+func TestOpen_HugePages(t *testing.T) {
+	for _, chunkSize := range []int{0, 1 << 20} {
+		t.Run(fmt.Sprint(chunkSize), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{HugePages: true, MmapChunkSize: chunkSize})
+			putBigBucket(t, db, "widgets", 5000)
+			db.MustClose()
+			db.MustReopen()
+			err := db.View(func(tx *bolt.Tx) error {
+				require.Equal(t, 5000, tx.Bucket([]byte("widgets")).Stats().KeyN)
+				return nil
+			})
+			require.NoError(t, err)
+			db.MustCheck()
+		})
+	}
+}
+
+// Ensure that the pages written with direct I/O are read back, including
+// after a compaction.
+func TestOpen_DirectIO(t *testing.T) {
+	for _, pageSize := range []int{0, 1024} {
+		t.Run(fmt.Sprint(pageSize), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{DirectIO: true, PageSize: pageSize})
+			putBigBucket(t, db, "widgets", 500)
+			putBigBucket(t, db, "deleted", 500)
+			err := db.Update(func(tx *bolt.Tx) error {
+				if err := tx.DeleteBucket([]byte("deleted")); err != nil {
+					return err
+				}
+				return tx.Bucket([]byte("widgets")).Put([]byte("big"), make([]byte, 100000))
+			})
+			require.NoError(t, err)
+			require.NoError(t, db.Compact())
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket([]byte("widgets")).Delete([]byte("00000000"))
+			}))
+			db.MustClose()
+			db.MustReopen()
+			err = db.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				require.Equal(t, 500, b.Stats().KeyN)
+				require.Len(t, b.Get([]byte("big")), 100000)
+				return nil
+			})
+			require.NoError(t, err)
+			db.MustCheck()
+		})
+	}
+}
+
+// Ensure that a database can be read with pread through a small page cache.
+func TestOpen_NoMmap(t *testing.T) {
+	for _, directIO := range []bool{false, true} {
+		t.Run(fmt.Sprint(directIO), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{NoMmap: true, PageCacheSize: 64 * 1024, DirectIO: directIO})
+			putBigBucket(t, db, "widgets", 500)
+			putBigBucket(t, db, "deleted", 500)
+
+			// A read transaction keeps its snapshot while the pages are
+			// evicted and rewritten.
+			rtx, err := db.Begin(false)
+			require.NoError(t, err)
+			err = db.Update(func(tx *bolt.Tx) error {
+				if err := tx.DeleteBucket([]byte("deleted")); err != nil {
+					return err
+				}
+				return tx.Bucket([]byte("widgets")).Put([]byte("big"), make([]byte, 100000))
+			})
+			require.NoError(t, err)
+			require.Equal(t, 500, rtx.Bucket([]byte("deleted")).Stats().KeyN)
+			require.Nil(t, rtx.Bucket([]byte("widgets")).Get([]byte("big")))
+			require.NoError(t, rtx.Rollback())
+
+			require.NoError(t, db.Compact())
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket([]byte("widgets")).Delete([]byte("00000000"))
+			}))
+			db.MustClose()
+			db.MustReopen()
+			// The data file isn't mapped.
+			require.Zero(t, db.Info().Data)
+			err = db.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				require.Equal(t, 500, b.Stats().KeyN)
+				require.Len(t, b.Get([]byte("big")), 100000)
+				require.Nil(t, tx.Bucket([]byte("deleted")))
+				return nil
+			})
+			require.NoError(t, err)
+			db.MustCheck()
+		})
+	}
+}
+
+// Ensure that the commits are durable with each sync mode, the ones which
+// aren't available falling back to the default.
+func TestOpen_SyncMode(t *testing.T) {
+	modes := []bolt.SyncMode{bolt.SyncDefault, bolt.SyncFdatasync, bolt.SyncFsync,
+		bolt.SyncFullFsync, bolt.SyncBarrierFsync, bolt.SyncFileRange}
+	for _, mode := range modes {
+		t.Run(fmt.Sprint(mode), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{SyncMode: mode})
+			putBigBucket(t, db, "widgets", 100)
+			require.NoError(t, db.Sync())
+			db.MustClose()
+			db.MustReopen()
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				require.Equal(t, 100, tx.Bucket([]byte("widgets")).Stats().KeyN)
+				return nil
+			}))
+		})
+	}
+}
+
 // Ensure that DB stats can be returned.
 func TestDB_Stats(t *testing.T) {
 	db := btesting.MustCreateDB(t)
@@ -1060,6 +1647,47 @@ func TestDB_Stats(t *testing.T) {
 	}
 }
 
+// Ensure that the fragmentation of the free pages is reported.
+func TestDB_Stats_Freelist(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	err := db.Update(func(tx *bolt.Tx) error {
+		for i := 0; i < 100; i++ {
+			b, err := tx.CreateBucket([]byte(fmt.Sprintf("%03d", i)))
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte("key"), make([]byte, 3*4096)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Zero(t, db.Stats().Freelist.SpanN)
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for i := 0; i < 100; i += 2 {
+			if err := tx.DeleteBucket([]byte(fmt.Sprintf("%03d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The deleted pages are released by the next transaction.
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	s := tx.Stats().Freelist
+	require.Equal(t, s, db.Stats().Freelist)
+	require.Greater(t, s.SpanN, 10)
+	require.GreaterOrEqual(t, s.MaxSpan, 4)
+	require.InDelta(t, float64(db.Stats().FreePageN)/float64(s.SpanN), s.AvgSpan, 1e-9)
+	require.Greater(t, s.Fragmentation, 0.5)
+	require.Less(t, s.Fragmentation, 1.0)
+}
+
 // Ensure that database pages are in expected order and type.
 func TestDB_Consistency(t *testing.T) {
 	db := btesting.MustCreateDB(t)
@@ -1127,6 +1755,55 @@ func TestDB_Consistency(t *testing.T) {
 	}
 }
 
+// Ensure that the page cache accesses are counted.
+func TestDB_Stats_PageCache(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{NoMmap: true, PageCacheSize: 64 * 1024})
+	putBigBucket(t, db, "widgets", 2000)
+	db.MustClose()
+	db.MustReopen()
+
+	get := func() {
+		require.NoError(t, db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			for i := 0; i < 2000; i++ {
+				require.NotNil(t, b.Get([]byte(fmt.Sprintf("%08d", i))))
+			}
+			return nil
+		}))
+	}
+	get()
+	a := db.Stats()
+	require.Positive(t, a.PageCacheHitN)
+	require.Positive(t, a.PageCacheMissN)
+	require.Positive(t, a.PageCacheEvictN)
+	require.LessOrEqual(t, a.PageCacheSize, 64*1024)
+
+	// The leaves are evicted before they're read again.
+	get()
+	b := db.Stats()
+	diff := b.Sub(&a)
+	require.Positive(t, diff.PageCacheHitN)
+	require.Positive(t, diff.PageCacheMissN)
+
+	// A larger cache holds all the pages.
+	db.MustClose()
+	db.SetOptions(&bolt.Options{NoMmap: true, PageCacheSize: 4 << 20})
+	db.MustReopen()
+	get()
+	a = db.Stats()
+	get()
+	b = db.Stats()
+	diff = b.Sub(&a)
+	require.Zero(t, diff.PageCacheMissN)
+	require.Zero(t, diff.PageCacheEvictN)
+	require.Positive(t, diff.PageCacheHitN)
+
+	// Memory-mapped databases don't use it.
+	plain := btesting.MustCreateDB(t)
+	putBigBucket(t, plain, "widgets", 100)
+	require.Zero(t, plain.Stats().PageCacheMissN)
+}
+
 // Ensure that DB stats can be subtracted from one another.
 func TestDBStats_Sub(t *testing.T) {
 	var a, b bolt.Stats
@@ -1324,6 +2001,74 @@ func TestDB_BatchTime(t *testing.T) {
 	}
 }
 
+// Ensure that the options of a call make the batch it joins run earlier.
+func TestDB_BatchWithOptions(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}))
+	db.MaxBatchSize = 1000
+	db.MaxBatchDelay = time.Hour
+
+	put := func(i int) func(*bolt.Tx) error {
+		return func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("widgets")).Put(u64tob(uint64(i)), []byte{})
+		}
+	}
+	ch := make(chan error, 2)
+	go func() { ch <- db.Batch(put(1)) }()
+	time.Sleep(10 * time.Millisecond)
+	go func() { ch <- db.BatchWithOptions(put(2), &bolt.BatchOptions{MaxBatchDelay: 10 * time.Millisecond}) }()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-ch:
+			require.NoError(t, err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("batch didn't run")
+		}
+	}
+
+	require.NoError(t, db.BatchWithOptions(put(3), &bolt.BatchOptions{MaxBatchSize: 1}))
+}
+
+// Ensure that adaptive batches don't wait for calls which aren't coming.
+func TestDB_AdaptiveBatch(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}))
+	db.MaxBatchSize = 1000
+	db.MaxBatchDelay = time.Hour
+	db.AdaptiveBatch = true
+
+	put := func(i int) func(*bolt.Tx) error {
+		return func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("widgets")).Put(u64tob(uint64(i)), []byte{})
+		}
+	}
+	// The first batch has nothing to adapt to yet.
+	require.NoError(t, db.BatchWithOptions(put(0), &bolt.BatchOptions{MaxBatchSize: 1}))
+
+	done := make(chan error, 1)
+	go func() {
+		for i := 1; i < 10; i++ {
+			if err := db.Batch(put(i)); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("batches wait for MaxBatchDelay")
+	}
+}
+
 // TestDBUnmap verifes that `dataref`, `data` and `datasz` must be reset
 // to zero values respectively after unmapping the db.
 func TestDBUnmap(t *testing.T) {
@@ -1,13 +1,19 @@
 package boltdb
 
 import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
 	"path/filepath"
 	"testing"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
 )
 
 func TestOpenWithPreLoadFreelist(t *testing.T) {
@@ -112,6 +118,345 @@ func TestMethodPage(t *testing.T) {
 	}
 }
 
+// Ensure that the files with format flags have another version, so that
+// older versions of the package refuse to open them.
+func TestOpenWithPageChecksumsVersion(t *testing.T) {
+	for _, checksums := range []bool{false, true} {
+		t.Run(fmt.Sprint(checksums), func(t *testing.T) {
+			db, err := Open(filepath.Join(t.TempDir(), "db"), 0666, &Options{PageChecksums: checksums})
+			require.NoError(t, err)
+			defer db.Close()
+
+			version := common.Version
+			if checksums {
+				version = common.VersionFlags
+			}
+			require.Equal(t, version, db.meta().Version())
+			require.NoError(t, db.Update(func(tx *Tx) error { return nil }))
+			require.Equal(t, version, db.meta().Version())
+			require.Equal(t, checksums, db.meta().HasPageChecksums())
+		})
+	}
+}
+
+func TestOpenWithFreelistSpans(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "db")
+	db, err := Open(fileName, 0666, &Options{FreelistSpans: true})
+	require.NoError(t, err)
+
+	// Leave many free spans of a few pages.
+	err = db.Update(func(tx *Tx) error {
+		for i := 0; i < 100; i++ {
+			b, err := tx.CreateBucket([]byte(fmt.Sprintf("%03d", i)))
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte("key"), make([]byte, 3*db.pageSize)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	err = db.Update(func(tx *Tx) error {
+		for i := 0; i < 100; i += 2 {
+			if err := tx.DeleteBucket([]byte(fmt.Sprintf("%03d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *Tx) error { return nil }))
+
+	// The page holds the free and the pending pages.
+	require.True(t, db.meta().HasFreelistSpans())
+	require.Equal(t, common.VersionFlags, db.meta().Version())
+	var ids []common.Pgid
+	spans := db.page(db.meta().Freelist()).FreelistPagePairs()
+	for i := 0; i < len(spans); i += 2 {
+		for id := spans[i]; id < spans[i]+spans[i+1]; id++ {
+			ids = append(ids, id)
+		}
+	}
+	require.Less(t, len(spans), len(ids))
+	require.Equal(t, db.freelist.count(), len(ids))
+	require.NoError(t, db.Close())
+
+	// The spans are read without the option, and the next commit writes the
+	// page ids.
+	db, err = Open(fileName, 0666, nil)
+	require.NoError(t, err)
+	require.Equal(t, ids, db.freelist.getFreePageIDs())
+	require.NoError(t, db.Update(func(tx *Tx) error { return nil }))
+	require.False(t, db.meta().HasFreelistSpans())
+	require.Equal(t, common.Version, db.meta().Version())
+	ids = append([]common.Pgid(nil), db.page(db.meta().Freelist()).FreelistPageIds()...)
+	require.Equal(t, db.freelist.count(), len(ids))
+	require.NoError(t, db.Close())
+
+	db, err = Open(fileName, 0666, &Options{ReadOnly: true, PreLoadFreelist: true, FreelistSpans: true})
+	require.NoError(t, err)
+	require.Equal(t, ids, db.freelist.getFreePageIDs())
+	require.NoError(t, db.View(func(tx *Tx) error {
+		for err := range tx.Check() {
+			return err
+		}
+		return nil
+	}))
+	require.NoError(t, db.Close())
+}
+
+// Ensure that a file whose meta has a flag unknown to this version, which may
+// change the format of its pages, isn't opened.
+func TestOpenWithUnknownMetaFlag(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "db")
+	db, err := Open(fileName, 0666, &Options{FreelistSpans: true})
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *Tx) error { return nil }))
+	require.Equal(t, common.VersionFlags, db.meta().Version())
+	pageSize := db.pageSize
+	require.NoError(t, db.Close())
+
+	buf, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	for id := 0; id < 2; id++ {
+		m := common.LoadPageMeta(buf[id*pageSize:])
+		m.SetFlags(m.Flags() | 0x80)
+		m.SetChecksum(m.Sum64())
+	}
+	require.NoError(t, os.WriteFile(fileName, buf, 0666))
+	_, err = Open(fileName, 0666, nil)
+	require.ErrorIs(t, err, errors.ErrVersionMismatch)
+}
+
+func TestOpenWithFreelistSegments(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "db")
+	db, err := Open(fileName, 0666, &Options{PageSize: 4096, FreelistSegments: true})
+	require.NoError(t, err)
+
+	// Leave free pages in many segments.
+	err = db.Update(func(tx *Tx) error {
+		for i := 0; i < 2000; i++ {
+			b, err := tx.CreateBucket([]byte(fmt.Sprintf("%04d", i)))
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte("key"), make([]byte, 3*db.pageSize)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	err = db.Update(func(tx *Tx) error {
+		for i := 0; i < 2000; i += 2 {
+			if err := tx.DeleteBucket([]byte(fmt.Sprintf("%04d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *Tx) error { return nil }))
+
+	require.True(t, db.meta().HasFreelistSegments())
+	require.Equal(t, common.VersionFlags, db.meta().Version())
+	segments := maps.Clone(db.freelist.segmentPages)
+	require.Greater(t, len(segments), 10)
+	var ids []common.Pgid
+	for _, p := range freelistPages(db.meta(), db.page)[1:] {
+		ids = append(ids, p.FreelistPageIds()...)
+	}
+	require.Equal(t, db.freelist.count(), len(ids))
+	require.NoError(t, checkErr(db))
+
+	// A small update only rewrites a few segments.
+	err = db.Update(func(tx *Tx) error {
+		return tx.Bucket([]byte("0001")).Put([]byte("key"), []byte("value"))
+	})
+	require.NoError(t, err)
+	var changed int
+	for i, id := range db.freelist.segmentPages {
+		if segments[i] != id {
+			changed++
+		}
+	}
+	require.Less(t, changed, len(segments)/2)
+	require.NoError(t, checkErr(db))
+	ids = nil
+	for _, p := range freelistPages(db.meta(), db.page)[1:] {
+		ids = append(ids, p.FreelistPageIds()...)
+	}
+	require.NoError(t, db.Close())
+
+	// The segments are read without the option, and the next commit frees
+	// their pages and writes the page ids.
+	db, err = Open(fileName, 0666, nil)
+	require.NoError(t, err)
+	require.Equal(t, ids, db.freelist.getFreePageIDs())
+	require.NoError(t, db.Update(func(tx *Tx) error { return nil }))
+	require.False(t, db.meta().HasFreelistSegments())
+	require.Equal(t, common.Version, db.meta().Version())
+	require.NoError(t, checkErr(db))
+	require.NoError(t, db.Close())
+
+	db, err = Open(fileName, 0666, &Options{FreelistSegments: true})
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *Tx) error { return nil }))
+	require.True(t, db.meta().HasFreelistSegments())
+	require.NoError(t, checkErr(db))
+	require.NoError(t, db.Close())
+}
+
+func TestOpenWithFreelistRebuild(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "db")
+	o := &Options{NoFreelistSync: true}
+	db, err := Open(fileName, 0666, o)
+	require.NoError(t, err)
+	err = db.Update(func(tx *Tx) error {
+		for i := 0; i < 100; i++ {
+			b, err := tx.CreateBucket([]byte(fmt.Sprintf("%03d", i)))
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte("key"), make([]byte, 3*db.pageSize)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	err = db.Update(func(tx *Tx) error {
+		for i := 0; i < 100; i += 2 {
+			if err := tx.DeleteBucket([]byte(fmt.Sprintf("%03d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// Open returns before the free pages are reconstructed, and read
+	// transactions don't wait for them.
+	db, err = Open(fileName, 0666, o)
+	require.NoError(t, err)
+	r := db.freelistRebuild.Load()
+	require.NotNil(t, r)
+	require.NoError(t, db.View(func(tx *Tx) error {
+		require.NotNil(t, tx.Bucket([]byte("001")))
+		return nil
+	}))
+	<-r.done
+	require.NoError(t, r.err)
+	require.NotEmpty(t, r.ids)
+	require.Zero(t, db.freelist.free_count())
+	require.Equal(t, len(r.ids), db.Stats().FreePageN)
+
+	// The first allocation adds them to the freelist.
+	hwm := db.meta().Pgid()
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Bucket([]byte("001")).Put([]byte("key2"), []byte("value"))
+	}))
+	require.Nil(t, db.freelistRebuild.Load())
+	require.Equal(t, hwm, db.meta().Pgid())
+	require.NotZero(t, db.freelist.free_count())
+	require.NoError(t, db.View(func(tx *Tx) error {
+		for err := range tx.Check() {
+			return err
+		}
+		return nil
+	}))
+	require.NoError(t, db.Close())
+
+	// Close stops the reconstruction.
+	db, err = Open(fileName, 0666, o)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestPagePools(t *testing.T) {
+	for _, directIO := range []bool{false, true} {
+		db := &DB{pageSize: 4096, directIO: directIO}
+		for _, count := range []int{1, 2, 3, 5, 64, 65} {
+			t.Run(fmt.Sprintf("%v/%d", directIO, count), func(t *testing.T) {
+				size := count * db.pageSize
+				if count <= maxPooledPages {
+					size = (1 << pageClass(count)) * db.pageSize
+				}
+				for i := 0; i < 3; i++ {
+					// The buffers are zeroed whether they're reused or not.
+					buf := db.getPageBuffer(count)
+					require.Len(t, buf, size)
+					require.Equal(t, make([]byte, size), buf)
+					if directIO {
+						require.Zero(t, uintptr(unsafe.Pointer(&buf[0]))%directIOAlign)
+					}
+					for j := range buf[:count*db.pageSize] {
+						buf[j] = 0xff
+					}
+					p := (*common.Page)(unsafe.Pointer(&buf[0]))
+					p.SetOverflow(uint32(count - 1))
+					db.putPageBuffer(p)
+				}
+			})
+		}
+	}
+}
+
+func TestMmapChunksReleaseSpans(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0666, &Options{MmapChunkSize: 64 * 1024})
+	require.NoError(t, err)
+	defer db.Close()
+
+	value := func(i int) []byte {
+		return bytes.Repeat([]byte{byte(i)}, 20000)
+	}
+	const n = 400
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), value(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	// An open reader keeps the mappings of the values it read.
+	rtx, err := db.Begin(false)
+	require.NoError(t, err)
+	values := make([][]byte, n)
+	for i := range values {
+		values[i] = rtx.Bucket([]byte("widgets")).Get([]byte(fmt.Sprintf("%04d", i)))
+	}
+	db.spanlock.Lock()
+	spans := len(db.spans)
+	db.spanlock.Unlock()
+	require.Greater(t, spans, spanCacheSize)
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for i := 0; i < n; i++ {
+			require.Equal(t, value(i), b.Get([]byte(fmt.Sprintf("%04d", i))))
+		}
+		return nil
+	}))
+	for i, v := range values {
+		require.Equal(t, value(i), v)
+	}
+	require.Len(t, db.spans, spans)
+
+	// Once it's closed, only spanCacheSize of them are kept.
+	require.NoError(t, rtx.Rollback())
+	require.Len(t, db.spans, spanCacheSize)
+	require.Empty(t, db.spantxs)
+}
+
 func prepareData(t *testing.T) (string, error) {
 	fileName := filepath.Join(t.TempDir(), "db")
 	db, err := Open(fileName, 0666, nil)
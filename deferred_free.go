@@ -0,0 +1,199 @@
+package boltdb
+
+import (
+	"fmt"
+
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// deferredFreeBatch is the number of pages of the deleted buckets which each
+// write transaction frees on commit, see deferBucket.
+const deferredFreeBatch = 4096
+
+// deferBucket defers freeing the pages of a nested bucket being deleted if
+// it spans several leaves and has no changes in memory, so that deleting a
+// large bucket doesn't walk all its pages. Its root is added to the list of
+// subtrees which the write transactions walk and free on commit, a batch at a
+// time, see freeDeferred. Returns whether it did.
+func (tx *Tx) deferBucket(child *Bucket) bool {
+	if child.RootPage() == 0 || child.changed() || !tx.page(child.RootPage()).IsBranchPage() {
+		return false
+	}
+	tx.loadDeferred()
+	tx.deferred = append([]common.DeferredFree{{Pgid: child.RootPage()}}, tx.deferred...)
+	return true
+}
+
+// loadDeferred reads the deferred page of the meta of the transaction, unless
+// it was already read.
+func (tx *Tx) loadDeferred() {
+	if tx.deferredLoaded {
+		return
+	}
+	tx.deferredLoaded = true
+	if id := tx.meta.Deferred(); id != 0 {
+		tx.deferred = append([]common.DeferredFree(nil), tx.page(id).DeferredFrees()...)
+	}
+}
+
+// freeDeferred frees up to deferredFreeBatch pages of the deleted buckets
+// which weren't freed yet, depth first, and writes the deferred page listing
+// the subtrees left to free. The buckets deleted by the transaction are
+// listed first, so that the earlier ones are freed first.
+func (tx *Tx) freeDeferred() error {
+	if tx.meta.Deferred() == 0 && len(tx.deferred) == 0 {
+		return nil
+	}
+	tx.loadDeferred()
+	txid := tx.meta.Txid()
+	for n := 0; n < deferredFreeBatch && len(tx.deferred) > 0; {
+		i := len(tx.deferred) - 1
+		p := tx.page(tx.deferred[i].Pgid)
+		if index := tx.deferred[i].Index; index < uint64(p.Count()) {
+			tx.deferred[i].Index++
+			var child common.Pgid
+			if p.IsBranchPage() {
+				child = p.BranchPageElement(uint16(index)).Pgid()
+			} else if e := p.LeafPageElement(uint16(index)); e.IsBucketEntry() {
+				child = e.Bucket().RootPage()
+			} else if e.IsBlobEntry() {
+				tx.forEachBlobPage(e.Value(), func(p *common.Page) {
+					tx.db.freelist.free(txid, p)
+					n += 1 + int(p.Overflow())
+				})
+			}
+			if child != 0 {
+				tx.deferred = append(tx.deferred, common.DeferredFree{Pgid: child})
+			}
+			continue
+		}
+		tx.db.freelist.free(txid, p)
+		n += 1 + int(p.Overflow())
+		tx.deferred = tx.deferred[:i]
+	}
+
+	// Replace the deferred page like the freelist.
+	if id := tx.meta.Deferred(); id != 0 {
+		tx.db.freelist.free(txid, tx.page(id))
+		tx.meta.SetDeferred(0)
+	}
+	if len(tx.deferred) == 0 {
+		return nil
+	}
+	size := int(common.PageHeaderSize) + (len(tx.deferred)+1)*common.DeferredFreeSize + tx.db.pageTrailerSize()
+	p, err := tx.allocate(size/tx.db.pageSize + 1)
+	if err != nil {
+		return err
+	}
+	p.SetDeferredFrees(tx.deferred)
+	tx.meta.SetDeferred(p.Id())
+	return nil
+}
+
+// checkDeferred marks the deferred page and the pages of the deleted buckets
+// which weren't freed yet as reachable.
+func (c *checker) checkDeferred() {
+	tx := c.tx
+	id := tx.meta.Deferred()
+	if id == 0 {
+		return
+	}
+	p := tx.page(id)
+	if !p.IsDeferredPage() {
+		c.ch <- fmt.Errorf("page %d: invalid deferred page type: %s", int(id), p.Typ())
+		return
+	}
+	c.markReachable(p, nil)
+	for _, f := range p.DeferredFrees() {
+		fp := c.checkDeferredPage(f.Pgid, []common.Pgid{id})
+		if fp == nil {
+			continue
+		}
+		stack := []common.Pgid{id, f.Pgid}
+		for i := f.Index; i < uint64(fp.Count()); i++ {
+			c.checkDeferredElement(fp, uint16(i), stack)
+		}
+	}
+}
+
+// checkDeferredSubtree marks the pages of a subtree of a deleted bucket as
+// reachable.
+func (c *checker) checkDeferredSubtree(id common.Pgid, stack []common.Pgid) {
+	p := c.checkDeferredPage(id, stack)
+	if p == nil {
+		return
+	}
+	stack = append(stack, id)
+	for i := uint16(0); i < p.Count(); i++ {
+		c.checkDeferredElement(p, i, stack)
+	}
+}
+
+// checkDeferredElement marks the pages an element of a page of a deleted
+// bucket refers to as reachable.
+func (c *checker) checkDeferredElement(p *common.Page, i uint16, stack []common.Pgid) {
+	if p.IsBranchPage() {
+		c.checkDeferredSubtree(p.BranchPageElement(i).Pgid(), stack)
+	} else if e := p.LeafPageElement(i); e.IsBucketEntry() {
+		if root := e.Bucket().RootPage(); root != 0 {
+			c.checkDeferredSubtree(root, stack)
+		}
+	} else if e.IsBlobEntry() {
+		c.checkBlob(e.Value(), stack)
+	}
+}
+
+// checkDeferredPage checks a branch or leaf page of a deleted bucket and marks
+// it as reachable. Returns nil if it's invalid.
+func (c *checker) checkDeferredPage(id common.Pgid, stack []common.Pgid) *common.Page {
+	tx := c.tx
+	if id < 2 || id >= tx.meta.Pgid() {
+		c.ch <- fmt.Errorf("page %d: deferred out of bounds: %d (stack: %v)", int(id), int(tx.meta.Pgid()), stack)
+		return nil
+	}
+	p := tx.page(id)
+	if !p.IsBranchPage() && !p.IsLeafPage() {
+		c.ch <- fmt.Errorf("page %d: invalid type: %s (stack: %v)", int(id), p.Typ(), stack)
+		return nil
+	}
+	c.markReachable(p, stack)
+	if c.freed[id] {
+		c.ch <- fmt.Errorf("page %d: reachable freed", int(id))
+	}
+	return p
+}
+
+// collectDeferredPages appends the ids of the pages written after since of
+// the deferred page and of the deleted buckets which weren't freed yet.
+func (tx *Tx) collectDeferredPages(since common.Txid, ids *[]common.Pgid) {
+	id := tx.meta.Deferred()
+	if id == 0 {
+		return
+	}
+	p := tx.page(id)
+	if since == 0 || p.WrittenTxid(tx.db.pageSize, tx.db.pageChecksums) > since {
+		*ids = append(*ids, id)
+	}
+	for _, f := range p.DeferredFrees() {
+		fp := tx.page(f.Pgid)
+		if since > 0 && fp.WrittenTxid(tx.db.pageSize, tx.db.pageChecksums) <= since {
+			continue
+		}
+		*ids = append(*ids, f.Pgid)
+		for i := f.Index; i < uint64(fp.Count()); i++ {
+			if fp.IsBranchPage() {
+				tx.collectChangedPages(fp.BranchPageElement(uint16(i)).Pgid(), since, ids)
+			} else if e := fp.LeafPageElement(uint16(i)); e.IsBucketEntry() {
+				if root := e.Bucket().RootPage(); root != 0 {
+					tx.collectChangedPages(root, since, ids)
+				}
+			} else if e.IsBlobEntry() {
+				tx.forEachBlobPage(e.Value(), func(p *common.Page) {
+					if since == 0 || p.WrittenTxid(tx.db.pageSize, tx.db.pageChecksums) > since {
+						*ids = append(*ids, p.Id())
+					}
+				})
+			}
+		}
+	}
+}
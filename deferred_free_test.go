@@ -0,0 +1,92 @@
+package boltdb_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+// Ensure the pages of a large deleted bucket are freed by the next write
+// transactions, across reopens.
+func TestTx_DeleteBucket_Deferred(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+	for _, noFreelistSync := range []bool{false, true} {
+		t.Run(fmt.Sprintf("NoFreelistSync=%v", noFreelistSync), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096, NoFreelistSync: noFreelistSync})
+			var pageN int
+			err := db.Update(func(tx *bolt.Tx) error {
+				if _, err := tx.CreateBucket([]byte("small")); err != nil {
+					return err
+				}
+				b, err := tx.CreateBucket([]byte("widgets"))
+				if err != nil {
+					return err
+				}
+				for i := 0; i < 20000; i++ {
+					if err := b.Put([]byte(fmt.Sprintf("%05d", i)), make([]byte, 1800)); err != nil {
+						return err
+					}
+				}
+				mustPutReader(t, b, "blob", largeValue(1<<20, 1))
+				nested, err := b.CreateBucket([]byte("nested"))
+				if err != nil {
+					return err
+				}
+				for i := 0; i < 1000; i++ {
+					if err := nested.Put([]byte(fmt.Sprintf("%05d", i)), make([]byte, 100)); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			require.NoError(t, err)
+			err = db.View(func(tx *bolt.Tx) error {
+				s := tx.Bucket([]byte("widgets")).Stats()
+				pageN = s.BranchPageN + s.BranchOverflowN + s.LeafPageN + s.LeafOverflowN
+				return nil
+			})
+			require.NoError(t, err)
+			freeN := func() int {
+				s := db.Stats()
+				return s.FreePageN + s.PendingPageN
+			}
+			before := freeN()
+
+			// Deleting the bucket only frees a batch of its pages.
+			err = db.Update(func(tx *bolt.Tx) error {
+				return tx.DeleteBucket([]byte("widgets"))
+			})
+			require.NoError(t, err)
+			require.Greater(t, freeN()-before, 0)
+			require.Less(t, freeN()-before, pageN/2)
+			db.MustCheck()
+			db.MustClose()
+			db.MustReopen()
+			db.MustCheck()
+
+			// The next transactions free them in batches.
+			var freed []int
+			for i := 0; i < 4; i++ {
+				err = db.Update(func(tx *bolt.Tx) error {
+					return tx.Bucket([]byte("small")).Put([]byte("key"), []byte(fmt.Sprint(i)))
+				})
+				require.NoError(t, err)
+				db.MustCheck()
+				freed = append(freed, freeN()-before)
+			}
+			require.Less(t, freed[0], pageN)
+			require.Greater(t, freed[3], pageN)
+			require.Equal(t, freed[2], freed[3])
+
+			db.MustClose()
+			db.MustReopen()
+			db.MustCheck()
+		})
+	}
+}
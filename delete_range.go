@@ -0,0 +1,238 @@
+package boltdb
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// DeleteRange removes all keys in the range [start, end) from the bucket. A
+// nil start begins the range at the first key and a nil end ends it after the
+// last key. Subtrees which lie entirely in the range are freed as a whole,
+// only reading the flags of their keys to find nested buckets and streamed
+// values, so it's much faster than deleting the keys one by one with a
+// cursor. The keys and values are still read when the bucket has indexes or
+// the database has watchers, which need them.
+// Returns an error if the bucket was created from a read-only transaction,
+// or if the range contains a nested bucket, in which case nothing is deleted.
+func (b *Bucket) DeleteRange(start, end []byte) (err error) {
+	defer b.tx.catch(&err)
+	if b.tx.db == nil {
+		return errors.ErrTxClosed
+	} else if !b.Writable() {
+		return errors.ErrTxNotWritable
+	}
+	if start != nil && end != nil && bytes.Compare(start, end) >= 0 {
+		return nil
+	}
+
+	// Nested buckets must be deleted with DeleteBucket, so that their pages
+	// are freed. Look for them before changing anything.
+	var s deletedRange
+	var deleted [][]byte
+	if watched := b.watched(); len(b.indexes) > 0 || watched {
+		var keys, values [][]byte
+		c := b.Cursor()
+		k, v, flags := c.seek(start)
+		if ref := &c.stack[len(c.stack)-1]; ref.index >= ref.count() {
+			k, v, flags = c.next()
+		}
+		for ; k != nil && (end == nil || bytes.Compare(k, end) < 0); k, v, flags = c.next() {
+			if (flags & common.BucketLeafFlag) != 0 {
+				return errors.ErrIncompatibleValue
+			}
+			if len(b.indexes) > 0 {
+				keys = append(keys, k)
+				values = append(values, b.indexedValue(v, flags))
+			}
+			if (flags & common.BlobLeafFlag) != 0 {
+				s.blobs = append(s.blobs, cloneBytes(v))
+			}
+			if watched {
+				deleted = append(deleted, cloneBytes(k))
+			}
+			s.n++
+		}
+		for i := range keys {
+			if err := b.updateIndexes(keys[i], values[i], true, nil, false); err != nil {
+				return err
+			}
+		}
+	} else if err := b.checkDeleteRange(b.RootPage(), start, end, false, &s); err != nil {
+		return err
+	}
+	if s.n == 0 {
+		return nil
+	}
+	for _, ref := range s.blobs {
+		b.tx.freeBlob(ref)
+	}
+
+	root := b.node(b.RootPage(), nil)
+	b.deleteRange(root, start, end)
+
+	// An emptied root branch becomes an empty leaf.
+	if !root.isLeaf && len(root.inodes) == 0 {
+		root.isLeaf = true
+	}
+	b.addCount(-s.n)
+	for _, k := range deleted {
+		b.recordChange(k, OpDelete)
+	}
+	return nil
+}
+
+// deleteRange removes the keys in [start, end) from the subtree of a node. The
+// children which lie entirely in the range are freed, the ones which overlap
+// it are read into nodes and trimmed. The nodes are left to be rebalanced on
+// commit.
+func (b *Bucket) deleteRange(n *node, start, end []byte) {
+	if n.isLeaf {
+		i := sort.Search(len(n.inodes), func(i int) bool { return bytes.Compare(n.inodes[i].Key(), start) >= 0 })
+		j := i
+		for j < len(n.inodes) && (end == nil || bytes.Compare(n.inodes[j].Key(), end) < 0) {
+			j++
+		}
+		if j > i {
+			n.inodes = append(n.inodes[:i], n.inodes[j:]...)
+			n.unbalanced = true
+		}
+		return
+	}
+
+	inodes := make(common.Inodes, 0, len(n.inodes))
+	for i, inode := range n.inodes {
+		var hi []byte
+		if i+1 < len(n.inodes) {
+			hi = n.inodes[i+1].Key()
+		}
+
+		switch outside, inside := childInRange(i, inode.Key(), hi, start, end); {
+		case outside:
+			inodes = append(inodes, inode)
+		case inside:
+			if child := b.nodes[inode.Pgid()]; child != nil {
+				n.removeChild(child)
+			}
+			b.freeSubtree(inode.Pgid())
+			n.unbalanced = true
+		default:
+			b.deleteRange(b.node(inode.Pgid(), n), start, end)
+			inodes = append(inodes, inode)
+		}
+	}
+	n.inodes = inodes
+}
+
+// childInRange returns whether the i-th child of a branch, whose key is lo and
+// the key of the next child hi, lies entirely outside or inside [start, end).
+// A child holds the keys from its own key up to the key of the next child,
+// and the first child also holds the keys before its own key.
+func childInRange(i int, lo, hi, start, end []byte) (outside, inside bool) {
+	if i == 0 {
+		lo = nil
+	}
+	outside = (hi != nil && bytes.Compare(hi, start) <= 0) || (lo != nil && end != nil && bytes.Compare(lo, end) >= 0)
+	inside = !outside && (start == nil || (lo != nil && bytes.Compare(lo, start) >= 0)) &&
+		(end == nil || (hi != nil && bytes.Compare(hi, end) <= 0))
+	return outside, inside
+}
+
+// deletedRange holds the number of keys in a range and their streamed values,
+// see Bucket.checkDeleteRange.
+type deletedRange struct {
+	n     int
+	blobs [][]byte
+}
+
+// checkDeleteRange counts the keys in [start, end) of the subtree at id, or
+// all of them if whole is set, and collects their streamed values. It returns
+// ErrIncompatibleValue if one of them is a nested bucket. Like deleteRange,
+// it only compares the keys of the pages at the bounds of the range, and it
+// only reads the flags of the other keys.
+func (b *Bucket) checkDeleteRange(id common.Pgid, start, end []byte, whole bool, s *deletedRange) error {
+	p, n := b.pageNode(id)
+	var count int
+	if n != nil {
+		count = len(n.inodes)
+	} else {
+		count = int(p.Count())
+	}
+	if (n != nil && !n.isLeaf) || (n == nil && p.IsBranchPage()) {
+		for i := 0; i < count; i++ {
+			key, child := branchChild(p, n, i)
+			if whole {
+				if err := b.checkDeleteRange(child, nil, nil, true, s); err != nil {
+					return err
+				}
+				continue
+			}
+			var hi []byte
+			if i+1 < count {
+				hi, _ = branchChild(p, n, i+1)
+			}
+			if outside, inside := childInRange(i, key, hi, start, end); !outside {
+				if err := b.checkDeleteRange(child, start, end, inside, s); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	for i := 0; i < count; i++ {
+		var k, v []byte
+		var flags uint32
+		if n != nil {
+			k, v, flags = n.inodes[i].Key(), n.inodes[i].Value(), n.inodes[i].Flags()
+		} else {
+			e := p.LeafPageElement(uint16(i))
+			k, v, flags = e.Key(), e.Value(), e.Flags()
+		}
+		if !whole && (bytes.Compare(k, start) < 0 || (end != nil && bytes.Compare(k, end) >= 0)) {
+			continue
+		}
+		if (flags & common.BucketLeafFlag) != 0 {
+			return errors.ErrIncompatibleValue
+		}
+		if (flags & common.BlobLeafFlag) != 0 {
+			s.blobs = append(s.blobs, cloneBytes(v))
+		}
+		s.n++
+	}
+	return nil
+}
+
+// branchChild returns the key and the page of the i-th child of a branch,
+// read from its node if it has one, or from its page otherwise.
+func branchChild(p *common.Page, n *node, i int) ([]byte, common.Pgid) {
+	if n != nil {
+		return n.inodes[i].Key(), n.inodes[i].Pgid()
+	}
+	e := p.BranchPageElement(uint16(i))
+	return e.Key(), e.Pgid()
+}
+
+// freeSubtree frees the pages of a subtree and drops its nodes.
+func (b *Bucket) freeSubtree(id common.Pgid) {
+	if n := b.nodes[id]; n != nil {
+		if !n.isLeaf {
+			for _, inode := range n.inodes {
+				b.freeSubtree(inode.Pgid())
+			}
+		}
+		delete(b.nodes, id)
+		n.free()
+		return
+	}
+
+	p := b.tx.page(id)
+	if p.IsBranchPage() {
+		for i := uint16(0); i < p.Count(); i++ {
+			b.freeSubtree(p.BranchPageElement(i).Pgid())
+		}
+	}
+	b.tx.db.freelist.free(b.tx.meta.Txid(), p)
+}
@@ -0,0 +1,192 @@
+package boltdb_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestBucket_DeleteRange(t *testing.T) {
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%05d", i)) }
+
+	for _, tc := range []struct {
+		name       string
+		start, end []byte
+		from, to   int
+	}{
+		{name: "middle", start: key(1234), end: key(8765), from: 1234, to: 8765},
+		{name: "head", end: key(5000), from: 0, to: 5000},
+		{name: "tail", start: key(5000), from: 5000, to: 10000},
+		{name: "all", from: 0, to: 10000},
+		{name: "between keys", start: []byte("01234x"), end: []byte("01240x"), from: 1235, to: 1241},
+		{name: "empty", start: key(10), end: key(10), from: 0, to: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := btesting.MustCreateDB(t)
+			err := db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucket([]byte("widgets"))
+				if err != nil {
+					return err
+				}
+				for i := 0; i < 10000; i++ {
+					if err := b.Put(key(i), make([]byte, 100)); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			require.NoError(t, err)
+
+			err = db.Update(func(tx *bolt.Tx) error {
+				if err := tx.Bucket([]byte("widgets")).DeleteRange(tc.start, tc.end); err != nil {
+					return err
+				}
+				// Only the pages at the bounds of the range are read into nodes.
+				stats := tx.Stats()
+				require.LessOrEqual(t, stats.GetNodeCount(), int64(10))
+				return nil
+			})
+			require.NoError(t, err)
+			db.MustCheck()
+
+			err = db.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				require.Equal(t, 10000-(tc.to-tc.from), b.Stats().KeyN)
+				for i := 0; i < 10000; i++ {
+					if i >= tc.from && i < tc.to {
+						require.Nil(t, b.Get(key(i)), "key %d", i)
+					} else {
+						require.NotNil(t, b.Get(key(i)), "key %d", i)
+					}
+				}
+				return nil
+			})
+			require.NoError(t, err)
+
+			// The bucket is still usable.
+			putWidgets(t, db, 0, 10)
+			db.MustCheck()
+		})
+	}
+}
+
+func TestBucket_DeleteRange_Random(t *testing.T) {
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%05d", i)) }
+	bound := func(r *rand.Rand, i int) []byte {
+		// The ranges are sometimes unbounded.
+		if r.Intn(10) == 0 {
+			return nil
+		}
+		return key(i)
+	}
+
+	for _, pageSize := range []int{1024, 4096, 16384} {
+		t.Run(fmt.Sprint(pageSize), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: pageSize})
+			r := rand.New(rand.NewSource(42))
+			keys := make(map[int]bool)
+
+			for round := 0; round < 40; round++ {
+				err := db.Update(func(tx *bolt.Tx) error {
+					b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+					if err != nil {
+						return err
+					}
+					// Changes in the same transaction are read into nodes
+					// first, before and after the ranges are deleted.
+					for op := 0; op < 3; op++ {
+						for i := r.Intn(2000); i > 0; i-- {
+							k := r.Intn(20000)
+							keys[k] = true
+							if err := b.Put(key(k), make([]byte, r.Intn(200))); err != nil {
+								return err
+							}
+						}
+						from := r.Intn(20000)
+						to := from + r.Intn(10000)
+						start, end := bound(r, from), bound(r, to)
+						for k := range keys {
+							if (start == nil || k >= from) && (end == nil || k < to) {
+								delete(keys, k)
+							}
+						}
+						if err := b.DeleteRange(start, end); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+				require.NoError(t, err)
+				db.MustCheck()
+
+				err = db.View(func(tx *bolt.Tx) error {
+					var n int
+					err := tx.Bucket([]byte("widgets")).ForEach(func(k, _ []byte) error {
+						var i int
+						_, err := fmt.Sscanf(string(k), "%05d", &i)
+						require.NoError(t, err)
+						require.True(t, keys[i], "key %s", k)
+						n++
+						return nil
+					})
+					require.Equal(t, len(keys), n)
+					require.Equal(t, len(keys), tx.Bucket([]byte("widgets")).Stats().KeyN)
+					return err
+				})
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestBucket_DeleteRange_NestedBucket(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("a"), []byte("1")))
+		_, err = b.CreateBucket([]byte("b"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("c"), []byte("3")))
+
+		require.ErrorIs(t, b.DeleteRange(nil, nil), berrors.ErrIncompatibleValue)
+		require.Equal(t, []byte("1"), b.Get([]byte("a")))
+
+		// The bucket is inline.
+		require.NoError(t, b.DeleteRange([]byte("c"), nil))
+		require.NoError(t, b.DeleteRange(nil, []byte("b")))
+		require.Nil(t, b.Get([]byte("a")))
+		require.Nil(t, b.Get([]byte("c")))
+		require.NotNil(t, b.Bucket([]byte("b")))
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		require.ErrorIs(t, tx.Bucket([]byte("widgets")).DeleteRange(nil, nil), berrors.ErrTxNotWritable)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// A nested bucket is found in the subtrees which lie entirely in the
+	// range, whose keys aren't compared.
+	putBigBucket(t, db, "big", 10000)
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.Bucket([]byte("big")).CreateBucket([]byte("00005000x"))
+		return err
+	})
+	require.NoError(t, err)
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("big"))
+		require.ErrorIs(t, b.DeleteRange([]byte("00001000"), []byte("00009000")), berrors.ErrIncompatibleValue)
+		require.Equal(t, 10001, b.Stats().KeyN)
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,376 @@
+package boltdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"unsafe"
+
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// diffMagic marks the start of an incremental backup written by WriteDiffTo.
+const diffMagic uint32 = 0xED0CD1FF
+
+const diffVersion uint32 = 1
+
+// diffHeaderSize is the size of the diff header: magic, version, page size,
+// slot size, base txid, txid and high water mark.
+const diffHeaderSize = 40
+
+// diffEntrySize is the size of the header of each run of pages in a diff:
+// page id, page count and padding.
+const diffEntrySize = 16
+
+// WriteDiffTo writes the pages changed after the transaction sinceTxid to w,
+// followed by the meta pages of tx. The diff can be applied with ApplyDiff to
+// a copy of the database taken at any transaction between sinceTxid and tx,
+// e.g. by Tx.WriteTo or by a previous ApplyDiff, to bring it up to date with
+// tx. Pages of an encrypted database are written encrypted.
+//
+// The database must have been created with Options.PageTxids. tx should be a
+// read-only transaction.
+func (tx *Tx) WriteDiffTo(w io.Writer, sinceTxid uint64) (n int64, err error) {
+	if !tx.db.pageTxids {
+		return 0, berrors.ErrDiffUnsupported
+	}
+	return tx.writeDiff(w, common.Txid(sinceTxid), tx.changedPages)
+}
+
+// writeDiff writes a diff of the pages returned by pages for since to w.
+func (tx *Tx) writeDiff(w io.Writer, since common.Txid, pages func(common.Txid) []common.Pgid) (n int64, err error) {
+	db := tx.db
+	slotSize := db.pageSize
+	var f dataFile
+	if db.cipher != nil {
+		// Copy the encrypted pages as they are in the data file.
+		slotSize = db.encryptedPageSize()
+		if f, err = tx.openDataFile(); err != nil {
+			return 0, err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+	}
+
+	dw := &diffWriter{w: w, h: crc32.New(walCRCTable)}
+
+	var hdr [diffHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:], diffMagic)
+	binary.LittleEndian.PutUint32(hdr[4:], diffVersion)
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(db.pageSize))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(slotSize))
+	binary.LittleEndian.PutUint64(hdr[16:], uint64(since))
+	binary.LittleEndian.PutUint64(hdr[24:], uint64(tx.meta.Txid()))
+	binary.LittleEndian.PutUint64(hdr[32:], uint64(tx.meta.Pgid()))
+	if err := dw.write(hdr[:]); err != nil {
+		return dw.n, err
+	}
+
+	if err := tx.writePageRuns(dw, pages(since), f, slotSize); err != nil {
+		return dw.n, err
+	}
+	if err := tx.writeMetaPages(dw); err != nil {
+		return dw.n, err
+	}
+
+	var trailer [4]byte
+	binary.LittleEndian.PutUint32(trailer[:], dw.h.Sum32())
+	nn, err := w.Write(trailer[:])
+	return dw.n + int64(nn), err
+}
+
+// writePageRuns writes the page run starting at each page of ids to dw,
+// preceded by its page id and page count, and followed by an empty entry.
+// Pages of an encrypted database are read from f as they are in the data
+// file, in slots of slotSize bytes.
+func (tx *Tx) writePageRuns(dw *diffWriter, ids []common.Pgid, f dataFile, slotSize int) error {
+	var buf []byte
+	for _, id := range ids {
+		p := tx.page(id)
+		count := int(p.Overflow()) + 1
+
+		var entry [diffEntrySize]byte
+		binary.LittleEndian.PutUint64(entry[0:], uint64(id))
+		binary.LittleEndian.PutUint32(entry[8:], uint32(count))
+		if err := dw.write(entry[:]); err != nil {
+			return err
+		}
+
+		if f != nil {
+			if cap(buf) < count*slotSize {
+				buf = make([]byte, count*slotSize)
+			}
+			buf = buf[:count*slotSize]
+			if _, err := f.ReadAt(buf, int64(id)*int64(slotSize)); err != nil {
+				return fmt.Errorf("page %d: %w", id, err)
+			}
+		} else {
+			buf = common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, count*tx.db.pageSize)
+		}
+		if err := dw.write(buf); err != nil {
+			return err
+		}
+	}
+	var end [diffEntrySize]byte
+	return dw.write(end[:])
+}
+
+// writeMetaPages generates both meta pages the same way as WriteTo, and
+// writes them to dw.
+func (tx *Tx) writeMetaPages(dw *diffWriter) error {
+	db := tx.db
+	mbuf := make([]byte, db.pageSize)
+	page := (*common.Page)(unsafe.Pointer(&mbuf[0]))
+	page.SetFlags(common.MetaPageFlag)
+	*page.Meta() = *tx.meta
+	for id := 0; id < 2; id++ {
+		page.SetId(common.Pgid(id))
+		if id == 1 {
+			page.Meta().DecTxid()
+		}
+		page.Meta().SetChecksum(page.Meta().Sum64())
+		out := mbuf
+		if db.cipher != nil {
+			out = db.cipher.Seal(nil, mbuf, uint64(id))
+		}
+		if err := dw.write(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changedPages returns the ids of the freelist page and of the pages of all
+// buckets which were written after the given transaction, in order. All of
+// them are returned if since is 0, which doesn't require page txids.
+func (tx *Tx) changedPages(since common.Txid) []common.Pgid {
+	var ids []common.Pgid
+	for _, p := range tx.freelistPages() {
+		if since == 0 || p.WrittenTxid(tx.db.pageSize, tx.db.pageChecksums) > since {
+			ids = append(ids, p.Id())
+		}
+	}
+	tx.collectChangedPages(tx.meta.RootBucket().RootPage(), since, &ids)
+	tx.collectDeferredPages(since, &ids)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// collectChangedPages appends the ids of the pages written after since in the
+// subtree rooted at id. Since pages are copied on write, all ancestors of a
+// changed page are changed as well, so unchanged subtrees are skipped.
+func (tx *Tx) collectChangedPages(id common.Pgid, since common.Txid, ids *[]common.Pgid) {
+	p := tx.page(id)
+	if since > 0 && p.WrittenTxid(tx.db.pageSize, tx.db.pageChecksums) <= since {
+		return
+	}
+	*ids = append(*ids, id)
+
+	switch {
+	case p.IsBranchPage():
+		for i := range p.BranchPageElements() {
+			tx.collectChangedPages(p.BranchPageElement(uint16(i)).Pgid(), since, ids)
+		}
+	case p.IsLeafPage():
+		for i := range p.LeafPageElements() {
+			elem := p.LeafPageElement(uint16(i))
+			if elem.IsBlobEntry() {
+				// The chunks of a streamed value are never rewritten.
+				tx.forEachBlobPage(elem.Value(), func(p *common.Page) {
+					if since == 0 || p.WrittenTxid(tx.db.pageSize, tx.db.pageChecksums) > since {
+						*ids = append(*ids, p.Id())
+					}
+				})
+			}
+			if !elem.IsBucketEntry() {
+				continue
+			}
+			// Inline buckets are stored within the leaf page.
+			if root := elem.Bucket().RootPage(); root != 0 {
+				tx.collectChangedPages(root, since, ids)
+			}
+		}
+	}
+}
+
+// diffWriter writes to w while counting bytes and computing a checksum.
+type diffWriter struct {
+	w io.Writer
+	h hash.Hash32
+	n int64
+}
+
+func (dw *diffWriter) write(b []byte) error {
+	n, err := dw.w.Write(b)
+	dw.n += int64(n)
+	_, _ = dw.h.Write(b[:n])
+	return err
+}
+
+// ApplyDiff applies a diff written by Tx.WriteDiffTo to the database file at
+// path, which must not be open. The file must be a copy of the database as of
+// a transaction between the base transaction of the diff and the transaction
+// which wrote it.
+//
+// The meta pages are only written once the whole diff has been read and
+// verified, but a failed ApplyDiff may still leave the file inconsistent, so
+// it should be applied to a copy of the backup.
+func ApplyDiff(path string, r io.Reader) error {
+	dr := newDiffReader(r)
+	hdr, err := dr.readHeader()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The meta pages can only be checked if the backup isn't encrypted.
+	if hdr.slotSize == int64(hdr.pageSize) {
+		if err := checkDiffBase(f, hdr.pageSize, hdr.since, hdr.txid); err != nil {
+			return err
+		}
+	}
+
+	meta, err := dr.readBody(f, hdr)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(meta, 0); err != nil {
+		return err
+	}
+	if err := f.Truncate(hdr.size()); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// diffHeader is the decoded header of a diff.
+type diffHeader struct {
+	pageSize int
+	slotSize int64
+	since    common.Txid
+	txid     common.Txid
+	pgid     uint64
+}
+
+// size returns the size of the data file after applying the diff.
+func (hdr diffHeader) size() int64 {
+	return int64(hdr.pgid) * hdr.slotSize
+}
+
+// diffReader reads a diff written by Tx.writeDiff while computing its
+// checksum.
+type diffReader struct {
+	r  io.Reader
+	tr io.Reader
+	h  hash.Hash32
+}
+
+func newDiffReader(r io.Reader) *diffReader {
+	h := crc32.New(walCRCTable)
+	return &diffReader{r: r, tr: io.TeeReader(r, h), h: h}
+}
+
+// readHeader reads and decodes the header of the diff.
+func (dr *diffReader) readHeader() (diffHeader, error) {
+	var hdr [diffHeaderSize]byte
+	if _, err := io.ReadFull(dr.tr, hdr[:]); err != nil {
+		return diffHeader{}, fmt.Errorf("read diff header: %w", err)
+	}
+	if binary.LittleEndian.Uint32(hdr[0:]) != diffMagic {
+		return diffHeader{}, berrors.ErrInvalid
+	} else if binary.LittleEndian.Uint32(hdr[4:]) != diffVersion {
+		return diffHeader{}, berrors.ErrVersionMismatch
+	}
+	return diffHeader{
+		pageSize: int(binary.LittleEndian.Uint32(hdr[8:])),
+		slotSize: int64(binary.LittleEndian.Uint32(hdr[12:])),
+		since:    common.Txid(binary.LittleEndian.Uint64(hdr[16:])),
+		txid:     common.Txid(binary.LittleEndian.Uint64(hdr[24:])),
+		pgid:     binary.LittleEndian.Uint64(hdr[32:]),
+	}, nil
+}
+
+// readBody writes the pages of the diff to w, verifies the checksum of the
+// diff, and returns its meta pages, which the caller writes once the pages
+// are applied.
+func (dr *diffReader) readBody(w io.WriterAt, hdr diffHeader) ([]byte, error) {
+	if err := readPageRuns(dr.tr, w, hdr.slotSize, hdr.pgid); err != nil {
+		return nil, err
+	}
+
+	meta := make([]byte, 2*hdr.slotSize)
+	if _, err := io.ReadFull(dr.tr, meta); err != nil {
+		return nil, fmt.Errorf("read diff meta: %w", err)
+	}
+	var trailer [4]byte
+	if _, err := io.ReadFull(dr.r, trailer[:]); err != nil {
+		return nil, fmt.Errorf("read diff: %w", err)
+	}
+	if binary.LittleEndian.Uint32(trailer[:]) != dr.h.Sum32() {
+		return nil, berrors.ErrChecksum
+	}
+	return meta, nil
+}
+
+// readPageRuns reads page runs written by writePageRuns from r, and writes
+// them to w in slots of slotSize bytes. Runs past the high water mark pgid
+// are rejected.
+func readPageRuns(r io.Reader, w io.WriterAt, slotSize int64, pgid uint64) error {
+	for {
+		var entry [diffEntrySize]byte
+		if _, err := io.ReadFull(r, entry[:]); err != nil {
+			return fmt.Errorf("read page run: %w", err)
+		}
+		id := binary.LittleEndian.Uint64(entry[0:])
+		count := int64(binary.LittleEndian.Uint32(entry[8:]))
+		if count == 0 {
+			return nil
+		}
+		if id+uint64(count) > pgid {
+			return fmt.Errorf("page %d: %w", id, berrors.ErrInvalid)
+		}
+		ow := io.NewOffsetWriter(w, int64(id)*slotSize)
+		if _, err := io.CopyN(ow, r, count*slotSize); err != nil {
+			return fmt.Errorf("apply page %d: %w", id, err)
+		}
+	}
+}
+
+// checkDiffBase verifies that the database in f is at a transaction between
+// since and txid, so that the diff can be applied to it.
+func checkDiffBase(f *os.File, pageSize int, since, txid common.Txid) error {
+	buf := make([]byte, 2*pageSize)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		return fmt.Errorf("read backup meta: %w", err)
+	}
+	var m *common.Meta
+	for i := 0; i < 2; i++ {
+		mi := common.LoadPageMeta(buf[i*pageSize:])
+		if mi.Validate() != nil {
+			continue
+		}
+		if m == nil || mi.Txid() > m.Txid() {
+			m = mi
+		}
+	}
+	if m == nil {
+		return berrors.ErrInvalid
+	}
+	if int(m.PageSize()) != pageSize || m.Txid() < since || m.Txid() > txid {
+		return berrors.ErrDiffBaseMismatch
+	}
+	return nil
+}
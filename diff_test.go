@@ -0,0 +1,152 @@
+package boltdb_test
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+// dumpDB returns all keys and values of the database, keyed by bucket path.
+func dumpDB(t *testing.T, db *bolt.DB) map[string]string {
+	m := make(map[string]string)
+	var walk func(prefix string, b *bolt.Bucket)
+	walk = func(prefix string, b *bolt.Bucket) {
+		m[prefix+"#seq"] = fmt.Sprint(b.Sequence())
+		err := b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				walk(prefix+"/"+string(k), b.Bucket(k))
+				return nil
+			}
+			m[prefix+"/"+string(k)] = string(v)
+			return nil
+		})
+		require.NoError(t, err)
+	}
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			walk(string(name), b)
+			return nil
+		})
+	})
+	require.NoError(t, err)
+	return m
+}
+
+func currentTxid(t *testing.T, db *bolt.DB) uint64 {
+	var txid int
+	err := db.View(func(tx *bolt.Tx) error {
+		txid = tx.ID()
+		return nil
+	})
+	require.NoError(t, err)
+	return uint64(txid)
+}
+
+func writeDiff(t *testing.T, db *bolt.DB, since uint64) []byte {
+	var buf bytes.Buffer
+	err := db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteDiffTo(&buf, since)
+		return err
+	})
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+func TestTx_WriteDiffTo(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		t.Run(fmt.Sprintf("encrypted=%v", encrypted), func(t *testing.T) {
+			o := &bolt.Options{PageTxids: true, PageChecksums: true}
+			if encrypted {
+				o.Encryption = mustAESGCMCipher(t, "0123456789abcdef")
+			}
+			db := btesting.MustCreateDBWithOption(t, o)
+			err := db.Fill([]byte("widgets"), 10, 1000, func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d%04d", tx, k)) },
+				func(tx int, k int) []byte { return make([]byte, 100) })
+			require.NoError(t, err)
+
+			// Take a full backup.
+			backup := filepath.Join(t.TempDir(), "backup.db")
+			err = db.View(func(tx *bolt.Tx) error {
+				return tx.CopyFile(backup, 0600)
+			})
+			require.NoError(t, err)
+			since := currentTxid(t, db.DB)
+
+			// Change a small part of the database.
+			err = db.Update(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				if err := b.Put([]byte("00050005"), []byte("changed")); err != nil {
+					return err
+				}
+				if err := b.Delete([]byte("00070007")); err != nil {
+					return err
+				}
+				child, err := b.CreateBucket([]byte("child"))
+				if err != nil {
+					return err
+				}
+				mustPutReader(t, child, "blob", largeValue(20000, 5))
+				_, err = child.NextSequence()
+				return err
+			})
+			require.NoError(t, err)
+
+			diff := writeDiff(t, db.DB, since)
+			require.Less(t, len(diff), int(fileSize(db.Path())/10))
+			require.NoError(t, bolt.ApplyDiff(backup, bytes.NewReader(diff)))
+
+			bdb := btesting.MustOpenDBWithOption(t, backup, &bolt.Options{Encryption: o.Encryption})
+			require.Equal(t, dumpDB(t, db.DB), dumpDB(t, bdb.DB))
+			bdb.MustCheck()
+			bdb.MustClose()
+
+			// Diffs can be chained, including across a compaction.
+			since = currentTxid(t, db.DB)
+			err = db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket([]byte("widgets")).Delete([]byte("00010001"))
+			})
+			require.NoError(t, err)
+			require.NoError(t, db.Compact())
+			require.NoError(t, bolt.ApplyDiff(backup, bytes.NewReader(writeDiff(t, db.DB, since))))
+
+			bdb = btesting.MustOpenDBWithOption(t, backup, &bolt.Options{Encryption: o.Encryption})
+			require.Equal(t, dumpDB(t, db.DB), dumpDB(t, bdb.DB))
+			bdb.MustCheck()
+		})
+	}
+}
+
+func TestApplyDiff_BaseMismatch(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageTxids: true})
+	putWidgets(t, db, 0, 10)
+
+	backup := filepath.Join(t.TempDir(), "backup.db")
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(backup, 0600)
+	})
+	require.NoError(t, err)
+
+	putWidgets(t, db, 10, 20)
+	since := currentTxid(t, db.DB)
+	putWidgets(t, db, 20, 30)
+
+	// The backup is older than the base of the diff.
+	err = bolt.ApplyDiff(backup, bytes.NewReader(writeDiff(t, db.DB, since)))
+	require.ErrorIs(t, err, berrors.ErrDiffBaseMismatch)
+}
+
+func TestTx_WriteDiffTo_Unsupported(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	err := db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteDiffTo(&bytes.Buffer{}, 0)
+		return err
+	})
+	require.ErrorIs(t, err, berrors.ErrDiffUnsupported)
+}
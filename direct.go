@@ -0,0 +1,81 @@
+package boltdb
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+// directIOAlign is the alignment of the page buffers written with direct
+// I/O, see Options.DirectIO. It's a multiple of the logical block size of
+// the usual devices.
+const directIOAlign = 4096
+
+// alignedBuffer returns a zeroed buffer of n bytes whose address is aligned
+// to directIOAlign.
+func alignedBuffer(n int) []byte {
+	buf := make([]byte, n+directIOAlign)
+	off := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) % directIOAlign); rem != 0 {
+		off = directIOAlign - rem
+	}
+	return buf[off : off+n : off+n]
+}
+
+// pageBuffer returns a buffer for n bytes of pages, which is aligned for
+// direct I/O if it's enabled.
+func (db *DB) pageBuffer(n int) []byte {
+	if db.directIO {
+		return alignedBuffer(n)
+	}
+	return make([]byte, n)
+}
+
+// directWriteAt writes b to the data file at offset off with direct I/O,
+// bypassing the page cache. The buffers which aren't aligned are copied,
+// and the writes whose offset or length isn't aligned to the logical block
+// size of the device go through the page cache.
+func (db *DB) directWriteAt(b []byte, off int64) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if uintptr(unsafe.Pointer(&b[0]))%directIOAlign != 0 {
+		buf := alignedBuffer(len(b))
+		copy(buf, b)
+		b = buf
+	}
+	n, err := db.directFile.WriteAt(b, off)
+	if errors.Is(err, syscall.EINVAL) {
+		return db.file.WriteAt(b, off)
+	}
+	return n, err
+}
+
+// setWriteAt sets the function which writes the pages to the data file.
+func (db *DB) setWriteAt() {
+	db.ops.writeAt = db.file.WriteAt
+	if db.directFile != nil {
+		db.ops.writeAt = db.directWriteAt
+	}
+	if db.cipher != nil {
+		db.ops.writeAt = db.encryptedWriteAt
+	}
+	if db.noMmap {
+		db.ops.writeAt = db.cachedWriteAt
+	}
+	// Only the plain writes are vectored.
+	db.ops.writev = nil
+	if db.directFile == nil && db.cipher == nil && !db.noMmap {
+		db.ops.writev = pwritev(db)
+	}
+}
+
+// closeDirect closes the descriptor of the data file used for direct I/O.
+func (db *DB) closeDirect() error {
+	if db.directFile == nil {
+		return nil
+	}
+	err := db.directFile.Close()
+	db.directFile = nil
+	return err
+}
@@ -0,0 +1,20 @@
+package boltdb
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// openDirect opens the data file again for the writes with direct I/O, see
+// Options.DirectIO.
+func (db *DB) openDirect() error {
+	if !db.directIO || db.readOnly || db.inMemory || db.cipher != nil {
+		return nil
+	}
+	f, err := db.openFile(db.path, syscall.O_WRONLY|syscall.O_DIRECT, 0)
+	if err != nil {
+		return fmt.Errorf("direct I/O: %w", err)
+	}
+	db.directFile = f
+	return nil
+}
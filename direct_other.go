@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package boltdb
+
+// openDirect does nothing, direct I/O is only supported on Linux, see
+// Options.DirectIO.
+func (db *DB) openDirect() error {
+	return nil
+}
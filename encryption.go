@@ -0,0 +1,267 @@
+package boltdb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// Cipher encrypts and decrypts database pages. Every page is encrypted on its
+// own, so that it can be written and read independently of its neighbours.
+//
+// An encrypted page occupies PageSize+Overhead() bytes in the data file.
+type Cipher interface {
+	// Overhead returns the number of bytes an encrypted page needs in
+	// addition to the page itself, e.g. for a nonce and an authentication tag.
+	Overhead() int
+
+	// Seal encrypts page and appends the result to dst. The page id must be
+	// bound to the ciphertext, so that pages can't be swapped on disk.
+	Seal(dst, page []byte, pgid uint64) []byte
+
+	// Open decrypts a page previously encrypted by Seal and appends the
+	// result to dst. It returns an error if the page fails authentication.
+	Open(dst, sealed []byte, pgid uint64) ([]byte, error)
+}
+
+// NewAESGCMCipher returns a Cipher which encrypts pages with AES-GCM using a
+// random nonce per write. The key must be 16, 24 or 32 bytes long.
+func NewAESGCMCipher(key []byte) (Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &aeadCipher{aead: aead}, nil
+}
+
+// aeadCipher stores a page as nonce || ciphertext || tag.
+type aeadCipher struct {
+	aead cipher.AEAD
+}
+
+func (c *aeadCipher) Overhead() int {
+	return c.aead.NonceSize() + c.aead.Overhead()
+}
+
+func (c *aeadCipher) Seal(dst, page []byte, pgid uint64) []byte {
+	var ad [8]byte
+	binary.LittleEndian.PutUint64(ad[:], pgid)
+
+	nonceSize := c.aead.NonceSize()
+	dst = append(dst, make([]byte, nonceSize)...)
+	nonce := dst[len(dst)-nonceSize:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		panic(fmt.Sprintf("aes-gcm: failed to generate nonce: %v", err))
+	}
+	return c.aead.Seal(dst, nonce, page, ad[:])
+}
+
+func (c *aeadCipher) Open(dst, sealed []byte, pgid uint64) ([]byte, error) {
+	var ad [8]byte
+	binary.LittleEndian.PutUint64(ad[:], pgid)
+
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, berrors.ErrDecrypt
+	}
+	out, err := c.aead.Open(dst, sealed[:nonceSize], sealed[nonceSize:], ad[:])
+	if err != nil {
+		return nil, berrors.ErrDecrypt
+	}
+	return out, nil
+}
+
+// encryptedPageSize returns the size of a page as stored in the data file.
+func (db *DB) encryptedPageSize() int {
+	return db.pageSize + db.cipher.Overhead()
+}
+
+// encryptedWriteAt encrypts the pages in b and writes them to the data file.
+// Both b and off must be aligned to the page size. The plaintext is also
+// copied into the in-memory image of the database, so that it's visible to
+// later transactions the same way writes are visible through the mmap.
+func (db *DB) encryptedWriteAt(b []byte, off int64) (int, error) {
+	if n, err := db.sealWriteAt(b, off); err != nil {
+		return n, err
+	}
+
+	if db.data != nil && off+int64(len(b)) <= int64(db.datasz) {
+		// Meta pages may be read concurrently by new transactions.
+		if off < int64(2*db.pageSize) {
+			db.metalock.Lock()
+			defer db.metalock.Unlock()
+		}
+		copy(db.data[off:], b)
+	}
+	return len(b), nil
+}
+
+// sealWriteAt encrypts the pages in b and writes them to their slots in the
+// data file. Both b and off must be aligned to the page size.
+func (db *DB) sealWriteAt(b []byte, off int64) (int, error) {
+	if off%int64(db.pageSize) != 0 || len(b)%db.pageSize != 0 {
+		return 0, fmt.Errorf("unaligned encrypted write: offset %d, size %d", off, len(b))
+	}
+
+	slot := db.encryptedPageSize()
+	sealed := make([]byte, 0, slot)
+	for i := 0; i < len(b); i += db.pageSize {
+		id := uint64(off)/uint64(db.pageSize) + uint64(i/db.pageSize)
+		sealed = db.cipher.Seal(sealed[:0], b[i:i+db.pageSize], id)
+		if _, err := db.file.WriteAt(sealed, int64(id)*int64(slot)); err != nil {
+			return i, err
+		}
+	}
+	return len(b), nil
+}
+
+// readSealedAt reads the pages at page id from their slots in the data file
+// and decrypts them into buf, which must be aligned to the page size. Pages
+// which have never been written are left zeroed, and so are the pages beyond
+// the end of the file.
+func (db *DB) readSealedAt(buf []byte, id common.Pgid) error {
+	slot := db.encryptedPageSize()
+	sealed := make([]byte, slot)
+	for i := 0; i < len(buf); i += db.pageSize {
+		pgid := uint64(id) + uint64(i/db.pageSize)
+		page := buf[i:i:min(i+db.pageSize, len(buf))]
+		n, err := db.file.ReadAt(sealed, int64(pgid)*int64(slot))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if n < slot || isZeroed(sealed) {
+			clear(page[:cap(page)])
+			continue
+		}
+		if _, err := db.cipher.Open(page, sealed, pgid); err != nil {
+			return fmt.Errorf("page %d: %w", pgid, err)
+		}
+	}
+	return nil
+}
+
+// mmapEncryptedProgressPages is the number of pages mmapEncrypted decrypts
+// between two reports to Options.OpenProgress.
+const mmapEncryptedProgressPages = 16384
+
+// mmapEncrypted loads and decrypts the data file into an in-memory image of
+// sz bytes, which takes the place of the mmap.
+func (db *DB) mmapEncrypted(sz int) error {
+	r, size, err := db.fileReader()
+	if err != nil {
+		return err
+	}
+	slot := db.encryptedPageSize()
+	n := int(size / int64(slot))
+	if n*db.pageSize > sz {
+		n = sz / db.pageSize
+	}
+
+	buf := make([]byte, sz)
+	sealed := make([]byte, slot)
+	for id := 0; id < n; id++ {
+		if id > 0 && id%mmapEncryptedProgressPages == 0 {
+			db.reportOpenProgress(OpenStageMmap, int64(id)*int64(db.pageSize), int64(n)*int64(db.pageSize))
+		}
+		if _, err := r.ReadAt(sealed, int64(id)*int64(slot)); err != nil {
+			return err
+		}
+		// Pages which have never been written are left zeroed.
+		if isZeroed(sealed) {
+			continue
+		}
+		page := buf[id*db.pageSize : id*db.pageSize : (id+1)*db.pageSize]
+		if _, err := db.cipher.Open(page, sealed, uint64(id)); err != nil {
+			return fmt.Errorf("page %d: %w", id, err)
+		}
+	}
+
+	db.data = (*[maxMapSize]byte)(unsafe.Pointer(&buf[0]))
+	db.datasz = sz
+	return nil
+}
+
+// readEncryptedMeta reads and decrypts the meta page with the given id,
+// trying all supported page sizes since the page size isn't known yet.
+func (db *DB) readEncryptedMeta(id int) (*common.Meta, error) {
+	r, _, err := db.fileReader()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i <= 14; i++ {
+		pageSize := 1024 << uint(i)
+		slot := pageSize + db.cipher.Overhead()
+		sealed := make([]byte, slot)
+		if _, err := r.ReadAt(sealed, int64(id)*int64(slot)); err != nil {
+			continue
+		}
+		page, err := db.cipher.Open(nil, sealed, uint64(id))
+		if err != nil {
+			continue
+		}
+		m := common.LoadPageMeta(page)
+		if m.Validate() == nil && int(m.PageSize()) == pageSize {
+			return m, nil
+		}
+	}
+	return nil, berrors.ErrInvalid
+}
+
+// getEncryptedPageSize reads the page size from either encrypted meta page.
+func (db *DB) getEncryptedPageSize() (int, error) {
+	for id := 0; id < 2; id++ {
+		if m, err := db.readEncryptedMeta(id); err == nil {
+			return int(m.PageSize()), nil
+		}
+	}
+	return 0, berrors.ErrInvalid
+}
+
+// writeEncryptedTo writes an encrypted copy of the database as seen by tx.
+// The meta pages are generated from page, which is backed by buf, and the
+// data pages are copied from f without decrypting them.
+func (tx *Tx) writeEncryptedTo(w *snapshotWriter, f dataFile, page *common.Page, buf []byte) (n int64, err error) {
+	db := tx.db
+	slot := db.encryptedPageSize()
+	for id := 0; id < 2; id++ {
+		page.SetId(common.Pgid(id))
+		if id == 1 {
+			page.Meta().DecTxid()
+		}
+		page.Meta().SetChecksum(page.Meta().Sum64())
+		nn, err := w.Write(db.cipher.Seal(nil, buf, uint64(id)))
+		n += int64(nn)
+		if err != nil {
+			return n, fmt.Errorf("meta %d copy: %s", id, err)
+		}
+	}
+
+	// Copy the encrypted data pages as they are.
+	if _, err := f.Seek(int64(slot*2), io.SeekStart); err != nil {
+		return n, fmt.Errorf("seek: %s", err)
+	}
+	wn, err := w.copyPages(f, (int64(tx.meta.Pgid())-2)*int64(slot), slot)
+	n += wn
+	return n, err
+}
+
+func isZeroed(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
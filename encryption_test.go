@@ -0,0 +1,219 @@
+package boltdb_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func mustAESGCMCipher(t *testing.T, key string) bolt.Cipher {
+	c, err := bolt.NewAESGCMCipher([]byte(key))
+	require.NoError(t, err)
+	return c
+}
+
+// putSecrets writes n keys with a recognizable value into the "secrets" bucket.
+func putSecrets(t *testing.T, db *btesting.DB, n int) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("secrets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%08d", i)), []byte("plaintext-value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func requireSecrets(t *testing.T, db *btesting.DB, n int) {
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("secrets"))
+		require.NotNil(t, b)
+		require.Equal(t, n, b.Stats().KeyN)
+		require.Equal(t, []byte("plaintext-value"), b.Get([]byte("00000000")))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestDB_Encryption(t *testing.T) {
+	key := "0123456789abcdef0123456789abcdef"
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{Encryption: mustAESGCMCipher(t, key)})
+
+	// Write enough data to force the database to grow and remap.
+	putSecrets(t, db, 10000)
+	requireSecrets(t, db, 10000)
+	db.MustCheck()
+	db.MustClose()
+
+	raw, err := os.ReadFile(db.Path())
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(raw, []byte("plaintext-value")))
+	require.False(t, bytes.Contains(raw, []byte("secrets")))
+
+	db.MustReopen()
+	requireSecrets(t, db, 10000)
+	db.MustClose()
+
+	// The database can't be opened without the key, or with a wrong one.
+	_, err = bolt.Open(db.Path(), 0600, nil)
+	require.ErrorIs(t, err, bolt.ErrInvalid)
+	_, err = bolt.Open(db.Path(), 0600, &bolt.Options{Encryption: mustAESGCMCipher(t, "fedcba9876543210")})
+	require.ErrorIs(t, err, bolt.ErrInvalid)
+}
+
+func TestDB_Encryption_NoGrowSync(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{
+		Encryption: mustAESGCMCipher(t, "0123456789abcdef"),
+		NoGrowSync: true,
+		PageSize:   1024,
+	})
+	putSecrets(t, db, 5000)
+	db.MustClose()
+	db.MustReopen()
+	requireSecrets(t, db, 5000)
+	db.MustCheck()
+}
+
+func TestTx_CopyFile_Encrypted(t *testing.T) {
+	c := mustAESGCMCipher(t, "0123456789abcdef")
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{Encryption: c})
+	putSecrets(t, db, 1000)
+
+	path := filepath.Join(t.TempDir(), "copy.db")
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	})
+	require.NoError(t, err)
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(raw, []byte("plaintext-value")))
+
+	cdb := btesting.MustOpenDBWithOption(t, path, &bolt.Options{Encryption: c})
+	requireSecrets(t, cdb, 1000)
+	cdb.MustCheck()
+}
+
+func TestDB_Compact_Encrypted(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{Encryption: mustAESGCMCipher(t, "0123456789abcdef")})
+	fillAndDelete(t, db, 4000, 100)
+	require.NoError(t, db.Compact())
+
+	err := db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, 40, tx.Bucket([]byte("widgets")).Stats().KeyN)
+		return nil
+	})
+	require.NoError(t, err)
+	db.MustClose()
+	db.MustReopen()
+	db.MustCheck()
+}
+
+func TestDB_Rekey(t *testing.T) {
+	oldCipher := mustAESGCMCipher(t, "0123456789abcdef")
+	newCipher := mustAESGCMCipher(t, "fedcba9876543210")
+
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{Encryption: oldCipher})
+	putSecrets(t, db, 1000)
+	require.NoError(t, db.Rekey(newCipher))
+
+	// The database stays usable with the new key.
+	requireSecrets(t, db, 1000)
+	putSecrets(t, db, 2000)
+	db.MustClose()
+
+	_, err := bolt.Open(db.Path(), 0600, &bolt.Options{Encryption: oldCipher})
+	require.ErrorIs(t, err, bolt.ErrInvalid)
+
+	db.SetOptions(&bolt.Options{Encryption: newCipher})
+	db.MustReopen()
+	requireSecrets(t, db, 2000)
+
+	// Rekeying with a nil cipher decrypts the database.
+	require.NoError(t, db.Rekey(nil))
+	db.MustClose()
+	db.SetOptions(nil)
+	db.MustReopen()
+	requireSecrets(t, db, 2000)
+	db.MustCheck()
+}
+
+// Ensure that the pages of an encrypted database read with pread are
+// decrypted on their own, so that a tampered page only fails when it's read.
+func TestDB_Encryption_NoMmap(t *testing.T) {
+	c := mustAESGCMCipher(t, "0123456789abcdef")
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{
+		Encryption:    c,
+		NoMmap:        true,
+		PageCacheSize: 64 * 1024,
+		PageSize:      4096,
+	})
+	putBigBucket(t, db, "widgets", 100)
+	putSecrets(t, db, 10000)
+	requireSecrets(t, db, 10000)
+	require.NoError(t, db.Compact())
+	db.MustClose()
+	db.MustReopen()
+	requireSecrets(t, db, 10000)
+	db.MustCheck()
+
+	// Find a leaf page of the secrets.
+	leaf := -1
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEachPage(int(tx.Cursor().Bucket().RootPage()), func(p *bolt.PageInfo) error {
+			if p.Type == "leaf" && len(p.Bucket) == 1 && string(p.Bucket[0]) == "secrets" {
+				leaf = p.ID
+			}
+			return nil
+		})
+	})
+	require.NoError(t, err)
+	require.Positive(t, leaf)
+	db.MustClose()
+
+	// Tamper with it.
+	f, err := os.OpenFile(db.Path(), os.O_RDWR, 0600)
+	require.NoError(t, err)
+	off := int64(leaf)*int64(4096+c.Overhead()) + 100
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, off)
+	require.NoError(t, err)
+	b[0] ^= 0xff
+	_, err = f.WriteAt(b, off)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// Decrypting the whole database fails.
+	_, err = bolt.Open(db.Path(), 0600, &bolt.Options{Encryption: c})
+	require.ErrorIs(t, err, berrors.ErrDecrypt)
+
+	// Only the tampered page fails when it's decrypted on its own.
+	db.SetOptions(&bolt.Options{Encryption: c, NoMmap: true, StrictErrors: true})
+	db.MustReopen()
+	err = db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, 100, tx.Bucket([]byte("widgets")).Stats().KeyN)
+		return nil
+	})
+	require.NoError(t, err)
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("secrets")).ForEach(func(k, v []byte) error { return nil })
+	})
+	require.ErrorIs(t, err, berrors.ErrCorrupted)
+	require.ErrorContains(t, err, berrors.ErrDecrypt.Error())
+
+	// Don't check the tampered database on cleanup.
+	db.MustClose()
+}
@@ -24,8 +24,50 @@ var (
 	// ErrChecksum is returned when either meta page checksum does not match.
 	ErrChecksum = errors.New("checksum error")
 
+	// ErrPageChecksum is returned when the checksum stored in a page does
+	// not match its content.
+	ErrPageChecksum = errors.New("page checksum mismatch")
+
+	// ErrDecrypt is returned when an encrypted page can't be decrypted,
+	// typically because the database was opened with the wrong key.
+	ErrDecrypt = errors.New("page decryption failed")
+
+	// ErrWALNotRecovered is returned when a database with a write-ahead log
+	// left by a crashed process is opened in read-only mode. The database
+	// must be opened in read-write mode once to replay the log.
+	ErrWALNotRecovered = errors.New("write-ahead log must be recovered in read-write mode")
+
+	// ErrDiffUnsupported is returned by Tx.WriteDiffTo when the database
+	// wasn't created with page txids.
+	ErrDiffUnsupported = errors.New("incremental backup requires page txids")
+
+	// ErrDiffBaseMismatch is returned by ApplyDiff when the backup isn't at
+	// a transaction the diff can be applied to.
+	ErrDiffBaseMismatch = errors.New("backup doesn't match the base of the diff")
+
+	// ErrInvalidPageSize is returned by DB.Migrate when the page size isn't a
+	// power of two in the supported range.
+	ErrInvalidPageSize = errors.New("invalid page size")
+
+	// ErrQuotaExceeded is returned when a write transaction needs to grow
+	// the database past Options.MaxSize.
+	ErrQuotaExceeded = errors.New("database size quota exceeded")
+
+	// ErrCorrupted is returned instead of a panic when a transaction runs
+	// into a corrupted page with Options.StrictErrors.
+	ErrCorrupted = errors.New("database is corrupted")
+
+	// ErrFreelistCorrupted is reported by Tx.Check when the freelist read
+	// by Open was corrupted and is ignored until DB.RepairFreelist.
+	ErrFreelistCorrupted = errors.New("freelist is corrupted")
+
+	// ErrInMemory is returned by operations which need a data file, such as
+	// DB.Compact, when the database is in memory.
+	ErrInMemory = errors.New("not supported by in-memory database")
+
 	// ErrTimeout is returned when a database cannot obtain an exclusive lock
-	// on the data file after the timeout passed to Open().
+	// on the data file after the timeout passed to Open(), or the locks of a
+	// transaction after the timeout passed to DB.BeginWithTimeout().
 	ErrTimeout = errors.New("timeout")
 )
 
@@ -46,6 +88,14 @@ var (
 	// ErrFreePagesNotLoaded is returned when a readonly transaction without
 	// preloading the free pages is trying to access the free pages.
 	ErrFreePagesNotLoaded = errors.New("free pages are not pre-loaded")
+
+	// ErrTxNotRetained is returned by DB.BeginAt when the pages of the
+	// requested transaction aren't retained, see Options.RetainTxs.
+	ErrTxNotRetained = errors.New("tx not retained")
+
+	// ErrSnapshotClosed is returned when using a snapshot which has been
+	// closed, explicitly or by compacting the database.
+	ErrSnapshotClosed = errors.New("snapshot closed")
 )
 
 // These errors can occur when putting or deleting a value or a bucket.
@@ -69,8 +119,57 @@ var (
 	// ErrValueTooLarge is returned when inserting a value that is larger than MaxValueSize.
 	ErrValueTooLarge = errors.New("value too large")
 
+	// ErrInvalidValueSize is returned when streaming a value of a negative
+	// size.
+	ErrInvalidValueSize = errors.New("value size must not be negative")
+
+	// ErrBucketMetaTooLarge is returned when setting bucket metadata that is
+	// larger than MaxBucketMetaSize.
+	ErrBucketMetaTooLarge = errors.New("bucket metadata too large")
+
+	// ErrCompressionDictTooLarge is returned when setting a compression
+	// dictionary that is larger than MaxCompressionDictSize.
+	ErrCompressionDictTooLarge = errors.New("compression dictionary too large")
+
 	// ErrIncompatibleValue is returned when trying create or delete a bucket
 	// on an existing non-bucket key or when trying to create or delete a
 	// non-bucket key on an existing bucket key.
 	ErrIncompatibleValue = errors.New("incompatible value")
+
+	// ErrKeysUnsorted is returned by Bucket.PutSorted when the keys aren't in
+	// strictly ascending order.
+	ErrKeysUnsorted = errors.New("keys must be in ascending order")
+
+	// ErrStopIteration can be returned by the function passed to
+	// Bucket.ForEachPrefix or Bucket.ForEachRange to stop the iteration
+	// without an error.
+	ErrStopIteration = errors.New("stop iteration")
+
+	// ErrSameDatabase is returned when copying a bucket to a transaction of
+	// the database it belongs to.
+	ErrSameDatabase = errors.New("source and destination are the same database")
+
+	// ErrIndexNotFound is returned when querying an index which isn't
+	// declared for the bucket.
+	ErrIndexNotFound = errors.New("index not found")
+
+	// ErrInvalidSequenceCount is returned when reserving a block of zero
+	// sequence values.
+	ErrInvalidSequenceCount = errors.New("sequence count must be positive")
+
+	// ErrSequenceOverflow is returned when reserving more sequence values
+	// than are left before the sequence overflows.
+	ErrSequenceOverflow = errors.New("sequence overflow")
+
+	// ErrInvalidTTL is returned when putting a value with a TTL which isn't
+	// positive.
+	ErrInvalidTTL = errors.New("ttl must be positive")
+
+	// ErrConflict is matched by the error Bucket.PutIf returns when the
+	// current value of the key isn't the expected one.
+	ErrConflict = errors.New("value conflict")
+
+	// ErrInvalidCursorToken is returned by Bucket.CursorAt when the token
+	// wasn't returned by Cursor.Token.
+	ErrInvalidCursorToken = errors.New("invalid cursor token")
 )
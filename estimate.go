@@ -0,0 +1,110 @@
+package boltdb
+
+import (
+	"bytes"
+	"math"
+)
+
+// EstimateCount estimates the number of keys in [start, end) without reading
+// the leaves, e.g. for query planning. The position of each bound is read
+// from the branches on the path to it, see position. The difference is
+// scaled by the number of keys of the bucket, or by an estimate of it from
+// the fan-out if it isn't counted.
+//
+// A nil start begins at the first key, and a nil end ends after the last
+// one. Like Count, the expired keys are counted. See CountRange for the
+// exact count.
+func (b *Bucket) EstimateCount(start, end []byte) int {
+	defer b.tx.catch(nil)
+	if start != nil && end != nil && bytes.Compare(start, end) >= 0 {
+		return 0
+	}
+	c := b.Cursor()
+	from, to := 0.0, 1.0
+	var fanout float64
+	if start != nil {
+		from, fanout = c.position(start)
+	}
+	if end != nil {
+		var n float64
+		to, n = c.position(end)
+		fanout = max(fanout, n)
+	}
+	if start == nil && end == nil {
+		_, fanout = c.position(nil)
+	}
+	total := fanout
+	if n, ok := b.ext.Count(); ok {
+		total = float64(n)
+	}
+	return int(math.Round(max(to-from, 0) * total))
+}
+
+// position returns the fraction of the keys of the bucket which are less
+// than key, and the number of keys the bucket would hold if all the pages
+// had the fan-out of the path to key. The subtrees of the branches above the
+// lowest level of branches are weighted by their number of elements, and
+// the leaves are assumed to hold as many keys, so that no leaf is read
+// besides the one of key.
+func (c *Cursor) position(key []byte) (float64, float64) {
+	c.seek(key)
+	pos, scale, total := 0.0, 1.0, 1.0
+	for i := range c.stack {
+		ref := &c.stack[i]
+		n := ref.count()
+		if n == 0 {
+			return pos, 0
+		}
+		total *= float64(n)
+		if i >= len(c.stack)-2 {
+			pos += scale * float64(ref.index) / float64(n)
+			scale /= float64(n)
+			continue
+		}
+		var before, w, all float64
+		for j := 0; j < n; j++ {
+			cw := float64(pageNodeCount(c.bucket.pageNode(ref.childPgid(j))))
+			if j < ref.index {
+				before += cw
+			} else if j == ref.index {
+				w = cw
+			}
+			all += cw
+		}
+		pos += scale * before / all
+		scale *= w / all
+	}
+	return pos, total
+}
+
+// CountRange returns the number of keys in [start, end). The leaves which
+// are entirely in the range are counted by their number of elements,
+// without reading their keys and values. A nil start begins at the first key
+// and a nil end ends after the last one. Like Count, the expired keys are
+// counted.
+func (b *Bucket) CountRange(start, end []byte) int {
+	defer b.tx.catch(nil)
+	c := b.Cursor()
+	var k []byte
+	if start == nil {
+		k, _, _ = c.first()
+	} else {
+		k, _, _ = c.seekFrom(start)
+	}
+	var n int
+	for k != nil {
+		ref := &c.stack[len(c.stack)-1]
+		if end == nil || bytes.Compare(ref.leafKey(ref.count()-1), end) < 0 {
+			n += ref.count() - ref.index
+			ref.index = ref.count() - 1
+			k, _, _ = c.next()
+			continue
+		}
+		// The range ends in this leaf.
+		for ; ref.index < ref.count() && bytes.Compare(ref.leafKey(ref.index), end) < 0; ref.index++ {
+			n++
+		}
+		break
+	}
+	return n
+}
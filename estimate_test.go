@@ -0,0 +1,76 @@
+package boltdb_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestBucket_EstimateCount(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	const n = 20000
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%06d", i)), []byte("value")); err != nil {
+				return err
+			}
+		}
+		small, err := tx.CreateBucket([]byte("small"))
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"a", "b", "c", "d"} {
+			if err := small.Put([]byte(k), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%06d", i)) }
+	check := func(t *testing.T, b *bolt.Bucket) {
+		for _, r := range []struct{ start, end []byte }{
+			{nil, nil},
+			{key(5000), key(15000)},
+			{nil, key(2000)},
+			{key(19000), nil},
+			{key(100), key(1100)},
+		} {
+			exact := b.CountRange(r.start, r.end)
+			estimate := b.EstimateCount(r.start, r.end)
+			require.InDelta(t, exact, estimate, float64(n)/50, "[%s, %s)", r.start, r.end)
+		}
+		require.Equal(t, n, b.CountRange(nil, nil))
+		require.Equal(t, 10000, b.CountRange(key(5000), key(15000)))
+		require.Equal(t, 1, b.CountRange(key(5000), key(5001)))
+		require.Equal(t, 1, b.CountRange([]byte("005000"), []byte("0050000")))
+		require.Zero(t, b.CountRange(key(5001), key(5000)))
+		require.Zero(t, b.EstimateCount(key(5001), key(5000)))
+		require.Zero(t, b.CountRange([]byte("a"), nil))
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		check(t, tx.Bucket([]byte("widgets")))
+		small := tx.Bucket([]byte("small"))
+		require.Equal(t, 2, small.CountRange([]byte("b"), []byte("d")))
+		require.Equal(t, 2, small.EstimateCount([]byte("b"), []byte("d")))
+		return nil
+	})
+	require.NoError(t, err)
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.NoError(t, b.Delete(key(10000)))
+		require.Equal(t, 9999, b.CountRange(key(5000), key(15000)))
+		require.InDelta(t, 9999, b.EstimateCount(key(5000), key(15000)), float64(n)/50)
+		return nil
+	})
+	require.NoError(t, err)
+}
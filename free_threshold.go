@@ -0,0 +1,27 @@
+package boltdb
+
+// checkFreePageThreshold returns the call of Options.OnFreePageThreshold if
+// the commit of tx brought the ratio of free and pending pages above
+// Options.FreePageThreshold, or nil. It must be called with the writer lock
+// held, before tx is closed, and the call made once the locks are released.
+func (db *DB) checkFreePageThreshold(tx *Tx) func() {
+	if db.freePageThreshold <= 0 || db.onFreePageThreshold == nil || db.freelist == nil {
+		return nil
+	}
+	total := tx.meta.Pgid()
+	if total == 0 {
+		return nil
+	}
+	free := db.freelist.free_count() + db.freelist.pending_count()
+	ratio := float64(free) / float64(total)
+	if ratio <= db.freePageThreshold {
+		db.aboveFreePageThreshold = false
+		return nil
+	}
+	if db.aboveFreePageThreshold {
+		return nil
+	}
+	db.aboveFreePageThreshold = true
+	fn, txid := db.onFreePageThreshold, int(tx.meta.Txid())
+	return func() { fn(txid, ratio) }
+}
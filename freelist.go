@@ -5,6 +5,7 @@ import (
 	"sort"
 	"unsafe"
 
+	"github.com/openkvlab/boltdb/errors"
 	"github.com/openkvlab/boltdb/internal/common"
 )
 
@@ -13,6 +14,16 @@ import (
 type txPending struct {
 	ids     []common.Pgid
 	alloctx []common.Txid // txids allocating the ids
+
+	// sorted is set once ids and alloctx are sorted by alloctx, see release.
+	sorted bool
+}
+
+func (txp *txPending) Len() int           { return len(txp.ids) }
+func (txp *txPending) Less(i, j int) bool { return txp.alloctx[i] < txp.alloctx[j] }
+func (txp *txPending) Swap(i, j int) {
+	txp.ids[i], txp.ids[j] = txp.ids[j], txp.ids[i]
+	txp.alloctx[i], txp.alloctx[j] = txp.alloctx[j], txp.alloctx[i]
 }
 
 // pidSet holds the set of starting pgids which have the same span size
@@ -21,30 +32,73 @@ type pidSet map[common.Pgid]struct{}
 // freelist represents a list of all pages that are available for allocation.
 // It also tracks pages that have been freed but are still in use by open transactions.
 type freelist struct {
-	ids            []common.Pgid                             // all free and available free page ids.
 	allocs         map[common.Pgid]common.Txid               // mapping of Txid that allocated a pgid.
 	pending        map[common.Txid]*txPending                // mapping of soon-to-be free page ids by tx.
 	cache          map[common.Pgid]struct{}                  // fast lookup of all free and pending page ids.
 	freemaps       map[uint64]pidSet                         // key is the size of continuous pages(span), value is a set which contains the starting pgids of same size
-	forwardMap     map[common.Pgid]uint64                    // key is start pgid, value is its span size
-	backwardMap    map[common.Pgid]uint64                    // key is end pgid, value is its span size
+	sizeClasses    [64]map[uint64]struct{}                   // the sizes of freemaps by size class, see spanClass
+	spanTree       *spanTree                                 // the spans of free pages ordered by start pgid
 	freePagesCount uint64                                    // count of free pages(hashmap version)
 	allocate       func(txid common.Txid, n int) common.Pgid // the freelist allocate func
 	free_count     func() int                                // the function which gives you free page number
 	mergeSpans     func(ids common.Pgids)                    // the mergeSpan func
 	getFreePageIDs func() []common.Pgid                      // get free pgids func
 	readIDs        func(pgids []common.Pgid)                 // readIDs func reads list of pages and init the freelist
+	trimTail       func(hwm common.Pgid) common.Pgid         // removes the free pages right below the high water mark and returns the new one
+
+	// spilled is the last transaction which wrote out dirty pages before
+	// commit, so it may free pages it allocated itself.
+	spilled common.Txid
+
+	// nextFit is where the next allocation looks for a span with
+	// AllocNextFit.
+	nextFit common.Pgid
+
+	// spans is set when the freelist page stores spans of free pages instead
+	// of page ids, see common.MetaFlagFreelistSpans. It's the format of the
+	// page read by read and reload, and written by write.
+	spans bool
+
+	// segments is set when the freelist is written as segments of page ids
+	// on pages of their own, see common.MetaFlagFreelistSegments. It's the
+	// format read by read and reload like spans.
+	segments bool
+
+	// segmentIDs is the number of page ids of each segment, see
+	// common.FreelistSegmentIDs, or 0 if the changed segments aren't tracked.
+	segmentIDs int
+
+	// segmentPages is the page of each segment of the freelist page read or
+	// written last, by index. dirtySegments are the segments whose page ids
+	// changed since, or all of them if allDirty is set.
+	segmentPages  map[uint64]common.Pgid
+	dirtySegments map[uint64]struct{}
+	allDirty      bool
+
+	// page returns a page of the database, to read the segments.
+	page func(id common.Pgid) *common.Page
+
+	// reuseDelay is the number of transactions which must be committed
+	// after the one which freed a page before it's released, see
+	// Options.ReuseDelay.
+	reuseDelay common.Txid
 }
 
 // newFreelist returns an empty, initialized freelist.
+//
+// The hashmap implementation is the only one. The freelist page only stores a
+// sorted list of page ids or of spans, see freelist.write, so the persisted
+// format doesn't depend on the in-memory representation.
 func newFreelist() *freelist {
 	f := &freelist{
-		allocs:      make(map[common.Pgid]common.Txid),
-		pending:     make(map[common.Txid]*txPending),
-		cache:       make(map[common.Pgid]struct{}),
-		freemaps:    make(map[uint64]pidSet),
-		forwardMap:  make(map[common.Pgid]uint64),
-		backwardMap: make(map[common.Pgid]uint64),
+		allocs:   make(map[common.Pgid]common.Txid),
+		pending:  make(map[common.Txid]*txPending),
+		cache:    make(map[common.Pgid]struct{}),
+		freemaps: make(map[uint64]pidSet),
+		spanTree: &spanTree{},
+
+		dirtySegments: make(map[uint64]struct{}),
+		allDirty:      true,
 	}
 
 	f.allocate = f.hashmapAllocate
@@ -52,18 +106,23 @@ func newFreelist() *freelist {
 	f.mergeSpans = f.hashmapMergeSpans
 	f.getFreePageIDs = f.hashmapGetFreePageIDs
 	f.readIDs = f.hashmapReadIDs
+	f.trimTail = f.hashmapTrimTail
 
 	return f
 }
 
-// size returns the size of the page after serialization.
+// size returns the size of the page after serialization. With spans, it's an
+// upper bound since adjacent spans may be merged.
 func (f *freelist) size() int {
-	n := f.count()
+	n, elem := f.count(), 1
+	if f.spans {
+		n, elem = f.spanTree.len()+f.pending_count(), 2
+	}
 	if n >= 0xFFFF {
 		// The first element will be used to store the count. See freelist.write.
-		n++
+		return int(common.PageHeaderSize) + (int(unsafe.Sizeof(common.Pgid(0))) * (elem*n + 1))
 	}
-	return int(common.PageHeaderSize) + (int(unsafe.Sizeof(common.Pgid(0))) * n)
+	return int(common.PageHeaderSize) + (int(unsafe.Sizeof(common.Pgid(0))) * elem * n)
 }
 
 // count returns count of pages on the freelist
@@ -80,6 +139,22 @@ func (f *freelist) pending_count() int {
 	return count
 }
 
+// spanStats returns the fragmentation of the free pages, excluding the
+// pending ones.
+func (f *freelist) spanStats() FreelistStats {
+	var s FreelistStats
+	f.spanTree.ascend(func(_ common.Pgid, size uint64) bool {
+		s.SpanN++
+		s.MaxSpan = max(s.MaxSpan, int(size))
+		return true
+	})
+	if n := f.free_count(); n > 0 {
+		s.AvgSpan = float64(n) / float64(s.SpanN)
+		s.Fragmentation = 1 - float64(s.MaxSpan)/float64(n)
+	}
+	return s
+}
+
 // copyall copies a list of all free ids and all pending ids in one sorted list.
 // f.count returns the minimum length required for dst.
 func (f *freelist) copyall(dst []common.Pgid) {
@@ -91,6 +166,37 @@ func (f *freelist) copyall(dst []common.Pgid) {
 	common.Mergepgids(dst, f.getFreePageIDs(), m)
 }
 
+// copySpans returns the spans of contiguous free and pending pages in
+// ascending order, as pairs of the first page id and the number of pages.
+func (f *freelist) copySpans() []common.Pgid {
+	m := make(common.Pgids, 0, f.pending_count())
+	for _, txp := range f.pending {
+		m = append(m, txp.ids...)
+	}
+	sort.Sort(m)
+
+	var spans []common.Pgid
+	add := func(start, n common.Pgid) {
+		if l := len(spans); l > 0 && spans[l-2]+spans[l-1] == start {
+			spans[l-1] += n
+			return
+		}
+		spans = append(spans, start, n)
+	}
+	j := 0
+	f.spanTree.ascend(func(start common.Pgid, size uint64) bool {
+		for ; j < len(m) && m[j] < start; j++ {
+			add(m[j], 1)
+		}
+		add(start, common.Pgid(size))
+		return true
+	})
+	for ; j < len(m); j++ {
+		add(m[j], 1)
+	}
+	return spans
+}
+
 // `free` initially releases a page and its overflow for a given transaction id.
 // If the page is already free then a panic will occur.
 func (f *freelist) free(txid common.Txid, p *common.Page) {
@@ -106,13 +212,14 @@ func (f *freelist) free(txid common.Txid, p *common.Page) {
 	}
 	allocTxid, ok := f.allocs[p.Id()]
 	common.Verify(func() {
-		if allocTxid == txid {
+		if allocTxid == txid && f.spilled != txid {
 			panic(fmt.Sprintf("free: freed page (%d) was allocated by the same transaction (%d)", p.Id(), txid))
 		}
 	})
 	if ok {
 		delete(f.allocs, p.Id())
 	}
+	f.touch(p.Id(), int(p.Overflow())+1)
 
 	for id := p.Id(); id <= p.Id()+common.Pgid(p.Overflow()); id++ {
 		// Verify that page is not already free.
@@ -124,34 +231,43 @@ func (f *freelist) free(txid common.Txid, p *common.Page) {
 		txp.alloctx = append(txp.alloctx, allocTxid)
 		f.cache[id] = struct{}{}
 	}
+	txp.sorted = false
 }
 
 // `release` completely releases any pages associated with closed read-only transactions.
-func (f *freelist) release(rtxids []common.Txid) {
+// The pages freed by the last reuseDelay transactions committed before txid,
+// the write transaction reusing them, are kept pending.
+func (f *freelist) release(txid common.Txid, rtxids []common.Txid) {
+	sorted := make([]common.Txid, len(rtxids))
+	copy(sorted, rtxids)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
 	var m common.Pgids
 	for ftxid, txp := range f.pending {
-		for i := 0; i < len(txp.ids); i++ {
-			atxid := txp.alloctx[i]
-
-			safe2Release := true
-			for _, rtxid := range rtxids {
-				// If a free page is visible to any readonly TXN, then we
-				// can't completely release the page.
-				if atxid <= rtxid && rtxid < ftxid {
-					safe2Release = false
-					break
-				}
-			}
+		if ftxid+f.reuseDelay >= txid {
+			continue
+		}
 
-			if safe2Release {
-				m = append(m, txp.ids[i])
-				txp.ids[i] = txp.ids[len(txp.ids)-1]
-				txp.ids = txp.ids[:len(txp.ids)-1]
-				txp.alloctx[i] = txp.alloctx[len(txp.alloctx)-1]
-				txp.alloctx = txp.alloctx[:len(txp.alloctx)-1]
-				i--
-			}
+		// A page freed by ftxid is visible to the readonly TXNs from the
+		// one which allocated it up to ftxid excluded, so it's released
+		// unless the latest of them before ftxid is at or after atxid.
+		i := sort.Search(len(sorted), func(i int) bool { return sorted[i] >= ftxid })
+		if i == 0 {
+			m = append(m, txp.ids...)
+			delete(f.pending, ftxid)
+			continue
 		}
+		rtxid := sorted[i-1]
+
+		// The pages are sorted by atxid, so the released ones come last.
+		if !txp.sorted {
+			sort.Sort(txp)
+			txp.sorted = true
+		}
+		n := sort.Search(len(txp.alloctx), func(i int) bool { return txp.alloctx[i] > rtxid })
+		m = append(m, txp.ids[n:]...)
+		txp.ids = txp.ids[:n]
+		txp.alloctx = txp.alloctx[:n]
 		if len(txp.ids) == 0 {
 			delete(f.pending, ftxid)
 		}
@@ -160,6 +276,22 @@ func (f *freelist) release(rtxids []common.Txid) {
 	f.mergeSpans(m)
 }
 
+// addFree adds pages to the free pages, except those which are already free
+// or pending.
+func (f *freelist) addFree(ids []common.Pgid) {
+	var m common.Pgids
+	for _, id := range ids {
+		if !f.freed(id) {
+			m = append(m, id)
+		}
+	}
+	f.mergeSpans(m)
+	for _, id := range m {
+		f.cache[id] = struct{}{}
+		f.touch(id, 1)
+	}
+}
+
 // rollback removes the pages from a given pending tx.
 func (f *freelist) rollback(txid common.Txid) {
 	// Remove page ids from cache.
@@ -169,6 +301,7 @@ func (f *freelist) rollback(txid common.Txid) {
 	}
 	for i, pgid := range txp.ids {
 		delete(f.cache, pgid)
+		f.touch(pgid, 1)
 		tx := txp.alloctx[i]
 		if tx == 0 {
 			continue
@@ -176,8 +309,9 @@ func (f *freelist) rollback(txid common.Txid) {
 		if tx != txid {
 			// Pending free aborted; restore page back to alloc list.
 			f.allocs[pgid] = tx
-		} else {
-			// A writing TXN should never free a page which was allocated by itself.
+		} else if f.spilled != txid {
+			// A writing TXN should never free a page which was allocated by
+			// itself, unless it wrote it out before commit.
 			panic(fmt.Sprintf("rollback: freed page (%d) was allocated by the same transaction (%d)", pgid, txid))
 		}
 	}
@@ -193,24 +327,56 @@ func (f *freelist) freed(pgId common.Pgid) bool {
 
 // read initializes the freelist from a freelist page.
 func (f *freelist) read(p *common.Page) {
+	f.readIDs(f.pageIDs(p))
+	f.markClean()
+}
+
+// pageIDs returns a sorted copy of the page ids of a freelist page.
+func (f *freelist) pageIDs(p *common.Page) []common.Pgid {
 	if !p.IsFreelistPage() {
 		panic(fmt.Sprintf("invalid freelist page: %d, page type is %s", p.Id(), p.Typ()))
 	}
 
-	ids := p.FreelistPageIds()
-
-	// Copy the list of page ids from the freelist.
-	if len(ids) == 0 {
-		f.ids = nil
+	var ids []common.Pgid
+	if f.segments {
+		ids = f.segmentPageIDs(p)
+	} else if f.spans {
+		// Expand the spans, readIDs merges them again.
+		spans := p.FreelistPagePairs()
+		for i := 0; i < len(spans); i += 2 {
+			for id := spans[i]; id < spans[i]+spans[i+1]; id++ {
+				ids = append(ids, id)
+			}
+		}
 	} else {
 		// copy the ids, so we don't modify on the freelist page directly
-		idsCopy := make([]common.Pgid, len(ids))
-		copy(idsCopy, ids)
-		// Make sure they're sorted.
-		sort.Sort(common.Pgids(idsCopy))
+		ids = append([]common.Pgid(nil), p.FreelistPageIds()...)
+		f.segmentPages = nil
+	}
+
+	// Make sure they're sorted.
+	sort.Sort(common.Pgids(ids))
+	return ids
+}
 
-		f.readIDs(idsCopy)
+// validateFreeIDs checks the sorted page ids of the freelist pages of a
+// database whose high water mark is hwm: they must be unique, and neither
+// meta pages, nor pages of the freelist, nor above hwm.
+func validateFreeIDs(ids []common.Pgid, pages []*common.Page, hwm common.Pgid) error {
+	for i, id := range ids {
+		switch {
+		case id < 2 || id >= hwm:
+			return fmt.Errorf("%w: page %d is out of bounds", errors.ErrFreelistCorrupted, id)
+		case i > 0 && id == ids[i-1]:
+			return fmt.Errorf("%w: page %d is free twice", errors.ErrFreelistCorrupted, id)
+		}
+		for _, p := range pages {
+			if id >= p.Id() && id <= p.Id()+common.Pgid(p.Overflow()) {
+				return fmt.Errorf("%w: page %d of the freelist is free", errors.ErrFreelistCorrupted, id)
+			}
+		}
 	}
+	return nil
 }
 
 // write writes the page ids onto a freelist page. All free and pending ids are
@@ -222,6 +388,11 @@ func (f *freelist) write(p *common.Page) error {
 	// Update the header flag.
 	p.SetFlags(common.FreelistPageFlag)
 
+	if f.spans {
+		f.writeSpans(p)
+		return nil
+	}
+
 	// The page.count can only hold up to 64k elements so if we overflow that
 	// number then we handle it by putting the size in the first element.
 	l := f.count()
@@ -243,6 +414,28 @@ func (f *freelist) write(p *common.Page) error {
 	return nil
 }
 
+// writeSpans writes the spans of free and pending pages onto a freelist page,
+// like write. The count of the page is the number of spans.
+func (f *freelist) writeSpans(p *common.Page) {
+	writePairs(p, f.copySpans())
+}
+
+// writePairs writes pairs of page ids onto a freelist page. The count of the
+// page is the number of pairs.
+func writePairs(p *common.Page, spans []common.Pgid) {
+	l := len(spans) / 2
+	data := common.UnsafeAdd(unsafe.Pointer(p), unsafe.Sizeof(*p))
+	if l < 0xFFFF {
+		p.SetCount(uint16(l))
+		copy(unsafe.Slice((*common.Pgid)(data), len(spans)), spans)
+	} else {
+		p.SetCount(0xFFFF)
+		ids := unsafe.Slice((*common.Pgid)(data), len(spans)+1)
+		ids[0] = common.Pgid(l)
+		copy(ids[1:], spans)
+	}
+}
+
 // reload reads the freelist from a page and filters out pending items.
 func (f *freelist) reload(p *common.Page) {
 	f.read(p)
@@ -265,6 +458,7 @@ func (f *freelist) reload(p *common.Page) {
 	}
 
 	f.readIDs(a)
+	f.markClean()
 }
 
 // noSyncReload reads the freelist from Pgids and filters out pending items.
@@ -287,6 +481,7 @@ func (f *freelist) noSyncReload(Pgids []common.Pgid) {
 	}
 
 	f.readIDs(a)
+	f.allDirty = true
 }
 
 // reindex rebuilds the free cache based on available and pending free lists.
@@ -1,6 +1,7 @@
 package boltdb
 
 import (
+	"math/bits"
 	"sort"
 
 	"github.com/openkvlab/boltdb/internal/common"
@@ -18,9 +19,10 @@ func (f *freelist) hashmapFreeCount() int {
 
 func (f *freelist) hashmapFreeCountSlow() int {
 	count := 0
-	for _, size := range f.forwardMap {
+	f.spanTree.ascend(func(_ common.Pgid, size uint64) bool {
 		count += int(size)
-	}
+		return true
+	})
 	return count
 }
 
@@ -35,6 +37,7 @@ func (f *freelist) hashmapAllocate(txid common.Txid, n int) common.Pgid {
 		for pid := range bm {
 			// remove the span
 			f.delSpan(pid, uint64(n))
+			f.touch(pid, n)
 
 			f.allocs[pid] = txid
 
@@ -45,15 +48,13 @@ func (f *freelist) hashmapAllocate(txid common.Txid, n int) common.Pgid {
 		}
 	}
 
-	// lookup the map to find larger span
-	for size, bm := range f.freemaps {
-		if size < uint64(n) {
-			continue
-		}
-
-		for pid := range bm {
+	// split one of the smallest larger spans, so that small allocations
+	// don't fragment the large spans which overflow chains need
+	if size := f.smallestFit(uint64(n)); size != 0 {
+		for pid := range f.freemaps[size] {
 			// remove the initial
 			f.delSpan(pid, size)
+			f.touch(pid, n)
 
 			f.allocs[pid] = txid
 
@@ -88,52 +89,65 @@ func (f *freelist) hashmapGetFreePageIDs() []common.Pgid {
 	}
 
 	m := make([]common.Pgid, 0, count)
-
-	startPageIds := make([]common.Pgid, 0, len(f.forwardMap))
-	for k := range f.forwardMap {
-		startPageIds = append(startPageIds, k)
-	}
-	sort.Sort(common.Pgids(startPageIds))
-
-	for _, start := range startPageIds {
-		if size, ok := f.forwardMap[start]; ok {
-			for i := 0; i < int(size); i++ {
-				m = append(m, start+common.Pgid(i))
-			}
+	f.spanTree.ascend(func(start common.Pgid, size uint64) bool {
+		for i := 0; i < int(size); i++ {
+			m = append(m, start+common.Pgid(i))
 		}
-	}
+		return true
+	})
 
 	return m
 }
 
-// hashmapMergeSpans try to merge list of pages(represented by pgids) with existing spans
+// hashmapTrimTail removes the free span which ends right below the high water
+// mark hwm, if any, and returns the start of the span as the new high water mark.
+func (f *freelist) hashmapTrimTail(hwm common.Pgid) common.Pgid {
+	start, size, ok := f.spanTree.floor(hwm - 1)
+	if !ok || start+common.Pgid(size) != hwm {
+		return hwm
+	}
+	f.delSpan(start, size)
+	f.touch(start, int(size))
+	for id := start; id < hwm; id++ {
+		delete(f.cache, id)
+	}
+	return start
+}
+
+// hashmapMergeSpans try to merge list of pages(represented by pgids) with existing spans.
+// The ids are sorted in place, so that each run of contiguous ids is merged at once.
 func (f *freelist) hashmapMergeSpans(ids common.Pgids) {
-	for _, id := range ids {
-		// try to see if we can merge and update
-		f.mergeWithExistingSpan(id)
+	sort.Sort(ids)
+	for i := 0; i < len(ids); {
+		j := i + 1
+		for j < len(ids) && ids[j] == ids[j-1]+1 {
+			j++
+		}
+		f.mergeRange(ids[i], uint64(j-i))
+		i = j
 	}
 }
 
 // mergeWithExistingSpan merges pid to the existing free spans, try to merge it backward and forward
 func (f *freelist) mergeWithExistingSpan(pid common.Pgid) {
-	prev := pid - 1
-	next := pid + 1
+	f.mergeRange(pid, 1)
+}
 
-	preSize, mergeWithPrev := f.backwardMap[prev]
-	nextSize, mergeWithNext := f.forwardMap[next]
+// mergeRange merges the size free pages at pid to the existing free spans, backward and forward.
+func (f *freelist) mergeRange(pid common.Pgid, size uint64) {
+	next := pid + common.Pgid(size)
 	newStart := pid
-	newSize := uint64(1)
+	newSize := size
 
-	if mergeWithPrev {
+	if prevStart, preSize, ok := f.spanTree.floor(pid - 1); ok && prevStart+common.Pgid(preSize) == pid {
 		//merge with previous span
-		start := prev + 1 - common.Pgid(preSize)
-		f.delSpan(start, preSize)
+		f.delSpan(prevStart, preSize)
 
-		newStart -= common.Pgid(preSize)
+		newStart = prevStart
 		newSize += preSize
 	}
 
-	if mergeWithNext {
+	if nextSize, ok := f.spanTree.get(next); ok {
 		// merge with next span
 		f.delSpan(next, nextSize)
 		newSize += nextSize
@@ -143,10 +157,14 @@ func (f *freelist) mergeWithExistingSpan(pid common.Pgid) {
 }
 
 func (f *freelist) addSpan(start common.Pgid, size uint64) {
-	f.backwardMap[start-1+common.Pgid(size)] = size
-	f.forwardMap[start] = size
+	f.spanTree.insert(start, size)
 	if _, ok := f.freemaps[size]; !ok {
 		f.freemaps[size] = make(map[common.Pgid]struct{})
+		c := spanClass(size)
+		if f.sizeClasses[c] == nil {
+			f.sizeClasses[c] = make(map[uint64]struct{})
+		}
+		f.sizeClasses[c][size] = struct{}{}
 	}
 
 	f.freemaps[size][start] = struct{}{}
@@ -154,11 +172,11 @@ func (f *freelist) addSpan(start common.Pgid, size uint64) {
 }
 
 func (f *freelist) delSpan(start common.Pgid, size uint64) {
-	delete(f.forwardMap, start)
-	delete(f.backwardMap, start+common.Pgid(size-1))
+	f.spanTree.delete(start)
 	delete(f.freemaps[size], start)
 	if len(f.freemaps[size]) == 0 {
 		delete(f.freemaps, size)
+		delete(f.sizeClasses[spanClass(size)], size)
 	}
 	f.freePagesCount -= size
 }
@@ -166,23 +184,22 @@ func (f *freelist) delSpan(start common.Pgid, size uint64) {
 // initial from pgids using when use hashmap version
 // pgids must be sorted
 func (f *freelist) init(pgids []common.Pgid) {
+	// reset the counter when freelist init
+	f.freePagesCount = 0
+	f.freemaps = make(map[uint64]pidSet)
+	f.sizeClasses = [64]map[uint64]struct{}{}
+	f.spanTree = &spanTree{}
 	if len(pgids) == 0 {
 		return
 	}
 
 	size := uint64(1)
 	start := pgids[0]
-	// reset the counter when freelist init
-	f.freePagesCount = 0
 
 	if !sort.SliceIsSorted([]common.Pgid(pgids), func(i, j int) bool { return pgids[i] < pgids[j] }) {
 		panic("pgids not sorted")
 	}
 
-	f.freemaps = make(map[uint64]pidSet)
-	f.forwardMap = make(map[common.Pgid]uint64)
-	f.backwardMap = make(map[common.Pgid]uint64)
-
 	for i := 1; i < len(pgids); i++ {
 		// continuous page
 		if pgids[i] == pgids[i-1]+1 {
@@ -200,3 +217,28 @@ func (f *freelist) init(pgids []common.Pgid) {
 		f.addSpan(start, size)
 	}
 }
+
+// spanClass returns the size class of the spans of size pages, the spans of
+// class i having from 2^i to 2^(i+1)-1 pages.
+func spanClass(size uint64) int {
+	return bits.Len64(size) - 1
+}
+
+// smallestFit returns the smallest size of the free spans which is at least
+// n, or 0 if all the spans are smaller. Only the sizes of the size class of n
+// and of the first larger class with spans are compared, instead of all the
+// sizes of the spans.
+func (f *freelist) smallestFit(n uint64) uint64 {
+	for c := spanClass(n); c < len(f.sizeClasses); c++ {
+		var best uint64
+		for size := range f.sizeClasses[c] {
+			if size >= n && (best == 0 || size < best) {
+				best = size
+			}
+		}
+		if best != 0 {
+			return best
+		}
+	}
+	return 0
+}
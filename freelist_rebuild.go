@@ -0,0 +1,96 @@
+package boltdb
+
+import (
+	"context"
+
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// freelistRebuild reconstructs the free pages in the background when a
+// database which doesn't sync its freelist is opened for writing, so that
+// Open returns and transactions begin before the whole tree is scanned. The
+// write transactions only wait for it when they allocate a page, see
+// DB.installFreelist.
+type freelistRebuild struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// ids are the free pages, or err why they couldn't be reconstructed,
+	// once done is closed.
+	ids []common.Pgid
+	err error
+}
+
+// startFreelistRebuild starts reconstructing the free pages with an empty
+// freelist.
+func (db *DB) startFreelistRebuild() {
+	db.freelist = db.newFreelist()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &freelistRebuild{cancel: cancel, done: make(chan struct{})}
+	db.freelistRebuild.Store(r)
+	go func() {
+		defer close(r.done)
+		tx, err := db.beginTx(ctx)
+		if err != nil {
+			r.err = err
+			return
+		}
+		defer func() { _ = tx.Rollback() }()
+		r.ids, r.err = db.unreachablePages(ctx, tx)
+	}()
+}
+
+// stopFreelistRebuild cancels the reconstruction of the free pages, waits
+// for it to stop and discards it.
+func (db *DB) stopFreelistRebuild() {
+	if r := db.freelistRebuild.Load(); r != nil {
+		r.cancel()
+		<-r.done
+		db.statlock.Lock()
+		db.freelistRebuild.Store(nil)
+		db.statlock.Unlock()
+	}
+}
+
+// installFreelist waits for the free pages reconstructed in the background
+// and adds them to the freelist, or reconstructs them again if it failed.
+// The free pages released since Open are kept. The caller must hold the
+// writer lock.
+func (db *DB) installFreelist() {
+	r := db.waitFreelistRebuild()
+	if r == nil {
+		return
+	}
+	if r.err != nil {
+		db.freelist.noSyncReload(db.freepages())
+	} else {
+		db.freelist.addFree(r.ids)
+	}
+
+	db.statlock.Lock()
+	db.freelistRebuild.Store(nil)
+	db.stats.FreePageN = db.freelist.free_count()
+	db.stats.Freelist = db.freelist.spanStats()
+	db.statlock.Unlock()
+}
+
+// waitFreelistRebuild waits for the free pages reconstructed in the
+// background, if any, and returns their reconstruction.
+func (db *DB) waitFreelistRebuild() *freelistRebuild {
+	r := db.freelistRebuild.Load()
+	if r != nil {
+		<-r.done
+	}
+	return r
+}
+
+// rebuiltFreePageN returns how many of the free pages reconstructed in the
+// background aren't in the freelist yet. The caller must hold the stat lock
+// and have waited for them.
+func (db *DB) rebuiltFreePageN() int {
+	if r := db.freelistRebuild.Load(); r != nil {
+		return len(r.ids)
+	}
+	return 0
+}
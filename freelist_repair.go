@@ -0,0 +1,48 @@
+package boltdb
+
+import (
+	"fmt"
+
+	"github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// verifyFreelist checks that none of the free pages read by Open is
+// reachable from the tree, see Options.VerifyFreelist. A reachable free page
+// would be overwritten once it's reused, so the freelist is then ignored.
+func (db *DB) verifyFreelist() {
+	if db.freelistErr.Load() != nil || !db.hasSyncedFreelist() {
+		return
+	}
+	unreachable := make(map[common.Pgid]struct{})
+	for _, id := range db.freepages() {
+		unreachable[id] = struct{}{}
+	}
+	for _, id := range db.freelist.getFreePageIDs() {
+		if _, ok := unreachable[id]; !ok {
+			err := fmt.Errorf("%w: page %d is free and in use", errors.ErrFreelistCorrupted, id)
+			db.freelistErr.Store(&err)
+			// The pages of the segments are still freed by the next commit.
+			segments := db.freelist.segmentPages
+			db.freelist = db.newFreelist()
+			db.freelist.segmentPages = segments
+			db.stats.FreePageN = 0
+			db.stats.Freelist = FreelistStats{}
+			return
+		}
+	}
+}
+
+// RepairFreelist rebuilds the free pages from the pages which aren't
+// reachable from the tree, and writes them out in a write transaction. It
+// repairs a corrupted freelist detected by Open, see Options.VerifyFreelist,
+// instead of a panic when a later commit frees a page twice. The pages freed
+// by the transactions which may still be read are kept pending.
+func (db *DB) RepairFreelist() error {
+	return db.Update(func(tx *Tx) error {
+		db.installFreelist()
+		db.freelist.noSyncReload(db.freepages())
+		db.freelistErr.Store(nil)
+		return nil
+	})
+}
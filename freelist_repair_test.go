@@ -0,0 +1,123 @@
+package boltdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+func TestDB_RepairFreelist(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		corrupt  func(ids []common.Pgid, root common.Pgid)
+		verify   bool
+		segments bool
+	}{
+		{name: "duplicate", corrupt: func(ids []common.Pgid, _ common.Pgid) { ids[1] = ids[0] }},
+		{name: "out of bounds", corrupt: func(ids []common.Pgid, _ common.Pgid) { ids[len(ids)-1] = 1 << 40 }},
+		{name: "in use", corrupt: func(ids []common.Pgid, root common.Pgid) { ids[0] = root }, verify: true},
+		{name: "segment in use", corrupt: func(ids []common.Pgid, root common.Pgid) { ids[0] = root }, verify: true, segments: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			o := &Options{FreelistSegments: tc.segments}
+			path := mustCreateFreePages(t, o)
+
+			// Corrupt the ids of the freelist page, or of its first segment.
+			db, err := Open(path, 0600, &Options{ReadOnly: true})
+			require.NoError(t, err)
+			freelist, root := db.meta().Freelist(), db.meta().RootBucket().RootPage()
+			if tc.segments {
+				freelist = db.page(freelist).FreelistPagePairs()[1]
+			}
+			require.NoError(t, db.Close())
+			f, err := os.OpenFile(path, os.O_RDWR, 0)
+			require.NoError(t, err)
+			buf := make([]byte, db.pageSize)
+			_, err = f.ReadAt(buf, int64(freelist)*int64(db.pageSize))
+			require.NoError(t, err)
+			p := (*common.Page)(unsafe.Pointer(&buf[0]))
+			ids := p.FreelistPageIds()
+			require.Greater(t, len(ids), 2)
+			tc.corrupt(ids, root)
+			_, err = f.WriteAt(buf, int64(freelist)*int64(db.pageSize))
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			// The freelist is ignored, and the writes don't reuse its pages.
+			db, err = Open(path, 0600, &Options{VerifyFreelist: tc.verify, FreelistSegments: tc.segments})
+			require.NoError(t, err)
+			defer db.Close()
+			require.Zero(t, db.freelist.free_count())
+			require.ErrorIs(t, checkErr(db), errors.ErrFreelistCorrupted)
+			require.NoError(t, db.Update(func(tx *Tx) error {
+				return tx.Bucket([]byte("001")).Put([]byte("key2"), []byte("value"))
+			}))
+
+			require.NoError(t, db.RepairFreelist())
+			require.NotZero(t, db.freelist.free_count())
+			require.NoError(t, checkErr(db))
+			require.NoError(t, db.Close())
+
+			db, err = Open(path, 0600, &Options{VerifyFreelist: true, FreelistSegments: tc.segments})
+			require.NoError(t, err)
+			require.NoError(t, checkErr(db))
+		})
+	}
+}
+
+// mustCreateFreePages creates a database with free pages with the options o
+// and returns its path.
+func mustCreateFreePages(t *testing.T, o *Options) string {
+	path := filepath.Join(t.TempDir(), "db")
+	db, err := Open(path, 0600, o)
+	require.NoError(t, err)
+	defer db.Close()
+	err = db.Update(func(tx *Tx) error {
+		for i := 0; i < 100; i++ {
+			b, err := tx.CreateBucket([]byte(fmt.Sprintf("%03d", i)))
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte("key"), make([]byte, 3*db.pageSize)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	err = db.Update(func(tx *Tx) error {
+		for i := 0; i < 100; i += 2 {
+			if err := tx.DeleteBucket([]byte(fmt.Sprintf("%03d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *Tx) error { return nil }))
+	return path
+}
+
+// checkErr returns the first error reported by Tx.Check.
+func checkErr(db *DB) error {
+	var first error
+	err := db.View(func(tx *Tx) error {
+		for err := range tx.Check() {
+			if first == nil {
+				first = err
+			}
+		}
+		return nil
+	})
+	if first != nil {
+		return first
+	}
+	return err
+}
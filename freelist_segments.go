@@ -0,0 +1,255 @@
+package boltdb
+
+import (
+	"sort"
+	"unsafe"
+
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// The freelist can be written as segments, see Options.FreelistSegments. The
+// free and pending page ids are split in segments of consecutive page ids,
+// which are written on a page each, and the freelist page lists the pages of
+// the segments. A commit only writes the segments whose page ids changed, and
+// the freelist page.
+
+// touch marks the segments of the n pages at id as changed.
+func (f *freelist) touch(id common.Pgid, n int) {
+	if f.segmentIDs == 0 || f.allDirty {
+		return
+	}
+	r := common.Pgid(f.segmentIDs)
+	for i := id / r; i <= (id+common.Pgid(n)-1)/r; i++ {
+		f.dirtySegments[uint64(i)] = struct{}{}
+	}
+}
+
+// markClean marks the segments as unchanged after the freelist was read from
+// its page, unless it's not written as segments.
+func (f *freelist) markClean() {
+	f.allDirty = !f.segments
+	f.dirtySegments = make(map[uint64]struct{})
+}
+
+// segmentPageIDs returns the page ids of the segments of the freelist page p,
+// and records the pages of the segments.
+func (f *freelist) segmentPageIDs(p *common.Page) []common.Pgid {
+	var ids []common.Pgid
+	segments := p.FreelistPagePairs()
+	f.segmentPages = make(map[uint64]common.Pgid, len(segments)/2)
+	for i := 0; i < len(segments); i += 2 {
+		f.segmentPages[uint64(segments[i])] = segments[i+1]
+		ids = append(ids, f.page(segments[i+1]).FreelistPageIds()...)
+	}
+	return ids
+}
+
+// freeSegments frees the pages of the segments once the freelist isn't
+// written as segments anymore.
+func (f *freelist) freeSegments(txid common.Txid) {
+	for _, id := range f.segmentPages {
+		f.free(txid, f.page(id))
+	}
+	f.segmentPages = nil
+	f.allDirty = true
+}
+
+// freeDirtySegments frees the pages of the changed segments, which changes
+// the segments of those pages too, and returns the changed segments in
+// ascending order.
+func (f *freelist) freeDirtySegments(txid common.Txid, hwm common.Pgid) []uint64 {
+	if f.allDirty {
+		for i := uint64(0); i*uint64(f.segmentIDs) < uint64(hwm); i++ {
+			f.dirtySegments[i] = struct{}{}
+		}
+		for i := range f.segmentPages {
+			f.dirtySegments[i] = struct{}{}
+		}
+		f.allDirty = false
+	}
+	for freed := true; freed; {
+		freed = false
+		for i := range f.dirtySegments {
+			if id, ok := f.segmentPages[i]; ok {
+				delete(f.segmentPages, i)
+				f.free(txid, f.page(id))
+				freed = true
+			}
+		}
+	}
+
+	dirty := make([]uint64, 0, len(f.dirtySegments))
+	for i := range f.dirtySegments {
+		dirty = append(dirty, i)
+	}
+	sort.Slice(dirty, func(i, j int) bool { return dirty[i] < dirty[j] })
+	return dirty
+}
+
+// segmentAllocate returns an allocate function which only allocates pages
+// from the given segments, so that the other segments don't change. The
+// pages are allocated at the end of the file if none of them has enough
+// free pages.
+func (f *freelist) segmentAllocate(segments []uint64) func(txid common.Txid, n int) common.Pgid {
+	r := common.Pgid(f.segmentIDs)
+	return func(txid common.Txid, n int) common.Pgid {
+		for k := 0; k < len(segments); {
+			lo := common.Pgid(segments[k]) * r
+			start, _, ok := f.spanTree.firstFit(lo, uint64(n))
+			if !ok {
+				return 0
+			}
+			if i := uint64(start / r); i != segments[k] {
+				// Skip the segments without a large enough span.
+				k = sort.Search(len(segments), func(j int) bool { return segments[j] >= i })
+				continue
+			}
+			if start+common.Pgid(n) <= lo+r {
+				return f.allocateSpan(txid, start, n)
+			}
+			k++
+		}
+		return 0
+	}
+}
+
+// segmentContents returns the sorted free and pending page ids of the given
+// segments.
+func (f *freelist) segmentContents(segments []uint64) map[uint64][]common.Pgid {
+	r := common.Pgid(f.segmentIDs)
+	m := make(map[uint64][]common.Pgid, len(segments))
+	for _, i := range segments {
+		lo, hi := common.Pgid(i)*r, common.Pgid(i+1)*r
+		var ids []common.Pgid
+		add := func(start common.Pgid, size uint64) {
+			for id := max(start, lo); id < min(start+common.Pgid(size), hi); id++ {
+				ids = append(ids, id)
+			}
+		}
+		if start, size, ok := f.spanTree.floor(lo); ok && start < lo {
+			add(start, size)
+		}
+		f.spanTree.ascendFrom(lo, func(start common.Pgid, size uint64) bool {
+			if start >= hi {
+				return false
+			}
+			add(start, size)
+			return true
+		})
+		m[i] = ids
+	}
+	for _, txp := range f.pending {
+		for _, id := range txp.ids {
+			if ids, ok := m[uint64(id/r)]; ok {
+				m[uint64(id/r)] = append(ids, id)
+			}
+		}
+	}
+	for _, ids := range m {
+		sort.Sort(common.Pgids(ids))
+	}
+	return m
+}
+
+// writeSegment writes the page ids of a segment onto its page.
+func writeSegment(p *common.Page, ids []common.Pgid) {
+	p.SetFlags(common.FreelistPageFlag)
+	p.SetCount(uint16(len(ids)))
+	copy(p.FreelistPageIds(), ids)
+}
+
+// writeSegmentPages writes the pages of the segments onto the freelist page
+// as pairs of the index of the segment and its page.
+func (f *freelist) writeSegmentPages(p *common.Page) {
+	p.SetFlags(common.FreelistPageFlag)
+	segments := make(common.Pgids, 0, len(f.segmentPages))
+	for i := range f.segmentPages {
+		segments = append(segments, common.Pgid(i))
+	}
+	sort.Sort(segments)
+	pairs := make([]common.Pgid, 0, 2*len(segments))
+	for _, i := range segments {
+		pairs = append(pairs, i, f.segmentPages[uint64(i)])
+	}
+	writePairs(p, pairs)
+}
+
+// commitFreelistSegments writes the segments of the freelist which changed
+// since the previous commit onto new pages, and the freelist page listing the
+// pages of all segments.
+func (tx *Tx) commitFreelistSegments() error {
+	f := tx.db.freelist
+	txid := tx.meta.Txid()
+	if tx.shrunk != nil {
+		// Rewrite all the segments on the lowest free pages, so that their
+		// pages don't keep the file from shrinking, see DB.Shrink.
+		f.allDirty = true
+	}
+	dirty := f.freeDirtySegments(txid, tx.meta.Pgid())
+
+	// The new pages are allocated from the changed segments, which are
+	// written anyway, so that the other segments don't change.
+	alloc := f.allocate
+	f.allocate = f.segmentAllocate(dirty)
+	defer func() { f.allocate = alloc }()
+
+	pages := make(map[uint64]*common.Page)
+	contents := f.segmentContents(dirty)
+	for _, i := range dirty {
+		if len(contents[i]) == 0 {
+			continue
+		}
+		p, err := tx.allocate(1)
+		if err != nil {
+			tx.rollback()
+			return err
+		}
+		pages[i] = p
+	}
+	if f.segmentPages == nil {
+		f.segmentPages = make(map[uint64]common.Pgid)
+	}
+	for i, p := range pages {
+		f.segmentPages[i] = p.Id()
+	}
+	size := int(common.PageHeaderSize) + int(unsafe.Sizeof(common.Pgid(0)))*(2*len(f.segmentPages)+1)
+	head, err := tx.allocate((size+tx.db.pageTrailerSize())/tx.db.pageSize + 1)
+	if err != nil {
+		tx.rollback()
+		return err
+	}
+
+	// The allocations may have emptied the segments, which are written
+	// anyway.
+	contents = f.segmentContents(dirty)
+	for i, p := range pages {
+		writeSegment(p, contents[i])
+	}
+	f.writeSegmentPages(head)
+	tx.meta.SetFreelist(head.Id())
+	f.dirtySegments = make(map[uint64]struct{})
+	return nil
+}
+
+// freelistPages returns the freelist page of tx followed by the pages of its
+// segments, if any.
+func (tx *Tx) freelistPages() []*common.Page {
+	return freelistPages(tx.meta, tx.page)
+}
+
+// freelistPages returns the freelist page of m followed by the pages of its
+// segments, if any, read with page.
+func freelistPages(m *common.Meta, page func(id common.Pgid) *common.Page) []*common.Page {
+	if m.Freelist() == common.PgidNoFreelist {
+		return nil
+	}
+	p := page(m.Freelist())
+	pages := []*common.Page{p}
+	if m.HasFreelistSegments() {
+		segments := p.FreelistPagePairs()
+		for i := 1; i < len(segments); i += 2 {
+			pages = append(pages, page(segments[i]))
+		}
+	}
+	return pages
+}
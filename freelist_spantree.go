@@ -0,0 +1,188 @@
+package boltdb
+
+import (
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// spanTree holds the spans of contiguous free pages ordered by their first
+// page id, so that the neighbours of a page and the spans in page order are
+// found in O(log n) instead of scanning every span. It's a treap whose nodes
+// also keep the largest span of their subtree, to find the lowest span of a
+// given size, see spanTree.firstFit.
+type spanTree struct {
+	root *spanNode
+	n    int
+}
+
+// spanNode is a span of size free pages starting at start.
+type spanNode struct {
+	start       common.Pgid
+	size        uint64
+	maxSize     uint64 // the largest size in the subtree
+	priority    uint64
+	left, right *spanNode
+}
+
+// spanPriority derives the heap priority of a node from its start, which
+// balances the tree as long as the page ids are well mixed by the hash.
+func spanPriority(start common.Pgid) uint64 {
+	x := uint64(start) + 0x9E3779B97F4A7C15
+	x = (x ^ (x >> 30)) * 0xBF58476D1CE4E5B9
+	x = (x ^ (x >> 27)) * 0x94D049BB133111EB
+	return x ^ (x >> 31)
+}
+
+func (t *spanNode) update() {
+	t.maxSize = t.size
+	if t.left != nil && t.left.maxSize > t.maxSize {
+		t.maxSize = t.left.maxSize
+	}
+	if t.right != nil && t.right.maxSize > t.maxSize {
+		t.maxSize = t.right.maxSize
+	}
+}
+
+// splitSpans splits t into the spans starting below key and the others.
+func splitSpans(t *spanNode, key common.Pgid) (*spanNode, *spanNode) {
+	if t == nil {
+		return nil, nil
+	}
+	if t.start < key {
+		l, r := splitSpans(t.right, key)
+		t.right = l
+		t.update()
+		return t, r
+	}
+	l, r := splitSpans(t.left, key)
+	t.left = r
+	t.update()
+	return l, t
+}
+
+// mergeSpanNodes joins a and b, all the spans of a starting below those of b.
+func mergeSpanNodes(a, b *spanNode) *spanNode {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case a.priority > b.priority:
+		a.right = mergeSpanNodes(a.right, b)
+		a.update()
+		return a
+	default:
+		b.left = mergeSpanNodes(a, b.left)
+		b.update()
+		return b
+	}
+}
+
+// len returns the number of spans.
+func (s *spanTree) len() int {
+	return s.n
+}
+
+// insert adds the span of size pages at start, which mustn't be in the tree.
+func (s *spanTree) insert(start common.Pgid, size uint64) {
+	node := &spanNode{start: start, size: size, maxSize: size, priority: spanPriority(start)}
+	l, r := splitSpans(s.root, start)
+	s.root = mergeSpanNodes(mergeSpanNodes(l, node), r)
+	s.n++
+}
+
+// delete removes the span at start, if any.
+func (s *spanTree) delete(start common.Pgid) {
+	l, r := splitSpans(s.root, start)
+	m, r := splitSpans(r, start+1)
+	if m != nil {
+		s.n--
+	}
+	s.root = mergeSpanNodes(l, r)
+}
+
+// get returns the size of the span at start.
+func (s *spanTree) get(start common.Pgid) (uint64, bool) {
+	for t := s.root; t != nil; {
+		switch {
+		case start < t.start:
+			t = t.left
+		case start > t.start:
+			t = t.right
+		default:
+			return t.size, true
+		}
+	}
+	return 0, false
+}
+
+// floor returns the span with the highest start which isn't above pid.
+func (s *spanTree) floor(pid common.Pgid) (common.Pgid, uint64, bool) {
+	var found *spanNode
+	for t := s.root; t != nil; {
+		if t.start <= pid {
+			found, t = t, t.right
+		} else {
+			t = t.left
+		}
+	}
+	if found == nil {
+		return 0, 0, false
+	}
+	return found.start, found.size, true
+}
+
+// firstFit returns the lowest span of at least n pages which starts at or
+// above from.
+func (s *spanTree) firstFit(from common.Pgid, n uint64) (common.Pgid, uint64, bool) {
+	if t := s.root.firstFit(from, n); t != nil {
+		return t.start, t.size, true
+	}
+	return 0, 0, false
+}
+
+func (t *spanNode) firstFit(from common.Pgid, n uint64) *spanNode {
+	for t != nil && t.maxSize >= n {
+		if t.start < from {
+			t = t.right
+			continue
+		}
+		if found := t.left.firstFit(from, n); found != nil {
+			return found
+		}
+		if t.size >= n {
+			return t
+		}
+		t = t.right
+	}
+	return nil
+}
+
+// ascend calls fn for each span in ascending order of start until it returns
+// false.
+func (s *spanTree) ascend(fn func(start common.Pgid, size uint64) bool) {
+	s.ascendFrom(0, fn)
+}
+
+// ascendFrom calls fn for each span starting at or above from in ascending
+// order of start until it returns false.
+func (s *spanTree) ascendFrom(from common.Pgid, fn func(start common.Pgid, size uint64) bool) {
+	var stack []*spanNode
+	for t := s.root; t != nil; {
+		if t.start >= from {
+			stack = append(stack, t)
+			t = t.left
+		} else {
+			t = t.right
+		}
+	}
+	for len(stack) > 0 {
+		t := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !fn(t.start, t.size) {
+			return
+		}
+		for t = t.right; t != nil; t = t.left {
+			stack = append(stack, t)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package boltdb
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+func TestSpanTree(t *testing.T) {
+	var s spanTree
+	model := make(map[common.Pgid]uint64)
+	starts := func() []common.Pgid {
+		var m []common.Pgid
+		for start := range model {
+			m = append(m, start)
+		}
+		sort.Slice(m, func(i, j int) bool { return m[i] < m[j] })
+		return m
+	}
+
+	r := rand.New(rand.NewSource(0))
+	for i := 0; i < 5000; i++ {
+		start := common.Pgid(r.Intn(2000))
+		if _, ok := model[start]; ok {
+			s.delete(start)
+			delete(model, start)
+		} else {
+			size := uint64(r.Intn(16) + 1)
+			s.insert(start, size)
+			model[start] = size
+		}
+		require.Equal(t, len(model), s.len())
+
+		pid := common.Pgid(r.Intn(2000))
+		n := uint64(r.Intn(16) + 1)
+		var expFloor, expFit common.Pgid
+		var floorOK, fitOK bool
+		for _, start := range starts() {
+			if start <= pid {
+				expFloor, floorOK = start, true
+			}
+			if !fitOK && start >= pid && model[start] >= n {
+				expFit, fitOK = start, true
+			}
+		}
+		floor, _, ok := s.floor(pid)
+		require.Equal(t, floorOK, ok)
+		require.Equal(t, expFloor, floor)
+		fit, size, ok := s.firstFit(pid, n)
+		require.Equal(t, fitOK, ok)
+		require.Equal(t, expFit, fit)
+		if fitOK {
+			require.Equal(t, model[expFit], size)
+		}
+	}
+
+	var got []common.Pgid
+	s.ascend(func(start common.Pgid, size uint64) bool {
+		require.Equal(t, model[start], size)
+		got = append(got, start)
+		return true
+	})
+	require.Equal(t, starts(), got)
+}
@@ -1,6 +1,7 @@
 package boltdb
 
 import (
+	"math"
 	"math/rand"
 	"reflect"
 	"sort"
@@ -34,18 +35,96 @@ func TestFreelist_release_singleTxn(t *testing.T) {
 	f.free(100, common.NewPage(12, 0, 0, 1))
 	f.free(100, common.NewPage(9, 0, 0, 0))
 	f.free(102, common.NewPage(39, 0, 0, 0))
-	f.release([]common.Txid{100})
-	f.release([]common.Txid{101})
+	f.release(common.Txid(math.MaxUint64), []common.Txid{100})
+	f.release(common.Txid(math.MaxUint64), []common.Txid{101})
 	if exp := []common.Pgid{9, 12, 13}; !reflect.DeepEqual(exp, f.getFreePageIDs()) {
 		t.Fatalf("exp=%v; got=%v", exp, f.getFreePageIDs())
 	}
 
-	f.release([]common.Txid{102})
+	f.release(common.Txid(math.MaxUint64), []common.Txid{102})
 	if exp := []common.Pgid{9, 12, 13, 39}; !reflect.DeepEqual(exp, f.getFreePageIDs()) {
 		t.Fatalf("exp=%v; got=%v", exp, f.getFreePageIDs())
 	}
 }
 
+// Ensure that the pages freed by the last reuseDelay transactions aren't
+// released.
+func TestFreelist_release_reuseDelay(t *testing.T) {
+	f := newTestFreelist()
+	f.reuseDelay = 2
+	f.free(100, common.NewPage(12, 0, 0, 0))
+	f.free(101, common.NewPage(13, 0, 0, 0))
+	f.release(102, nil)
+	f.release(103, nil)
+	if exp := []common.Pgid{12}; !reflect.DeepEqual(exp, f.getFreePageIDs()) {
+		t.Fatalf("exp=%v; got=%v", exp, f.getFreePageIDs())
+	}
+	f.release(104, nil)
+	if exp := []common.Pgid{12, 13}; !reflect.DeepEqual(exp, f.getFreePageIDs()) {
+		t.Fatalf("exp=%v; got=%v", exp, f.getFreePageIDs())
+	}
+}
+
+// Ensure that release only keeps the pages visible to a readonly TXN, as
+// checked against every TXN for each page.
+func TestFreelist_release_readers(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	for n := 0; n < 100; n++ {
+		f := newTestFreelist()
+		visible := make(map[common.Pgid]bool)
+		rtxids := make([]common.Txid, r.Intn(20))
+		for i := range rtxids {
+			rtxids[i] = common.Txid(r.Intn(100))
+		}
+		id := common.Pgid(2)
+		for ftxid := common.Txid(1); ftxid < 100; ftxid++ {
+			for i := r.Intn(10); i > 0; i-- {
+				atxid := common.Txid(r.Intn(int(ftxid)))
+				f.allocs[id] = atxid
+				f.free(ftxid, common.NewPage(id, 0, 0, 0))
+				for _, rtxid := range rtxids {
+					if atxid <= rtxid && rtxid < ftxid {
+						visible[id] = true
+					}
+				}
+				id++
+			}
+		}
+
+		f.release(100, rtxids)
+		pending := make(map[common.Pgid]bool)
+		for _, txp := range f.pending {
+			for _, pid := range txp.ids {
+				pending[pid] = true
+			}
+		}
+		if !reflect.DeepEqual(visible, pending) {
+			t.Fatalf("exp=%v; got=%v for readers %v", visible, pending, rtxids)
+		}
+		if exp := int(id) - 2 - len(visible); f.free_count() != exp {
+			t.Fatalf("exp=%d free; got=%d", exp, f.free_count())
+		}
+	}
+}
+
+// Ensure that reload drops the free pages which are pending, even if all of
+// them are.
+func TestFreelist_reload_allPending(t *testing.T) {
+	var buf [4096]byte
+	f := newTestFreelist()
+	f.readIDs([]common.Pgid{12, 13})
+	p := (*common.Page)(unsafe.Pointer(&buf[0]))
+	if err := f.write(p); err != nil {
+		t.Fatal(err)
+	}
+
+	f.pending[100] = &txPending{ids: []common.Pgid{12, 13}, alloctx: []common.Txid{0, 0}}
+	f.reload(p)
+	if ids := f.getFreePageIDs(); len(ids) != 0 {
+		t.Fatalf("exp=[]; got=%v", ids)
+	}
+}
+
 // Ensure that release handles boundary conditions correctly
 func TestFreelist_release_multipleTxn(t *testing.T) {
 	type testPage struct {
@@ -158,7 +237,7 @@ func TestFreelist_release_multipleTxn(t *testing.T) {
 				f.free(p.freeTxn, common.NewPage(p.id, 0, 0, uint32(p.n-1)))
 			}
 
-			f.release(c.txids)
+			f.release(common.Txid(math.MaxUint64), c.txids)
 
 			if exp := c.wantFree; !reflect.DeepEqual(exp, f.getFreePageIDs()) {
 				t.Errorf("exp=%v; got=%v for %s", exp, f.getFreePageIDs(), c.title)
@@ -193,6 +272,66 @@ func TestFreelistHashmap_allocate(t *testing.T) {
 	}
 }
 
+// Ensure that the default allocation splits one of the smallest larger spans
+// instead of a large one.
+func TestFreelistHashmap_allocateSizeClass(t *testing.T) {
+	f := newTestFreelist()
+	// Spans: 3-5 (3), 10-25 (16), 30-31 (2).
+	ids := []common.Pgid{3, 4, 5, 30, 31}
+	for id := common.Pgid(10); id <= 25; id++ {
+		ids = append(ids, id)
+	}
+	sort.Sort(common.Pgids(ids))
+	f.readIDs(ids)
+
+	for _, exp := range []common.Pgid{30, 31, 3, 4, 5} {
+		if id := f.allocate(1, 1); id != exp {
+			t.Fatalf("exp=%v; got=%v", exp, id)
+		}
+	}
+	if exp := map[common.Pgid]uint64{10: 16}; !reflect.DeepEqual(exp, spanMap(f)) {
+		t.Fatalf("exp=%v; got=%v", exp, spanMap(f))
+	}
+	if id := f.allocate(1, 17); id != 0 {
+		t.Fatalf("exp=0; got=%v", id)
+	}
+}
+
+// Ensure that the allocation strategies pick the expected spans.
+func TestFreelist_allocStrategy(t *testing.T) {
+	// Spans: 3-7 (5), 9 (1), 12-13 (2), 18-20 (3), 30-31 (2).
+	ids := []common.Pgid{3, 4, 5, 6, 7, 9, 12, 13, 18, 19, 20, 30, 31}
+	for _, tc := range []struct {
+		name     string
+		strategy AllocStrategy
+		sizes    []int
+		exp      []common.Pgid
+	}{
+		{name: "first fit", strategy: AllocFirstFit, sizes: []int{2, 2, 1, 2, 3}, exp: []common.Pgid{3, 5, 7, 12, 18}},
+		{name: "best fit", strategy: AllocBestFit, sizes: []int{2, 2, 1, 2, 3}, exp: []common.Pgid{12, 30, 9, 18, 3}},
+		{name: "next fit", strategy: AllocNextFit, sizes: []int{2, 2, 2, 1, 2, 2, 1}, exp: []common.Pgid{3, 5, 12, 18, 19, 30, 7}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestFreelist()
+			f.setAllocStrategy(tc.strategy)
+			f.readIDs(ids)
+			n := 0
+			for i, size := range tc.sizes {
+				if id := f.allocate(1, size); id != tc.exp[i] {
+					t.Fatalf("allocation %d of %d pages: exp=%v; got=%v", i, size, tc.exp[i], id)
+				}
+				n += size
+				if x := f.free_count(); x != len(ids)-n {
+					t.Fatalf("exp=%v; got=%v", len(ids)-n, x)
+				}
+			}
+			if id := f.allocate(1, 6); id != 0 {
+				t.Fatalf("exp=0; got=%v", id)
+			}
+		})
+	}
+}
+
 // Ensure that a freelist can deserialize from a freelist page.
 func TestFreelist_read(t *testing.T) {
 	// Create a page.
@@ -241,6 +380,50 @@ func TestFreelist_write(t *testing.T) {
 	}
 }
 
+// Ensure that a freelist can serialize into a freelist page as spans.
+func TestFreelist_writeSpans(t *testing.T) {
+	var buf [4096]byte
+	f := newTestFreelist()
+	f.spans = true
+
+	f.readIDs([]common.Pgid{5, 6, 7, 12, 39, 40})
+	f.pending[100] = &txPending{ids: []common.Pgid{28, 11, 8}}
+	f.pending[101] = &txPending{ids: []common.Pgid{3, 41}}
+	p := (*common.Page)(unsafe.Pointer(&buf[0]))
+	if err := f.write(p); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []common.Pgid{3, 1, 5, 4, 11, 2, 28, 1, 39, 3}; !reflect.DeepEqual(exp, p.FreelistPagePairs()) {
+		t.Fatalf("exp=%v; got=%v", exp, p.FreelistPagePairs())
+	}
+	if f.size() < int(common.PageHeaderSize)+len(p.FreelistPagePairs())*int(unsafe.Sizeof(common.Pgid(0))) {
+		t.Fatalf("size %d is smaller than the page", f.size())
+	}
+
+	// Read the page back out.
+	f2 := newTestFreelist()
+	f2.spans = true
+	f2.read(p)
+	if exp := []common.Pgid{3, 5, 6, 7, 8, 11, 12, 28, 39, 40, 41}; !reflect.DeepEqual(exp, f2.getFreePageIDs()) {
+		t.Fatalf("exp=%v; got=%v", exp, f2.getFreePageIDs())
+	}
+}
+
+// Ensure that the fragmentation of the free pages is computed from the spans.
+func TestFreelist_spanStats(t *testing.T) {
+	f := newTestFreelist()
+	if s := f.spanStats(); s != (FreelistStats{}) {
+		t.Fatalf("unexpected stats of an empty freelist: %+v", s)
+	}
+
+	f.readIDs([]common.Pgid{3, 4, 5, 6, 10, 12, 13, 20})
+	f.pending[100] = &txPending{ids: []common.Pgid{7}}
+	exp := FreelistStats{SpanN: 4, MaxSpan: 4, AvgSpan: 2, Fragmentation: 0.5}
+	if s := f.spanStats(); s != exp {
+		t.Fatalf("exp=%+v; got=%+v", exp, s)
+	}
+}
+
 func Benchmark_FreelistRelease10K(b *testing.B)    { benchmark_FreelistRelease(b, 10000) }
 func Benchmark_FreelistRelease100K(b *testing.B)   { benchmark_FreelistRelease(b, 100000) }
 func Benchmark_FreelistRelease1000K(b *testing.B)  { benchmark_FreelistRelease(b, 1000000) }
@@ -255,7 +438,7 @@ func benchmark_FreelistRelease(b *testing.B, size int) {
 		f := newTestFreelist()
 		f.pending = map[common.Txid]*txPending{1: txp}
 		f.readIDs(ids)
-		f.release([]common.Txid{1})
+		f.release(common.Txid(math.MaxUint64), []common.Txid{1})
 	}
 }
 
@@ -293,49 +476,44 @@ func Test_freelist_mergeWithExist(t *testing.T) {
 
 	bm2 := pidSet{5: struct{}{}}
 	tests := []struct {
-		name            string
-		ids             []common.Pgid
-		pgid            common.Pgid
-		want            []common.Pgid
-		wantForwardmap  map[common.Pgid]uint64
-		wantBackwardmap map[common.Pgid]uint64
-		wantfreemap     map[uint64]pidSet
+		name        string
+		ids         []common.Pgid
+		pgid        common.Pgid
+		want        []common.Pgid
+		wantSpans   map[common.Pgid]uint64
+		wantfreemap map[uint64]pidSet
 	}{
 		{
-			name:            "test1",
-			ids:             []common.Pgid{1, 2, 4, 5, 6},
-			pgid:            3,
-			want:            []common.Pgid{1, 2, 3, 4, 5, 6},
-			wantForwardmap:  map[common.Pgid]uint64{1: 6},
-			wantBackwardmap: map[common.Pgid]uint64{6: 6},
-			wantfreemap:     map[uint64]pidSet{6: bm1},
+			name:        "test1",
+			ids:         []common.Pgid{1, 2, 4, 5, 6},
+			pgid:        3,
+			want:        []common.Pgid{1, 2, 3, 4, 5, 6},
+			wantSpans:   map[common.Pgid]uint64{1: 6},
+			wantfreemap: map[uint64]pidSet{6: bm1},
 		},
 		{
-			name:            "test2",
-			ids:             []common.Pgid{1, 2, 5, 6},
-			pgid:            3,
-			want:            []common.Pgid{1, 2, 3, 5, 6},
-			wantForwardmap:  map[common.Pgid]uint64{1: 3, 5: 2},
-			wantBackwardmap: map[common.Pgid]uint64{6: 2, 3: 3},
-			wantfreemap:     map[uint64]pidSet{3: bm1, 2: bm2},
+			name:        "test2",
+			ids:         []common.Pgid{1, 2, 5, 6},
+			pgid:        3,
+			want:        []common.Pgid{1, 2, 3, 5, 6},
+			wantSpans:   map[common.Pgid]uint64{1: 3, 5: 2},
+			wantfreemap: map[uint64]pidSet{3: bm1, 2: bm2},
 		},
 		{
-			name:            "test3",
-			ids:             []common.Pgid{1, 2},
-			pgid:            3,
-			want:            []common.Pgid{1, 2, 3},
-			wantForwardmap:  map[common.Pgid]uint64{1: 3},
-			wantBackwardmap: map[common.Pgid]uint64{3: 3},
-			wantfreemap:     map[uint64]pidSet{3: bm1},
+			name:        "test3",
+			ids:         []common.Pgid{1, 2},
+			pgid:        3,
+			want:        []common.Pgid{1, 2, 3},
+			wantSpans:   map[common.Pgid]uint64{1: 3},
+			wantfreemap: map[uint64]pidSet{3: bm1},
 		},
 		{
-			name:            "test4",
-			ids:             []common.Pgid{2, 3},
-			pgid:            1,
-			want:            []common.Pgid{1, 2, 3},
-			wantForwardmap:  map[common.Pgid]uint64{1: 3},
-			wantBackwardmap: map[common.Pgid]uint64{3: 3},
-			wantfreemap:     map[uint64]pidSet{3: bm1},
+			name:        "test4",
+			ids:         []common.Pgid{2, 3},
+			pgid:        1,
+			want:        []common.Pgid{1, 2, 3},
+			wantSpans:   map[common.Pgid]uint64{1: 3},
+			wantfreemap: map[uint64]pidSet{3: bm1},
 		},
 	}
 	for _, tt := range tests {
@@ -348,11 +526,8 @@ func Test_freelist_mergeWithExist(t *testing.T) {
 		if got := f.getFreePageIDs(); !reflect.DeepEqual(tt.want, got) {
 			t.Fatalf("name %s; exp=%v; got=%v", tt.name, tt.want, got)
 		}
-		if got := f.forwardMap; !reflect.DeepEqual(tt.wantForwardmap, got) {
-			t.Fatalf("name %s; exp=%v; got=%v", tt.name, tt.wantForwardmap, got)
-		}
-		if got := f.backwardMap; !reflect.DeepEqual(tt.wantBackwardmap, got) {
-			t.Fatalf("name %s; exp=%v; got=%v", tt.name, tt.wantBackwardmap, got)
+		if got := spanMap(f); !reflect.DeepEqual(tt.wantSpans, got) {
+			t.Fatalf("name %s; exp=%v; got=%v", tt.name, tt.wantSpans, got)
 		}
 		if got := f.freemaps; !reflect.DeepEqual(tt.wantfreemap, got) {
 			t.Fatalf("name %s; exp=%v; got=%v", tt.name, tt.wantfreemap, got)
@@ -360,6 +535,16 @@ func Test_freelist_mergeWithExist(t *testing.T) {
 	}
 }
 
+// spanMap returns the size of the free spans by their start.
+func spanMap(f *freelist) map[common.Pgid]uint64 {
+	m := make(map[common.Pgid]uint64)
+	f.spanTree.ascend(func(start common.Pgid, size uint64) bool {
+		m[start] = size
+		return true
+	})
+	return m
+}
+
 // newTestFreelist get the freelist type from env and initial the freelist
 func newTestFreelist() *freelist {
 	return newFreelist()
@@ -369,17 +554,15 @@ func Test_freelist_hashmapGetFreePageIDs(t *testing.T) {
 	f := newTestFreelist()
 
 	N := int32(100000)
-	fm := make(map[common.Pgid]uint64)
 	i := int32(0)
 	val := int32(0)
 	for i = 0; i < N; {
-		val = rand.Int31n(1000)
-		fm[common.Pgid(i)] = uint64(val)
+		val = rand.Int31n(1000) + 1
+		f.spanTree.insert(common.Pgid(i), uint64(val))
 		i += val
 		f.freePagesCount += uint64(val)
 	}
 
-	f.forwardMap = fm
 	res := f.hashmapGetFreePageIDs()
 
 	if !sort.SliceIsSorted(res, func(i, j int) bool { return res[i] < res[j] }) {
@@ -391,17 +574,15 @@ func Benchmark_freelist_hashmapGetFreePageIDs(b *testing.B) {
 	f := newTestFreelist()
 
 	N := int32(100000)
-	fm := make(map[common.Pgid]uint64)
 	i := int32(0)
 	val := int32(0)
 	for i = 0; i < N; {
-		val = rand.Int31n(1000)
-		fm[common.Pgid(i)] = uint64(val)
+		val = rand.Int31n(1000) + 1
+		f.spanTree.insert(common.Pgid(i), uint64(val))
 		i += val
+		f.freePagesCount += uint64(val)
 	}
 
-	f.forwardMap = fm
-
 	b.ReportAllocs()
 	b.ResetTimer()
 	for n := 0; n < b.N; n++ {
@@ -0,0 +1,133 @@
+package boltdb
+
+import (
+	"sync"
+	"time"
+
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// groupCommit lets concurrent write transactions share fsyncs.
+//
+// A commit normally syncs the data file twice: once after writing the dirty
+// pages and once after writing the meta page. With group commit, the second
+// sync is deferred: the transaction releases the writer lock after writing
+// its meta page, and waits until the meta page is synced before returning.
+// The next writer syncs its own dirty pages before writing its meta page,
+// which also syncs the meta pages of the transactions before it. If no other
+// writer comes along within the interval, the waiting transaction syncs the
+// file itself.
+//
+// Until a meta page is synced, the previous synced meta is the one that
+// would be used after a crash, so the pages it references are kept from
+// being reused as if a read transaction was open on it.
+type groupCommit struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	written common.Txid   // txid of the last meta page written
+	synced  common.Txid   // txid of the last meta page known to be synced
+	changed chan struct{} // closed when synced moves forward
+	closed  bool
+}
+
+func (db *DB) startGroupCommit(interval time.Duration) {
+	txid := db.meta().Txid()
+	db.groupCommit = &groupCommit{
+		interval: interval,
+		written:  txid,
+		synced:   txid,
+		changed:  make(chan struct{}),
+	}
+}
+
+// metaWritten records that the meta page of txid has been written. If synced
+// is set, the file doesn't need to be synced for the meta page to be durable.
+func (g *groupCommit) metaWritten(txid common.Txid, synced bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.written = txid
+	if synced {
+		g.advance(txid)
+	}
+}
+
+// unsynced returns the txid of the last synced meta page, if a later meta
+// page has been written but not synced yet.
+func (g *groupCommit) unsynced() (common.Txid, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.synced, g.written > g.synced
+}
+
+// sync syncs the data file, which makes all meta pages written so far
+// durable.
+func (g *groupCommit) sync(db *DB) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.syncLocked(db)
+}
+
+// wait blocks until the meta page of txid is synced. It syncs the data file
+// itself if no other writer did within the group commit interval.
+func (g *groupCommit) wait(db *DB, txid common.Txid) error {
+	timer := time.NewTimer(g.interval)
+	defer timer.Stop()
+	for {
+		g.mu.Lock()
+		if g.synced >= txid {
+			g.mu.Unlock()
+			return nil
+		}
+		changed := g.changed
+		g.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if g.synced >= txid {
+				return nil
+			}
+			return g.syncLocked(db)
+		}
+	}
+}
+
+// close syncs any meta page which hasn't been synced yet. The data file must
+// not be synced through g afterwards.
+func (g *groupCommit) close(db *DB) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var err error
+	if g.written > g.synced {
+		err = g.syncLocked(db)
+	}
+	g.closed = true
+	return err
+}
+
+// syncLocked syncs the data file. The caller must hold mu.
+func (g *groupCommit) syncLocked(db *DB) error {
+	if g.closed {
+		return berrors.ErrDatabaseNotOpen
+	}
+	target := g.written
+	if err := fdatasync(db); err != nil {
+		return err
+	}
+	g.advance(target)
+	return nil
+}
+
+// advance marks the meta pages up to txid as synced. The caller must hold mu.
+func (g *groupCommit) advance(txid common.Txid) {
+	if txid <= g.synced {
+		return
+	}
+	g.synced = txid
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
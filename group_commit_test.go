@@ -0,0 +1,70 @@
+package boltdb_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestDB_GroupCommit(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{GroupCommitInterval: 5 * time.Millisecond})
+
+	const writers, perWriter = 8, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				err := db.Update(func(tx *bolt.Tx) error {
+					b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+					if err != nil {
+						return err
+					}
+					// Rewrite a shared key so that pages are freed and reused.
+					if err := b.Put([]byte("shared"), []byte(fmt.Sprintf("%d-%d", w, i))); err != nil {
+						return err
+					}
+					return b.Put([]byte(fmt.Sprintf("%02d-%04d", w, i)), make([]byte, 100))
+				})
+				require.NoError(t, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	db.MustClose()
+	db.MustReopen()
+	err := db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, writers*perWriter+1, tx.Bucket([]byte("widgets")).Stats().KeyN)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestDB_GroupCommit_SingleWriter(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{GroupCommitInterval: 10 * time.Millisecond})
+
+	// A lone writer syncs by itself once the interval has passed.
+	start := time.Now()
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	})
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+
+	var committed bool
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	tx.OnCommit(func() { committed = true })
+	require.NoError(t, tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar")))
+	require.NoError(t, tx.Commit())
+	require.True(t, committed)
+}
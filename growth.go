@@ -0,0 +1,44 @@
+package boltdb
+
+// GrowthPolicy returns the size in bytes which the database grows to when it
+// needs at least size bytes. It's used both for the size of the memory map and
+// for the size the data file is extended to, see Options.GrowthPolicy.
+// Returning less than size grows the database to exactly size bytes.
+type GrowthPolicy func(size int) int
+
+// FixedGrowth returns a GrowthPolicy which grows the database in increments
+// of step bytes.
+func FixedGrowth(step int) GrowthPolicy {
+	return func(size int) int {
+		if step <= 0 {
+			return size
+		}
+		sz := (int64(size) + int64(step) - 1) / int64(step) * int64(step)
+		return int(min(sz, maxMapSize))
+	}
+}
+
+// PercentGrowth returns a GrowthPolicy which grows the database by percent
+// of the size it needs, but by at least minStep bytes, e.g.
+// PercentGrowth(25, 1<<20) grows it to 1.25 times the size it needs.
+func PercentGrowth(percent int, minStep int) GrowthPolicy {
+	return func(size int) int {
+		step := max(int64(size)*int64(percent)/100, int64(minStep))
+		return int(min(int64(size)+step, maxMapSize))
+	}
+}
+
+// growthSize returns the size which the growth policy grows the database to
+// when it needs at least size bytes, rounded up to a multiple of the page
+// size and capped to maxMapSize.
+func (db *DB) growthSize(size int) int {
+	sz := int64(max(db.growth(size), size))
+	pageSize := int64(db.pageSize)
+	if sz%pageSize != 0 {
+		sz = (sz/pageSize + 1) * pageSize
+	}
+	if sz > maxMapSize {
+		sz = maxMapSize
+	}
+	return int(sz)
+}
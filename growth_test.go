@@ -0,0 +1,38 @@
+package boltdb_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestGrowthPolicy(t *testing.T) {
+	require.Equal(t, 1<<20, bolt.FixedGrowth(1<<20)(1))
+	require.Equal(t, 2<<20, bolt.FixedGrowth(1<<20)(1<<20+1))
+	require.Equal(t, 1250, bolt.PercentGrowth(25, 0)(1000))
+	require.Equal(t, 1100, bolt.PercentGrowth(1, 100)(1000))
+}
+
+// Ensure that the data file grows in the increments of the growth policy.
+func TestDB_GrowthPolicy(t *testing.T) {
+	const step = 256 * 1024
+	var calls int
+	growth := func(size int) int {
+		calls++
+		return bolt.FixedGrowth(step)(size)
+	}
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{GrowthPolicy: growth})
+	putBigBucket(t, db, "widgets", 1000)
+
+	require.Greater(t, calls, 0)
+	require.Zero(t, fileSize(db.Path())%step)
+	err := db.View(func(tx *bolt.Tx) error {
+		require.Less(t, fileSize(db.Path())-tx.Size(), int64(step+db.Info().PageSize))
+		return nil
+	})
+	require.NoError(t, err)
+	db.MustCheck()
+}
@@ -0,0 +1,49 @@
+package boltdb
+
+import (
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// retainTx records the latest committed transaction so that it can still be
+// opened with BeginAt once newer transactions are committed. The caller must
+// hold the writer lock and metalock.
+func (db *DB) retainTx() {
+	if db.retainTxs <= 0 {
+		return
+	}
+	m := db.meta()
+	if n := len(db.retained); n > 0 && db.retained[n-1].Txid() == m.Txid() {
+		return
+	}
+
+	var retained common.Meta
+	m.Copy(&retained)
+	db.retained = append(db.retained, retained)
+	if extra := len(db.retained) - db.retainTxs; extra > 0 {
+		db.retained = append(db.retained[:0], db.retained[extra:]...)
+	}
+}
+
+// retainedTxids returns the ids of the retained transactions, whose pages
+// must not be released. The caller must hold metalock.
+func (db *DB) retainedTxids() []common.Txid {
+	txids := make([]common.Txid, len(db.retained))
+	for i := range db.retained {
+		txids[i] = db.retained[i].Txid()
+	}
+	return txids
+}
+
+// retainedMeta returns the meta of the committed transaction txid if it can
+// be opened, or nil. The caller must hold metalock.
+func (db *DB) retainedMeta(txid common.Txid) *common.Meta {
+	if m := db.meta(); m.Txid() == txid {
+		return m
+	}
+	for i := range db.retained {
+		if db.retained[i].Txid() == txid {
+			return &db.retained[i]
+		}
+	}
+	return nil
+}
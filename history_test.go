@@ -0,0 +1,134 @@
+package boltdb_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestDB_BeginAt(t *testing.T) {
+	// Map enough up front that writers don't remap while the historical
+	// view is open.
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{RetainTxs: 3, InitialMmapSize: 1 << 20})
+
+	// Overwrite the same keys in each transaction, so that the pages of
+	// earlier transactions are freed.
+	var txids []int
+	for i := 0; i < 10; i++ {
+		err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			for k := 0; k < 100; k++ {
+				if err := b.Put([]byte(fmt.Sprintf("%04d", k)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+					return err
+				}
+			}
+			txids = append(txids, tx.ID())
+			return nil
+		})
+		require.NoError(t, err)
+	}
+
+	requireVersion := func(tx *bolt.Tx, i int) {
+		b := tx.Bucket([]byte("widgets"))
+		for k := 0; k < 100; k++ {
+			require.Equal(t, []byte(fmt.Sprintf("value-%d", i)), b.Get([]byte(fmt.Sprintf("%04d", k))))
+		}
+	}
+
+	// The latest transaction and the three before it can be opened.
+	for i := 6; i < 10; i++ {
+		tx, err := db.BeginAt(txids[i])
+		require.NoError(t, err)
+		require.Equal(t, txids[i], tx.ID())
+		requireVersion(tx, i)
+		require.NoError(t, tx.Rollback())
+	}
+	_, err := db.BeginAt(txids[5])
+	require.ErrorIs(t, err, berrors.ErrTxNotRetained)
+
+	// A historical view stays consistent while new transactions are
+	// committed, even after it's pushed out of the retained ones.
+	tx, err := db.BeginAt(txids[6])
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		err := db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("widgets")).Put([]byte("0000"), make([]byte, 1000))
+		})
+		require.NoError(t, err)
+	}
+	requireVersion(tx, 6)
+	require.NoError(t, tx.Rollback())
+	_, err = db.BeginAt(txids[6])
+	require.ErrorIs(t, err, berrors.ErrTxNotRetained)
+	db.MustCheck()
+}
+
+func TestDB_BeginAt_NotRetained(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	putWidgets(t, db, 0, 2)
+
+	tx, err := db.Begin(false)
+	require.NoError(t, err)
+	latest := tx.ID()
+	require.NoError(t, tx.Rollback())
+
+	tx, err = db.BeginAt(latest)
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+	_, err = db.BeginAt(latest - 1)
+	require.ErrorIs(t, err, berrors.ErrTxNotRetained)
+}
+
+// Ensure that the pages freed by a transaction aren't reused before
+// ReuseDelay more transactions are committed.
+func TestDB_ReuseDelay(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{ReuseDelay: 3})
+	putBigBucket(t, db, "widgets", 100)
+
+	var freed int
+	err := db.Update(func(tx *bolt.Tx) error {
+		freed = tx.ID()
+		return tx.DeleteBucket([]byte("widgets"))
+	})
+	require.NoError(t, err)
+
+	pendingOf := func(tx *bolt.Tx, txid int) int {
+		pages, err := tx.PendingPages(false)
+		require.NoError(t, err)
+		var n int
+		for _, p := range pages {
+			if p.Txid == txid {
+				n++
+			}
+		}
+		return n
+	}
+	var n int
+	for i := 0; i < 4; i++ {
+		err := db.Update(func(tx *bolt.Tx) error {
+			switch {
+			case i == 0:
+				n = pendingOf(tx, freed)
+				require.NotZero(t, n)
+			case i < 3:
+				require.Equal(t, n, pendingOf(tx, freed), "transaction %d", tx.ID())
+			default:
+				require.Zero(t, pendingOf(tx, freed))
+			}
+			b, err := tx.CreateBucketIfNotExists([]byte("small"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(fmt.Sprint(i)), []byte("v"))
+		})
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,22 @@
+package boltdb
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// madviseHugePages asks the kernel to back the mapping b with transparent
+// huge pages if Options.HugePages is set. Kernels without transparent huge
+// pages reject the advice, which is ignored.
+func madviseHugePages(db *DB, b []byte) error {
+	if !db.hugePages {
+		return nil
+	}
+	err := unix.Madvise(b, unix.MADV_HUGEPAGE)
+	if err != nil && err != syscall.ENOSYS && err != syscall.EINVAL {
+		return fmt.Errorf("madvise: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package boltdb
+
+// madviseHugePages does nothing, huge pages are only requested on Linux, see
+// Options.HugePages.
+func madviseHugePages(db *DB, b []byte) error {
+	return nil
+}
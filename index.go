@@ -0,0 +1,357 @@
+package boltdb
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// rebuildBatchSize is the number of keys RebuildIndex reads from the indexed
+// bucket before it writes their index entries.
+const rebuildBatchSize = 1000
+
+// Index declares a secondary index of a top-level bucket, see
+// Options.Indexes. Whenever a key of the bucket is put or deleted, its
+// entries in the index are updated in the same transaction. The index is
+// stored in a top-level bucket of its own, where each index key is a nested
+// bucket holding the keys of the indexed bucket which map to it, so the
+// index is checked, compacted and backed up like any other bucket.
+//
+// Only the changes made while the index is declared are indexed, see
+// Tx.RebuildIndex to index existing keys.
+type Index struct {
+	// Name is the name of the top-level bucket which holds the index. It
+	// mustn't be used for anything else.
+	Name string
+
+	// Bucket is the name of the top-level bucket which is indexed.
+	Bucket string
+
+	// Keys returns the index keys of a key/value pair of the bucket, or nil
+	// if the pair isn't indexed. It must always return the same keys for
+	// the same pair, since they're used to find the entries to delete. The
+	// value is nil for the values streamed with Bucket.PutReader.
+	Keys func(key, value []byte) [][]byte
+}
+
+// newIndexes checks the declared indexes and maps the name of each indexed
+// bucket to its indexes.
+func newIndexes(list []Index) (map[string][]*Index, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	m := make(map[string][]*Index)
+	names := make(map[string]bool)
+	for i := range list {
+		idx := list[i]
+		if idx.Name == "" || idx.Bucket == "" || idx.Keys == nil {
+			return nil, fmt.Errorf("index %q: name, bucket and keys required", idx.Name)
+		} else if names[idx.Name] {
+			return nil, fmt.Errorf("index %q: declared twice", idx.Name)
+		}
+		names[idx.Name] = true
+		m[idx.Bucket] = append(m[idx.Bucket], &idx)
+	}
+	for name := range names {
+		if _, ok := m[name]; ok {
+			return nil, fmt.Errorf("index %q: named like an indexed bucket", name)
+		}
+	}
+	return m, nil
+}
+
+// index returns the index of the bucket with the given name.
+func (b *Bucket) index(name string) *Index {
+	for _, idx := range b.indexes {
+		if idx.Name == name {
+			return idx
+		}
+	}
+	return nil
+}
+
+// Lookup calls fn for each key/value pair of the bucket which has indexKey
+// among its keys in the named index, in key order. Expired keys are skipped.
+// If fn returns an error then the iteration is stopped and the error is
+// returned to the caller.
+// Returns ErrIndexNotFound if the index isn't declared for the bucket.
+func (b *Bucket) Lookup(index string, indexKey []byte, fn func(k, v []byte) error) error {
+	idx := b.index(index)
+	if idx == nil {
+		return errors.ErrIndexNotFound
+	}
+	ib := b.tx.Bucket([]byte(idx.Name))
+	if ib == nil {
+		return nil
+	}
+	entries := ib.Bucket(indexKey)
+	if entries == nil {
+		return nil
+	}
+	return b.forEachIndexed(entries, func(k, v []byte) error {
+		return fn(k, v)
+	})
+}
+
+// ForEachIndex calls fn for each key/value pair of the bucket in the order
+// of its keys in the named index, for the index keys in [start, end). A nil
+// start begins at the first index key and a nil end ends after the last one.
+// A pair is visited once for each of its index keys in the range, with the
+// pairs of the same index key in key order. Expired keys are skipped.
+// If fn returns an error then the iteration is stopped and the error is
+// returned to the caller.
+// Returns ErrIndexNotFound if the index isn't declared for the bucket.
+func (b *Bucket) ForEachIndex(index string, start, end []byte, fn func(indexKey, k, v []byte) error) error {
+	idx := b.index(index)
+	if idx == nil {
+		return errors.ErrIndexNotFound
+	}
+	ib := b.tx.Bucket([]byte(idx.Name))
+	if ib == nil {
+		return nil
+	}
+	c := ib.Cursor()
+	var ik []byte
+	if start == nil {
+		ik, _ = c.First()
+	} else {
+		ik, _ = c.Seek(start)
+	}
+	for ; ik != nil && (end == nil || bytes.Compare(ik, end) < 0); ik, _ = c.Next() {
+		entries := ib.Bucket(ik)
+		if entries == nil {
+			continue
+		}
+		if err := b.forEachIndexed(entries, func(k, v []byte) error {
+			return fn(ik, k, v)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forEachIndexed calls fn for the key/value pairs of the bucket whose keys
+// are in the entries of an index key.
+func (b *Bucket) forEachIndexed(entries *Bucket, fn func(k, v []byte) error) error {
+	now := time.Now().UnixNano()
+	return entries.ForEach(func(k, _ []byte) error {
+		v, flags, ok := b.getRaw(k)
+		if !ok || (flags&common.BucketLeafFlag) != 0 {
+			return nil
+		}
+		if (flags & common.ExpiringLeafFlag) != 0 {
+			if expired(v, now) {
+				return nil
+			}
+			v = v[expiryLen:]
+		}
+		return fn(k, b.value(v, flags))
+	})
+}
+
+// getRaw returns the stored value and flags of a key, and whether it exists.
+func (b *Bucket) getRaw(key []byte) ([]byte, uint32, bool) {
+	k, v, flags := b.Cursor().seek(key)
+	if !bytes.Equal(key, k) {
+		return nil, 0, false
+	}
+	return v, flags, true
+}
+
+// indexPut updates the indexes of the bucket before a value is put.
+func (b *Bucket) indexPut(key, value []byte, flags uint32) error {
+	old, oflags, ok := b.getRaw(key)
+	if ok && (oflags&common.BucketLeafFlag) != 0 {
+		return errors.ErrIncompatibleValue
+	}
+	return b.updateIndexes(key, b.indexedValue(old, oflags), ok, b.indexedValue(value, flags), true)
+}
+
+// indexDelete updates the indexes of the bucket before a key is deleted.
+func (b *Bucket) indexDelete(key []byte) error {
+	old, oflags, ok := b.getRaw(key)
+	if !ok || (oflags&common.BucketLeafFlag) != 0 {
+		return nil
+	}
+	return b.updateIndexes(key, b.indexedValue(old, oflags), true, nil, false)
+}
+
+// indexedValue returns the value an index sees for a stored value: nil for a
+// streamed value, which isn't read, or the value without its expiry and
+// uncompressed.
+func (b *Bucket) indexedValue(v []byte, flags uint32) []byte {
+	if (flags & common.BlobLeafFlag) != 0 {
+		return nil
+	}
+	return b.value(stripExpiry(v, flags), flags)
+}
+
+// updateIndexes replaces the index entries of a key for its old value, if
+// hadOld is set, with the ones for its new value, if hasNew is set. The
+// dirty pages aren't written out meanwhile, so that the cursors and the
+// values of the bucket read by the caller stay valid.
+func (b *Bucket) updateIndexes(key, old []byte, hadOld bool, value []byte, hasNew bool) error {
+	b.tx.spillHeld++
+	defer func() { b.tx.spillHeld-- }()
+
+	for _, idx := range b.indexes {
+		var oldKeys, newKeys [][]byte
+		if hadOld {
+			oldKeys = idx.Keys(key, old)
+		}
+		if hasNew {
+			newKeys = idx.Keys(key, value)
+		}
+		for _, ik := range oldKeys {
+			if !containsKey(newKeys, ik) {
+				if err := b.tx.deleteIndexEntry(idx, ik, key); err != nil {
+					return err
+				}
+			}
+		}
+		for _, ik := range newKeys {
+			if !containsKey(oldKeys, ik) {
+				if err := b.tx.putIndexEntry(idx, ik, key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// putIndexEntry adds a key of the indexed bucket to an index key.
+func (tx *Tx) putIndexEntry(idx *Index, indexKey, key []byte) error {
+	ib, err := tx.CreateBucketIfNotExists([]byte(idx.Name))
+	if err != nil {
+		return fmt.Errorf("index %q: %w", idx.Name, err)
+	}
+	entries, err := ib.CreateBucketIfNotExists(indexKey)
+	if err != nil {
+		return fmt.Errorf("index %q: %w", idx.Name, err)
+	}
+	return entries.Put(key, []byte{})
+}
+
+// deleteIndexEntry removes a key of the indexed bucket from an index key,
+// and the index key once it has no keys left.
+func (tx *Tx) deleteIndexEntry(idx *Index, indexKey, key []byte) error {
+	ib := tx.Bucket([]byte(idx.Name))
+	if ib == nil {
+		return nil
+	}
+	entries := ib.Bucket(indexKey)
+	if entries == nil {
+		return nil
+	}
+	if err := entries.Delete(key); err != nil {
+		return err
+	}
+	if entries.Count() == 0 {
+		return ib.DeleteBucket(indexKey)
+	}
+	return nil
+}
+
+// dropIndexes deletes the indexes of a top-level bucket which is deleted.
+func (tx *Tx) dropIndexes(name []byte) error {
+	for _, idx := range tx.db.indexes[string(name)] {
+		if err := tx.DeleteBucket([]byte(idx.Name)); err != nil && err != errors.ErrBucketNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebuildIndex deletes the named index and indexes all the keys of its
+// bucket again, e.g. after the index was declared for a bucket which already
+// had keys, or after its Keys function changed.
+// Returns ErrIndexNotFound if the index isn't declared.
+func (tx *Tx) RebuildIndex(name string) error {
+	if tx.db == nil {
+		return errors.ErrTxClosed
+	} else if !tx.writable {
+		return errors.ErrTxNotWritable
+	}
+
+	var idx *Index
+	for _, list := range tx.db.indexes {
+		for _, i := range list {
+			if i.Name == name {
+				idx = i
+			}
+		}
+	}
+	if idx == nil {
+		return errors.ErrIndexNotFound
+	}
+	if err := tx.DeleteBucket([]byte(idx.Name)); err != nil && err != errors.ErrBucketNotFound {
+		return err
+	}
+	b := tx.Bucket([]byte(idx.Bucket))
+	if b == nil {
+		return nil
+	}
+
+	// Read the keys in batches, since writing the index may write out the
+	// dirty pages under the cursor.
+	now := time.Now().UnixNano()
+	var keys, values [][]byte
+	var next []byte
+	for {
+		keys, values = keys[:0], values[:0]
+		c := b.Cursor()
+		var k, v []byte
+		var flags uint32
+		if next == nil {
+			k, v, flags = c.first()
+		} else {
+			k, v, flags = c.seek(next)
+			if ref := &c.stack[len(c.stack)-1]; ref.index >= ref.count() {
+				k, v, flags = c.next()
+			}
+		}
+		for ; k != nil && len(keys) < rebuildBatchSize; k, v, flags = c.next() {
+			if (flags & common.BucketLeafFlag) != 0 {
+				continue
+			} else if (flags&common.ExpiringLeafFlag) != 0 && expired(v, now) {
+				continue
+			}
+			keys = append(keys, cloneBytes(k))
+			values = append(values, cloneBytes(b.indexedValue(v, flags)))
+		}
+		next = cloneBytes(k)
+
+		for i := range keys {
+			for _, ik := range idx.Keys(keys[i], values[i]) {
+				if err := tx.putIndexEntry(idx, ik, keys[i]); err != nil {
+					return err
+				}
+			}
+		}
+		if k == nil {
+			return nil
+		}
+	}
+}
+
+// containsKey returns whether keys contains key.
+func containsKey(keys [][]byte, key []byte) bool {
+	for _, k := range keys {
+		if bytes.Equal(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// openIndexes attaches the indexes of a top-level bucket when it's opened.
+func (b *Bucket) openIndexes(name []byte, child *Bucket) {
+	if b == &b.tx.root {
+		child.indexes = b.tx.db.indexes[string(name)]
+	}
+}
@@ -0,0 +1,219 @@
+package boltdb_test
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+// byColor indexes the values "color/size" of the widgets bucket by color, and
+// the values with a size by size.
+var byColor = []bolt.Index{
+	{
+		Name:   "widgets-by-color",
+		Bucket: "widgets",
+		Keys: func(_, v []byte) [][]byte {
+			color, _, _ := bytes.Cut(v, []byte("/"))
+			return [][]byte{color}
+		},
+	},
+	{
+		Name:   "widgets-by-size",
+		Bucket: "widgets",
+		Keys: func(_, v []byte) [][]byte {
+			if _, size, ok := bytes.Cut(v, []byte("/")); ok {
+				return [][]byte{size}
+			}
+			return nil
+		},
+	},
+}
+
+func lookup(t *testing.T, b *bolt.Bucket, index, indexKey string) []string {
+	var keys []string
+	err := b.Lookup(index, []byte(indexKey), func(k, v []byte) error {
+		keys = append(keys, string(k)+"="+string(v))
+		return nil
+	})
+	require.NoError(t, err)
+	return keys
+}
+
+func TestBucket_Index(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{Indexes: byColor})
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("a"), []byte("red/1")))
+		require.NoError(t, b.Put([]byte("b"), []byte("blue/2")))
+		require.NoError(t, b.Put([]byte("c"), []byte("red")))
+		require.NoError(t, b.PutWithTTL([]byte("d"), []byte("red/3"), time.Millisecond))
+		require.NoError(t, b.PutSorted([][]byte{[]byte("e"), []byte("f")}, [][]byte{[]byte("green/1"), []byte("red/1")}))
+
+		require.Equal(t, []string{"a=red/1", "c=red", "d=red/3", "f=red/1"}, lookup(t, b, "widgets-by-color", "red"))
+		require.Equal(t, []string{"a=red/1", "e=green/1", "f=red/1"}, lookup(t, b, "widgets-by-size", "1"))
+		require.ErrorIs(t, b.Lookup("unknown", []byte("red"), nil), berrors.ErrIndexNotFound)
+		return nil
+	})
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+
+		// Expired keys are skipped until they're reaped.
+		require.Equal(t, []string{"a=red/1", "c=red", "f=red/1"}, lookup(t, b, "widgets-by-color", "red"))
+		_, err := tx.ReapExpired(100)
+		require.NoError(t, err)
+
+		// The entries of the old value are replaced.
+		require.NoError(t, b.Put([]byte("a"), []byte("blue/1")))
+		require.NoError(t, b.Delete([]byte("c")))
+		c := b.Cursor()
+		k, _ := c.Seek([]byte("e"))
+		require.Equal(t, []byte("e"), k)
+		require.NoError(t, c.Delete())
+		require.NoError(t, b.DeleteRange([]byte("f"), nil))
+
+		require.Empty(t, lookup(t, b, "widgets-by-color", "red"))
+		require.Equal(t, []string{"a=blue/1", "b=blue/2"}, lookup(t, b, "widgets-by-color", "blue"))
+
+		// The index keys without entries are deleted.
+		var indexKeys []string
+		require.NoError(t, tx.Bucket([]byte("widgets-by-color")).ForEach(func(k, _ []byte) error {
+			indexKeys = append(indexKeys, string(k))
+			return nil
+		}))
+		require.Equal(t, []string{"blue"}, indexKeys)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The changes of a rolled back transaction are rolled back in the index.
+	err = db.Update(func(tx *bolt.Tx) error {
+		require.NoError(t, tx.Bucket([]byte("widgets")).Put([]byte("g"), []byte("red/2")))
+		return fmt.Errorf("rollback")
+	})
+	require.Error(t, err)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Empty(t, lookup(t, b, "widgets-by-color", "red"))
+
+		var entries []string
+		require.NoError(t, b.ForEachIndex("widgets-by-size", nil, nil, func(ik, k, v []byte) error {
+			entries = append(entries, string(ik)+":"+string(k))
+			return nil
+		}))
+		require.Equal(t, []string{"1:a", "2:b"}, entries)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Deleting the bucket deletes its indexes.
+	err = db.Update(func(tx *bolt.Tx) error {
+		require.NoError(t, tx.DeleteBucket([]byte("widgets")))
+		require.Nil(t, tx.Bucket([]byte("widgets-by-color")))
+		require.Nil(t, tx.Bucket([]byte("widgets-by-size")))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestBucket_ForEachIndex(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{Indexes: byColor, MaxDirtyBytes: 16 * 1024})
+
+	colors := []string{"blue", "green", "red", "yellow"}
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 2000; i++ {
+			v := fmt.Sprintf("%s/%d", colors[i%len(colors)], i%7)
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), []byte(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	db.MustCheck()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		var keys []string
+		err := b.ForEachIndex("widgets-by-color", []byte("green"), []byte("red"), func(ik, k, v []byte) error {
+			require.Equal(t, "green", string(ik))
+			require.True(t, bytes.HasPrefix(v, ik))
+			keys = append(keys, string(k))
+			return nil
+		})
+		require.NoError(t, err)
+		require.Len(t, keys, 500)
+		require.True(t, sort.StringsAreSorted(keys))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestTx_RebuildIndex(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 2500; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), []byte(fmt.Sprintf("red/%d", i%2))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	path := db.Path()
+	db.MustClose()
+
+	// Declare the indexes for the existing keys.
+	idb, err := bolt.Open(path, 0600, &bolt.Options{Indexes: byColor})
+	require.NoError(t, err)
+	defer idb.Close()
+	err = idb.Update(func(tx *bolt.Tx) error {
+		require.Empty(t, lookup(t, tx.Bucket([]byte("widgets")), "widgets-by-color", "red"))
+		require.NoError(t, tx.RebuildIndex("widgets-by-color"))
+		require.ErrorIs(t, tx.RebuildIndex("unknown"), berrors.ErrIndexNotFound)
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = idb.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Len(t, lookup(t, b, "widgets-by-color", "red"), 2500)
+		require.Empty(t, lookup(t, b, "widgets-by-size", "1"))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestOpen_InvalidIndexes(t *testing.T) {
+	keys := func(_, v []byte) [][]byte { return [][]byte{v} }
+	for _, indexes := range [][]bolt.Index{
+		{{Name: "idx", Bucket: "widgets"}},
+		{{Name: "idx", Bucket: "widgets", Keys: keys}, {Name: "idx", Bucket: "woojits", Keys: keys}},
+		{{Name: "idx", Bucket: "widgets", Keys: keys}, {Name: "widgets", Bucket: "woojits", Keys: keys}},
+	} {
+		_, err := bolt.Open(filepath.Join(t.TempDir(), "db"), 0600, &bolt.Options{Indexes: indexes})
+		require.Error(t, err)
+	}
+}
@@ -2,6 +2,7 @@ package common
 
 import (
 	"fmt"
+	"math"
 	"unsafe"
 )
 
@@ -49,6 +50,180 @@ func (b *InBucket) InlinePage(v []byte) *Page {
 	return (*Page)(unsafe.Pointer(&v[BucketHeaderSize]))
 }
 
+// InlinePageSize returns the size of the inline page stored in v, which ends
+// with the data of its last element.
+func (b *InBucket) InlinePageSize(v []byte) int {
+	p := b.InlinePage(v)
+	if p.Count() == 0 {
+		return int(PageHeaderSize)
+	}
+	e := p.LeafPageElement(p.Count() - 1)
+	off := uintptr(unsafe.Pointer(e)) - uintptr(unsafe.Pointer(p))
+	return int(off) + int(e.Pos()+e.Ksize()+e.Vsize())
+}
+
 func (b *InBucket) String() string {
 	return fmt.Sprintf("<pgid=%d,seq=%d>", b.root, b.sequence)
 }
+
+const BucketExtSize = int(unsafe.Sizeof(InBucketExt{}))
+
+// InBucketExt is the extension of the bucket header. It's stored at the end
+// of the bucket value, after the inline page of inline buckets, so that
+// versions which don't know about it ignore it, and it's followed by the
+// compression dictionary and the user metadata of the bucket, if any. The
+// buckets written before it was added have a zero extension.
+type InBucketExt struct {
+	version     uint64 // id of the last transaction which changed the bucket
+	nextExpiry  int64  // no key of the bucket or its nested buckets expires before, 0 if none expires
+	count       uint64 // number of keys of the bucket, if bucketExtCounted is set
+	flags       uint32
+	dictSize    uint32 // size of the compression dictionary after the extension
+	metaSize    uint32 // size of the user metadata after the dictionary
+	fillPercent uint32 // fill percent of the bucket in thousandths, 0 if not set
+}
+
+const (
+	// bucketExtCounted is set once the keys of the bucket are counted. The
+	// buckets written before the count was added aren't counted.
+	bucketExtCounted = 0x01
+	// bucketExtBlobs is set once a value is streamed into the bucket, so that
+	// the buckets without streamed values aren't scanned when deleted.
+	bucketExtBlobs = 0x02
+	// bucketExtCompressed is set once a value is compressed with the
+	// dictionary of the bucket, so that the buckets without compressed
+	// values aren't scanned when their dictionary changes.
+	bucketExtCompressed = 0x04
+)
+
+// LoadBucketExt returns the extension of the bucket stored in v, which must
+// be aligned.
+func LoadBucketExt(v []byte) InBucketExt {
+	var ext InBucketExt
+	off := BucketHeaderSize
+	if b := LoadBucket(v); b.RootPage() == 0 {
+		off += b.InlinePageSize(v)
+	}
+	if off < len(v) {
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(&ext)), BucketExtSize), v[off:])
+	}
+	return ext
+}
+
+// LoadBucketMeta returns the user metadata of the bucket stored in v, which
+// must be aligned, or nil if it has none.
+func LoadBucketMeta(v []byte) []byte {
+	ext := LoadBucketExt(v)
+	if ext.metaSize == 0 {
+		return nil
+	}
+	end := len(v)
+	return v[end-int(ext.metaSize) : end]
+}
+
+// LoadBucketDict returns the compression dictionary of the bucket stored in
+// v, which must be aligned, or nil if it has none.
+func LoadBucketDict(v []byte) []byte {
+	ext := LoadBucketExt(v)
+	if ext.dictSize == 0 {
+		return nil
+	}
+	end := len(v) - int(ext.metaSize)
+	return v[end-int(ext.dictSize) : end]
+}
+
+// Size returns the size of the extension and of the compression dictionary
+// and user metadata after it.
+func (e *InBucketExt) Size() int {
+	return BucketExtSize + int(e.dictSize) + int(e.metaSize)
+}
+
+// Write writes the extension to the end of v, before the compression
+// dictionary and the user metadata.
+func (e *InBucketExt) Write(v []byte) {
+	copy(v[len(v)-e.Size():], unsafe.Slice((*byte)(unsafe.Pointer(e)), BucketExtSize))
+}
+
+func (e *InBucketExt) Version() uint64 {
+	return e.version
+}
+
+func (e *InBucketExt) SetVersion(v uint64) {
+	e.version = v
+}
+
+func (e *InBucketExt) NextExpiry() int64 {
+	return e.nextExpiry
+}
+
+func (e *InBucketExt) SetNextExpiry(v int64) {
+	e.nextExpiry = v
+}
+
+// LowerNextExpiry lowers the next expiry to v, unless it's already lower.
+func (e *InBucketExt) LowerNextExpiry(v int64) {
+	if v != 0 && (e.nextExpiry == 0 || v < e.nextExpiry) {
+		e.nextExpiry = v
+	}
+}
+
+// Count returns the number of keys of the bucket, and whether they're counted.
+func (e *InBucketExt) Count() (uint64, bool) {
+	return e.count, (e.flags & bucketExtCounted) != 0
+}
+
+// SetCount sets the number of keys of the bucket and marks it as counted.
+func (e *InBucketExt) SetCount(n uint64) {
+	e.count = n
+	e.flags |= bucketExtCounted
+}
+
+// HasBlobs returns whether values were streamed into the bucket.
+func (e *InBucketExt) HasBlobs() bool {
+	return (e.flags & bucketExtBlobs) != 0
+}
+
+func (e *InBucketExt) SetHasBlobs() {
+	e.flags |= bucketExtBlobs
+}
+
+// HasCompressed returns whether values of the bucket were compressed with
+// its dictionary.
+func (e *InBucketExt) HasCompressed() bool {
+	return (e.flags & bucketExtCompressed) != 0
+}
+
+func (e *InBucketExt) SetHasCompressed(v bool) {
+	if v {
+		e.flags |= bucketExtCompressed
+	} else {
+		e.flags &^= bucketExtCompressed
+	}
+}
+
+// DictSize returns the size of the compression dictionary of the bucket.
+func (e *InBucketExt) DictSize() int {
+	return int(e.dictSize)
+}
+
+func (e *InBucketExt) SetDictSize(n int) {
+	e.dictSize = uint32(n)
+}
+
+// MetaSize returns the size of the user metadata of the bucket.
+func (e *InBucketExt) MetaSize() int {
+	return int(e.metaSize)
+}
+
+func (e *InBucketExt) SetMetaSize(n int) {
+	e.metaSize = uint32(n)
+}
+
+// FillPercent returns the fill percent set for the bucket, or 0 if none is.
+func (e *InBucketExt) FillPercent() float64 {
+	return float64(e.fillPercent) / 1000
+}
+
+func (e *InBucketExt) SetFillPercent(v float64) {
+	e.fillPercent = uint32(math.Round(v * 1000))
+}
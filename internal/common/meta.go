@@ -9,6 +9,36 @@ import (
 	"github.com/openkvlab/boltdb/errors"
 )
 
+// MetaFlagPageChecksums is set in the meta flags when every page except the
+// meta pages ends with a CRC32C of its content.
+const MetaFlagPageChecksums = 0x01
+
+// MetaFlagPageTxids is set in the meta flags when every page except the meta
+// pages stores the id of the transaction which wrote it.
+const MetaFlagPageTxids = 0x02
+
+// MetaFlagFreelistSpans is set in the meta flags when the freelist page
+// stores spans of contiguous free pages instead of every free page id, see
+// Page.FreelistPagePairs.
+const MetaFlagFreelistSpans = 0x04
+
+// MetaFlagFreelistSegments is set in the meta flags when the freelist page
+// stores the pages of the segments of the freelist, see
+// Page.FreelistPagePairs.
+const MetaFlagFreelistSegments = 0x08
+
+// MetaFlagsFormat are the meta flags known to this version, which all change
+// the format of the pages. A meta with any other flag fails validation.
+const MetaFlagsFormat = MetaFlagPageChecksums | MetaFlagPageTxids | MetaFlagFreelistSpans | MetaFlagFreelistSegments
+
+// VersionOf returns the data file format version of a meta with flags.
+func VersionOf(flags uint32) uint32 {
+	if flags&MetaFlagsFormat != 0 {
+		return VersionFlags
+	}
+	return Version
+}
+
 type Meta struct {
 	magic    uint32
 	version  uint32
@@ -19,13 +49,22 @@ type Meta struct {
 	pgid     Pgid
 	txid     Txid
 	checksum uint64
+
+	// deferred is the id of the deferred page, or 0 if all the pages of the
+	// deleted buckets are freed. It follows the checksum, so that the meta
+	// pages written before it was added read it as 0, and it's only part of
+	// the checksum when it's set.
+	deferred Pgid
 }
 
 // Validate checks the marker bytes and version of the meta page to ensure it matches this binary.
+// The flags unknown to this binary are a version mismatch too.
 func (m *Meta) Validate() error {
 	if m.magic != Magic {
 		return errors.ErrInvalid
-	} else if m.version != Version {
+	} else if m.version != Version && m.version != VersionFlags {
+		return errors.ErrVersionMismatch
+	} else if m.flags&^MetaFlagsFormat != 0 {
 		return errors.ErrVersionMismatch
 	} else if m.checksum != m.Sum64() {
 		return errors.ErrChecksum
@@ -42,6 +81,8 @@ func (m *Meta) Copy(dest *Meta) {
 func (m *Meta) Write(p *Page) {
 	if m.root.root >= m.pgid {
 		panic(fmt.Sprintf("root bucket pgid (%d) above high water mark (%d)", m.root.root, m.pgid))
+	} else if m.deferred >= m.pgid && m.deferred != 0 {
+		panic(fmt.Sprintf("deferred pgid (%d) above high water mark (%d)", m.deferred, m.pgid))
 	} else if m.freelist >= m.pgid && m.freelist != PgidNoFreelist {
 		// TODO: reject pgidNoFreeList if !NoFreelistSync
 		panic(fmt.Sprintf("freelist pgid (%d) above high water mark (%d)", m.freelist, m.pgid))
@@ -51,6 +92,9 @@ func (m *Meta) Write(p *Page) {
 	p.id = Pgid(m.txid % 2)
 	p.SetFlags(MetaPageFlag)
 
+	// The version follows the flags, which may have changed.
+	m.version = VersionOf(m.flags)
+
 	// Calculate the checksum.
 	m.checksum = m.Sum64()
 
@@ -61,6 +105,9 @@ func (m *Meta) Write(p *Page) {
 func (m *Meta) Sum64() uint64 {
 	var h = fnv.New64a()
 	_, _ = h.Write((*[unsafe.Offsetof(Meta{}.checksum)]byte)(unsafe.Pointer(m))[:])
+	if m.deferred != 0 {
+		_, _ = h.Write((*[unsafe.Sizeof(m.deferred)]byte)(unsafe.Pointer(&m.deferred))[:])
+	}
 	return h.Sum64()
 }
 
@@ -96,6 +143,29 @@ func (m *Meta) SetFlags(v uint32) {
 	m.flags = v
 }
 
+// HasPageChecksums returns true if the pages of the database are checksummed.
+func (m *Meta) HasPageChecksums() bool {
+	return m.flags&MetaFlagPageChecksums != 0
+}
+
+// HasPageTxids returns true if the pages of the database store the id of the
+// transaction which wrote them.
+func (m *Meta) HasPageTxids() bool {
+	return m.flags&MetaFlagPageTxids != 0
+}
+
+// HasFreelistSpans returns true if the freelist page stores spans of free
+// pages.
+func (m *Meta) HasFreelistSpans() bool {
+	return m.flags&MetaFlagFreelistSpans != 0
+}
+
+// HasFreelistSegments returns true if the freelist page stores the pages of
+// the segments of the freelist.
+func (m *Meta) HasFreelistSegments() bool {
+	return m.flags&MetaFlagFreelistSegments != 0
+}
+
 func (m *Meta) SetRootBucket(b InBucket) {
 	m.root = b
 }
@@ -116,6 +186,16 @@ func (m *Meta) IsFreelistPersisted() bool {
 	return m.freelist != PgidNoFreelist
 }
 
+// Deferred returns the id of the deferred page listing the subtrees of the
+// deleted buckets whose pages aren't freed yet, or 0 if there are none.
+func (m *Meta) Deferred() Pgid {
+	return m.deferred
+}
+
+func (m *Meta) SetDeferred(v Pgid) {
+	m.deferred = v
+}
+
 func (m *Meta) Pgid() Pgid {
 	return m.pgid
 }
@@ -157,5 +237,8 @@ func (m *Meta) Print(w io.Writer) {
 	fmt.Fprintf(w, "HWM:        <pgid=%d>\n", m.pgid)
 	fmt.Fprintf(w, "Txn ID:     %d\n", m.txid)
 	fmt.Fprintf(w, "Checksum:   %016x\n", m.checksum)
+	if m.deferred != 0 {
+		fmt.Fprintf(w, "Deferred:   <pgid=%d>\n", m.deferred)
+	}
 	fmt.Fprintf(w, "\n")
 }
@@ -1,10 +1,14 @@
 package common
 
 import (
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"os"
 	"sort"
 	"unsafe"
+
+	"github.com/openkvlab/boltdb/errors"
 )
 
 const PageHeaderSize = unsafe.Sizeof(Page{})
@@ -15,15 +19,43 @@ const BranchPageElementSize = unsafe.Sizeof(branchPageElement{})
 const LeafPageElementSize = unsafe.Sizeof(leafPageElement{})
 const pgidSize = unsafe.Sizeof(Pgid(0))
 
+// PageChecksumSize is the number of bytes reserved at the end of every
+// non-meta page for its checksum, when page checksums are enabled.
+const PageChecksumSize = 4
+
+// PageTxidSize is the number of bytes reserved at the end of every non-meta
+// page for the id of the transaction which wrote it, when page txids are
+// enabled. It's stored right before the checksum, if there is one.
+const PageTxidSize = 8
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
 const (
 	BranchPageFlag   = 0x01
 	LeafPageFlag     = 0x02
 	MetaPageFlag     = 0x04
 	FreelistPageFlag = 0x10
+	// BlobPageFlag marks the chunks of a value streamed with PutReader. The
+	// id of the next chunk follows the page header, and then the data.
+	BlobPageFlag = 0x20
+	// DeferredPageFlag marks the pages listing the subtrees of the deleted
+	// buckets whose pages aren't freed yet, see Meta.Deferred.
+	DeferredPageFlag = 0x40
 )
 
 const (
 	BucketLeafFlag = 0x01
+	// ExpiringLeafFlag marks the values which are prefixed by the time they
+	// expire at, in Unix nanoseconds.
+	ExpiringLeafFlag = 0x02
+	// BlobLeafFlag marks the values which are stored in a chain of blob
+	// pages. The value holds the id of the first page and the size.
+	BlobLeafFlag = 0x04
+	// CompressedLeafFlag marks the values which are compressed with the
+	// dictionary of their bucket, or without one if it has none. The value
+	// holds the size of the uncompressed value as a uvarint, followed by the
+	// DEFLATE stream.
+	CompressedLeafFlag = 0x08
 )
 
 type Pgid uint64
@@ -54,6 +86,10 @@ func (p *Page) Typ() string {
 		return "meta"
 	} else if p.IsFreelistPage() {
 		return "freelist"
+	} else if p.IsBlobPage() {
+		return "blob"
+	} else if p.IsDeferredPage() {
+		return "deferred"
 	}
 	return fmt.Sprintf("unknown<%02x>", p.flags)
 }
@@ -74,7 +110,15 @@ func (p *Page) IsFreelistPage() bool {
 	return p.flags == FreelistPageFlag
 }
 
+func (p *Page) IsBlobPage() bool {
+	return p.flags == BlobPageFlag
+}
+
 // Meta returns a pointer to the metadata section of the page.
+func (p *Page) IsDeferredPage() bool {
+	return p.flags == DeferredPageFlag
+}
+
 func (p *Page) Meta() *Meta {
 	return (*Meta)(UnsafeAdd(unsafe.Pointer(p), unsafe.Sizeof(*p)))
 }
@@ -85,7 +129,9 @@ func (p *Page) FastCheck(id Pgid) {
 	Assert(p.IsBranchPage() ||
 		p.IsLeafPage() ||
 		p.IsMetaPage() ||
-		p.IsFreelistPage(),
+		p.IsFreelistPage() ||
+		p.IsBlobPage() ||
+		p.IsDeferredPage(),
 		"page %v: has unexpected type/flags: %x", p.id, p.flags)
 }
 
@@ -154,12 +200,127 @@ func (p *Page) FreelistPageIds() []Pgid {
 	return ids
 }
 
+// FreelistPagePairs returns the pairs of page ids of a freelist page written
+// with MetaFlagFreelistSpans or MetaFlagFreelistSegments, whose count is the
+// number of pairs. Both flags share the layout, not the meaning of the pairs:
+// with MetaFlagFreelistSpans, each pair is the first page id and the number
+// of pages of a span of free pages, and with MetaFlagFreelistSegments, it's
+// the index of a segment and the id of the page holding its page ids, see
+// FreelistSegmentIDs.
+func (p *Page) FreelistPagePairs() []Pgid {
+	Assert(p.IsFreelistPage(), fmt.Sprintf("can't get freelist page pairs from a non-freelist page: %2x", p.flags))
+
+	idx, count := p.FreelistPageCount()
+
+	if count == 0 {
+		return nil
+	}
+
+	data := UnsafeIndex(unsafe.Pointer(p), unsafe.Sizeof(*p), pgidSize, idx)
+	pairs := unsafe.Slice((*Pgid)(data), 2*count)
+
+	return pairs
+}
+
+// FreelistSegmentIDs returns the number of page ids covered by each segment
+// of a freelist written with MetaFlagFreelistSegments: segment i holds the
+// free page ids from i*n to (i+1)*n-1 on a single page, whichever the page
+// trailer.
+func FreelistSegmentIDs(pageSize int) int {
+	return (pageSize - int(PageHeaderSize) - PageTxidSize - PageChecksumSize) / int(pgidSize)
+}
+
+// DeferredFree is an entry of a deferred page: the pages of the subtree of a
+// deleted bucket at Pgid which are left to free, which are its elements from
+// Index on, followed by the page itself.
+type DeferredFree struct {
+	Pgid  Pgid
+	Index uint64
+}
+
+// DeferredFreeSize is the size of an entry of a deferred page.
+const DeferredFreeSize = int(unsafe.Sizeof(DeferredFree{}))
+
+// DeferredFrees returns the entries of a deferred page. Like on freelist
+// pages, a count of 0xFFFF means that the first entry holds the count.
+func (p *Page) DeferredFrees() []DeferredFree {
+	Assert(p.IsDeferredPage(), fmt.Sprintf("can't get deferred frees from a non-deferred page: %2x", p.flags))
+	data := UnsafeAdd(unsafe.Pointer(p), unsafe.Sizeof(*p))
+	if p.count == 0xFFFF {
+		entries := unsafe.Slice((*DeferredFree)(data), 1)
+		return unsafe.Slice((*DeferredFree)(data), int(entries[0].Pgid)+1)[1:]
+	}
+	return unsafe.Slice((*DeferredFree)(data), int(p.count))
+}
+
+// SetDeferredFrees writes the entries of a deferred page, which must be large
+// enough to hold them and their count.
+func (p *Page) SetDeferredFrees(entries []DeferredFree) {
+	p.flags = DeferredPageFlag
+	data := UnsafeAdd(unsafe.Pointer(p), unsafe.Sizeof(*p))
+	if len(entries) < 0xFFFF {
+		p.count = uint16(len(entries))
+		copy(unsafe.Slice((*DeferredFree)(data), len(entries)), entries)
+		return
+	}
+	p.count = 0xFFFF
+	dst := unsafe.Slice((*DeferredFree)(data), len(entries)+1)
+	dst[0] = DeferredFree{Pgid: Pgid(len(entries))}
+	copy(dst[1:], entries)
+}
+
 // dump writes n bytes of the page to STDERR as hex output.
 func (p *Page) hexdump(n int) {
 	buf := UnsafeByteSlice(unsafe.Pointer(p), 0, 0, n)
 	fmt.Fprintf(os.Stderr, "%x\n", buf)
 }
 
+// bytes returns the whole page, including its overflow pages.
+func (p *Page) bytes(pageSize int) []byte {
+	return UnsafeByteSlice(unsafe.Pointer(p), 0, 0, (int(p.overflow)+1)*pageSize)
+}
+
+// SetChecksum computes the CRC32C of the page and stores it in the last
+// PageChecksumSize bytes of the page.
+func (p *Page) SetChecksum(pageSize int) {
+	buf := p.bytes(pageSize)
+	n := len(buf) - PageChecksumSize
+	binary.LittleEndian.PutUint32(buf[n:], crc32.Checksum(buf[:n], castagnoliTable))
+}
+
+// VerifyChecksum returns an error if the checksum stored at the end of the
+// page doesn't match its content.
+func (p *Page) VerifyChecksum(pageSize int) error {
+	buf := p.bytes(pageSize)
+	n := len(buf) - PageChecksumSize
+	if binary.LittleEndian.Uint32(buf[n:]) != crc32.Checksum(buf[:n], castagnoliTable) {
+		return errors.ErrPageChecksum
+	}
+	return nil
+}
+
+// writtenTxidBytes returns the bytes of the page holding the txid of the
+// transaction which wrote it.
+func (p *Page) writtenTxidBytes(pageSize int, checksummed bool) []byte {
+	buf := p.bytes(pageSize)
+	end := len(buf)
+	if checksummed {
+		end -= PageChecksumSize
+	}
+	return buf[end-PageTxidSize : end]
+}
+
+// WrittenTxid returns the id of the transaction which wrote the page.
+func (p *Page) WrittenTxid(pageSize int, checksummed bool) Txid {
+	return Txid(binary.LittleEndian.Uint64(p.writtenTxidBytes(pageSize, checksummed)))
+}
+
+// SetWrittenTxid stores the id of the transaction writing the page. It must
+// be called before SetChecksum.
+func (p *Page) SetWrittenTxid(pageSize int, checksummed bool, txid Txid) {
+	binary.LittleEndian.PutUint64(p.writtenTxidBytes(pageSize, checksummed), uint64(txid))
+}
+
 func (p *Page) PageElementSize() uintptr {
 	if p.IsLeafPage() {
 		return LeafPageElementSize
@@ -312,6 +473,10 @@ func (n *leafPageElement) IsBucketEntry() bool {
 	return n.flags&uint32(BucketLeafFlag) != 0
 }
 
+func (n *leafPageElement) IsBlobEntry() bool {
+	return n.flags&uint32(BlobLeafFlag) != 0
+}
+
 func (n *leafPageElement) Bucket() *InBucket {
 	if n.IsBucketEntry() {
 		return LoadBucket(n.Value())
@@ -323,9 +488,14 @@ func (n *leafPageElement) Bucket() *InBucket {
 // PageInfo represents human readable information about a page.
 type PageInfo struct {
 	ID            int
-	Type          string
-	Count         int
+	Type          string // "meta", "freelist", "branch", "leaf" or "free"
+	Count         int    // number of elements
 	OverflowCount int
+
+	// The fields below are only set for the pages of buckets.
+	ParentID int      // the branch page, or the leaf page holding the bucket, referencing the page
+	Depth    int      // depth of the page in the tree of its bucket, 0 for its root
+	Bucket   [][]byte // names of the nested buckets leading to the bucket of the page
 }
 
 type Pgids []Pgid
@@ -5,6 +5,8 @@ import (
 	"sort"
 	"testing"
 	"testing/quick"
+
+	"github.com/openkvlab/boltdb/errors"
 )
 
 // Ensure that the page type can be returned in human readable format.
@@ -31,6 +33,30 @@ func TestPage_dump(t *testing.T) {
 	(&Page{id: 256}).hexdump(16)
 }
 
+// Ensure that a page checksum detects a flipped bit anywhere in the page.
+func TestPage_Checksum(t *testing.T) {
+	const pageSize = 1024
+	buf := make([]byte, pageSize*2)
+	p := LoadPage(buf)
+	p.SetId(7)
+	p.SetFlags(LeafPageFlag)
+	p.SetOverflow(1)
+	buf[pageSize+100] = 0xff
+
+	p.SetChecksum(pageSize)
+	if err := p.VerifyChecksum(pageSize); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, pos := range []int{0, 50, pageSize + 100, len(buf) - PageChecksumSize - 1} {
+		buf[pos] ^= 0x01
+		if err := p.VerifyChecksum(pageSize); err != errors.ErrPageChecksum {
+			t.Fatalf("byte %d: expected checksum error, got %v", pos, err)
+		}
+		buf[pos] ^= 0x01
+	}
+}
+
 func TestPgids_merge(t *testing.T) {
 	a := Pgids{4, 5, 6, 10, 11, 12, 13, 27}
 	b := Pgids{1, 3, 8, 9, 25, 30}
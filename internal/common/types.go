@@ -12,6 +12,11 @@ const MaxMmapStep = 1 << 30 // 1GB
 // Version represents the data file format version.
 const Version uint32 = 2
 
+// VersionFlags is the data file format version of the files whose meta sets
+// any of MetaFlagsFormat, so that the versions of the package which don't
+// know the flags refuse to open them instead of misreading their pages.
+const VersionFlags uint32 = 3
+
 // Magic represents a marker value to indicate that a file is a Bolt DB.
 const Magic uint32 = 0xED0CDAED
 
@@ -31,6 +36,11 @@ const (
 	DefaultMaxBatchSize  int = 1000
 	DefaultMaxBatchDelay     = 10 * time.Millisecond
 	DefaultAllocSize         = 16 * 1024 * 1024
+
+	DefaultAutoCompactInterval  = time.Minute
+	DefaultAutoCompactFreeRatio = 0.5
+
+	DefaultWALCheckpointSize = 64 * 1024 * 1024
 )
 
 // DefaultPageSize is the default page size for db which is set to the OS page size.
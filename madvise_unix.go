@@ -0,0 +1,24 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package boltdb
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// madviseFlag returns the madvise flag of the memory map for advice a.
+func madviseFlag(a Advice) int {
+	switch a {
+	case AdviceNormal:
+		return unix.MADV_NORMAL
+	case AdviceSequential:
+		return unix.MADV_SEQUENTIAL
+	case AdviceWillNeed:
+		return unix.MADV_WILLNEED
+	case AdviceDontNeed:
+		return unix.MADV_DONTNEED
+	default:
+		return unix.MADV_RANDOM
+	}
+}
@@ -0,0 +1,72 @@
+package boltdb
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// openMemory opens a new, empty database whose pages are held in db.image
+// instead of a data file. There's nothing to lock, map or sync, so options
+// which only apply to the data file, such as Timeout, Mlock, MmapChunkSize,
+// Encryption, WAL, GroupCommitInterval and AutoCompact, are ignored.
+func (db *DB) openMemory(options *Options) (*DB, error) {
+	db.inMemory = true
+	db.path = MemoryPath
+	db.cipher = nil
+	db.Mlock = false
+	db.ops.writeAt = db.memoryWriteAt
+
+	if db.pageSize = options.PageSize; db.pageSize == 0 {
+		db.pageSize = common.DefaultPageSize
+	}
+
+	// Initialize the meta pages, the buffer is grown by mmap below.
+	db.image = make([]byte, db.pageSize*4)
+	if err := db.init(); err != nil {
+		_ = db.close()
+		return nil, err
+	}
+
+	if err := db.mmap(options.InitialMmapSize); err != nil {
+		_ = db.close()
+		return nil, err
+	}
+
+	if db.PreLoadFreelist {
+		db.loadFreelist()
+	}
+
+	if options.MaxReadTxDuration > 0 {
+		db.startReadTxWatchdog(options)
+	}
+	return db, nil
+}
+
+// mmapMemory grows the buffer of an in-memory database to size bytes. The
+// caller must hold the mmap lock, so that no read transaction references the
+// old buffer.
+func (db *DB) mmapMemory(size int) {
+	if size > len(db.image) {
+		image := make([]byte, size)
+		copy(image, db.image)
+		db.image = image
+	}
+	db.data = (*[maxMapSize]byte)(unsafe.Pointer(&db.image[0]))
+	db.datasz = len(db.image)
+}
+
+// memoryWriteAt writes b to the buffer of an in-memory database at offset
+// off. Unlike a data file, the buffer is only grown by mmap.
+func (db *DB) memoryWriteAt(b []byte, off int64) (int, error) {
+	if off+int64(len(b)) > int64(len(db.image)) {
+		return 0, fmt.Errorf("write of %d bytes at offset %d beyond in-memory database of %d bytes", len(b), off, len(db.image))
+	}
+	// Meta pages are copied by new read transactions under the meta lock.
+	if off < int64(db.pageSize)*2 {
+		db.metalock.Lock()
+		defer db.metalock.Unlock()
+	}
+	return copy(db.image[off:], b), nil
+}
@@ -0,0 +1,76 @@
+package boltdb_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestOpen_Memory(t *testing.T) {
+	db, err := bolt.Open(bolt.MemoryPath, 0600, nil)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db.Close()) }()
+	require.Equal(t, bolt.MemoryPath, db.Path())
+
+	// Grow the database past the initial buffer.
+	for i := 0; i < 5000; i += 500 {
+		err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			for j := i; j < i+500; j++ {
+				if err := b.Put([]byte(fmt.Sprintf("%04d", j)), []byte(fmt.Sprintf("value-%d", j))); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+	}
+	requireWidgets(t, db, 5000)
+	err = db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			require.NoError(t, err)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The database can be copied to a file.
+	path := filepath.Join(t.TempDir(), "copy.db")
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	})
+	require.NoError(t, err)
+	cdb := btesting.MustOpenDBWithOption(t, path, nil)
+	requireWidgets(t, cdb.DB, 5000)
+
+	require.ErrorIs(t, db.Compact(), berrors.ErrInMemory)
+}
+
+func TestOpen_Memory_Separate(t *testing.T) {
+	db0, err := bolt.Open(bolt.MemoryPath, 0600, nil)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db0.Close()) }()
+	db1, err := bolt.Open(bolt.MemoryPath, 0600, nil)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, db1.Close()) }()
+
+	err = db0.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	})
+	require.NoError(t, err)
+	err = db1.View(func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket([]byte("widgets")))
+		return nil
+	})
+	require.NoError(t, err)
+}
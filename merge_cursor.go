@@ -0,0 +1,190 @@
+package boltdb
+
+import (
+	"bytes"
+)
+
+// MergePolicy decides which values a MergeCursor returns for a key held by
+// several of its buckets.
+type MergePolicy int
+
+const (
+	// MergeFirst returns the key once, with the value of the first bucket
+	// holding it.
+	MergeFirst MergePolicy = iota
+	// MergeLast returns the key once, with the value of the last bucket
+	// holding it.
+	MergeLast
+	// MergeAll returns the key once per bucket holding it, in the order of
+	// the buckets when moving forward and in reverse order when moving
+	// backward.
+	MergeAll
+)
+
+// MergeCursor iterates over the union of the keys of several buckets, e.g.
+// of a dataset sharded into buckets, in lexicographical order. It's built on
+// a cursor per bucket, which may be bounded, see Bucket.RangeCursor, and
+// follows the same rules: keys and values are only valid for the life of the
+// transaction, and nested buckets have a nil value.
+type MergeCursor struct {
+	cursors []*Cursor
+	policy  MergePolicy
+
+	// keys and values are the current elements of the cursors, the next
+	// ones in the direction of the iteration. A nil key is exhausted.
+	keys    [][]byte
+	values  [][]byte
+	reverse bool
+
+	key    []byte // the current key, nil if the cursor isn't positioned
+	source int    // the index of the cursor of the current key
+}
+
+// NewMergeCursor returns a cursor over the union of the keys of the given
+// cursors, which it positions itself, with policy deciding which values are
+// returned for the keys held by several of them.
+func NewMergeCursor(policy MergePolicy, cursors ...*Cursor) *MergeCursor {
+	return &MergeCursor{
+		cursors: cursors,
+		policy:  policy,
+		keys:    make([][]byte, len(cursors)),
+		values:  make([][]byte, len(cursors)),
+	}
+}
+
+// First moves the cursor to the first key and returns it with its value, or
+// a nil key if all the buckets are empty.
+func (m *MergeCursor) First() (key []byte, value []byte) {
+	for i, c := range m.cursors {
+		m.keys[i], m.values[i] = c.First()
+	}
+	m.reverse = false
+	return m.pick()
+}
+
+// Last moves the cursor to the last key and returns it with its value, or a
+// nil key if all the buckets are empty.
+func (m *MergeCursor) Last() (key []byte, value []byte) {
+	for i, c := range m.cursors {
+		m.keys[i], m.values[i] = c.Last()
+	}
+	m.reverse = true
+	return m.pick()
+}
+
+// Seek moves the cursor to the first key which isn't less than seek and
+// returns it with its value, or a nil key if no keys follow.
+func (m *MergeCursor) Seek(seek []byte) (key []byte, value []byte) {
+	for i, c := range m.cursors {
+		m.keys[i], m.values[i] = c.Seek(seek)
+	}
+	m.reverse = false
+	return m.pick()
+}
+
+// Next moves the cursor to the next key and returns it with its value, or a
+// nil key at the end.
+func (m *MergeCursor) Next() (key []byte, value []byte) {
+	return m.step(false)
+}
+
+// Prev moves the cursor to the previous key and returns it with its value,
+// or a nil key at the beginning.
+func (m *MergeCursor) Prev() (key []byte, value []byte) {
+	return m.step(true)
+}
+
+// Source returns the index of the cursor the current key and value were
+// read from, or -1 if the cursor isn't positioned at a key.
+func (m *MergeCursor) Source() int {
+	if m.key == nil {
+		return -1
+	}
+	return m.source
+}
+
+// step moves the cursor to the next key in the given direction.
+func (m *MergeCursor) step(reverse bool) ([]byte, []byte) {
+	if m.key == nil {
+		return nil, nil
+	}
+	if reverse != m.reverse {
+		m.turn(reverse)
+		return m.pick()
+	}
+	for i, c := range m.cursors {
+		if m.consumed(i) {
+			m.keys[i], m.values[i] = m.move(c)
+		}
+	}
+	return m.pick()
+}
+
+// turn positions the cursors at the elements which follow the current one in
+// the new direction.
+func (m *MergeCursor) turn(reverse bool) {
+	m.reverse = reverse
+	for i, c := range m.cursors {
+		k, v := c.Seek(m.key)
+		// In the order of MergeAll, the duplicates of the current key in
+		// the cursors before it come before it.
+		if bytes.Equal(k, m.key) && m.policy == MergeAll && (i < m.source) == reverse && i != m.source {
+			m.keys[i], m.values[i] = k, v
+			continue
+		}
+		switch {
+		case !reverse && bytes.Equal(k, m.key):
+			k, v = c.Next()
+		case reverse && k == nil:
+			k, v = c.Last()
+		case reverse:
+			k, v = c.Prev()
+		}
+		m.keys[i], m.values[i] = k, v
+	}
+}
+
+// consumed returns whether the current element of the i-th cursor was
+// returned already.
+func (m *MergeCursor) consumed(i int) bool {
+	if m.policy == MergeAll {
+		return i == m.source
+	}
+	return m.keys[i] != nil && bytes.Equal(m.keys[i], m.key)
+}
+
+// move moves a cursor in the direction of the iteration.
+func (m *MergeCursor) move(c *Cursor) ([]byte, []byte) {
+	if m.reverse {
+		return c.Prev()
+	}
+	return c.Next()
+}
+
+// pick makes the next element of the cursors in the direction of the
+// iteration the current one, and returns it.
+func (m *MergeCursor) pick() ([]byte, []byte) {
+	// Ties go to the first cursor, or to the last one for MergeLast and for
+	// MergeAll moving backward.
+	last := m.policy == MergeLast || (m.policy == MergeAll && m.reverse)
+	m.key, m.source = nil, -1
+	for i, k := range m.keys {
+		if k == nil {
+			continue
+		}
+		var cmp int
+		if m.key != nil {
+			cmp = bytes.Compare(k, m.key)
+			if m.reverse {
+				cmp = -cmp
+			}
+		}
+		if m.key == nil || cmp < 0 || (cmp == 0 && last) {
+			m.key, m.source = k, i
+		}
+	}
+	if m.key == nil {
+		return nil, nil
+	}
+	return m.key, m.values[m.source]
+}
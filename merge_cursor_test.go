@@ -0,0 +1,102 @@
+package boltdb_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestMergeCursor(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	shards := map[string][]string{
+		"a": {"1", "3", "5", "7"},
+		"b": {"2", "3", "6"},
+		"c": {"3", "7", "9"},
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		for name, keys := range shards {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			for _, k := range keys {
+				if err := b.Put([]byte(k), []byte(name)); err != nil {
+					return err
+				}
+			}
+		}
+		_, err := tx.CreateBucket([]byte("empty"))
+		return err
+	})
+	require.NoError(t, err)
+
+	// scan returns the keys and values in the given direction.
+	scan := func(m *bolt.MergeCursor, reverse bool) []string {
+		var got []string
+		first, next := m.First, m.Next
+		if reverse {
+			first, next = m.Last, m.Prev
+		}
+		for k, v := first(); k != nil; k, v = next() {
+			got = append(got, fmt.Sprintf("%s=%s", k, v))
+		}
+		return got
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		cursors := func() []*bolt.Cursor {
+			var cursors []*bolt.Cursor
+			for _, name := range []string{"a", "empty", "b", "c"} {
+				cursors = append(cursors, tx.Bucket([]byte(name)).Cursor())
+			}
+			return cursors
+		}
+
+		m := bolt.NewMergeCursor(bolt.MergeFirst, cursors()...)
+		require.Equal(t, []string{"1=a", "2=b", "3=a", "5=a", "6=b", "7=a", "9=c"}, scan(m, false))
+		require.Equal(t, []string{"9=c", "7=a", "6=b", "5=a", "3=a", "2=b", "1=a"}, scan(m, true))
+
+		m = bolt.NewMergeCursor(bolt.MergeLast, cursors()...)
+		require.Equal(t, []string{"1=a", "2=b", "3=c", "5=a", "6=b", "7=c", "9=c"}, scan(m, false))
+		require.Equal(t, []string{"9=c", "7=c", "6=b", "5=a", "3=c", "2=b", "1=a"}, scan(m, true))
+
+		m = bolt.NewMergeCursor(bolt.MergeAll, cursors()...)
+		require.Equal(t, []string{"1=a", "2=b", "3=a", "3=b", "3=c", "5=a", "6=b", "7=a", "7=c", "9=c"}, scan(m, false))
+		require.Equal(t, []string{"9=c", "7=c", "7=a", "6=b", "5=a", "3=c", "3=b", "3=a", "2=b", "1=a"}, scan(m, true))
+
+		// Changing direction returns the previous element.
+		k, v := m.Seek([]byte("3"))
+		require.Equal(t, "3=a", fmt.Sprintf("%s=%s", k, v))
+		require.Equal(t, 0, m.Source())
+		k, v = m.Next()
+		require.Equal(t, "3=b", fmt.Sprintf("%s=%s", k, v))
+		require.Equal(t, 2, m.Source())
+		k, v = m.Prev()
+		require.Equal(t, "3=a", fmt.Sprintf("%s=%s", k, v))
+		k, v = m.Prev()
+		require.Equal(t, "2=b", fmt.Sprintf("%s=%s", k, v))
+		k, v = m.Next()
+		require.Equal(t, "3=a", fmt.Sprintf("%s=%s", k, v))
+
+		m = bolt.NewMergeCursor(bolt.MergeFirst, cursors()...)
+		k, _ = m.Seek([]byte("4"))
+		require.Equal(t, "5", string(k))
+		k, _ = m.Prev()
+		require.Equal(t, "3", string(k))
+		k, _ = m.Next()
+		require.Equal(t, "5", string(k))
+		k, _ = m.Seek([]byte("91"))
+		require.Nil(t, k)
+		require.Equal(t, -1, m.Source())
+
+		// Bounded cursors bound the merge.
+		m = bolt.NewMergeCursor(bolt.MergeFirst, tx.Bucket([]byte("a")).RangeCursor([]byte("2"), []byte("6")), tx.Bucket([]byte("c")).RangeCursor([]byte("2"), []byte("6")))
+		require.Equal(t, []string{"3=a", "5=a"}, scan(m, false))
+		require.Equal(t, []string{"5=a", "3=a"}, scan(m, true))
+		return nil
+	})
+	require.NoError(t, err)
+}
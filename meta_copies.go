@@ -0,0 +1,107 @@
+package boltdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// The meta page of each commit can also be written onto one of the last
+// pages of the file, see Options.MetaCopies. The copies are written round-robin
+// by transaction id, so they hold the meta of the last transactions. They
+// are beyond the high water mark, so they're overwritten once the database
+// grows, and the next commits write them at the new end of the file.
+
+// metaCopyScanSize is the size of the reads which scan the file for meta
+// copies.
+const metaCopyScanSize = 1 << 20
+
+// writeMetaCopy writes the meta page buf of tx onto its copy at the end of
+// the file.
+func (tx *Tx) writeMetaCopy(buf []byte) error {
+	db := tx.db
+	if db.metaCopies == 0 {
+		return nil
+	}
+	fileSize, err := db.fileSize()
+	if err != nil {
+		return err
+	}
+	end := max(fileSize/db.pageSize, int(tx.meta.Pgid())+db.metaCopies)
+	slot := int(tx.meta.Txid() % common.Txid(db.metaCopies))
+	if _, err := db.ops.writeAt(buf, int64(end-1-slot)*int64(db.pageSize)); err != nil {
+		return fmt.Errorf("write meta copy: %w", err)
+	}
+	tx.stats.IncWrite(1)
+	tx.stats.IncWriteBytes(int64(len(buf)))
+	return nil
+}
+
+// restoreMetaCopy restores the latest meta copy onto the meta pages when
+// both meta pages at the start of the file fail validation.
+func (db *DB) restoreMetaCopy() error {
+	r, fileSize, err := db.fileReader()
+	if err != nil {
+		return err
+	}
+	if _, _, err := db.getPageSizeFromFirstMeta(); err == nil {
+		return nil
+	}
+	if _, _, err := db.getPageSizeFromSecondMeta(); err == nil {
+		return nil
+	}
+
+	var (
+		best    *common.Meta
+		bestOff int64
+		buf     = make([]byte, metaCopyScanSize)
+	)
+	for pos := int64(0); pos < fileSize; pos += metaCopyScanSize {
+		n, err := r.ReadAt(buf, pos)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		for off := 0; off+minPageSize <= n; off += minPageSize {
+			m := db.pageInBuffer(buf[off:], 0).Meta()
+			if m.Validate() != nil || !validMetaCopy(m, pos+int64(off), fileSize) {
+				continue
+			}
+			if best == nil || m.Txid() > best.Txid() {
+				best = new(common.Meta)
+				m.Copy(best)
+				bestOff = pos + int64(off)
+			}
+		}
+	}
+	if best == nil {
+		return nil
+	}
+
+	page := make([]byte, best.PageSize())
+	if _, err := r.ReadAt(page, bestOff); err != nil {
+		return fmt.Errorf("read meta copy: %w", err)
+	}
+	// Both meta pages are restored, since the other one is corrupted too.
+	for id := common.Pgid(0); id < 2; id++ {
+		db.pageInBuffer(page, 0).SetId(id)
+		if _, err := db.file.WriteAt(page, int64(id)*int64(best.PageSize())); err != nil {
+			return fmt.Errorf("restore meta copy: %w", err)
+		}
+	}
+	if err := fdatasync(db); err != nil {
+		return err
+	}
+	db.recovery.MetaCopy = true
+	return nil
+}
+
+// validMetaCopy returns whether the valid meta m found at offset off is a
+// copy which fits the file of fileSize bytes.
+func validMetaCopy(m *common.Meta, off, fileSize int64) bool {
+	pageSize := int64(m.PageSize())
+	return pageSize >= minPageSize && pageSize <= minPageSize<<14 &&
+		pageSize&(pageSize-1) == 0 && off%pageSize == 0 && off >= 2*pageSize &&
+		int64(m.Pgid())*pageSize <= off && off+pageSize <= fileSize
+}
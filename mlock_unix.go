@@ -7,6 +7,15 @@ import "golang.org/x/sys/unix"
 
 // mlock locks memory of db file
 func mlock(db *DB, fileSize int) error {
+	if db.chunks.Load() != nil {
+		for _, b := range db.mappedChunks(fileSize) {
+			if err := unix.Mlock(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	sizeToLock := fileSize
 	if sizeToLock > db.datasz {
 		// Can't lock more than mmaped slice
@@ -20,6 +29,15 @@ func mlock(db *DB, fileSize int) error {
 
 // munlock unlocks memory of db file
 func munlock(db *DB, fileSize int) error {
+	if db.chunks.Load() != nil {
+		for _, b := range db.mappedChunks(fileSize) {
+			if err := unix.Munlock(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	if db.dataref == nil {
 		return nil
 	}
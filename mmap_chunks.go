@@ -0,0 +1,274 @@
+package boltdb
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// mmapAlignment is the alignment of the file offsets of chunks and of page
+// run mappings. It's a multiple of the OS page size and of the 64KB
+// allocation granularity of Windows.
+var mmapAlignment = max(os.Getpagesize(), 64*1024)
+
+// span is a separate mapping of a page run which crosses a chunk boundary.
+type span struct {
+	ref []byte // the whole mapping, starting at an aligned file offset
+	off int    // offset of the first page of the run within ref
+	seq uint64 // last transaction begun when it was last returned
+}
+
+// spanCacheSize is the number of page run mappings which are kept for reuse
+// once no open transaction may reference them.
+const spanCacheSize = 64
+
+// roundChunkSize rounds size up to a multiple of the page size and of
+// mmapAlignment, without going over maxMapSize.
+func (db *DB) roundChunkSize(size int) int {
+	align := int64(max(db.pageSize, mmapAlignment))
+	sz := (int64(size) + align - 1) / align * align
+	for sz > maxMapSize {
+		sz -= align
+	}
+	return int(sz)
+}
+
+// mmapChunks maps the data file in chunks of db.chunkSize bytes, so that at
+// least minsz bytes are mapped. Chunks which are already mapped are kept, so
+// unlike a full remap, growing the mapping doesn't wait for read transactions
+// to finish.
+func (db *DB) mmapChunks(minsz int) (err error) {
+	fileSize, err := db.fileSize()
+	if err != nil {
+		return err
+	}
+	size := max(fileSize, minsz)
+
+	var chunks [][]byte
+	if p := db.chunks.Load(); p != nil {
+		chunks = *p
+	}
+	n := int((int64(size) + int64(db.chunkSize) - 1) / int64(db.chunkSize))
+	if n <= len(chunks) {
+		return nil
+	}
+
+	initial := len(chunks) == 0
+	if initial {
+		// Nothing is mapped yet, so the meta pages are loaded below.
+		db.mmaplock.Lock()
+		defer db.mmaplock.Unlock()
+	}
+
+	grown := make([][]byte, len(chunks), n)
+	copy(grown, chunks)
+	for i := len(chunks); i < n; i++ {
+		b, err := mmapRegion(db, int64(i)*int64(db.chunkSize), db.chunkSize)
+		if err != nil {
+			for _, b := range grown[len(chunks):] {
+				_ = munmapRegion(b)
+			}
+			return fmt.Errorf("mmap chunk %d: %w", i, err)
+		}
+		grown = append(grown, b)
+	}
+	db.chunks.Store(&grown)
+
+	var mapped int
+	for _, b := range grown {
+		mapped += len(b)
+	}
+	db.datasz = mapped
+
+	if !initial {
+		if db.Mlock {
+			return db.mlock(fileSize)
+		}
+		return nil
+	}
+
+	// Perform unmmap on any error to reset all data fields.
+	defer func() {
+		if err != nil {
+			if unmapErr := db.munmap(); unmapErr != nil {
+				err = fmt.Errorf("%w; rollback unmap also failed: %v", err, unmapErr)
+			}
+		}
+	}()
+
+	db.data = (*[maxMapSize]byte)(unsafe.Pointer(&grown[0][0]))
+	if db.Mlock {
+		if err := db.mlock(fileSize); err != nil {
+			return err
+		}
+	}
+	return db.loadMeta()
+}
+
+// munmapChunks unmaps all chunks and page run mappings of the data file.
+func (db *DB) munmapChunks() error {
+	var err error
+	if p := db.chunks.Swap(nil); p != nil {
+		for _, b := range *p {
+			if uerr := munmapRegion(b); err == nil {
+				err = uerr
+			}
+		}
+	}
+
+	db.spanlock.Lock()
+	defer db.spanlock.Unlock()
+	for _, s := range db.spans {
+		if uerr := munmapRegion(s.ref); err == nil {
+			err = uerr
+		}
+	}
+	for _, s := range db.retired {
+		if uerr := munmapRegion(s.ref); err == nil {
+			err = uerr
+		}
+	}
+	db.spans = nil
+	db.retired = nil
+	return err
+}
+
+// mappedChunks returns the chunks of the data file, cut off after size bytes.
+func (db *DB) mappedChunks(size int) [][]byte {
+	p := db.chunks.Load()
+	if p == nil {
+		return nil
+	}
+	var chunks [][]byte
+	for _, b := range *p {
+		if size <= 0 {
+			break
+		}
+		if len(b) > size {
+			b = b[:size]
+		}
+		chunks = append(chunks, b)
+		size -= len(b)
+	}
+	return chunks
+}
+
+// chunkedPage returns the page at file offset pos from the mapped chunks. If
+// the page run crosses the end of its chunk, it's returned from a separate
+// mapping of the whole run instead.
+func (db *DB) chunkedPage(chunks [][]byte, id common.Pgid, pos int64) *common.Page {
+	chunk := chunks[pos/int64(db.chunkSize)]
+	off := int(pos % int64(db.chunkSize))
+	p := (*common.Page)(unsafe.Pointer(&chunk[off]))
+
+	end := pos + (int64(p.Overflow())+1)*int64(db.pageSize)
+	if end <= pos-int64(off)+int64(len(chunk)) {
+		return p
+	}
+
+	// Pages beyond the mapped size, such as a stale header of a free page,
+	// can't be read anyway, so don't map past it.
+	last := chunks[len(chunks)-1]
+	if mapped := int64(len(chunks)-1)*int64(db.chunkSize) + int64(len(last)); end > mapped {
+		end = mapped
+	}
+	if end <= pos-int64(off)+int64(len(chunk)) {
+		return p
+	}
+	if p, err := db.spanPage(id, pos, end); err == nil {
+		return p
+	}
+
+	// The address space may be exhausted, so read the run instead.
+	buf := make([]byte, end-pos)
+	if err := db.readRun(buf, id); err != nil {
+		panic("pread " + err.Error())
+	}
+	return (*common.Page)(unsafe.Pointer(&buf[0]))
+}
+
+// spanPage returns the page at file offset pos from a mapping which extends
+// at least up to end. Mappings are cached by page id, and since read
+// transactions may still reference them after the page is freed and reused,
+// they're only unmapped once those are closed, see releaseSpans.
+func (db *DB) spanPage(id common.Pgid, pos, end int64) (*common.Page, error) {
+	db.spanlock.Lock()
+	defer db.spanlock.Unlock()
+
+	s, ok := db.spans[id]
+	if !ok || int64(len(s.ref)-s.off) < end-pos {
+		start := pos - pos%int64(mmapAlignment)
+		b, err := mmapRegion(db, start, int(end-start))
+		if err != nil {
+			return nil, fmt.Errorf("mmap page %d: %w", id, err)
+		}
+		if ok {
+			db.retired = append(db.retired, s)
+		}
+		if db.spans == nil {
+			db.spans = make(map[common.Pgid]span)
+		}
+		s = span{ref: b, off: int(pos - start)}
+	}
+	s.seq = db.spanseq
+	db.spans[id] = s
+	return (*common.Page)(unsafe.Pointer(&s.ref[s.off])), nil
+}
+
+// trackSpans numbers a transaction which begins in chunked mode, so that the
+// page run mappings it may reference are kept until it's closed.
+func (db *DB) trackSpans(tx *Tx) {
+	if db.chunks.Load() == nil {
+		return
+	}
+	db.spanlock.Lock()
+	defer db.spanlock.Unlock()
+	db.spanseq++
+	tx.spanseq = db.spanseq
+	if db.spantxs == nil {
+		db.spantxs = make(map[uint64]struct{})
+	}
+	db.spantxs[tx.spanseq] = struct{}{}
+}
+
+// releaseSpans stops tracking a transaction which is closed, and unmaps the
+// page run mappings which no open transaction may reference anymore: the
+// ones replaced by a longer mapping, and the cached ones over spanCacheSize.
+func (db *DB) releaseSpans(tx *Tx) {
+	if tx.spanseq == 0 {
+		return
+	}
+	db.spanlock.Lock()
+	defer db.spanlock.Unlock()
+	delete(db.spantxs, tx.spanseq)
+	tx.spanseq = 0
+
+	// A mapping is only referenced by the transactions begun up to its seq.
+	oldest := db.spanseq + 1
+	for seq := range db.spantxs {
+		oldest = min(oldest, seq)
+	}
+
+	retired := db.retired[:0]
+	for _, s := range db.retired {
+		if s.seq < oldest {
+			_ = munmapRegion(s.ref)
+		} else {
+			retired = append(retired, s)
+		}
+	}
+	clear(db.retired[len(retired):])
+	db.retired = retired
+
+	for id, s := range db.spans {
+		if len(db.spans) <= spanCacheSize {
+			break
+		}
+		if s.seq < oldest {
+			_ = munmapRegion(s.ref)
+			delete(db.spans, id)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package boltdb_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+// Ensure that a database mapped in chunks can read values whose overflow
+// pages cross chunk boundaries.
+func TestDB_MmapChunkSize(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{MmapChunkSize: 64 * 1024})
+
+	value := func(i int) []byte {
+		return bytes.Repeat([]byte{byte(i)}, 10000+i*1000)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(fmt.Sprintf("%04d", i)), value(i))
+		})
+		require.NoError(t, err)
+	}
+
+	check := func() {
+		err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			for i := 0; i < 100; i++ {
+				require.Equal(t, value(i), b.Get([]byte(fmt.Sprintf("%04d", i))))
+			}
+			return nil
+		})
+		require.NoError(t, err)
+	}
+	check()
+	db.MustCheck()
+	db.MustClose()
+
+	db.MustReopen()
+	check()
+}
+
+// Ensure that growing a database mapped in chunks doesn't wait for read
+// transactions to finish.
+func TestDB_MmapChunkSize_GrowWithOpenReader(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{MmapChunkSize: 64 * 1024})
+	putWidgets(t, db, 0, 10)
+
+	rtx, err := db.Begin(false)
+	require.NoError(t, err)
+	size := rtx.Size()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			for i := 0; i < 1000; i++ {
+				if err := b.Put([]byte(fmt.Sprintf("big%04d", i)), make([]byte, 1000)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("write transaction is blocked by the read transaction")
+	}
+
+	// The read transaction still sees its own snapshot.
+	require.Equal(t, size, rtx.Size())
+	require.Nil(t, rtx.Bucket([]byte("widgets")).Get([]byte("big0000")))
+	require.NoError(t, rtx.Rollback())
+	db.MustCheck()
+}
@@ -312,7 +312,7 @@ func (n *node) spill() error {
 	n.children = nil
 
 	// Split nodes into appropriate sizes. The first node will always be n.
-	var nodes = n.split(uintptr(tx.db.pageSize))
+	var nodes = n.split(uintptr(tx.db.pageSize - tx.db.pageTrailerSize()))
 	for _, node := range nodes {
 		// Add node's page to the freelist if it's not new.
 		if node.pgid > 0 {
@@ -321,7 +321,7 @@ func (n *node) spill() error {
 		}
 
 		// Allocate contiguous space for the node.
-		p, err := tx.allocate((node.size() + tx.db.pageSize - 1) / tx.db.pageSize)
+		p, err := tx.allocate((node.size() + tx.db.pageTrailerSize() + tx.db.pageSize - 1) / tx.db.pageSize)
 		if err != nil {
 			return err
 		}
@@ -400,6 +400,11 @@ func (n *node) rebalance() {
 			child.free()
 		}
 
+		// An emptied root branch becomes an empty leaf.
+		if !n.isLeaf && len(n.inodes) == 0 {
+			n.isLeaf = true
+		}
+
 		return
 	}
 
@@ -413,6 +418,17 @@ func (n *node) rebalance() {
 		return
 	}
 
+	// DeleteRange may leave the parent with this node only, in which case
+	// the parent is rebalanced first, so that it's merged with its sibling
+	// and this node has one, or collapsed into this node if it's the root.
+	if n.parent.numChildren() == 1 {
+		n.parent.unbalanced = true
+		n.parent.rebalance()
+		if n.parent == nil {
+			return
+		}
+	}
+
 	common.Assert(n.parent.numChildren() > 1, "parent must have at least 2 children")
 
 	// Merge with right sibling if idx == 0, otherwise left sibling.
@@ -0,0 +1,57 @@
+package boltdb
+
+import (
+	"math/bits"
+	"sync"
+	"unsafe"
+
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// maxPooledPages is the largest number of pages of a dirty page buffer which
+// is reused across transactions, larger buffers are left to the GC.
+const maxPooledPages = 64
+
+// pagePoolClasses is the number of size classes of the page pools, class i
+// holding buffers of 1<<i pages.
+const pagePoolClasses = 7
+
+// pagePools holds the zeroed dirty page buffers released by the commits, by
+// size class, see pageClass.
+type pagePools [pagePoolClasses]sync.Pool
+
+// pageClass returns the size class of the buffers of count pages, the
+// smallest power of two pages which holds them.
+func pageClass(count int) int {
+	return bits.Len(uint(count - 1))
+}
+
+// getPageBuffer returns a zeroed buffer for a dirty page run of count pages,
+// reusing a released one of its size class if there is any. It's aligned for
+// direct I/O if it's enabled.
+func (db *DB) getPageBuffer(count int) []byte {
+	if count > maxPooledPages {
+		return db.pageBuffer(count * db.pageSize)
+	}
+	class := pageClass(count)
+	if buf, ok := db.pagePools[class].Get().([]byte); ok {
+		return buf
+	}
+	return db.pageBuffer((1 << class) * db.pageSize)
+}
+
+// putPageBuffer zeroes the buffer of the dirty page run p once it's written,
+// and releases it for reuse by later transactions.
+func (db *DB) putPageBuffer(p *common.Page) {
+	count := int(p.Overflow()) + 1
+	if count > maxPooledPages {
+		return
+	}
+	class := pageClass(count)
+	buf := common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, (1<<class)*db.pageSize)
+
+	// The pages beyond the run were never used, so they're still zeroed.
+	// See https://go.googlesource.com/go/+/f03c9202c43e0abb130669852082117ca50aa9b1
+	clear(buf[:count*db.pageSize])
+	db.pagePools[class].Put(buf) //nolint:staticcheck
+}
@@ -0,0 +1,82 @@
+package boltdb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// GetPinned retrieves the value for a key in the bucket like Get, and keeps
+// it valid until release is called, even once the transaction is closed, so
+// that a large value can be used after View returns without copying it.
+// Until then, the pages of the transaction aren't reused and the database
+// can't be remapped, as if the transaction was still open, so writes which
+// grow the database block, and DB.Close waits for the release.
+//
+// In a write transaction, and for values streamed with PutReader or
+// compressed, the value is copied instead. Returns a nil value if the key does not exist or if the
+// key is a nested bucket. release is never nil, and may be called more than
+// once.
+func (b *Bucket) GetPinned(key []byte) (value []byte, release func()) {
+	defer b.tx.catch(nil)
+	release = func() {}
+	if b.tx.db == nil {
+		return nil, release
+	}
+	v, flags, ok := b.getRaw(key)
+	if !ok || (flags&common.BucketLeafFlag) != 0 {
+		return nil, release
+	}
+	if (flags & common.ExpiringLeafFlag) != 0 {
+		if expired(v, time.Now().UnixNano()) {
+			return nil, release
+		}
+		v = v[expiryLen:]
+	}
+	if (flags & (common.BlobLeafFlag | common.CompressedLeafFlag)) != 0 {
+		return b.value(v, flags), release
+	} else if b.tx.writable {
+		return cloneBytes(v), release
+	}
+	return v, b.tx.pin()
+}
+
+// pin keeps the pages of a read transaction from being reused or remapped
+// once it's closed, until the returned function is called.
+func (tx *Tx) pin() func() {
+	tx.pinMu.Lock()
+	tx.pins++
+	tx.pinMu.Unlock()
+
+	var once sync.Once
+	return func() { once.Do(tx.unpin) }
+}
+
+// unpin releases a pin of the transaction, and removes it from the database
+// if it was closed meanwhile and this was its last pin.
+func (tx *Tx) unpin() {
+	tx.pinMu.Lock()
+	tx.pins--
+	db := tx.pinnedDB
+	if tx.pins > 0 || db == nil {
+		tx.pinMu.Unlock()
+		return
+	}
+	tx.pinnedDB = nil
+	tx.pinMu.Unlock()
+	db.removeTx(tx)
+}
+
+// holdPinned returns whether the read transaction, which is being closed,
+// still has pinned values, in which case it's removed from the database by
+// their last release instead.
+func (tx *Tx) holdPinned() bool {
+	tx.pinMu.Lock()
+	defer tx.pinMu.Unlock()
+	if tx.pins == 0 {
+		return false
+	}
+	tx.pinnedDB = tx.db
+	return true
+}
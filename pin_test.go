@@ -0,0 +1,75 @@
+package boltdb_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestBucket_GetPinned(t *testing.T) {
+	// The database mustn't be remapped while a value is pinned.
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{InitialMmapSize: 16 << 20})
+	want := bytes.Repeat([]byte("pinned"), 2000)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		if _, err := b.CreateBucket([]byte("nested")); err != nil {
+			return err
+		}
+		return b.Put([]byte("key"), want)
+	})
+	require.NoError(t, err)
+
+	var value []byte
+	var release func()
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		value, release = b.GetPinned([]byte("key"))
+
+		v, r := b.GetPinned([]byte("missing"))
+		require.Nil(t, v)
+		r()
+		v, r = b.GetPinned([]byte("nested"))
+		require.Nil(t, v)
+		r()
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, db.Stats().OpenTxN)
+
+	// The pages of the value aren't reused while it's pinned.
+	for i := 0; i < 10; i++ {
+		err = db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			if err := b.Delete([]byte("key")); err != nil {
+				return err
+			}
+			return b.Put([]byte(fmt.Sprintf("other%d", i)), bytes.Repeat([]byte{byte(i)}, 12000))
+		})
+		require.NoError(t, err)
+	}
+	require.Equal(t, want, value)
+
+	release()
+	release()
+	require.Equal(t, 0, db.Stats().OpenTxN)
+	db.MustCheck()
+
+	// Write transactions return a copy.
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		v, r := b.GetPinned([]byte("other1"))
+		defer r()
+		require.NoError(t, b.Put([]byte("other1"), []byte("changed")))
+		require.Equal(t, bytes.Repeat([]byte{1}, 12000), v)
+		return nil
+	})
+	require.NoError(t, err)
+}
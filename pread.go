@@ -0,0 +1,238 @@
+package boltdb
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// defaultPageCacheSize is the size of the page cache if
+// Options.PageCacheSize isn't set.
+const defaultPageCacheSize = 64 << 20
+
+// pageCache holds the page runs read with pread when the data file isn't
+// memory-mapped, see Options.NoMmap. The least recently used runs are
+// evicted once they take more than max bytes. The transactions which
+// reference an evicted run keep it alive until they're closed.
+type pageCache struct {
+	mu    sync.Mutex
+	max   int
+	size  int
+	limit int64 // the size of the data file which can be read
+	lru   *list.List
+	runs  map[common.Pgid]*list.Element
+
+	// meta holds the two meta pages, which are never evicted and are
+	// updated in place when they're written, like a mapping would be.
+	meta []byte
+
+	stats *pageCacheStats
+}
+
+// pageCacheStats counts the page cache accesses for Stats. It's kept by the
+// DB, so that the totals aren't reset when the data file is replaced.
+type pageCacheStats struct {
+	hitN   atomic.Int64
+	missN  atomic.Int64
+	evictN atomic.Int64
+	size   atomic.Int64
+}
+
+// cachedRun is a page and its overflow pages.
+type cachedRun struct {
+	id  common.Pgid
+	buf []byte
+}
+
+// get returns the cached run of page id, or nil.
+func (c *pageCache) get(id common.Pgid) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.runs[id]; ok {
+		c.lru.MoveToFront(e)
+		c.stats.hitN.Add(1)
+		return e.Value.(*cachedRun).buf
+	}
+	c.stats.missN.Add(1)
+	return nil
+}
+
+// put caches the run buf of page id unless another transaction did first,
+// and returns the cached run.
+func (c *pageCache) put(id common.Pgid, buf []byte) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.runs[id]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*cachedRun).buf
+	}
+	if len(buf) > c.max {
+		return buf
+	}
+	c.runs[id] = c.lru.PushFront(&cachedRun{id: id, buf: buf})
+	c.size += len(buf)
+	for c.size > c.max {
+		r := c.lru.Remove(c.lru.Back()).(*cachedRun)
+		delete(c.runs, r.id)
+		c.size -= len(r.buf)
+		c.stats.evictN.Add(1)
+	}
+	c.stats.size.Store(int64(c.size))
+	return buf
+}
+
+// invalidate drops the cached runs of the n pages at id once they're
+// written.
+func (c *pageCache) invalidate(id common.Pgid, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := id; i < id+common.Pgid(n); i++ {
+		if e, ok := c.runs[i]; ok {
+			c.lru.Remove(e)
+			delete(c.runs, i)
+			c.size -= len(e.Value.(*cachedRun).buf)
+		}
+	}
+	c.stats.size.Store(int64(c.size))
+}
+
+// setLimit sets the size of the data file which can be read.
+func (c *pageCache) setLimit(limit int64) {
+	c.mu.Lock()
+	c.limit = limit
+	c.mu.Unlock()
+}
+
+// readLimit returns the number of bytes of a run of n bytes at pos which are
+// in the data file.
+func (c *pageCache) readLimit(pos int64, n int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int(max(min(int64(n), c.limit-pos), 0))
+}
+
+// mmapPread sets up the page cache which takes the place of the mmap, or
+// only extends the size of the data file which can be read if it's already
+// set up. The meta pages are loaded on setup.
+func (db *DB) mmapPread(minsz int) error {
+	fileSize, err := db.fileSize()
+	if err != nil {
+		return err
+	}
+	size := max(fileSize, minsz)
+	if db.pageCache != nil {
+		db.pageCache.setLimit(int64(size))
+		db.datasz = size
+		return nil
+	}
+
+	db.mmaplock.Lock()
+	defer db.mmaplock.Unlock()
+	c := &pageCache{
+		max:   db.pageCacheSize,
+		limit: int64(size),
+		lru:   list.New(),
+		runs:  make(map[common.Pgid]*list.Element),
+		meta:  make([]byte, 2*db.pageSize),
+		stats: &db.pageCacheStats,
+	}
+	for id := common.Pgid(0); id < 2; id++ {
+		// A meta page which fails authentication fails validation, so that
+		// the other one is used.
+		err := db.readRun(c.meta[int(id)*db.pageSize:int(id+1)*db.pageSize], id)
+		if err != nil && !errors.Is(err, berrors.ErrDecrypt) {
+			return fmt.Errorf("pread meta: %w", err)
+		}
+	}
+	db.pageCache = c
+	db.datasz = size
+	if err := db.loadMeta(); err != nil {
+		db.resetPageCache()
+		return err
+	}
+	return nil
+}
+
+// resetPageCache drops the page cache once the data file is unmapped.
+func (db *DB) resetPageCache() {
+	db.pageCache = nil
+	db.pageCacheStats.size.Store(0)
+}
+
+// cachedPage returns page id from the page cache, reading its run from the
+// data file if it isn't cached.
+func (db *DB) cachedPage(id common.Pgid) *common.Page {
+	c := db.pageCache
+	if id < 2 {
+		return (*common.Page)(unsafe.Pointer(&c.meta[int(id)*db.pageSize]))
+	}
+	if buf := c.get(id); buf != nil {
+		return (*common.Page)(unsafe.Pointer(&buf[0]))
+	}
+
+	pos := int64(id) * int64(db.pageSize)
+	buf := make([]byte, db.pageSize)
+	if err := db.readRun(buf[:c.readLimit(pos, len(buf))], id); err != nil {
+		panic("pread " + err.Error())
+	}
+	if p := (*common.Page)(unsafe.Pointer(&buf[0])); p.Overflow() > 0 {
+		// Pages beyond the data file, such as a stale header of a free
+		// page, can't be read anyway, so don't allocate them.
+		n := (int(p.Overflow()) + 1) * db.pageSize
+		run := make([]byte, max(c.readLimit(pos, n), db.pageSize))
+		copy(run, buf)
+		if err := db.readRun(run[db.pageSize:], id+1); err != nil {
+			panic("pread " + err.Error())
+		}
+		buf = run
+	}
+	buf = c.put(id, buf)
+	return (*common.Page)(unsafe.Pointer(&buf[0]))
+}
+
+// readRun reads the pages at page id into buf, decrypting them if the
+// database is encrypted. The pages beyond the end of the file are left
+// zeroed.
+func (db *DB) readRun(buf []byte, id common.Pgid) error {
+	if db.cipher != nil {
+		return db.readSealedAt(buf, id)
+	}
+	if _, err := db.file.ReadAt(buf, int64(id)*int64(db.pageSize)); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("page %d: %w", id, err)
+	}
+	return nil
+}
+
+// cachedWriteAt writes b to the data file at offset off, and drops the runs
+// of the written pages from the page cache. The meta pages are updated.
+func (db *DB) cachedWriteAt(b []byte, off int64) (int, error) {
+	write := db.file.WriteAt
+	if db.directFile != nil {
+		write = db.directWriteAt
+	}
+	if db.cipher != nil {
+		write = db.sealWriteAt
+	}
+	n, err := write(b, off)
+
+	c := db.pageCache
+	if c == nil {
+		return n, err
+	}
+	id := common.Pgid(off / int64(db.pageSize))
+	c.invalidate(id, (len(b)+db.pageSize-1)/db.pageSize)
+	if metasz := int64(len(c.meta)); off < metasz {
+		// Meta pages may be read concurrently by new transactions.
+		db.metalock.Lock()
+		copy(c.meta[off:], b[:min(int64(len(b)), metasz-off)])
+		db.metalock.Unlock()
+	}
+	return n, err
+}
@@ -0,0 +1,84 @@
+package boltdb
+
+import (
+	"bytes"
+
+	"github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// PutSorted sets the values for keys given in strictly ascending order, like
+// calling Put for each of them. The keys after the last key of the bucket
+// are appended to its last leaf without searching the tree, and split into
+// pages filled up to FillPercent on commit, so loading sorted data into an
+// empty bucket or at its end is much faster than with Put. The keys which
+// fall before the last key of the bucket are put one by one. values must have
+// the same length as keys, and the values must remain valid for the life of
+// the transaction like with Put.
+// Returns an error if the bucket was created from a read-only transaction, if
+// the keys aren't in ascending order, if a key is blank, too large, or
+// refers to a nested bucket, or if a value is too large. The keys and values
+// are checked before anything is put, except for nested buckets.
+func (b *Bucket) PutSorted(keys, values [][]byte) (err error) {
+	common.Assert(len(keys) == len(values), "PutSorted: %d keys but %d values", len(keys), len(values))
+	defer b.tx.catch(&err)
+	if b.tx.db == nil {
+		return errors.ErrTxClosed
+	} else if !b.Writable() {
+		return errors.ErrTxNotWritable
+	}
+	for i, key := range keys {
+		if len(key) == 0 {
+			return errors.ErrKeyRequired
+		} else if len(key) > MaxKeySize {
+			return errors.ErrKeyTooLarge
+		} else if int64(len(values[i])) > MaxValueSize {
+			return errors.ErrValueTooLarge
+		} else if i > 0 && bytes.Compare(keys[i-1], key) >= 0 {
+			return errors.ErrKeysUnsorted
+		}
+	}
+
+	// Put the keys which may replace or fall between existing ones.
+	last, _, _ := b.Cursor().seekLast()
+	i := 0
+	for ; i < len(keys) && last != nil && bytes.Compare(keys[i], last) <= 0; i++ {
+		if err := b.put(keys[i], values[i], 0); err != nil {
+			return err
+		}
+	}
+
+	// Append the others to the last leaf. The nodes are dropped when the
+	// dirty pages are written out, so the leaf is looked up again then.
+	var leaf *node
+	for ; i < len(keys); i++ {
+		if leaf == nil || b.rootNode == nil {
+			leaf = b.lastLeaf()
+		}
+		if len(b.indexes) > 0 {
+			if err := b.updateIndexes(keys[i], nil, false, values[i], true); err != nil {
+				return err
+			}
+		}
+		var inode common.Inode
+		inode.SetKey(cloneBytes(keys[i]))
+		inode.SetValue(values[i])
+		leaf.inodes = append(leaf.inodes, inode)
+		b.addCount(1)
+		b.recordChange(keys[i], OpPut)
+		if err := b.tx.dirty(int(common.LeafPageElementSize) + len(keys[i]) + len(values[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lastLeaf returns the node of the last leaf of the bucket, reading it and its
+// parents into nodes if needed.
+func (b *Bucket) lastLeaf() *node {
+	n := b.node(b.RootPage(), nil)
+	for !n.isLeaf {
+		n = n.childAt(len(n.inodes) - 1)
+	}
+	return n
+}
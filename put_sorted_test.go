@@ -0,0 +1,150 @@
+package boltdb_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func sortedPairs(from, to int) (keys, values [][]byte) {
+	for i := from; i < to; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("%06d", i)))
+		values = append(values, []byte(fmt.Sprintf("value-%d", i)))
+	}
+	return keys, values
+}
+
+func TestBucket_PutSorted(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options bolt.Options
+	}{
+		{name: "default"},
+		{name: "max dirty bytes", options: bolt.Options{MaxDirtyBytes: 64 * 1024}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &tc.options)
+
+			err := db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucket([]byte("widgets"))
+				if err != nil {
+					return err
+				}
+				b.FillPercent = 1.0
+				keys, values := sortedPairs(0, 50000)
+				return b.PutSorted(keys, values)
+			})
+			require.NoError(t, err)
+			db.MustCheck()
+
+			if tc.options.MaxDirtyBytes == 0 {
+				// The pages are filled up to FillPercent.
+				err = db.View(func(tx *bolt.Tx) error {
+					stats := tx.Bucket([]byte("widgets")).Stats()
+					require.Greater(t, float64(stats.LeafInuse)/float64(stats.LeafAlloc), 0.95)
+					return nil
+				})
+				require.NoError(t, err)
+			}
+
+			// Overwrite and insert keys before the last one, and append more.
+			err = db.Update(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				require.NoError(t, b.Delete([]byte("049999")))
+				keys, values := sortedPairs(49990, 60000)
+				keys = append([][]byte{[]byte("000100x")}, keys...)
+				values = append([][]byte{[]byte("inserted")}, values...)
+				values[1] = []byte("replaced")
+				return b.PutSorted(keys, values)
+			})
+			require.NoError(t, err)
+			db.MustCheck()
+
+			err = db.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				stats := b.Stats()
+				require.Equal(t, 60001, stats.KeyN)
+				require.Equal(t, []byte("inserted"), b.Get([]byte("000100x")))
+				require.Equal(t, []byte("replaced"), b.Get([]byte("049990")))
+				require.Equal(t, []byte("value-49999"), b.Get([]byte("049999")))
+				require.Equal(t, []byte("value-59999"), b.Get([]byte("059999")))
+
+				i := 0
+				c := b.Cursor()
+				for k, _ := c.First(); k != nil; k, _ = c.Next() {
+					i++
+				}
+				require.Equal(t, 60001, i)
+				return nil
+			})
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestBucket_PutSorted_Errors(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		_, err = b.CreateBucket([]byte("b"))
+		require.NoError(t, err)
+
+		pairs := func(keys ...string) ([][]byte, [][]byte) {
+			var k, v [][]byte
+			for _, key := range keys {
+				k = append(k, []byte(key))
+				v = append(v, []byte("value"))
+			}
+			return k, v
+		}
+		require.ErrorIs(t, b.PutSorted(pairs("a", "c", "c")), berrors.ErrKeysUnsorted)
+		require.ErrorIs(t, b.PutSorted(pairs("c", "a")), berrors.ErrKeysUnsorted)
+		require.ErrorIs(t, b.PutSorted(pairs("a", "")), berrors.ErrKeyRequired)
+		require.Nil(t, b.Get([]byte("a")))
+		require.ErrorIs(t, b.PutSorted(pairs("a", "b")), berrors.ErrIncompatibleValue)
+		require.NoError(t, b.PutSorted(nil, nil))
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		require.ErrorIs(t, tx.Bucket([]byte("widgets")).PutSorted([][]byte{[]byte("a")}, [][]byte{nil}), berrors.ErrTxNotWritable)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func BenchmarkBucket_PutSorted(b *testing.B) {
+	keys, values := sortedPairs(0, 100000)
+	for _, sorted := range []bool{false, true} {
+		b.Run(fmt.Sprintf("sorted=%v", sorted), func(b *testing.B) {
+			db := btesting.MustCreateDB(b)
+			for i := 0; i < b.N; i++ {
+				err := db.Update(func(tx *bolt.Tx) error {
+					_ = tx.DeleteBucket([]byte("widgets"))
+					bkt, err := tx.CreateBucket([]byte("widgets"))
+					if err != nil {
+						return err
+					}
+					if sorted {
+						return bkt.PutSorted(keys, values)
+					}
+					for j := range keys {
+						if err := bkt.Put(keys[j], values[j]); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+				require.NoError(b, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package boltdb
+
+import (
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// SetReadAhead makes the cursor advise the OS to read about n bytes ahead
+// of it while it moves through the leaves: whenever it reaches a leaf, the
+// next leaves of the same branch in the direction it moves, and the first
+// chunks of the streamed values of the leaf, are read in the background
+// (POSIX_FADV_WILLNEED), so that cold sequential scans don't wait for each
+// page fault in turn. Zero disables it. The advice is only given on Linux,
+// and not for in-memory databases.
+func (c *Cursor) SetReadAhead(n int) {
+	c.readAhead = max(n, 0)
+	c.readAheadLeaf, c.readAheadBranch, c.readAheadIndex = 0, 0, 0
+}
+
+// advise gives the read-ahead advice when the cursor reached another leaf.
+func (c *Cursor) advise() {
+	db := c.bucket.tx.db
+	if db.inMemory || len(c.stack) == 0 {
+		return
+	}
+	leaf := &c.stack[len(c.stack)-1]
+	var id common.Pgid
+	if leaf.node != nil {
+		id = leaf.node.pgid
+	} else {
+		id = leaf.page.Id()
+	}
+	if id == c.readAheadLeaf && id != 0 {
+		return
+	}
+	c.readAheadLeaf = id
+
+	budget := c.readAhead
+	var runs []pageRun
+	add := func(id common.Pgid, n int) {
+		if id == 0 || id >= c.bucket.tx.meta.Pgid() {
+			return
+		}
+		runs = append(runs, pageRun{id: id, n: n})
+		budget -= n * db.pageSize
+	}
+
+	// The streamed values of the leaf.
+	tx := c.bucket.tx
+	for i := 0; i < leaf.count() && budget > 0; i++ {
+		var v []byte
+		var flags uint32
+		if leaf.node != nil {
+			v, flags = leaf.node.inodes[i].Value(), leaf.node.inodes[i].Flags()
+		} else {
+			e := leaf.page.LeafPageElement(uint16(i))
+			v, flags = e.Value(), e.Flags()
+		}
+		if (flags & common.BlobLeafFlag) == 0 {
+			continue
+		}
+		for ref := v; len(ref) > 0 && budget > 0; ref = ref[blobRefSize:] {
+			id, size := blobRef(ref)
+			add(id, tx.blobChunkPages(size))
+		}
+	}
+
+	// The next leaves of the branch, unless they were advised already.
+	if len(c.stack) > 1 && budget > 0 {
+		branch := &c.stack[len(c.stack)-2]
+		var bid common.Pgid
+		if branch.node != nil {
+			bid = branch.node.pgid
+		} else {
+			bid = branch.page.Id()
+		}
+		step, i := 1, branch.index+1
+		if c.reverse {
+			step, i = -1, branch.index-1
+		}
+		if bid == c.readAheadBranch && bid != 0 && (i-c.readAheadIndex)*step <= 0 {
+			i = c.readAheadIndex + step
+		}
+		for ; i >= 0 && i < branch.count() && budget > 0; i += step {
+			if branch.node != nil {
+				add(branch.node.inodes[i].Pgid(), 1)
+			} else {
+				add(branch.page.BranchPageElement(uint16(i)).Pgid(), 1)
+			}
+			c.readAheadBranch, c.readAheadIndex = bid, i
+		}
+	}
+	_ = db.fadviseRuns(runs, AdviceWillNeed)
+}
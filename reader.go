@@ -0,0 +1,135 @@
+package boltdb
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"unsafe"
+
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// dataFile is the source of the pages copied by Tx.WriteTo and Tx.WriteDiffTo.
+type dataFile interface {
+	io.ReadSeeker
+	io.ReaderAt
+	io.Closer
+}
+
+// imageFile is the dataFile of a database opened with OpenReader.
+type imageFile struct {
+	*bytes.Reader
+}
+
+func (imageFile) Close() error { return nil }
+
+// OpenReader opens a read-only database from r, e.g. a database embedded
+// with go:embed, without copying it to a file first. The whole database is
+// read into memory, so r isn't used once OpenReader returns.
+//
+// The database is always opened in read-only mode. Options which only apply
+// to the data file, such as Timeout or MmapFlags, are ignored.
+func OpenReader(r io.ReaderAt, options *Options) (*DB, error) {
+	image, err := io.ReadAll(io.NewSectionReader(r, 0, math.MaxInt64))
+	if err != nil {
+		return nil, err
+	}
+	return openImage(image, options)
+}
+
+// OpenFS opens the read-only database stored in the file name of fsys, e.g.
+// an embed.FS, like OpenReader.
+func OpenFS(fsys fs.FS, name string, options *Options) (*DB, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if r, ok := f.(io.ReaderAt); ok {
+		return OpenReader(r, options)
+	}
+	image, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return openImage(image, options)
+}
+
+// openImage opens a read-only database from the content of its data file.
+func openImage(image []byte, options *Options) (*DB, error) {
+	if options == nil {
+		options = DefaultOptions
+	}
+	db := &DB{
+		opened:   true,
+		readOnly: true,
+		inMemory: true,
+		image:    image,
+	}
+	db.PreLoadFreelist = options.PreLoadFreelist
+	db.cipher = options.Encryption
+	db.MaxBatchSize = common.DefaultMaxBatchSize
+	db.MaxBatchDelay = common.DefaultMaxBatchDelay
+	db.AllocSize = common.DefaultAllocSize
+
+	// The file opener is still used to write copies, e.g. by Tx.CopyFile.
+	db.openFile = options.OpenFile
+	if db.openFile == nil {
+		db.openFile = os.OpenFile
+	}
+
+	if db.pageSize = options.PageSize; db.pageSize == 0 {
+		db.pageSize = common.DefaultPageSize
+	}
+	pgSize, err := db.getPageSize()
+	if err != nil {
+		return nil, berrors.ErrInvalid
+	}
+	db.pageSize = pgSize
+
+	if db.cipher != nil {
+		sz := len(image) / db.encryptedPageSize() * db.pageSize
+		if sz < db.pageSize*2 {
+			return nil, berrors.ErrInvalid
+		}
+		if err := db.mmapEncrypted(sz); err != nil {
+			return nil, err
+		}
+	} else {
+		if len(image) < db.pageSize*2 {
+			return nil, berrors.ErrInvalid
+		}
+		db.data = (*[maxMapSize]byte)(unsafe.Pointer(&image[0]))
+		db.datasz = len(image)
+	}
+	if err := db.loadMeta(); err != nil {
+		db.invalidate()
+		return nil, err
+	}
+	if err := db.checkRecovery(options.OnMetaRollback); err != nil {
+		db.invalidate()
+		return nil, err
+	}
+
+	if db.PreLoadFreelist {
+		db.loadFreelist()
+	}
+	return db, nil
+}
+
+// fileReader returns a reader of the data file, or of the image of an
+// in-memory database, and its size.
+func (db *DB) fileReader() (io.ReaderAt, int64, error) {
+	if db.inMemory {
+		return bytes.NewReader(db.image), int64(len(db.image)), nil
+	}
+	info, err := db.file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	return db.file, info.Size(), nil
+}
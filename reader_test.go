@@ -0,0 +1,82 @@
+package boltdb_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestOpenReader(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plain", true: "encrypted"}[encrypted], func(t *testing.T) {
+			var o *bolt.Options
+			if encrypted {
+				o = &bolt.Options{Encryption: mustAESGCMCipher(t, "0123456789abcdef")}
+			}
+			db := btesting.MustCreateDBWithOption(t, o)
+			putWidgets(t, db, 0, 1000)
+			db.MustClose()
+			raw, err := os.ReadFile(db.Path())
+			require.NoError(t, err)
+
+			rdb, err := bolt.OpenReader(bytes.NewReader(raw), o)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, rdb.Close()) }()
+			require.True(t, rdb.IsReadOnly())
+
+			requireWidgets(t, rdb, 1000)
+			err = rdb.View(func(tx *bolt.Tx) error {
+				for err := range tx.Check() {
+					require.NoError(t, err)
+				}
+				return nil
+			})
+			require.NoError(t, err)
+
+			err = rdb.Update(func(tx *bolt.Tx) error { return nil })
+			require.ErrorIs(t, err, berrors.ErrDatabaseReadOnly)
+
+			// The database can be copied out of the image.
+			path := filepath.Join(t.TempDir(), "copy.db")
+			err = rdb.View(func(tx *bolt.Tx) error {
+				return tx.CopyFile(path, 0600)
+			})
+			require.NoError(t, err)
+			cdb := btesting.MustOpenDBWithOption(t, path, o)
+			requireWidgets(t, cdb.DB, 1000)
+		})
+	}
+}
+
+func TestOpenFS(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	putWidgets(t, db, 0, 100)
+	db.MustClose()
+	raw, err := os.ReadFile(db.Path())
+	require.NoError(t, err)
+
+	fsys := fstest.MapFS{"data/widgets.db": &fstest.MapFile{Data: raw}}
+	rdb, err := bolt.OpenFS(fsys, "data/widgets.db", nil)
+	require.NoError(t, err)
+	requireWidgets(t, rdb, 100)
+	require.NoError(t, rdb.Close())
+
+	_, err = bolt.OpenFS(fsys, "missing.db", nil)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestOpenReader_ErrInvalid(t *testing.T) {
+	_, err := bolt.OpenReader(bytes.NewReader(nil), nil)
+	require.ErrorIs(t, err, bolt.ErrInvalid)
+
+	_, err = bolt.OpenReader(bytes.NewReader(bytes.Repeat([]byte("x"), 8192)), nil)
+	require.ErrorIs(t, err, bolt.ErrInvalid)
+}
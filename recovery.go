@@ -0,0 +1,55 @@
+package boltdb
+
+// RecoveryInfo describes the meta page a database was opened with, see
+// DB.RecoveryInfo.
+type RecoveryInfo struct {
+	// Meta is the meta page the database was opened with, 0 or 1.
+	Meta int
+
+	// Txid is the id of the last transaction committed to that meta page.
+	Txid uint64
+
+	// RolledBack is set when the meta page with the higher transaction id
+	// failed validation, e.g. because its write was torn by a crash, so the
+	// database was opened at the previous transaction instead.
+	RolledBack bool
+
+	// Err is the validation error of the other meta page, or nil if it's
+	// valid.
+	Err error
+
+	// MetaCopy is set when both meta pages failed validation, and the meta
+	// page was restored from the latest copy at the end of the file, see
+	// Options.MetaCopies. The database may have been rolled back by more
+	// than one transaction.
+	MetaCopy bool
+}
+
+// RecoveryInfo returns which meta page the database was opened with, and
+// whether it had to fall back to the previous transaction.
+func (db *DB) RecoveryInfo() RecoveryInfo {
+	return db.recovery
+}
+
+// checkRecovery records which meta page the database is opened with, and
+// calls onRollback, if it's set, when the database was rolled back to the
+// previous transaction or restored from a meta copy. An error returned by
+// onRollback aborts the opening.
+func (db *DB) checkRecovery(onRollback func(RecoveryInfo) error) error {
+	m := db.meta()
+	info := RecoveryInfo{Txid: uint64(m.Txid()), MetaCopy: db.recovery.MetaCopy}
+	other := db.meta1
+	if m == db.meta1 {
+		info.Meta = 1
+		other = db.meta0
+	}
+	if info.Err = other.Validate(); info.Err != nil {
+		info.RolledBack = other.Txid() > m.Txid()
+	}
+	db.recovery = info
+
+	if (info.RolledBack || info.MetaCopy) && onRollback != nil {
+		return onRollback(info)
+	}
+	return nil
+}
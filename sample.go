@@ -0,0 +1,116 @@
+package boltdb
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// sampleAttempts bounds the number of descents of SampleKeys per key it
+// returns, as descents may pick the same key again.
+const sampleAttempts = 16
+
+// SampleKeys returns about n random keys of the bucket in ascending order,
+// without reading all its pages, e.g. to build a histogram of the keys or to
+// pick points to split them at. Each key is picked by descending the tree
+// from its root, choosing each child with a weight of the number of elements
+// of its page, and a key of the leaf uniformly, so the keys are close to
+// uniformly distributed unless the pages are filled very unevenly. Keys are
+// only returned once, and all the keys are returned if the bucket holds at
+// most n of them. Like Count, the keys include the nested buckets and the
+// expired keys.
+// The returned keys are only valid for the life of the transaction.
+func (b *Bucket) SampleKeys(n int) [][]byte {
+	defer b.tx.catch(nil)
+	if n <= 0 {
+		return nil
+	}
+	if b.Count() <= n {
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _, _ := c.first(); k != nil; k, _, _ = c.next() {
+			keys = append(keys, k)
+		}
+		return keys
+	}
+
+	s := keySampler{b: b, weights: make(map[common.Pgid][]int)}
+	seen := make(map[string]struct{}, n)
+	keys := make([][]byte, 0, n)
+	for i := 0; i < n*sampleAttempts && len(keys) < n; i++ {
+		k := s.sample(b.RootPage())
+		if k == nil {
+			continue
+		}
+		if _, ok := seen[string(k)]; !ok {
+			seen[string(k)] = struct{}{}
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	return keys
+}
+
+// keySampler picks random keys of a bucket for SampleKeys.
+type keySampler struct {
+	b *Bucket
+	// weights caches the cumulative weights of the children of the branches
+	// which were descended already.
+	weights map[common.Pgid][]int
+}
+
+// sample returns a random key of the subtree of a page, or nil if the leaf
+// it reached is empty.
+func (s *keySampler) sample(id common.Pgid) []byte {
+	p, n := s.b.pageNode(id)
+	if (n != nil && n.isLeaf) || (p != nil && p.IsLeafPage()) {
+		count := pageNodeCount(p, n)
+		if count == 0 {
+			return nil
+		}
+		i := rand.Intn(count)
+		if n != nil {
+			return n.inodes[i].Key()
+		}
+		return p.LeafPageElement(uint16(i)).Key()
+	}
+
+	cum, ok := s.weights[id]
+	if !ok {
+		var children []common.Pgid
+		if n != nil {
+			for _, inode := range n.inodes {
+				children = append(children, inode.Pgid())
+			}
+		} else {
+			for i := uint16(0); i < p.Count(); i++ {
+				children = append(children, p.BranchPageElement(i).Pgid())
+			}
+		}
+		var total int
+		for _, child := range children {
+			total += pageNodeCount(s.b.pageNode(child))
+			cum = append(cum, total)
+		}
+		s.weights[id] = cum
+	}
+	if len(cum) == 0 || cum[len(cum)-1] == 0 {
+		return nil
+	}
+	w := rand.Intn(cum[len(cum)-1])
+	i := sort.Search(len(cum), func(i int) bool { return cum[i] > w })
+	if n != nil {
+		return s.sample(n.inodes[i].Pgid())
+	}
+	return s.sample(p.BranchPageElement(uint16(i)).Pgid())
+}
+
+// pageNodeCount returns the number of elements of a page or of its node.
+func pageNodeCount(p *common.Page, n *node) int {
+	if n != nil {
+		return len(n.inodes)
+	}
+	return int(p.Count())
+}
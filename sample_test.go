@@ -0,0 +1,75 @@
+package boltdb_test
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestBucket_SampleKeys(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	const n = 20000
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%06d", i)), []byte("value")); err != nil {
+				return err
+			}
+		}
+		small, err := tx.CreateBucket([]byte("small"))
+		if err != nil {
+			return err
+		}
+		return small.Put([]byte("key"), []byte("value"))
+	})
+	require.NoError(t, err)
+
+	check := func(t *testing.T, b *bolt.Bucket) {
+		keys := b.SampleKeys(200)
+		require.Greater(t, len(keys), 150)
+		require.LessOrEqual(t, len(keys), 200)
+		require.True(t, sort.SliceIsSorted(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 }))
+		var low int
+		for i, k := range keys {
+			require.NotNil(t, b.Get(k))
+			if i > 0 {
+				require.NotEqual(t, keys[i-1], k)
+			}
+			if string(k) < fmt.Sprintf("%06d", n/2) {
+				low++
+			}
+		}
+		// The keys are spread across the bucket.
+		require.Greater(t, low, len(keys)/4)
+		require.Less(t, low, len(keys)*3/4)
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		check(t, tx.Bucket([]byte("widgets")))
+		require.Equal(t, [][]byte{[]byte("key")}, tx.Bucket([]byte("small")).SampleKeys(10))
+		require.Nil(t, tx.Bucket([]byte("small")).SampleKeys(0))
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Write transactions sample their changes.
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.NoError(t, b.DeleteRange([]byte("005000"), []byte("015000")))
+		for _, k := range b.SampleKeys(100) {
+			require.False(t, string(k) >= "005000" && string(k) < "015000", string(k))
+		}
+		require.NoError(t, b.DeleteRange(nil, nil))
+		require.Empty(t, b.SampleKeys(10))
+		return nil
+	})
+	require.NoError(t, err)
+}
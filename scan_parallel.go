@@ -0,0 +1,127 @@
+package boltdb
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/openkvlab/boltdb/errors"
+)
+
+// ScanParallel executes a function for each key/value pair in a bucket with
+// up to workers goroutines, or GOMAXPROCS goroutines if workers is not
+// positive. The keyspace is divided with SplitPoints and each goroutine scans
+// its range in lexicographical order in its own read transaction at the same
+// txid as the bucket's, so the function sees the same data as the caller and
+// must be safe for concurrent use. The keys and values are only valid for the
+// life of the call. If the function returns ErrStopIteration then all the
+// goroutines stop and nil is returned; any other error, or the cancellation
+// of ctx, stops them and is returned to the caller.
+//
+// The buckets of a writable transaction are scanned in the calling goroutine
+// since the other goroutines can't see its uncommitted changes.
+func (b *Bucket) ScanParallel(ctx context.Context, workers int, fn func(k, v []byte) error) error {
+	if b.tx.db == nil {
+		return errors.ErrTxClosed
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if b.tx.writable {
+		workers = 1
+	}
+	keys := b.SplitPoints(workers)
+	if b.tx.err != nil {
+		return b.tx.err
+	}
+	if len(keys) == 0 {
+		err := b.scanRange(ctx, nil, nil, fn)
+		if err == errors.ErrStopIteration {
+			return nil
+		}
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i := 0; i <= len(keys); i++ {
+		var start, end []byte
+		if i > 0 {
+			start = keys[i-1]
+		}
+		if i < len(keys) {
+			end = keys[i]
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx := b.tx.fork()
+			err := b.fork(tx).scanRange(ctx, start, end, fn)
+			b.tx.stats.add(&tx.stats)
+			if err == nil {
+				return
+			}
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if firstErr == errors.ErrStopIteration {
+		return nil
+	}
+	return firstErr
+}
+
+// scanRange executes fn for each key/value pair in [start, end) until ctx is
+// canceled.
+func (b *Bucket) scanRange(ctx context.Context, start, end []byte, fn func(k, v []byte) error) error {
+	c := b.RangeCursor(start, end)
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	if b.tx.err != nil {
+		return b.tx.err
+	}
+	return ctx.Err()
+}
+
+// fork returns a read transaction at the same txid as tx for another
+// goroutine. It holds no lock and isn't tracked by the database, so it's only
+// valid while tx is open, which keeps the mmap and the pages in place.
+func (tx *Tx) fork() *Tx {
+	t := &Tx{started: time.Now()}
+	t.initAt(tx.db, tx.meta)
+	return t
+}
+
+// fork returns a copy of a bucket of a read transaction associated with tx,
+// a fork of the bucket's transaction.
+func (b *Bucket) fork(tx *Tx) *Bucket {
+	return &Bucket{
+		InBucket:    b.InBucket,
+		tx:          tx,
+		ext:         b.ext,
+		dict:        b.dict,
+		meta:        b.meta,
+		page:        b.page,
+		indexes:     b.indexes,
+		FillPercent: b.FillPercent,
+	}
+}
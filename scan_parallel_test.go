@@ -0,0 +1,109 @@
+package boltdb_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestBucket_ScanParallel(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	const n = 20000
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%06d", i)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	scan := func(t *testing.T, b *bolt.Bucket) {
+		var mu sync.Mutex
+		seen := make(map[string]string)
+		var calls int
+		err := b.ScanParallel(context.Background(), 4, func(k, v []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			seen[string(k)] = string(v)
+			calls++
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, n, calls)
+		require.Len(t, seen, n)
+		for i := 0; i < n; i++ {
+			require.Equal(t, fmt.Sprintf("v%d", i), seen[fmt.Sprintf("%06d", i)])
+		}
+	}
+
+	t.Run("read", func(t *testing.T) {
+		err := db.View(func(tx *bolt.Tx) error {
+			// A write committed during the scan is not seen.
+			done := make(chan error)
+			go func() {
+				done <- db.Update(func(tx *bolt.Tx) error {
+					return tx.Bucket([]byte("widgets")).Put([]byte("new"), []byte("value"))
+				})
+			}()
+			scan(t, tx.Bucket([]byte("widgets")))
+			return <-done
+		})
+		require.NoError(t, err)
+		err = db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("widgets")).Delete([]byte("new"))
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("write", func(t *testing.T) {
+		err := db.Update(func(tx *bolt.Tx) error {
+			scan(t, tx.Bucket([]byte("widgets")))
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		err := db.View(func(tx *bolt.Tx) error {
+			var count atomic.Int64
+			err := tx.Bucket([]byte("widgets")).ScanParallel(context.Background(), 4, func(k, v []byte) error {
+				if count.Add(1) == 100 {
+					return berrors.ErrStopIteration
+				}
+				return nil
+			})
+			require.NoError(t, err)
+			require.Less(t, count.Load(), int64(n))
+
+			failed := errors.New("failed")
+			err = tx.Bucket([]byte("widgets")).ScanParallel(context.Background(), 4, func(k, v []byte) error {
+				return failed
+			})
+			require.ErrorIs(t, err, failed)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			err = tx.Bucket([]byte("widgets")).ScanParallel(ctx, 4, func(k, v []byte) error {
+				return nil
+			})
+			require.ErrorIs(t, err, context.Canceled)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,165 @@
+package boltdb
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// SizeHistogramBuckets is the number of buckets of a SizeHistogram.
+const SizeHistogramBuckets = 65
+
+// SizeHistogram is a histogram of sizes by powers of two: Counts[0] counts
+// the sizes of 0, and Counts[i] the sizes from 1<<(i-1) to 1<<i - 1.
+type SizeHistogram struct {
+	Counts [SizeHistogramBuckets]int
+
+	N     int   // number of sizes
+	Total int64 // sum of the sizes
+	Min   int64 // smallest size, 0 if there are none
+	Max   int64 // largest size
+}
+
+// add adds a size to the histogram.
+func (h *SizeHistogram) add(size int64) {
+	h.Counts[bits.Len64(uint64(size))]++
+	if h.N == 0 || size < h.Min {
+		h.Min = size
+	}
+	if size > h.Max {
+		h.Max = size
+	}
+	h.N++
+	h.Total += size
+}
+
+// Add adds the sizes of other to the histogram.
+func (h *SizeHistogram) Add(other SizeHistogram) {
+	if other.N == 0 {
+		return
+	}
+	for i, n := range other.Counts {
+		h.Counts[i] += n
+	}
+	if h.N == 0 || other.Min < h.Min {
+		h.Min = other.Min
+	}
+	if other.Max > h.Max {
+		h.Max = other.Max
+	}
+	h.N += other.N
+	h.Total += other.Total
+}
+
+// Mean returns the mean size, 0 if there are none.
+func (h *SizeHistogram) Mean() float64 {
+	if h.N == 0 {
+		return 0
+	}
+	return float64(h.Total) / float64(h.N)
+}
+
+// Percentile returns an upper bound of the size which p percent of the sizes
+// don't exceed, the largest size of its bucket.
+func (h *SizeHistogram) Percentile(p float64) int64 {
+	if h.N == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(h.N)))
+	var n int
+	for i, count := range h.Counts {
+		if n += count; n >= rank && count > 0 {
+			return min(SizeHistogramBound(i), h.Max)
+		}
+	}
+	return h.Max
+}
+
+// SizeHistogramBound returns the largest size counted by the i-th bucket of a
+// SizeHistogram.
+func SizeHistogramBound(i int) int64 {
+	if i >= 64 {
+		return math.MaxInt64
+	}
+	return int64(1)<<i - 1
+}
+
+// SizeStats records the distribution of the sizes of the keys and values of a
+// bucket, see Bucket.SizeStats.
+type SizeStats struct {
+	KeySizes   SizeHistogram // sizes of the keys, not of the nested buckets
+	ValueSizes SizeHistogram // sizes of the values as they were put
+
+	// StoredValueSizes are the sizes of the values on their pages, which
+	// differ for compressed values, values with a TTL, and streamed values,
+	// whose chunks are counted by BlobPageN instead.
+	StoredValueSizes SizeHistogram
+	BlobPageN        int // number of pages of the chunks of streamed values
+
+	// LeafOverflow counts the number of physical overflow pages of each
+	// leaf page, so that pages with large values show up.
+	LeafOverflow SizeHistogram
+}
+
+// Add adds the sizes of other to the stats.
+func (s *SizeStats) Add(other SizeStats) {
+	s.KeySizes.Add(other.KeySizes)
+	s.ValueSizes.Add(other.ValueSizes)
+	s.StoredValueSizes.Add(other.StoredValueSizes)
+	s.BlobPageN += other.BlobPageN
+	s.LeafOverflow.Add(other.LeafOverflow)
+}
+
+// SizeStats returns the distribution of the sizes of the keys and values of
+// the bucket, read in a single walk of its pages, e.g. for capacity planning.
+// The keys of nested buckets aren't counted, nor their own keys and values.
+// Like Stats, a write transaction only sees the committed overflow pages.
+func (b *Bucket) SizeStats() SizeStats {
+	defer b.tx.catch(nil)
+	var s SizeStats
+	add := func(k, v []byte, flags uint32) {
+		if (flags & common.BucketLeafFlag) != 0 {
+			return
+		}
+		s.KeySizes.add(int64(len(k)))
+		s.StoredValueSizes.add(int64(len(v)))
+		switch {
+		case (flags & common.BlobLeafFlag) != 0:
+			s.ValueSizes.add(blobSize(v))
+			_ = b.tx.walkBlobPages(v, 0, 0, nil, func(info *PageInfo) error {
+				s.BlobPageN += 1 + info.OverflowCount
+				return nil
+			})
+		case (flags & common.CompressedLeafFlag) != 0:
+			size, _ := binary.Uvarint(v)
+			s.ValueSizes.add(int64(size))
+		case (flags & common.ExpiringLeafFlag) != 0:
+			s.ValueSizes.add(int64(len(v) - expiryLen))
+		default:
+			s.ValueSizes.add(int64(len(v)))
+		}
+	}
+	b._forEachPageNode(b.RootPage(), 0, func(p *common.Page, n *node, _ int) {
+		if n != nil {
+			if n.isLeaf {
+				for _, inode := range n.inodes {
+					add(inode.Key(), inode.Value(), inode.Flags())
+				}
+			}
+			return
+		}
+		if !p.IsLeafPage() {
+			return
+		}
+		if b.RootPage() != 0 {
+			s.LeafOverflow.add(int64(p.Overflow()))
+		}
+		for i := uint16(0); i < p.Count(); i++ {
+			e := p.LeafPageElement(i)
+			add(e.Key(), e.Value(), e.Flags())
+		}
+	})
+	return s
+}
@@ -0,0 +1,81 @@
+package boltdb_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestBucket_SizeStats(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		require.NoError(t, b.Put([]byte("large"), make([]byte, 10000)))
+		require.NoError(t, b.PutWithTTL([]byte("ttl"), make([]byte, 10), time.Hour))
+		mustPutReader(t, b, "blob", largeValue(100000, 1))
+		nested, err := b.CreateBucket([]byte("nested"))
+		if err != nil {
+			return err
+		}
+		return nested.Put([]byte("key"), []byte("value"))
+	})
+	require.NoError(t, err)
+
+	check := func(t *testing.T, b *bolt.Bucket) {
+		s := b.SizeStats()
+		require.Equal(t, 1003, s.KeySizes.N)
+		require.Equal(t, 1002, s.KeySizes.Counts[3]) // 4 to 7 bytes
+		require.Equal(t, int64(3), s.KeySizes.Min)
+		require.Equal(t, int64(5), s.KeySizes.Max)
+
+		require.Equal(t, 1003, s.ValueSizes.N)
+		require.Equal(t, int64(100*1000+10000+10+100000), s.ValueSizes.Total)
+		require.Equal(t, 1000, s.ValueSizes.Counts[7]) // 100 bytes
+		require.Equal(t, int64(127), s.ValueSizes.Percentile(50))
+		require.Equal(t, int64(100000), s.ValueSizes.Percentile(100))
+		require.Equal(t, int64(100000), s.ValueSizes.Max)
+		require.Equal(t, int64(10), s.ValueSizes.Min)
+		require.Less(t, s.StoredValueSizes.Total, s.ValueSizes.Total)
+		require.Greater(t, s.BlobPageN, 100000/4096)
+
+		// The large value overflows its leaf page.
+		require.Greater(t, s.LeafOverflow.N, 10)
+		require.Equal(t, int64(2), s.LeafOverflow.Max)
+
+		var total bolt.SizeStats
+		total.Add(s)
+		total.Add(b.Bucket([]byte("nested")).SizeStats())
+		require.Equal(t, 1004, total.KeySizes.N)
+		require.Equal(t, int64(3), total.KeySizes.Min)
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		check(t, tx.Bucket([]byte("widgets")))
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Write transactions see their changes.
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.NoError(t, b.Put([]byte("more"), bytes.Repeat([]byte("x"), 20)))
+		s := b.SizeStats()
+		require.Equal(t, 1004, s.KeySizes.N)
+		require.Equal(t, 1, s.ValueSizes.Counts[5])
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,282 @@
+package boltdb
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// snapshotChunkSize is the amount of data copied between progress reports
+// and rate limiting pauses by Tx.WriteToWithOptions.
+const snapshotChunkSize = 1024 * 1024
+
+// WriteToOptions controls how Tx.WriteToWithOptions and Tx.CopyFileWithOptions
+// write the database.
+type WriteToOptions struct {
+	// BytesPerSecond limits the average rate at which the database is
+	// written, so that a hot backup doesn't saturate the disk.
+	// If <=0, the rate isn't limited.
+	BytesPerSecond int64
+
+	// Progress is called after each chunk of pages is written. The copy is
+	// paused while Progress runs, and it's aborted with the returned error
+	// if it's not nil.
+	Progress func(WriteToProgress) error
+
+	// ProgressPages is the number of pages written between calls to
+	// Progress. If <=0, Progress is called after each 1MB of pages.
+	ProgressPages int
+
+	// Control pauses and resumes the copy. Since the transaction is kept
+	// open while the copy is paused, pages freed in the meantime can't be
+	// reused and the database may grow.
+	Control *CopyControl
+
+	// SkipFreelist leaves the freelist out of the copy, as if it had been
+	// written with Options.NoFreelistSync, so that it's rebuilt from the
+	// reachable pages when the copy is opened.
+	SkipFreelist bool
+}
+
+// CopyControl pauses and resumes a copy made by Tx.WriteToWithOptions or
+// Tx.CopyFileWithOptions from another goroutine. The copy is paused between
+// chunks of pages. The zero value is ready to use.
+type CopyControl struct {
+	mu      sync.Mutex
+	resumed chan struct{} // closed by Resume, nil unless paused
+}
+
+// Pause pauses the copy until Resume is called.
+func (c *CopyControl) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resumed == nil {
+		c.resumed = make(chan struct{})
+	}
+}
+
+// Resume resumes a paused copy.
+func (c *CopyControl) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resumed != nil {
+		close(c.resumed)
+		c.resumed = nil
+	}
+}
+
+// Paused returns whether the copy is paused.
+func (c *CopyControl) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.resumed != nil
+}
+
+// wait blocks while the copy is paused.
+func (c *CopyControl) wait() {
+	c.mu.Lock()
+	resumed := c.resumed
+	c.mu.Unlock()
+	if resumed != nil {
+		<-resumed
+	}
+}
+
+// WriteToProgress reports the progress of Tx.WriteToWithOptions.
+type WriteToProgress struct {
+	PagesWritten int64 // number of pages written so far, including meta pages
+	PagesTotal   int64 // total number of pages to write
+	BytesWritten int64 // number of bytes written so far
+}
+
+// snapshotWriter writes a database snapshot while enforcing WriteToOptions.
+type snapshotWriter struct {
+	w        io.Writer
+	o        WriteToOptions
+	progress WriteToProgress
+
+	// Rate limiting window, which restarts after every chunk.
+	windowStart time.Time
+}
+
+func newSnapshotWriter(w io.Writer, o *WriteToOptions, pagesTotal int64) *snapshotWriter {
+	sw := &snapshotWriter{w: w, windowStart: time.Now()}
+	if o != nil {
+		sw.o = *o
+	}
+	sw.progress.PagesTotal = pagesTotal
+	return sw
+}
+
+// Write writes a single page, e.g. a meta page.
+func (sw *snapshotWriter) Write(b []byte) (int, error) {
+	n, err := sw.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, sw.written(int64(n), 1)
+}
+
+// copyPages copies size bytes of pages of the given size from r.
+func (sw *snapshotWriter) copyPages(r io.Reader, size int64, pageSize int) (n int64, err error) {
+	if sw.o.BytesPerSecond <= 0 && sw.o.Progress == nil && sw.o.Control == nil {
+		return io.CopyN(sw.w, r, size)
+	}
+
+	chunk := int64(snapshotChunkSize)
+	if sw.o.ProgressPages > 0 {
+		chunk = int64(sw.o.ProgressPages) * int64(pageSize)
+	}
+	if rate := sw.o.BytesPerSecond; rate > 0 && chunk > rate/10 {
+		// Pause at least ten times per second at low rates.
+		chunk = rate / 10
+	}
+	if chunk < int64(pageSize) {
+		chunk = int64(pageSize)
+	}
+	chunk -= chunk % int64(pageSize)
+
+	for n < size {
+		sz := chunk
+		if size-n < sz {
+			sz = size - n
+		}
+		wn, err := io.CopyN(sw.w, r, sz)
+		n += wn
+		if err != nil {
+			return n, err
+		}
+		if err := sw.written(wn, wn/int64(pageSize)); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// written accounts for n bytes in the given number of pages, waits as long as
+// needed to respect the rate limit and reports progress.
+func (sw *snapshotWriter) written(n, pages int64) error {
+	sw.progress.BytesWritten += n
+	sw.progress.PagesWritten += pages
+
+	if rate := sw.o.BytesPerSecond; rate > 0 {
+		expected := time.Duration(float64(n) / float64(rate) * float64(time.Second))
+		if d := expected - time.Since(sw.windowStart); d > 0 {
+			time.Sleep(d)
+		}
+	}
+
+	var err error
+	if sw.o.Progress != nil {
+		err = sw.o.Progress(sw.progress)
+	}
+	if err == nil && sw.o.Control != nil {
+		sw.o.Control.wait()
+	}
+	// Time spent in Progress and while the copy is paused doesn't count
+	// towards the rate limit.
+	sw.windowStart = time.Now()
+	return err
+}
+
+// Snapshot is a consistent view of the database as of the transaction
+// committed last when it was taken, see DB.Snapshot. Unlike a read-only
+// transaction it isn't bound to a goroutine and doesn't block remapping the
+// database between uses, so it can be kept and handed around, e.g. across
+// API boundaries. Its pages aren't reused until it's closed, so it must be
+// closed once it's no longer needed.
+type Snapshot struct {
+	db     *DB
+	meta   common.Meta
+	closed bool // protected by db.metalock
+}
+
+// Snapshot takes a snapshot of the database as of the transaction committed
+// last. Compacting, migrating or rekeying the database closes the open
+// snapshots, since their pages are gone.
+func (db *DB) Snapshot() (*Snapshot, error) {
+	db.metalock.Lock()
+	defer db.metalock.Unlock()
+	if !db.opened {
+		return nil, berrors.ErrDatabaseNotOpen
+	}
+
+	s := &Snapshot{db: db}
+	db.meta().Copy(&s.meta)
+	if db.snapshots == nil {
+		db.snapshots = make(map[*Snapshot]struct{})
+	}
+	db.snapshots[s] = struct{}{}
+	return s, nil
+}
+
+// ID returns the id of the transaction the snapshot was taken at.
+func (s *Snapshot) ID() int {
+	return int(s.meta.Txid())
+}
+
+// View executes fn within a read-only transaction at the snapshot, like
+// DB.View. Views can run concurrently.
+func (s *Snapshot) View(fn func(*Tx) error) error {
+	t, err := s.db.beginTxAt(context.Background(), func() (*common.Meta, error) {
+		if s.closed {
+			return nil, berrors.ErrSnapshotClosed
+		}
+		return &s.meta, nil
+	})
+	if err != nil {
+		return err
+	}
+	return t.view(fn)
+}
+
+// WriteTo writes the database as of the snapshot to w, like Tx.WriteTo.
+func (s *Snapshot) WriteTo(w io.Writer) (n int64, err error) {
+	err = s.View(func(tx *Tx) error {
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// CopyFile copies the database as of the snapshot to the file at path, like
+// Tx.CopyFile.
+func (s *Snapshot) CopyFile(path string, mode os.FileMode) error {
+	return s.View(func(tx *Tx) error {
+		return tx.CopyFile(path, mode)
+	})
+}
+
+// Close releases the pages of the snapshot. Closing a closed snapshot is a
+// no-op.
+func (s *Snapshot) Close() error {
+	s.db.metalock.Lock()
+	defer s.db.metalock.Unlock()
+	s.closed = true
+	delete(s.db.snapshots, s)
+	return nil
+}
+
+// snapshotTxids returns the ids of the transactions of the open snapshots,
+// whose pages must not be released. The caller must hold metalock.
+func (db *DB) snapshotTxids() []common.Txid {
+	var txids []common.Txid
+	for s := range db.snapshots {
+		txids = append(txids, s.meta.Txid())
+	}
+	return txids
+}
+
+// closeSnapshots closes all the open snapshots. The caller must hold
+// metalock.
+func (db *DB) closeSnapshots() {
+	for s := range db.snapshots {
+		s.closed = true
+	}
+	db.snapshots = nil
+}
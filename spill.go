@@ -0,0 +1,104 @@
+package boltdb
+
+import (
+	"time"
+	"unsafe"
+
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// dirty records n more bytes of changes in a write transaction and writes
+// out the dirty pages once they exceed Options.MaxDirtyBytes.
+func (tx *Tx) dirty(n int) error {
+	tx.dirtyBytes += n
+	if tx.db.maxDirtyBytes <= 0 || tx.dirtyBytes <= tx.db.maxDirtyBytes || tx.spillHeld > 0 {
+		return nil
+	}
+	if err := tx.spillDirty(); err != nil {
+		// The changes can't be committed once part of them is lost.
+		tx.err = err
+		return err
+	}
+	return nil
+}
+
+// spillDirty writes the dirty pages out to the data file before commit and
+// drops the nodes, so that the memory of a large write transaction stays
+// bounded. The pages are allocated like on commit, so no committed meta
+// refers to them and a crash or a rollback leaves them free. They're read
+// back from the mmap when they're changed again, and the pages they replace
+// stay pending until the transaction commits, so that the values returned
+// by Get stay valid.
+func (tx *Tx) spillDirty() error {
+	// The nodes are dropped, so they must be verified now.
+	if tx.touched != nil {
+		if err := tx.VerifyTouchedPages(); err != nil {
+			return err
+		}
+	}
+
+	// The transaction may now free pages it allocated itself.
+	tx.db.freelist.spilled = tx.meta.Txid()
+
+	startTime := time.Now()
+	tx.root.rebalance()
+	if tx.stats.GetRebalance() > 0 {
+		tx.stats.IncRebalanceTime(time.Since(startTime))
+	}
+
+	startTime = time.Now()
+	if err := tx.root.spill(); err != nil {
+		return err
+	}
+	tx.stats.IncSpillTime(time.Since(startTime))
+	tx.meta.RootBucket().SetRootPage(tx.root.RootPage())
+
+	startTime = time.Now()
+	if err := tx.writeOut(tx.dirtyPages()); err != nil {
+		return err
+	}
+	tx.stats.IncWriteTime(time.Since(startTime))
+
+	tx.root.dropNodes()
+	tx.dirtyBytes = 0
+	tx.stats.IncDirtySpill(1)
+	return nil
+}
+
+// writeOut writes dirty pages out to the data file before commit and
+// releases them. They must have been removed from tx.pages.
+func (tx *Tx) writeOut(pages common.Pages) error {
+	// Pages written out mustn't be reused before commit once freed.
+	tx.db.freelist.spilled = tx.meta.Txid()
+
+	if err := tx.db.grow(int(tx.meta.Pgid()+1) * tx.db.pageSize); err != nil {
+		return err
+	}
+	if err := tx.writePages(pages); err != nil {
+		return err
+	}
+	if err := tx.db.fadviseWritten(pages); err != nil {
+		return err
+	}
+	tx.releasePages(pages)
+	tx.spilled = true
+	return nil
+}
+
+// dropNodes drops the nodes of a bucket and its cached child buckets once
+// they have been spilled, so that they're read again from their pages. The
+// child buckets stay valid for the caller.
+func (b *Bucket) dropNodes() {
+	for _, child := range b.buckets {
+		if child.RootPage() != 0 {
+			child.page = nil
+		} else if child.rootNode != nil {
+			// The inline page was written into the parent, keep a copy of it.
+			value := child.write()
+			child.page = (*common.Page)(unsafe.Pointer(&value[common.BucketHeaderSize]))
+		}
+		child.dropNodes()
+	}
+	b.rootNode = nil
+	b.nodes = make(map[common.Pgid]*node)
+}
@@ -0,0 +1,120 @@
+package boltdb_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestTx_MaxDirtyBytes(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options bolt.Options
+	}{
+		{name: "default"},
+		{name: "wal", options: bolt.Options{WAL: true}},
+		{name: "encrypted", options: bolt.Options{Encryption: mustAESGCMCipher(t, "0123456789abcdef")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			o := tc.options
+			o.MaxDirtyBytes = 64 * 1024
+			db := btesting.MustCreateDBWithOption(t, &o)
+
+			value := func(i int) []byte {
+				return bytes.Repeat([]byte{byte(i)}, 100)
+			}
+			err := db.Update(func(tx *bolt.Tx) error {
+				root, err := tx.CreateBucket([]byte("widgets"))
+				if err != nil {
+					return err
+				}
+				child, err := root.CreateBucket([]byte("child"))
+				if err != nil {
+					return err
+				}
+				if err := child.Put([]byte("first"), []byte("value")); err != nil {
+					return err
+				}
+				first := child.Get([]byte("first"))
+
+				// The bucket handles stay valid while the dirty pages are
+				// written out.
+				for i := 0; i < 10000; i++ {
+					if err := root.Put([]byte(fmt.Sprintf("%05d", i)), value(i)); err != nil {
+						return err
+					}
+					if i%1000 == 0 {
+						if err := child.Put([]byte(fmt.Sprintf("%05d", i)), value(i)); err != nil {
+							return err
+						}
+					}
+				}
+				for i := 0; i < 10000; i += 2 {
+					if err := root.Delete([]byte(fmt.Sprintf("%05d", i))); err != nil {
+						return err
+					}
+				}
+
+				require.Positive(t, tx.Stats().DirtySpill)
+				require.Equal(t, []byte("value"), first)
+				require.Equal(t, value(3), root.Get([]byte("00003")))
+				require.Equal(t, value(2000), child.Get([]byte("02000")))
+				return nil
+			})
+			require.NoError(t, err)
+
+			db.MustCheck()
+			db.MustClose()
+			db.MustReopen()
+			err = db.View(func(tx *bolt.Tx) error {
+				root := tx.Bucket([]byte("widgets"))
+				for i := 0; i < 10000; i++ {
+					v := root.Get([]byte(fmt.Sprintf("%05d", i)))
+					if i%2 == 0 {
+						require.Nil(t, v)
+					} else {
+						require.Equal(t, value(i), v)
+					}
+				}
+				child := root.Bucket([]byte("child"))
+				require.Equal(t, []byte("value"), child.Get([]byte("first")))
+				require.Equal(t, 11, child.Stats().KeyN)
+				return nil
+			})
+			require.NoError(t, err)
+			db.MustCheck()
+		})
+	}
+}
+
+func TestTx_MaxDirtyBytes_Rollback(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{MaxDirtyBytes: 16 * 1024})
+	putWidgets(t, db, 0, 100)
+
+	errRollback := errors.New("rollback")
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for i := 0; i < 5000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		require.Positive(t, tx.Stats().DirtySpill)
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+
+	db.MustCheck()
+	requireWidgets(t, db.DB, 100)
+
+	// The pages written out by the rolled back transaction are free again.
+	putWidgets(t, db, 100, 200)
+	db.MustCheck()
+	requireWidgets(t, db.DB, 200)
+}
@@ -0,0 +1,76 @@
+package boltdb
+
+import (
+	"bytes"
+
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// splitGranularity is the number of pages of the tree SplitPoints looks for
+// per range, so that the ranges are close in size.
+const splitGranularity = 8
+
+// splitElement is a child of a branch or a key of a leaf, see SplitPoints.
+type splitElement struct {
+	key  []byte
+	pgid common.Pgid
+}
+
+// SplitPoints returns up to n-1 ascending keys dividing the bucket into n
+// ranges of about the same number of pages, e.g. to scan or export it with n
+// goroutines: the first range ends before the first key, and the i-th range
+// starts at the (i-1)-th key. The keys are read from the branch pages, level
+// by level, until a level has enough pages, so only the leaves of small
+// buckets are read. Fewer keys are returned if the bucket is too small.
+// The returned keys are only valid for the life of the transaction.
+func (b *Bucket) SplitPoints(n int) [][]byte {
+	defer b.tx.catch(nil)
+	if n <= 1 {
+		return nil
+	}
+
+	elems := b.splitElements(b.RootPage())
+	for len(elems) < n*splitGranularity && len(elems) > 0 && elems[0].pgid != 0 {
+		var next []splitElement
+		for _, e := range elems {
+			next = append(next, b.splitElements(e.pgid)...)
+		}
+		elems = next
+	}
+
+	var keys [][]byte
+	for i := 1; i < n; i++ {
+		j := i * len(elems) / n
+		if j == 0 || (len(keys) > 0 && bytes.Equal(keys[len(keys)-1], elems[j].key)) {
+			continue
+		}
+		keys = append(keys, elems[j].key)
+	}
+	return keys
+}
+
+// splitElements returns the children of a branch, or the keys of a leaf with
+// a zero pgid.
+func (b *Bucket) splitElements(id common.Pgid) []splitElement {
+	p, n := b.pageNode(id)
+	var elems []splitElement
+	if n != nil {
+		for _, inode := range n.inodes {
+			e := splitElement{key: inode.Key()}
+			if !n.isLeaf {
+				e.pgid = inode.Pgid()
+			}
+			elems = append(elems, e)
+		}
+		return elems
+	}
+	for i := uint16(0); i < p.Count(); i++ {
+		if p.IsBranchPage() {
+			e := p.BranchPageElement(i)
+			elems = append(elems, splitElement{key: e.Key(), pgid: e.Pgid()})
+		} else {
+			elems = append(elems, splitElement{key: p.LeafPageElement(i).Key()})
+		}
+	}
+	return elems
+}
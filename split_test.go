@@ -0,0 +1,82 @@
+package boltdb_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestBucket_SplitPoints(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageSize: 4096})
+	const n = 20000
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%06d", i)), []byte("value")); err != nil {
+				return err
+			}
+		}
+		small, err := tx.CreateBucket([]byte("small"))
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"a", "b", "c"} {
+			if err := small.Put([]byte(k), []byte("value")); err != nil {
+				return err
+			}
+		}
+		_, err = tx.CreateBucket([]byte("empty"))
+		return err
+	})
+	require.NoError(t, err)
+
+	check := func(t *testing.T, b *bolt.Bucket) {
+		keys := b.SplitPoints(4)
+		require.Len(t, keys, 3)
+
+		// The ranges hold about the same number of keys.
+		var counts []int
+		var count int
+		c := b.Cursor()
+		i := 0
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if i < len(keys) && bytes.Equal(k, keys[i]) {
+				counts = append(counts, count)
+				count = 0
+				i++
+			}
+			count++
+		}
+		counts = append(counts, count)
+		require.Len(t, counts, 4)
+		for _, count := range counts {
+			require.InDelta(t, n/4, count, n/20)
+		}
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		check(t, tx.Bucket([]byte("widgets")))
+		require.Nil(t, tx.Bucket([]byte("widgets")).SplitPoints(1))
+		require.Equal(t, [][]byte{[]byte("b"), []byte("c")}, tx.Bucket([]byte("small")).SplitPoints(3))
+		require.Equal(t, [][]byte{[]byte("b"), []byte("c")}, tx.Bucket([]byte("small")).SplitPoints(10))
+		require.Nil(t, tx.Bucket([]byte("empty")).SplitPoints(4))
+		return nil
+	})
+	require.NoError(t, err)
+
+	// A write transaction splits the keys it changed.
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.NoError(t, b.Put([]byte("000100"), []byte("changed")))
+		check(t, b)
+		return nil
+	})
+	require.NoError(t, err)
+}
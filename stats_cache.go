@@ -0,0 +1,73 @@
+package boltdb
+
+import (
+	"sync"
+
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// maxCachedStats bounds the number of buckets whose stats are cached.
+const maxCachedStats = 4096
+
+// StatsBreakdown returns the stats of the bucket like Stats, along with the
+// stats of each of its nested buckets by name, which include the stats of
+// their own nested buckets.
+func (b *Bucket) StatsBreakdown() (BucketStats, map[string]BucketStats) {
+	defer b.tx.catch(nil)
+	return b.stats(true)
+}
+
+// statsKey identifies the committed state of a bucket: its pages don't change
+// as long as its root page and version stay the same.
+type statsKey struct {
+	root    common.Pgid
+	version uint64
+}
+
+// statsCache holds the stats of the buckets read by read-only transactions,
+// so that the unchanged nested buckets aren't walked again by Bucket.Stats.
+type statsCache struct {
+	mu    sync.Mutex
+	stats map[statsKey]BucketStats
+}
+
+func (c *statsCache) get(k statsKey) (BucketStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.stats[k]
+	return s, ok
+}
+
+func (c *statsCache) put(k statsKey, s BucketStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stats == nil || len(c.stats) >= maxCachedStats {
+		c.stats = make(map[statsKey]BucketStats)
+	}
+	c.stats[k] = s
+}
+
+// reset drops the cached stats once the pages they describe are gone.
+func (c *statsCache) reset() {
+	c.mu.Lock()
+	c.stats = nil
+	c.mu.Unlock()
+}
+
+// cachedStats returns the stats of the bucket, from the cache of the
+// database if the bucket wasn't changed since they were computed. Only
+// committed buckets with pages of their own are cached, as inline buckets
+// are cheap to walk.
+func (b *Bucket) cachedStats() BucketStats {
+	if b.tx.writable || b.RootPage() == 0 || b.ext.Version() == 0 {
+		s, _ := b.stats(false)
+		return s
+	}
+	k := statsKey{root: b.RootPage(), version: b.ext.Version()}
+	if s, ok := b.tx.db.statsCache.get(k); ok {
+		return s
+	}
+	s, _ := b.stats(false)
+	b.tx.db.statsCache.put(k, s)
+	return s
+}
@@ -0,0 +1,40 @@
+package boltdb
+
+// SyncMode is the system call used to flush the data file to disk on commit,
+// see Options.SyncMode. The modes which aren't available on a platform fall
+// back to its default.
+type SyncMode int
+
+const (
+	// SyncDefault keeps the default of the platform: fdatasync(2) on Linux,
+	// fcntl(F_FULLFSYNC) on darwin, msync(2) on OpenBSD, FlushFileBuffers on
+	// Windows and fsync(2) elsewhere.
+	SyncDefault SyncMode = iota
+
+	// SyncFdatasync flushes the data and only the metadata needed to read
+	// it back, such as the file size, with fdatasync(2). It's fsync(2) on
+	// the platforms without fdatasync.
+	SyncFdatasync
+
+	// SyncFsync flushes the data and all the metadata with fsync(2). On
+	// darwin, fsync(2) doesn't flush the write cache of the drive, so a
+	// power loss may still lose or reorder the commits.
+	SyncFsync
+
+	// SyncFullFsync flushes the data and asks the drive to flush its write
+	// cache with fcntl(F_FULLFSYNC), which is durable but slow. (darwin only)
+	SyncFullFsync
+
+	// SyncBarrierFsync flushes the data with fcntl(F_BARRIERFSYNC), which
+	// only keeps the drive from reordering the writes across the barrier.
+	// A power loss may lose the last commits, but not corrupt the database.
+	// It's F_FULLFSYNC on older systems. (darwin only)
+	SyncBarrierFsync
+
+	// SyncFileRange writes out the pages of the data file and waits for
+	// them with sync_file_range(2), which neither flushes the metadata nor
+	// the write cache of the drive. It's only safe on drives without a
+	// volatile write cache, and unless NoGrowSync is set, since the file
+	// size is only flushed when the file grows. (Linux only)
+	SyncFileRange
+)
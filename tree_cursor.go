@@ -0,0 +1,92 @@
+package boltdb
+
+import (
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// TreeCursor iterates depth-first over the keys of a bucket and of all its
+// nested buckets, see Bucket.TreeCursor. A nested bucket is returned with a
+// nil value right before its own keys.
+// The paths, keys and values it returns are only valid for the life of the
+// transaction, and the slice of a path is reused by the next call.
+type TreeCursor struct {
+	cursors []*Cursor // a cursor per level, the first one of the bucket
+	names   [][]byte  // the names of the nested buckets of the levels
+	keys    [][]byte  // the path of the current key
+
+	// descend is set when the current key is a nested bucket whose keys
+	// come next.
+	descend bool
+}
+
+// TreeCursor creates a cursor over the keys of the bucket and of all its
+// nested buckets, e.g. to export or audit a whole hierarchy without nesting
+// cursors by hand.
+func (b *Bucket) TreeCursor() *TreeCursor {
+	return &TreeCursor{cursors: []*Cursor{b.Cursor()}}
+}
+
+// First moves the cursor to the first key of the bucket and returns its path
+// and value. The path holds the names of the nested buckets leading to the
+// key, followed by the key. A nil path is returned if the bucket is empty.
+func (t *TreeCursor) First() (path [][]byte, value []byte) {
+	t.cursors, t.names = t.cursors[:1], t.names[:0]
+	return t.result(t.cursors[0].First())
+}
+
+// Next moves the cursor to the next key, depth-first, and returns its path
+// and value, or a nil path at the end.
+func (t *TreeCursor) Next() (path [][]byte, value []byte) {
+	if len(t.keys) == 0 {
+		return nil, nil
+	}
+	if t.descend {
+		t.descend = false
+		name := t.keys[len(t.keys)-1]
+		c := t.cursors[len(t.cursors)-1].Bucket().Bucket(name).Cursor()
+		t.cursors, t.names = append(t.cursors, c), append(t.names, name)
+		if k, v := c.First(); k != nil {
+			return t.result(k, v)
+		}
+		t.pop()
+	}
+	for {
+		if k, v := t.cursors[len(t.cursors)-1].Next(); k != nil {
+			return t.result(k, v)
+		}
+		if len(t.cursors) == 1 {
+			t.keys = t.keys[:0]
+			return nil, nil
+		}
+		t.pop()
+	}
+}
+
+// SkipBucket makes Next skip the keys of the current key if it's a nested
+// bucket.
+func (t *TreeCursor) SkipBucket() {
+	t.descend = false
+}
+
+// Depth returns the number of nested buckets leading to the current key,
+// zero for the keys of the bucket itself.
+func (t *TreeCursor) Depth() int {
+	return len(t.names)
+}
+
+// pop goes back to the level of the parent bucket.
+func (t *TreeCursor) pop() {
+	t.cursors, t.names = t.cursors[:len(t.cursors)-1], t.names[:len(t.names)-1]
+}
+
+// result makes an element of the cursor of the last level the current one.
+func (t *TreeCursor) result(k, v []byte) ([][]byte, []byte) {
+	if k == nil {
+		t.keys, t.descend = t.keys[:0], false
+		return nil, nil
+	}
+	_, _, flags := t.cursors[len(t.cursors)-1].keyValue()
+	t.descend = (flags & common.BucketLeafFlag) != 0
+	t.keys = append(append(t.keys[:0], t.names...), k)
+	return t.keys, v
+}
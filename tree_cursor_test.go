@@ -0,0 +1,69 @@
+package boltdb_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestBucket_TreeCursor(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		require.NoError(t, b.Put([]byte("a"), []byte("1")))
+		sub, err := b.CreateBucket([]byte("b"))
+		require.NoError(t, err)
+		require.NoError(t, sub.Put([]byte("x"), []byte("2")))
+		_, err = sub.CreateBucket([]byte("empty"))
+		require.NoError(t, err)
+		subsub, err := sub.CreateBucket([]byte("y"))
+		require.NoError(t, err)
+		require.NoError(t, subsub.Put([]byte("z"), []byte("3")))
+		require.NoError(t, b.Put([]byte("c"), []byte("4")))
+		_, err = tx.CreateBucket([]byte("none"))
+		return err
+	})
+	require.NoError(t, err)
+
+	// scan returns the paths of the keys joined with slashes, and the values.
+	scan := func(t *bolt.TreeCursor, skip string) []string {
+		var got []string
+		for path, v := t.First(); path != nil; path, v = t.Next() {
+			p := string(bytes.Join(path, []byte("/")))
+			if v == nil {
+				got = append(got, p+"/")
+			} else {
+				got = append(got, p+"="+string(v))
+			}
+			if p == skip {
+				t.SkipBucket()
+			}
+		}
+		return got
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("widgets")).TreeCursor()
+		require.Equal(t, []string{"a=1", "b/", "b/empty/", "b/x=2", "b/y/", "b/y/z=3", "c=4"}, scan(c, ""))
+		require.Equal(t, []string{"a=1", "b/", "c=4"}, scan(c, "b"))
+		path, _ := c.Next()
+		require.Nil(t, path)
+
+		path, _ = c.First()
+		require.Equal(t, 0, c.Depth())
+		for ; string(path[len(path)-1]) != "z"; path, _ = c.Next() {
+		}
+		require.Equal(t, 2, c.Depth())
+
+		path, _ = tx.Bucket([]byte("none")).TreeCursor().First()
+		require.Nil(t, path)
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,251 @@
+package boltdb
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// expiryLen is the size of the expiry prepended to the values put with a TTL.
+const expiryLen = 8
+
+// reapTxMaxKeys limits the number of keys the background reaper deletes in
+// a single write transaction.
+const reapTxMaxKeys = 10000
+
+// PutWithTTL sets the value for a key in the bucket like Put, and makes the
+// key expire once ttl has passed. Expired keys are hidden from Get, cursors
+// and ForEach right away, and deleted by Tx.ReapExpired, which runs in the
+// background when Options.ReapInterval is set. Putting the key again with Put
+// removes its TTL. The value is copied.
+// Returns an error if ttl isn't positive, or like Put.
+func (b *Bucket) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return errors.ErrInvalidTTL
+	}
+	return b.putExpiring(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+// putExpiring puts a value which expires at the given time, in Unix
+// nanoseconds.
+func (b *Bucket) putExpiring(key []byte, value []byte, expires int64) error {
+	v := make([]byte, expiryLen+len(value))
+	binary.LittleEndian.PutUint64(v, uint64(expires))
+	copy(v[expiryLen:], value)
+	if err := b.put(key, v, common.ExpiringLeafFlag); err != nil {
+		return err
+	}
+	b.ext.LowerNextExpiry(expires)
+	return nil
+}
+
+// ExpiresAt returns the time a key put with PutWithTTL expires at. It returns
+// the zero time if the key doesn't exist, has no TTL or has expired.
+func (b *Bucket) ExpiresAt(key []byte) (t time.Time) {
+	defer b.tx.catch(nil)
+	k, v, flags := b.Cursor().seek(key)
+	if (flags&common.ExpiringLeafFlag) == 0 || string(k) != string(key) {
+		return time.Time{}
+	}
+	if expired(v, time.Now().UnixNano()) {
+		return time.Time{}
+	}
+	return time.Unix(0, expiry(v))
+}
+
+// expiry returns the expiry of a value put with a TTL.
+func expiry(v []byte) int64 {
+	return int64(binary.LittleEndian.Uint64(v))
+}
+
+// stripExpiry returns a value without the expiry of a value put with a TTL.
+func stripExpiry(v []byte, flags uint32) []byte {
+	if (flags & common.ExpiringLeafFlag) != 0 {
+		return v[expiryLen:]
+	}
+	return v
+}
+
+// expired returns whether a value put with a TTL expired at now.
+func expired(v []byte, now int64) bool {
+	return expiry(v) <= now
+}
+
+// skipExpired moves the cursor past the expired values with step, and strips
+// the expiry off the value it stops at.
+func (c *Cursor) skipExpired(k, v []byte, flags uint32, step func() ([]byte, []byte, uint32)) ([]byte, []byte, uint32) {
+	if (flags & common.ExpiringLeafFlag) == 0 {
+		return k, v, flags
+	}
+	now := time.Now().UnixNano()
+	for k != nil && (flags&common.ExpiringLeafFlag) != 0 {
+		if !expired(v, now) {
+			return k, v[expiryLen:], flags
+		}
+		k, v, flags = step()
+	}
+	return k, v, flags
+}
+
+// ReapExpired deletes up to limit expired keys, see Bucket.PutWithTTL, and
+// returns how many it deleted. Each bucket records when its first key
+// expires, so only the buckets with expired keys are scanned.
+func (tx *Tx) ReapExpired(limit int) (int, error) {
+	if tx.db == nil {
+		return 0, errors.ErrTxClosed
+	} else if !tx.writable {
+		return 0, errors.ErrTxNotWritable
+	}
+	r := reap{now: time.Now().UnixNano(), limit: limit}
+	_, err := tx.root.reapExpired(&r)
+	return r.n, err
+}
+
+// reap is the state of a Tx.ReapExpired call.
+type reap struct {
+	now   int64
+	limit int
+
+	// n is the number of keys deleted so far.
+	n int
+	// changed is set when keys are deleted or a bucket header is updated.
+	changed bool
+}
+
+// reapExpired deletes the expired keys of the bucket and its nested buckets
+// until the limit is reached, and returns whether it went through all of
+// them. It then updates the next expiry of the bucket.
+func (b *Bucket) reapExpired(r *reap) (bool, error) {
+	// The root bucket has no header, so its buckets are always looked at.
+	isRoot := b == &b.tx.root
+	if next := b.ext.NextExpiry(); !isRoot && (next == 0 || next > r.now) {
+		return true, nil
+	}
+
+	var keys, children [][]byte
+	var next common.InBucketExt
+	done := true
+	c := b.Cursor()
+	for k, v, flags := c.first(); k != nil; k, v, flags = c.next() {
+		if (flags & common.BucketLeafFlag) != 0 {
+			children = append(children, cloneBytes(k))
+		} else if (flags & common.ExpiringLeafFlag) == 0 {
+			continue
+		} else if !expired(v, r.now) {
+			next.LowerNextExpiry(expiry(v))
+		} else if r.n+len(keys) < r.limit {
+			keys = append(keys, cloneBytes(k))
+		} else {
+			done = false
+			break
+		}
+	}
+
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return false, err
+		}
+		r.n++
+		r.changed = true
+	}
+	if !done {
+		return false, nil
+	}
+
+	for _, name := range children {
+		child := b.Bucket(name)
+		ok, err := child.reapExpired(r)
+		if err != nil || !ok {
+			return false, err
+		}
+		next.LowerNextExpiry(child.ext.NextExpiry())
+	}
+
+	if !isRoot && next.NextExpiry() != b.ext.NextExpiry() {
+		// Materialize the root node so that the bucket header is saved.
+		if b.rootNode == nil {
+			_ = b.node(b.RootPage(), nil)
+		}
+		b.ext.SetNextExpiry(next.NextExpiry())
+		r.changed = true
+	}
+	return true, nil
+}
+
+// reaper periodically deletes the expired keys of a database.
+type reaper struct {
+	db       *DB
+	interval time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (db *DB) startReaper(interval time.Duration) {
+	r := &reaper{
+		db:       db,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+	db.reaper = r
+
+	r.wg.Add(1)
+	go r.run()
+}
+
+// stopReaper stops the background reaper and waits for the transaction in
+// progress to finish.
+func (db *DB) stopReaper() {
+	if db.reaper == nil {
+		return
+	}
+	close(db.reaper.stop)
+	db.reaper.wg.Wait()
+	db.reaper = nil
+}
+
+func (r *reaper) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+
+		// Delete the expired keys in batches, so that the writer lock is
+		// released in between. Errors are retried on the next tick.
+		for {
+			n, err := r.reap()
+			if err != nil || n < reapTxMaxKeys {
+				break
+			}
+			select {
+			case <-r.stop:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// reap deletes a batch of expired keys. The transaction is only committed if
+// it changed anything.
+func (r *reaper) reap() (int, error) {
+	tx, err := r.db.Begin(true)
+	if err != nil {
+		return 0, err
+	}
+	state := reap{now: time.Now().UnixNano(), limit: reapTxMaxKeys}
+	if _, err := tx.root.reapExpired(&state); err != nil || !state.changed {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	return state.n, tx.Commit()
+}
@@ -0,0 +1,195 @@
+package boltdb_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestBucket_PutWithTTL(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.ErrorIs(t, b.PutWithTTL([]byte("foo"), []byte("bar"), 0), berrors.ErrInvalidTTL)
+		require.NoError(t, b.Put([]byte("a"), []byte("1")))
+		require.NoError(t, b.PutWithTTL([]byte("b"), []byte("2"), 50*time.Millisecond))
+		require.NoError(t, b.PutWithTTL([]byte("c"), []byte("3"), time.Hour))
+		require.NoError(t, b.PutWithTTL([]byte("d"), []byte("4"), 50*time.Millisecond))
+
+		require.Equal(t, []byte("2"), b.Get([]byte("b")))
+		require.WithinDuration(t, time.Now().Add(time.Hour), b.ExpiresAt([]byte("c")), time.Minute)
+		require.True(t, b.ExpiresAt([]byte("a")).IsZero())
+		require.True(t, b.ExpiresAt([]byte("x")).IsZero())
+		return nil
+	})
+	require.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Nil(t, b.Get([]byte("b")))
+		require.Nil(t, b.Get([]byte("d")))
+		require.Equal(t, []byte("3"), b.Get([]byte("c")))
+		require.True(t, b.ExpiresAt([]byte("b")).IsZero())
+
+		var keys []string
+		require.NoError(t, b.ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k)+"="+string(v))
+			return nil
+		}))
+		require.Equal(t, []string{"a=1", "c=3"}, keys)
+
+		c := b.Cursor()
+		k, v := c.Seek([]byte("b"))
+		require.Equal(t, []byte("c"), k)
+		require.Equal(t, []byte("3"), v)
+		k, _ = c.Next()
+		require.Nil(t, k)
+		k, _ = c.Last()
+		require.Equal(t, []byte("c"), k)
+		k, _ = c.Prev()
+		require.Equal(t, []byte("a"), k)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Put removes the TTL.
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.NoError(t, b.Put([]byte("c"), []byte("5")))
+		require.Equal(t, []byte("5"), b.Get([]byte("c")))
+		require.True(t, b.ExpiresAt([]byte("c")).IsZero())
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestTx_ReapExpired(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		child, err := b.CreateBucket([]byte("child"))
+		require.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			k := []byte(fmt.Sprintf("%04d", i))
+			require.NoError(t, b.PutWithTTL(k, []byte("value"), 50*time.Millisecond))
+			require.NoError(t, child.PutWithTTL(k, []byte("value"), time.Duration(i+1)*50*time.Millisecond))
+		}
+		require.NoError(t, b.Put([]byte("kept"), []byte("value")))
+
+		n, err := tx.ReapExpired(1000)
+		require.NoError(t, err)
+		require.Zero(t, n)
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		_, err := tx.ReapExpired(1)
+		require.ErrorIs(t, err, berrors.ErrTxNotWritable)
+		return nil
+	})
+	require.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+
+	// The deletes are limited per call.
+	reap := func(limit int) int {
+		var n int
+		err := db.Update(func(tx *bolt.Tx) (err error) {
+			n, err = tx.ReapExpired(limit)
+			return err
+		})
+		require.NoError(t, err)
+		return n
+	}
+	require.Equal(t, 60, reap(60))
+	n := reap(1000)
+	require.GreaterOrEqual(t, n, 42)
+	db.MustCheck()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		// The 40 other keys of widgets, and the expired keys of child.
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, 100-(n-40), b.Bucket([]byte("child")).Stats().KeyN)
+		require.Equal(t, 2, b.Stats().KeyN-b.Bucket([]byte("child")).Stats().KeyN)
+		require.Equal(t, []byte("value"), b.Get([]byte("kept")))
+		require.Equal(t, []byte("value"), b.Bucket([]byte("child")).Get([]byte("0099")))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestDB_ReapInterval(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{ReapInterval: 10 * time.Millisecond})
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			if err := b.PutWithTTL([]byte(fmt.Sprintf("%04d", i)), []byte("value"), time.Millisecond); err != nil {
+				return err
+			}
+		}
+		return b.Put([]byte("kept"), []byte("value"))
+	})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		var keyN int
+		err := db.View(func(tx *bolt.Tx) error {
+			keyN = tx.Bucket([]byte("widgets")).Stats().KeyN
+			return nil
+		})
+		require.NoError(t, err)
+		return keyN == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestCompact_TTL(t *testing.T) {
+	src := btesting.MustCreateDB(t)
+	err := src.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		if err := b.PutWithTTL([]byte("expired"), []byte("value"), time.Millisecond); err != nil {
+			return err
+		}
+		if err := b.PutWithTTL([]byte("ttl"), []byte("value"), time.Hour); err != nil {
+			return err
+		}
+		return b.Put([]byte("kept"), []byte("value"))
+	})
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	dst, err := bolt.Open(filepath.Join(t.TempDir(), "dst.db"), 0600, nil)
+	require.NoError(t, err)
+	defer dst.Close()
+	require.NoError(t, bolt.Compact(dst, src.DB, 0))
+
+	err = dst.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, 2, b.Stats().KeyN)
+		require.Equal(t, []byte("value"), b.Get([]byte("ttl")))
+		require.WithinDuration(t, time.Now().Add(time.Hour), b.ExpiresAt([]byte("ttl")), time.Minute)
+		require.Equal(t, []byte("value"), b.Get([]byte("kept")))
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -1,12 +1,14 @@
 package boltdb
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -24,14 +26,68 @@ import (
 // are using them. A long running read transaction can cause the database to
 // quickly grow.
 type Tx struct {
-	writable       bool
-	managed        bool
-	db             *DB
-	meta           *common.Meta
-	root           Bucket
-	pages          map[common.Pgid]*common.Page
-	stats          TxStats
-	commitHandlers []func()
+	writable         bool
+	managed          bool
+	db               *DB
+	meta             *common.Meta
+	root             Bucket
+	pages            map[common.Pgid]*common.Page
+	stats            TxStats
+	commitHandlers   []func()
+	rollbackHandlers []func()
+	closeHandlers    []func()
+
+	// strictErrors converts corruption panics into err, see
+	// Options.StrictErrors.
+	strictErrors bool
+	err          error
+
+	// started is when a read transaction started. overdue is set by the
+	// watchdog once it's open for too long, under the meta lock.
+	started time.Time
+	overdue bool
+
+	// spanseq numbers the transaction in chunked mode, see DB.trackSpans.
+	spanseq uint64
+
+	// shrunk is set by DB.Shrink to trim the free pages at the end of the
+	// file on commit. It receives the number of bytes the file shrank by.
+	shrunk *int64
+
+	// dirtyBytes estimates the memory held by the changes since the dirty
+	// pages were last written out, see Options.MaxDirtyBytes. spilled is set
+	// once they have been. They aren't written out while spillHeld is set,
+	// e.g. while the indexes of a key are updated.
+	dirtyBytes int
+	spilled    bool
+	spillHeld  int
+
+	// written, grown and remapped are reported by CommitResult, which is
+	// set once the transaction is committed.
+	written  int
+	grown    int64
+	remapped bool
+	result   *CommitResult
+
+	// deferred lists the subtrees of the deleted buckets whose pages aren't
+	// freed yet, once deferredLoaded is set, see deferBucket.
+	deferred       []common.DeferredFree
+	deferredLoaded bool
+
+	// changes are the watched changes reported once the transaction is
+	// committed, see DB.Watch.
+	changes []ChangeEvent
+
+	// touched holds the pages read by a write transaction when
+	// Options.VerifyTouchedPages is set.
+	touched map[common.Pgid]struct{}
+
+	// pins counts the values pinned by Bucket.GetPinned. A read transaction
+	// closed with pinned values keeps its meta and stays open in pinnedDB
+	// until they're released.
+	pinMu    sync.Mutex
+	pins     int
+	pinnedDB *DB
 
 	// WriteFlag specifies the flag for write-related methods like WriteTo().
 	// Tx opens the database file with the specified flag to copy the data.
@@ -44,12 +100,19 @@ type Tx struct {
 
 // init initializes the transaction.
 func (tx *Tx) init(db *DB) {
+	tx.initAt(db, db.meta())
+}
+
+// initAt initializes the transaction at the committed transaction of meta.
+func (tx *Tx) initAt(db *DB, meta *common.Meta) {
 	tx.db = db
 	tx.pages = nil
 
+	tx.strictErrors = db.strictErrors
+
 	// Copy the meta page since it can be changed by the writer.
 	tx.meta = &common.Meta{}
-	db.meta().Copy(tx.meta)
+	meta.Copy(tx.meta)
 
 	// Copy over the root bucket.
 	tx.root = newBucket(tx)
@@ -60,6 +123,9 @@ func (tx *Tx) init(db *DB) {
 	if tx.writable {
 		tx.pages = make(map[common.Pgid]*common.Page)
 		tx.meta.IncTxid()
+		if db.verifyTouchedPages {
+			tx.touched = make(map[common.Pgid]struct{})
+		}
 	}
 }
 
@@ -123,6 +189,11 @@ func (tx *Tx) DeleteBucket(name []byte) error {
 	return tx.root.DeleteBucket(name)
 }
 
+// RenameBucket renames a top-level bucket, see Bucket.RenameBucket.
+func (tx *Tx) RenameBucket(oldName, newName []byte) error {
+	return tx.root.RenameBucket(oldName, newName)
+}
+
 // ForEach executes a function for each bucket in the root.
 // If the provided function returns an error then the iteration is stopped and
 // the error is returned to the caller.
@@ -132,15 +203,47 @@ func (tx *Tx) ForEach(fn func(name []byte, b *Bucket) error) error {
 	})
 }
 
+// catch recovers from a panic raised by a corrupted database in strict error
+// mode, see Options.StrictErrors, and returns ErrCorrupted in err, if it's not
+// nil, instead. The error is kept by the transaction, so that it can't be
+// committed. It must be deferred by the exported methods which read pages.
+func (tx *Tx) catch(err *error) {
+	if !tx.strictErrors {
+		return
+	}
+	if r := recover(); r != nil {
+		e := fmt.Errorf("%w: %v", berrors.ErrCorrupted, r)
+		if tx.err == nil {
+			tx.err = e
+		}
+		if err != nil {
+			*err = e
+		}
+	}
+}
+
 // OnCommit adds a handler function to be executed after the transaction successfully commits.
 func (tx *Tx) OnCommit(fn func()) {
 	tx.commitHandlers = append(tx.commitHandlers, fn)
 }
 
+// OnRollback adds a handler function to be executed after the transaction is
+// rolled back, either by Rollback or because Commit failed.
+func (tx *Tx) OnRollback(fn func()) {
+	tx.rollbackHandlers = append(tx.rollbackHandlers, fn)
+}
+
+// OnClose adds a handler function to be executed after the transaction is
+// closed, whether it's committed or rolled back, so that the resources tied
+// to it can be released. It runs after the commit or rollback handlers.
+func (tx *Tx) OnClose(fn func()) {
+	tx.closeHandlers = append(tx.closeHandlers, fn)
+}
+
 // Commit writes all changes to disk, updates the meta page and closes the transaction.
 // Returns an error if a disk write error occurs, or if Commit is
 // called on a read-only transaction.
-func (tx *Tx) Commit() error {
+func (tx *Tx) Commit() (err error) {
 	common.Assert(!tx.managed, "managed tx commit not allowed")
 	if tx.db == nil {
 		return berrors.ErrTxClosed
@@ -148,8 +251,27 @@ func (tx *Tx) Commit() error {
 		return berrors.ErrTxNotWritable
 	}
 
+	// A transaction which ran into a corrupted page can't be committed.
+	if tx.err != nil {
+		tx.rollback()
+		return tx.err
+	}
+	defer func() {
+		if tx.err != nil && tx.db != nil {
+			tx.rollback()
+		}
+	}()
+	defer tx.catch(&err)
+
 	// TODO(benbjohnson): Use vectorized I/O to write out dirty pages.
 
+	if tx.touched != nil {
+		if err := tx.VerifyTouchedPages(); err != nil {
+			tx.rollback()
+			return err
+		}
+	}
+
 	// Rebalance nodes which have had deletions.
 	var startTime = time.Now()
 	tx.root.rebalance()
@@ -170,17 +292,30 @@ func (tx *Tx) Commit() error {
 	// Free the old root bucket.
 	tx.meta.RootBucket().SetRootPage(tx.root.RootPage())
 
+	// Free some of the pages of the deleted buckets.
+	if err := tx.freeDeferred(); err != nil {
+		tx.rollback()
+		return err
+	}
+
 	// Free the old freelist because commit writes out a fresh freelist.
 	if tx.meta.Freelist() != common.PgidNoFreelist {
 		tx.db.freelist.free(tx.meta.Txid(), tx.db.page(tx.meta.Freelist()))
 	}
 
+	// Move the high water mark below the trailing free pages, so that the
+	// file can be truncated once the meta page is written.
+	if tx.shrunk != nil {
+		tx.meta.SetPgid(tx.db.freelist.trimTail(tx.meta.Pgid()))
+	}
+
 	if !tx.db.NoFreelistSync {
 		err := tx.commitFreelist()
 		if err != nil {
 			return err
 		}
 	} else {
+		tx.db.freelist.freeSegments(tx.meta.Txid())
 		tx.meta.SetFreelist(common.PgidNoFreelist)
 	}
 
@@ -226,21 +361,89 @@ func (tx *Tx) Commit() error {
 	}
 	tx.stats.IncWriteTime(time.Since(startTime))
 
+	// Truncate the trimmed pages while still holding the writer lock. The
+	// transaction is committed even if it fails.
+	var shrinkErr error
+	if tx.shrunk != nil {
+		*tx.shrunk, shrinkErr = tx.db.shrinkFile(tx.meta.Pgid())
+	}
+
 	// Finalize the transaction.
+	db, txid, stats := tx.db, tx.meta.Txid(), tx.stats
+	onFreePageThreshold := db.checkFreePageThreshold(tx)
+	tx.result = &CommitResult{
+		Txid:     int(txid),
+		PageN:    tx.written,
+		Grown:    tx.grown,
+		Remapped: tx.remapped,
+	}
+	changes := tx.changes
+	if changes != nil {
+		db.queueChanges(uint64(txid), changes)
+	}
 	tx.close()
+	defer tx.runCloseHandlers()
+
+	// Wait for the meta page to be synced, possibly along with the meta
+	// pages of later transactions.
+	if db.groupCommit != nil {
+		if err := db.groupCommit.wait(db, txid); err != nil {
+			return err
+		}
+	}
+	if changes != nil {
+		db.flushChanges(uint64(txid))
+	}
 
 	// Execute commit handlers now that the locks have been removed.
 	for _, fn := range tx.commitHandlers {
 		fn()
 	}
+	db.hooklock.RLock()
+	hooks := db.commitHooks
+	db.hooklock.RUnlock()
+	for _, fn := range hooks {
+		fn(uint64(txid), stats)
+	}
+	if onFreePageThreshold != nil {
+		onFreePageThreshold()
+	}
 
-	return nil
+	return shrinkErr
+}
+
+// CommitResult describes the commit of a write transaction.
+type CommitResult struct {
+	Txid     int   // id of the committed transaction
+	PageN    int   // number of pages written, including overflow and meta pages
+	Grown    int64 // number of bytes the data file was grown by, 0 with NoGrowSync
+	Remapped bool  // whether the data file was remapped
+}
+
+// CommitResult returns the result of the commit of a write transaction, or
+// nil if it isn't committed. The result of a managed transaction can be
+// retrieved from a handler registered with OnCommit.
+func (tx *Tx) CommitResult() *CommitResult {
+	return tx.result
 }
 
 func (tx *Tx) commitFreelist() error {
+	f := tx.db.freelist
+	f.segments = tx.db.freelistSegments
+	f.spans = tx.db.freelistSpans && !f.segments
+	tx.meta.SetFlags(tx.meta.Flags() &^ (common.MetaFlagFreelistSpans | common.MetaFlagFreelistSegments))
+	if f.segments {
+		tx.meta.SetFlags(tx.meta.Flags() | common.MetaFlagFreelistSegments)
+		return tx.commitFreelistSegments()
+	}
+	if f.spans {
+		tx.meta.SetFlags(tx.meta.Flags() | common.MetaFlagFreelistSpans)
+	}
+	f.freeSegments(tx.meta.Txid())
+
 	// Allocate new pages for the new free list. This will overestimate
 	// the size of the freelist but not underestimate the size (which would be bad).
-	p, err := tx.allocate((tx.db.freelist.size() / tx.db.pageSize) + 1)
+	p, err := tx.allocate(((tx.db.freelist.size() + tx.db.pageTrailerSize()) / tx.db.pageSize) + 1)
 	if err != nil {
 		tx.rollback()
 		return err
@@ -270,10 +473,18 @@ func (tx *Tx) nonPhysicalRollback() {
 	if tx.db == nil {
 		return
 	}
+	// The pages allocated for the dirty pages written out before commit
+	// must be freed again.
+	if tx.spilled {
+		tx.rollback()
+		return
+	}
 	if tx.writable {
 		tx.db.freelist.rollback(tx.meta.Txid())
 	}
 	tx.close()
+	tx.runRollbackHandlers()
+	tx.runCloseHandlers()
 }
 
 // rollback needs to reload the free pages from disk in case some system error happens like fsync error.
@@ -285,31 +496,58 @@ func (tx *Tx) rollback() {
 		tx.db.freelist.rollback(tx.meta.Txid())
 		// When mmap fails, the `data`, `dataref` and `datasz` may be reset to
 		// zero values, and there is no way to reload free page IDs in this case.
-		if tx.db.data != nil {
-			if !tx.db.hasSyncedFreelist() {
+		if tx.db.mapped() {
+			tx.db.installFreelist()
+			if tx.db.freelistErr.Load() != nil {
+				// The corrupted freelist stays ignored.
+				tx.db.freelist.noSyncReload(nil)
+			} else if !tx.db.hasSyncedFreelist() {
 				// Reconstruct free page list by scanning the DB to get the whole free page list.
 				// Note: scaning the whole db is heavy if your db size is large in NoSyncFreeList mode.
 				tx.db.freelist.noSyncReload(tx.db.freepages())
 			} else {
 				// Read free page list from freelist page.
+				tx.db.freelist.spans = tx.db.meta().HasFreelistSpans()
+				tx.db.freelist.segments = tx.db.meta().HasFreelistSegments()
 				tx.db.freelist.reload(tx.db.page(tx.db.meta().Freelist()))
 			}
 		}
 	}
 	tx.close()
+	tx.runRollbackHandlers()
+	tx.runCloseHandlers()
+}
+
+// runRollbackHandlers executes the rollback handlers once the locks have been
+// removed.
+func (tx *Tx) runRollbackHandlers() {
+	for _, fn := range tx.rollbackHandlers {
+		fn()
+	}
+}
+
+// runCloseHandlers executes the close handlers once the locks have been
+// removed.
+func (tx *Tx) runCloseHandlers() {
+	for _, fn := range tx.closeHandlers {
+		fn()
+	}
 }
 
 func (tx *Tx) close() {
 	if tx.db == nil {
 		return
 	}
+	var pinned bool
 	if tx.writable {
 		// Grab freelist stats.
 		var freelistFreeN = tx.db.freelist.free_count()
 		var freelistPendingN = tx.db.freelist.pending_count()
 		var freelistAlloc = tx.db.freelist.size()
+		tx.stats.Freelist = tx.db.freelist.spanStats()
 
 		// Remove transaction ref & writer lock.
+		tx.db.releaseSpans(tx)
 		tx.db.rwtx = nil
 		tx.db.rwlock.Unlock()
 
@@ -319,15 +557,18 @@ func (tx *Tx) close() {
 		tx.db.stats.PendingPageN = freelistPendingN
 		tx.db.stats.FreeAlloc = (freelistFreeN + freelistPendingN) * tx.db.pageSize
 		tx.db.stats.FreelistInuse = freelistAlloc
+		tx.db.stats.Freelist = tx.stats.Freelist
 		tx.db.stats.TxStats.add(&tx.stats)
 		tx.db.statlock.Unlock()
-	} else {
+	} else if pinned = tx.holdPinned(); !pinned {
 		tx.db.removeTx(tx)
 	}
 
 	// Clear all references.
 	tx.db = nil
-	tx.meta = nil
+	if !pinned {
+		tx.meta = nil
+	}
 	tx.root = Bucket{tx: tx}
 	tx.pages = nil
 }
@@ -344,8 +585,14 @@ func (tx *Tx) Copy(w io.Writer) error {
 // WriteTo writes the entire database to a writer.
 // If err == nil then exactly tx.Size() bytes will be written into the writer.
 func (tx *Tx) WriteTo(w io.Writer) (n int64, err error) {
+	return tx.WriteToWithOptions(w, nil)
+}
+
+// WriteToWithOptions writes the entire database to a writer like WriteTo,
+// optionally limiting the bandwidth and reporting progress, see WriteToOptions.
+func (tx *Tx) WriteToWithOptions(w io.Writer, o *WriteToOptions) (n int64, err error) {
 	// Attempt to open reader with WriteFlag
-	f, err := tx.db.openFile(tx.db.path, os.O_RDONLY|tx.WriteFlag, 0)
+	f, err := tx.openDataFile()
 	if err != nil {
 		return 0, err
 	}
@@ -360,11 +607,19 @@ func (tx *Tx) WriteTo(w io.Writer) (n int64, err error) {
 	page := (*common.Page)(unsafe.Pointer(&buf[0]))
 	page.SetFlags(common.MetaPageFlag)
 	*page.Meta() = *tx.meta
+	if o != nil && o.SkipFreelist {
+		page.Meta().SetFreelist(common.PgidNoFreelist)
+	}
+
+	cw := newSnapshotWriter(w, o, int64(tx.meta.Pgid()))
+	if tx.db.cipher != nil {
+		return tx.writeEncryptedTo(cw, f, page, buf)
+	}
 
 	// Write meta 0.
 	page.SetId(0)
 	page.Meta().SetChecksum(page.Meta().Sum64())
-	nn, err := w.Write(buf)
+	nn, err := cw.Write(buf)
 	n += int64(nn)
 	if err != nil {
 		return n, fmt.Errorf("meta 0 copy: %s", err)
@@ -374,7 +629,7 @@ func (tx *Tx) WriteTo(w io.Writer) (n int64, err error) {
 	page.SetId(1)
 	page.Meta().DecTxid()
 	page.Meta().SetChecksum(page.Meta().Sum64())
-	nn, err = w.Write(buf)
+	nn, err = cw.Write(buf)
 	n += int64(nn)
 	if err != nil {
 		return n, fmt.Errorf("meta 1 copy: %s", err)
@@ -386,7 +641,7 @@ func (tx *Tx) WriteTo(w io.Writer) (n int64, err error) {
 	}
 
 	// Copy data pages.
-	wn, err := io.CopyN(w, f, tx.Size()-int64(tx.db.pageSize*2))
+	wn, err := cw.copyPages(f, tx.Size()-int64(tx.db.pageSize*2), tx.db.pageSize)
 	n += wn
 	if err != nil {
 		return n, err
@@ -395,16 +650,35 @@ func (tx *Tx) WriteTo(w io.Writer) (n int64, err error) {
 	return n, nil
 }
 
+// openDataFile opens the data file with WriteFlag to copy pages from it, or
+// returns a reader of the image of an in-memory database.
+func (tx *Tx) openDataFile() (dataFile, error) {
+	if tx.db.inMemory {
+		return imageFile{bytes.NewReader(tx.db.image)}, nil
+	}
+	f, err := tx.db.openFile(tx.db.path, os.O_RDONLY|tx.WriteFlag, 0)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
 // CopyFile copies the entire database to file at the given path.
 // A reader transaction is maintained during the copy so it is safe to continue
 // using the database while a copy is in progress.
 func (tx *Tx) CopyFile(path string, mode os.FileMode) error {
+	return tx.CopyFileWithOptions(path, mode, nil)
+}
+
+// CopyFileWithOptions copies the entire database to file at the given path
+// like CopyFile, see WriteToOptions.
+func (tx *Tx) CopyFileWithOptions(path string, mode os.FileMode, o *WriteToOptions) error {
 	f, err := tx.db.openFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.WriteTo(f)
+	_, err = tx.WriteToWithOptions(f, o)
 	if err != nil {
 		_ = f.Close()
 		return err
@@ -431,79 +705,142 @@ func (tx *Tx) allocate(count int) (*common.Page, error) {
 
 // write writes any dirty pages to disk.
 func (tx *Tx) write() error {
-	// Sort pages by id.
-	pages := make(common.Pages, 0, len(tx.pages))
-	for _, p := range tx.pages {
-		pages = append(pages, p)
+	pages := tx.dirtyPages()
+
+	// Checkpoint the write-ahead log before appending to it if it's full.
+	if tx.db.wal != nil && tx.db.wal.full() {
+		if err := tx.db.checkpoint(); err != nil {
+			return err
+		}
 	}
-	// Clear out page cache early.
-	tx.pages = make(map[common.Pgid]*common.Page)
-	sort.Sort(pages)
 
-	// Write pages to disk in order.
-	for _, p := range pages {
-		rem := (uint64(p.Overflow()) + 1) * uint64(tx.db.pageSize)
-		offset := int64(p.Id()) * int64(tx.db.pageSize)
-		var written uintptr
+	if err := tx.writePages(pages); err != nil {
+		return err
+	}
 
-		// Write out page in "max allocation" sized chunks.
-		for {
-			sz := rem
-			if sz > maxAllocSize-1 {
-				sz = maxAllocSize - 1
+	if tx.db.wal != nil {
+		// The commit is durable once it's in the write-ahead log, the data
+		// file is synced on checkpoint. The pages written out before commit
+		// aren't in the log though, so they must be synced first.
+		if tx.spilled {
+			syncStart := time.Now()
+			if err := fdatasync(tx.db); err != nil {
+				return err
 			}
-			buf := common.UnsafeByteSlice(unsafe.Pointer(p), written, 0, int(sz))
-
-			if _, err := tx.db.ops.writeAt(buf, offset); err != nil {
+			tx.stats.IncSync(1)
+			tx.stats.IncSyncTime(time.Since(syncStart))
+		}
+		if err := tx.db.wal.append(tx.db, pages, tx.meta, &tx.stats); err != nil {
+			return err
+		}
+	} else if !tx.db.NoSync || common.IgnoreNoSync {
+		// Ignore file sync if flag is set on DB.
+		syncStart := time.Now()
+		if tx.db.groupCommit != nil {
+			// Syncing the pages also syncs the meta pages of earlier
+			// transactions waiting for a group commit.
+			if err := tx.db.groupCommit.sync(tx.db); err != nil {
 				return err
 			}
+		} else if err := fdatasync(tx.db); err != nil {
+			return err
+		}
+		tx.stats.IncSync(1)
+		tx.stats.IncSyncTime(time.Since(syncStart))
+	}
+	if err := tx.db.fadviseWritten(pages); err != nil {
+		return err
+	}
+	tx.releasePages(pages)
 
-			// Update statistics.
-			tx.stats.IncWrite(1)
-
-			// Exit inner for loop if we've written all the chunks.
-			rem -= sz
-			if rem == 0 {
-				break
-			}
+	return nil
+}
 
-			// Otherwise move offset forward and move pointer to next chunk.
-			offset += int64(sz)
-			written += uintptr(sz)
-		}
+// dirtyPages returns the dirty pages sorted by id and clears the page cache.
+func (tx *Tx) dirtyPages() common.Pages {
+	pages := make(common.Pages, 0, len(tx.pages))
+	for _, p := range tx.pages {
+		pages = append(pages, p)
 	}
+	tx.pages = make(map[common.Pgid]*common.Page)
+	sort.Sort(pages)
+	return pages
+}
 
-	// Ignore file sync if flag is set on DB.
-	if !tx.db.NoSync || common.IgnoreNoSync {
-		if err := fdatasync(tx.db); err != nil {
+// writePages writes pages to the data file in order. The runs of contiguous
+// pages are written together with vectored writes where they're supported.
+func (tx *Tx) writePages(pages common.Pages) error {
+	var (
+		bufs   [][]byte
+		offset int64
+		size   int64
+		next   common.Pgid
+	)
+	flush := func() error {
+		if len(bufs) == 0 {
+			return nil
+		}
+		n, err := tx.db.writeBuffers(bufs, offset)
+		if err != nil {
 			return err
 		}
+
+		// Update statistics.
+		tx.stats.IncWrite(int64(n))
+		tx.stats.IncWriteBytes(size)
+		bufs, size = bufs[:0], 0
+		return nil
 	}
 
-	// Put small pages back to page pool.
 	for _, p := range pages {
-		// Ignore page sizes over 1 page.
-		// These are allocated using make() instead of the page pool.
-		if int(p.Overflow()) != 0 {
-			continue
+		tx.written += int(p.Overflow()) + 1
+		if tx.db.pageTxids {
+			p.SetWrittenTxid(tx.db.pageSize, tx.db.pageChecksums, tx.meta.Txid())
+		}
+		if tx.db.pageChecksums {
+			p.SetChecksum(tx.db.pageSize)
 		}
 
-		buf := common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, tx.db.pageSize)
+		if p.Id() != next {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if len(bufs) == 0 {
+			offset = int64(p.Id()) * int64(tx.db.pageSize)
+		}
+		next = p.Id() + common.Pgid(p.Overflow()) + 1
 
-		// See https://go.googlesource.com/go/+/f03c9202c43e0abb130669852082117ca50aa9b1
-		for i := range buf {
-			buf[i] = 0
+		// Split the page in "max allocation" sized chunks.
+		rem := (uint64(p.Overflow()) + 1) * uint64(tx.db.pageSize)
+		for written := uintptr(0); rem > 0; {
+			if len(bufs) == maxWriteIovecs {
+				if err := flush(); err != nil {
+					return err
+				}
+				offset = int64(p.Id())*int64(tx.db.pageSize) + int64(written)
+			}
+			sz := min(rem, maxAllocSize-1)
+			bufs = append(bufs, common.UnsafeByteSlice(unsafe.Pointer(p), written, 0, int(sz)))
+			size += int64(sz)
+			rem -= sz
+			written += uintptr(sz)
 		}
-		tx.db.pagePool.Put(buf) //nolint:staticcheck
 	}
+	return flush()
+}
 
-	return nil
+// releasePages puts the written pages back to the page pool.
+func (tx *Tx) releasePages(pages common.Pages) {
+	for _, p := range pages {
+		tx.db.putPageBuffer(p)
+	}
 }
 
 // writeMeta writes the meta to the disk.
 func (tx *Tx) writeMeta() error {
 	// Create a temporary buffer for the meta page.
-	buf := make([]byte, tx.db.pageSize)
+	buf := tx.db.pageBuffer(tx.db.pageSize)
 	p := tx.db.pageInBuffer(buf, 0)
 	tx.meta.Write(p)
 
@@ -511,14 +848,26 @@ func (tx *Tx) writeMeta() error {
 	if _, err := tx.db.ops.writeAt(buf, int64(p.Id())*int64(tx.db.pageSize)); err != nil {
 		return err
 	}
-	if !tx.db.NoSync || common.IgnoreNoSync {
+	if err := tx.writeMetaCopy(buf); err != nil {
+		return err
+	}
+	// In WAL mode the meta page has already been synced to the log, and in
+	// group commit mode it's synced after the writer lock is released.
+	if tx.db.groupCommit != nil {
+		tx.db.groupCommit.metaWritten(tx.meta.Txid(), tx.db.NoSync && !common.IgnoreNoSync)
+	} else if tx.db.wal == nil && (!tx.db.NoSync || common.IgnoreNoSync) {
+		syncStart := time.Now()
 		if err := fdatasync(tx.db); err != nil {
 			return err
 		}
+		tx.stats.IncSync(1)
+		tx.stats.IncSyncTime(time.Since(syncStart))
 	}
 
 	// Update statistics.
+	tx.written++
 	tx.stats.IncWrite(1)
+	tx.stats.IncWriteBytes(int64(len(buf)))
 
 	return nil
 }
@@ -534,12 +883,44 @@ func (tx *Tx) page(id common.Pgid) *common.Page {
 		}
 	}
 
-	// Otherwise return directly from the mmap.
-	p := tx.db.page(id)
+	// Otherwise return directly from the mmap. Meta pages have their own
+	// checksum, which is validated when they are loaded.
+	var p *common.Page
+	if tx.db.pageChecksums && id > 1 {
+		var err error
+		if p, err = tx.verifiedPage(id); err != nil {
+			panic(err.Error())
+		}
+	} else {
+		p = tx.db.page(id)
+	}
 	p.FastCheck(id)
+	if tx.touched != nil {
+		tx.touched[id] = struct{}{}
+	}
+
+	// Update statistics.
+	tx.stats.IncPageRead(1)
+	tx.stats.IncReadBytes((int64(p.Overflow()) + 1) * int64(tx.db.pageSize))
 	return p
 }
 
+// verifiedPage returns the page with a given id from the mmap, after
+// verifying its checksum.
+func (tx *Tx) verifiedPage(id common.Pgid) (*common.Page, error) {
+	if id >= tx.meta.Pgid() {
+		return nil, fmt.Errorf("page %d: out of bounds: %d", id, tx.meta.Pgid())
+	}
+	p := tx.db.page(id)
+	if uint64(id)+uint64(p.Overflow()) >= uint64(tx.meta.Pgid()) {
+		return nil, fmt.Errorf("page %d: overflow out of bounds: %d", id, p.Overflow())
+	}
+	if err := p.VerifyChecksum(tx.db.pageSize); err != nil {
+		return nil, fmt.Errorf("page %d: %w", id, err)
+	}
+	return p, nil
+}
+
 // forEachPage iterates over every page within a given page and executes a function.
 func (tx *Tx) forEachPage(pgidnum common.Pgid, fn func(*common.Page, int, []common.Pgid)) {
 	stack := make([]common.Pgid, 10)
@@ -564,7 +945,7 @@ func (tx *Tx) forEachPageInternal(pgidstack []common.Pgid, fn func(*common.Page,
 
 // Page returns page information for a given page number.
 // This is only safe for concurrent use when used by a writable transaction.
-func (tx *Tx) Page(id int) (*common.PageInfo, error) {
+func (tx *Tx) Page(id int) (*PageInfo, error) {
 	if tx.db == nil {
 		return nil, berrors.ErrTxClosed
 	} else if common.Pgid(id) >= tx.meta.Pgid() {
@@ -577,7 +958,7 @@ func (tx *Tx) Page(id int) (*common.PageInfo, error) {
 
 	// Build the page info.
 	p := tx.db.page(common.Pgid(id))
-	info := &common.PageInfo{
+	info := &PageInfo{
 		ID:            id,
 		Count:         int(p.Count()),
 		OverflowCount: int(p.Overflow()),
@@ -593,6 +974,119 @@ func (tx *Tx) Page(id int) (*common.PageInfo, error) {
 	return info, nil
 }
 
+// PageInfo describes a page, see Tx.Page, Tx.PageInfo and Tx.ForEachPage.
+type PageInfo = common.PageInfo
+
+// PageInfo returns information about the page with a given id like Page,
+// and also attributes it to its bucket if it belongs to one, which requires
+// walking all the buckets.
+func (tx *Tx) PageInfo(id int) (*PageInfo, error) {
+	info, err := tx.Page(id)
+	if err != nil || info == nil || (info.Type != "branch" && info.Type != "leaf" && info.Type != "blob") {
+		return info, err
+	}
+
+	errFound := errors.New("found")
+	err = tx.ForEachPage(int(tx.root.RootPage()), func(p *PageInfo) error {
+		if p.ID == id {
+			info = p
+			return errFound
+		}
+		return nil
+	})
+	if err != nil && err != errFound {
+		return nil, err
+	}
+	return info, nil
+}
+
+// ForEachPage calls fn for each page of the bucket whose root page is pgid,
+// see Bucket.RootPage, and of its nested buckets, depth first. The bucket
+// names in the page infos are relative to that bucket, so passing the root
+// page of the root bucket, i.e. tx.Cursor().Bucket().RootPage(), walks all
+// the buckets with their full names. The iteration stops at the first error
+// returned by fn, which is returned.
+func (tx *Tx) ForEachPage(pgid int, fn func(info *PageInfo) error) error {
+	if tx.db == nil {
+		return berrors.ErrTxClosed
+	} else if pgid < 2 || common.Pgid(pgid) >= tx.meta.Pgid() {
+		return fmt.Errorf("page %d: %w", pgid, berrors.ErrInvalid)
+	}
+	return tx.walkPages(common.Pgid(pgid), 0, 0, nil, fn)
+}
+
+// walkPages calls fn for the page id referenced by parent, and for its
+// children.
+func (tx *Tx) walkPages(id, parent common.Pgid, depth int, bucket [][]byte, fn func(*PageInfo) error) error {
+	p := tx.page(id)
+	info := &PageInfo{
+		ID:            int(id),
+		Type:          p.Typ(),
+		Count:         int(p.Count()),
+		OverflowCount: int(p.Overflow()),
+		ParentID:      int(parent),
+		Depth:         depth,
+		Bucket:        bucket,
+	}
+	if err := fn(info); err != nil {
+		return err
+	}
+
+	switch {
+	case p.IsBranchPage():
+		for i := range p.BranchPageElements() {
+			if err := tx.walkPages(p.BranchPageElement(uint16(i)).Pgid(), id, depth+1, bucket, fn); err != nil {
+				return err
+			}
+		}
+	case p.IsLeafPage():
+		for i := range p.LeafPageElements() {
+			elem := p.LeafPageElement(uint16(i))
+			if elem.IsBlobEntry() {
+				if err := tx.walkBlobPages(elem.Value(), id, depth+1, bucket, fn); err != nil {
+					return err
+				}
+			}
+			if !elem.IsBucketEntry() {
+				continue
+			}
+			// Inline buckets are stored within the leaf page.
+			if root := elem.Bucket().RootPage(); root != 0 {
+				child := append(bucket[:len(bucket):len(bucket)], cloneBytes(elem.Key()))
+				if err := tx.walkPages(root, id, 0, child, fn); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// walkBlobPages calls fn for the chunks of a streamed value referenced by
+// the leaf parent.
+func (tx *Tx) walkBlobPages(ref []byte, parent common.Pgid, depth int, bucket [][]byte, fn func(*PageInfo) error) error {
+	for ; len(ref) > 0; ref = ref[blobRefSize:] {
+		id, left := blobRef(ref)
+		for left > 0 {
+			p := tx.page(id)
+			info := &PageInfo{
+				ID:            int(id),
+				Type:          p.Typ(),
+				OverflowCount: int(p.Overflow()),
+				ParentID:      int(parent),
+				Depth:         depth,
+				Bucket:        bucket,
+			}
+			if err := fn(info); err != nil {
+				return err
+			}
+			left -= int64(len(tx.blobData(p)))
+			id = blobNext(p)
+		}
+	}
+	return nil
+}
+
 // TxStats represents statistics about the actions performed by the transaction.
 type TxStats struct {
 	// Page statistics.
@@ -601,6 +1095,8 @@ type TxStats struct {
 	PageCount int64 // number of page allocations
 	// DEPRECATED: Use GetPageAlloc() or IncPageAlloc()
 	PageAlloc int64 // total bytes allocated
+	// DEPRECATED: Use GetAllocFallback() or IncAllocFallback()
+	AllocFallback int64 // number of allocations which grew the file while there were enough free pages, but no large enough span of them
 
 	// Cursor statistics.
 	//
@@ -629,6 +1125,8 @@ type TxStats struct {
 	Spill int64 // number of nodes spilled
 	// DEPRECATED: Use GetSpillTime() or IncSpillTime()
 	SpillTime time.Duration // total time spent spilling
+	// DEPRECATED: Use GetDirtySpill() or IncDirtySpill()
+	DirtySpill int64 // number of times dirty pages were written out before commit, see Options.MaxDirtyBytes
 
 	// Write statistics.
 	//
@@ -636,11 +1134,33 @@ type TxStats struct {
 	Write int64 // number of writes performed
 	// DEPRECATED: Use GetWriteTime() or IncWriteTime()
 	WriteTime time.Duration // total time spent writing to disk
+	// DEPRECATED: Use GetWriteBytes() or IncWriteBytes()
+	WriteBytes int64 // total bytes of pages written to the data file
+
+	// Read statistics.
+	//
+	// DEPRECATED: Use GetPageRead() or IncPageRead()
+	PageRead int64 // number of pages read from the mmap
+	// DEPRECATED: Use GetReadBytes() or IncReadBytes()
+	ReadBytes int64 // total bytes of pages read from the mmap, including overflow pages
+
+	// Sync statistics.
+	//
+	// DEPRECATED: Use GetSync() or IncSync()
+	Sync int64 // number of fsyncs of the data file or the write-ahead log
+	// DEPRECATED: Use GetSyncTime() or IncSyncTime()
+	SyncTime time.Duration // total time spent in fsync
+
+	// Freelist is the fragmentation of the free pages when a write
+	// transaction closes. It isn't merged into DB.Stats().TxStats, see
+	// Stats.Freelist.
+	Freelist FreelistStats
 }
 
 func (s *TxStats) add(other *TxStats) {
 	s.IncPageCount(other.GetPageCount())
 	s.IncPageAlloc(other.GetPageAlloc())
+	s.IncAllocFallback(other.GetAllocFallback())
 	s.IncCursorCount(other.GetCursorCount())
 	s.IncNodeCount(other.GetNodeCount())
 	s.IncNodeDeref(other.GetNodeDeref())
@@ -649,8 +1169,14 @@ func (s *TxStats) add(other *TxStats) {
 	s.IncSplit(other.GetSplit())
 	s.IncSpill(other.GetSpill())
 	s.IncSpillTime(other.GetSpillTime())
+	s.IncDirtySpill(other.GetDirtySpill())
 	s.IncWrite(other.GetWrite())
 	s.IncWriteTime(other.GetWriteTime())
+	s.IncWriteBytes(other.GetWriteBytes())
+	s.IncPageRead(other.GetPageRead())
+	s.IncReadBytes(other.GetReadBytes())
+	s.IncSync(other.GetSync())
+	s.IncSyncTime(other.GetSyncTime())
 }
 
 // Sub calculates and returns the difference between two sets of transaction stats.
@@ -660,6 +1186,7 @@ func (s *TxStats) Sub(other *TxStats) TxStats {
 	var diff TxStats
 	diff.PageCount = s.GetPageCount() - other.GetPageCount()
 	diff.PageAlloc = s.GetPageAlloc() - other.GetPageAlloc()
+	diff.AllocFallback = s.GetAllocFallback() - other.GetAllocFallback()
 	diff.CursorCount = s.GetCursorCount() - other.GetCursorCount()
 	diff.NodeCount = s.GetNodeCount() - other.GetNodeCount()
 	diff.NodeDeref = s.GetNodeDeref() - other.GetNodeDeref()
@@ -668,8 +1195,15 @@ func (s *TxStats) Sub(other *TxStats) TxStats {
 	diff.Split = s.GetSplit() - other.GetSplit()
 	diff.Spill = s.GetSpill() - other.GetSpill()
 	diff.SpillTime = s.GetSpillTime() - other.GetSpillTime()
+	diff.DirtySpill = s.GetDirtySpill() - other.GetDirtySpill()
 	diff.Write = s.GetWrite() - other.GetWrite()
 	diff.WriteTime = s.GetWriteTime() - other.GetWriteTime()
+	diff.WriteBytes = s.GetWriteBytes() - other.GetWriteBytes()
+	diff.PageRead = s.GetPageRead() - other.GetPageRead()
+	diff.ReadBytes = s.GetReadBytes() - other.GetReadBytes()
+	diff.Sync = s.GetSync() - other.GetSync()
+	diff.SyncTime = s.GetSyncTime() - other.GetSyncTime()
+	diff.Freelist = s.Freelist
 	return diff
 }
 
@@ -693,6 +1227,16 @@ func (s *TxStats) IncPageAlloc(delta int64) int64 {
 	return atomic.AddInt64(&s.PageAlloc, delta)
 }
 
+// GetAllocFallback returns AllocFallback atomically.
+func (s *TxStats) GetAllocFallback() int64 {
+	return atomic.LoadInt64(&s.AllocFallback)
+}
+
+// IncAllocFallback increases AllocFallback atomically and returns the new value.
+func (s *TxStats) IncAllocFallback(delta int64) int64 {
+	return atomic.AddInt64(&s.AllocFallback, delta)
+}
+
 // GetCursorCount returns CursorCount atomically.
 func (s *TxStats) GetCursorCount() int64 {
 	return atomic.LoadInt64(&s.CursorCount)
@@ -773,6 +1317,16 @@ func (s *TxStats) IncSpillTime(delta time.Duration) time.Duration {
 	return atomicAddDuration(&s.SpillTime, delta)
 }
 
+// GetDirtySpill returns DirtySpill atomically.
+func (s *TxStats) GetDirtySpill() int64 {
+	return atomic.LoadInt64(&s.DirtySpill)
+}
+
+// IncDirtySpill increases DirtySpill atomically and returns the new value.
+func (s *TxStats) IncDirtySpill(delta int64) int64 {
+	return atomic.AddInt64(&s.DirtySpill, delta)
+}
+
 // GetWrite returns Write atomically.
 func (s *TxStats) GetWrite() int64 {
 	return atomic.LoadInt64(&s.Write)
@@ -793,6 +1347,56 @@ func (s *TxStats) IncWriteTime(delta time.Duration) time.Duration {
 	return atomicAddDuration(&s.WriteTime, delta)
 }
 
+// GetWriteBytes returns WriteBytes atomically.
+func (s *TxStats) GetWriteBytes() int64 {
+	return atomic.LoadInt64(&s.WriteBytes)
+}
+
+// IncWriteBytes increases WriteBytes atomically and returns the new value.
+func (s *TxStats) IncWriteBytes(delta int64) int64 {
+	return atomic.AddInt64(&s.WriteBytes, delta)
+}
+
+// GetPageRead returns PageRead atomically.
+func (s *TxStats) GetPageRead() int64 {
+	return atomic.LoadInt64(&s.PageRead)
+}
+
+// IncPageRead increases PageRead atomically and returns the new value.
+func (s *TxStats) IncPageRead(delta int64) int64 {
+	return atomic.AddInt64(&s.PageRead, delta)
+}
+
+// GetReadBytes returns ReadBytes atomically.
+func (s *TxStats) GetReadBytes() int64 {
+	return atomic.LoadInt64(&s.ReadBytes)
+}
+
+// IncReadBytes increases ReadBytes atomically and returns the new value.
+func (s *TxStats) IncReadBytes(delta int64) int64 {
+	return atomic.AddInt64(&s.ReadBytes, delta)
+}
+
+// GetSync returns Sync atomically.
+func (s *TxStats) GetSync() int64 {
+	return atomic.LoadInt64(&s.Sync)
+}
+
+// IncSync increases Sync atomically and returns the new value.
+func (s *TxStats) IncSync(delta int64) int64 {
+	return atomic.AddInt64(&s.Sync, delta)
+}
+
+// GetSyncTime returns SyncTime atomically.
+func (s *TxStats) GetSyncTime() time.Duration {
+	return atomicLoadDuration(&s.SyncTime)
+}
+
+// IncSyncTime increases SyncTime atomically and returns the new value.
+func (s *TxStats) IncSyncTime(delta time.Duration) time.Duration {
+	return atomicAddDuration(&s.SyncTime, delta)
+}
+
 func atomicAddDuration(ptr *time.Duration, du time.Duration) time.Duration {
 	return time.Duration(atomic.AddInt64((*int64)(unsafe.Pointer(ptr)), int64(du)))
 }
@@ -1,8 +1,11 @@
 package boltdb
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/openkvlab/boltdb/internal/common"
 )
@@ -18,61 +21,155 @@ import (
 //
 // It also allows users to provide a customized `KVStringer` implementation,
 // so that bolt can generate human-readable diagnostic messages.
+//
+// Buckets can be checked in parallel with WithParallelism, in which case the
+// errors aren't reported in a deterministic order, and the check can be
+// aborted with WithContext.
 func (tx *Tx) Check(options ...CheckOption) <-chan error {
 	chkConfig := checkConfig{
-		kvStringer: HexKVStringer(),
+		kvStringer:  HexKVStringer(),
+		ctx:         context.Background(),
+		parallelism: 1,
 	}
 	for _, op := range options {
 		op(&chkConfig)
 	}
 
 	ch := make(chan error)
-	go tx.check(chkConfig.kvStringer, ch)
+	go tx.check(chkConfig, ch)
 	return ch
 }
 
-func (tx *Tx) check(kvStringer KVStringer, ch chan error) {
+// checker holds the state of a consistency check which is shared by the
+// goroutines checking buckets in parallel.
+type checker struct {
+	tx         *Tx
+	kvStringer KVStringer
+	ctx        context.Context
+	ch         chan error
+
+	sem chan struct{} // limits the number of extra goroutines
+	wg  sync.WaitGroup
+
+	freed     map[common.Pgid]bool // read-only once the check of the buckets starts
+	mu        sync.Mutex           // protects reachable
+	reachable map[common.Pgid]*common.Page
+}
+
+// spawn runs fn in a new goroutine if the parallelism allows it, and inline
+// otherwise.
+func (c *checker) spawn(fn func()) {
+	select {
+	case c.sem <- struct{}{}:
+		c.wg.Add(1)
+		go func() {
+			defer func() {
+				<-c.sem
+				c.wg.Done()
+			}()
+			fn()
+		}()
+	default:
+		fn()
+	}
+}
+
+// canceled returns whether the context of the check is done.
+func (c *checker) canceled() bool {
+	select {
+	case <-c.ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func (tx *Tx) check(cfg checkConfig, ch chan error) {
+	// Close the channel to signal completion.
+	defer close(ch)
+
+	c := &checker{
+		tx:         tx,
+		kvStringer: cfg.kvStringer,
+		ctx:        cfg.ctx,
+		ch:         ch,
+		sem:        make(chan struct{}, max(cfg.parallelism-1, 0)),
+		freed:      make(map[common.Pgid]bool),
+		reachable:  make(map[common.Pgid]*common.Page),
+	}
+	defer func() {
+		if err := c.ctx.Err(); err != nil {
+			ch <- err
+		}
+	}()
+
 	// Force loading free list if opened in ReadOnly mode.
 	tx.db.loadFreelist()
+	if err := tx.db.freelistErr.Load(); err != nil {
+		ch <- *err
+	}
 
 	// Check if any pages are double freed.
-	freed := make(map[common.Pgid]bool)
 	all := make([]common.Pgid, tx.db.freelist.count())
 	tx.db.freelist.copyall(all)
 	for _, id := range all {
-		if freed[id] {
+		if c.freed[id] {
 			ch <- fmt.Errorf("page %d: already freed", id)
 		}
-		freed[id] = true
+		c.freed[id] = true
+	}
+	if r := tx.db.waitFreelistRebuild(); r != nil {
+		// The free pages reconstructed in the background may not be in
+		// the freelist yet.
+		for _, id := range r.ids {
+			c.freed[id] = true
+		}
+	}
+
+	// Verify page checksums first, since the checks below would panic
+	// when reading a corrupted page.
+	if tx.db.pageChecksums && !c.checkPageChecksums() {
+		return
+	}
+	if c.canceled() {
+		return
 	}
 
 	// Track every reachable page.
-	reachable := make(map[common.Pgid]*common.Page)
-	reachable[0] = tx.page(0) // meta0
-	reachable[1] = tx.page(1) // meta1
-	if tx.meta.Freelist() != common.PgidNoFreelist {
-		for i := uint32(0); i <= tx.page(tx.meta.Freelist()).Overflow(); i++ {
-			reachable[tx.meta.Freelist()+common.Pgid(i)] = tx.page(tx.meta.Freelist())
+	c.reachable[0] = tx.page(0) // meta0
+	c.reachable[1] = tx.page(1) // meta1
+	for _, p := range tx.freelistPages() {
+		for i := uint32(0); i <= p.Overflow(); i++ {
+			c.reachable[p.Id()+common.Pgid(i)] = p
 		}
 	}
 
 	// Recursively check buckets.
-	tx.checkBucket(&tx.root, reachable, freed, kvStringer, ch)
+	c.checkDeferred()
+	c.checkBucket(&tx.root)
+	c.wg.Wait()
+	if c.canceled() {
+		return
+	}
 
 	// Ensure all pages below high water mark are either reachable or freed.
 	for i := common.Pgid(0); i < tx.meta.Pgid(); i++ {
-		_, isReachable := reachable[i]
-		if !isReachable && !freed[i] {
+		_, isReachable := c.reachable[i]
+		if !isReachable && !c.freed[i] {
 			ch <- fmt.Errorf("page %d: unreachable unfreed", int(i))
 		}
 	}
-
-	// Close the channel to signal completion.
-	close(ch)
 }
 
-func (tx *Tx) checkBucket(b *Bucket, reachable map[common.Pgid]*common.Page, freed map[common.Pgid]bool,
-	kvStringer KVStringer, ch chan error) {
+// checkBucket checks the pages of b, and then of its nested buckets. A
+// bucket and its nested buckets are only accessed by one goroutine, since
+// opening a nested bucket caches it in its parent.
+func (c *checker) checkBucket(b *Bucket) {
+	tx := c.tx
+	if c.canceled() {
+		return
+	}
+
 	// Ignore inline buckets.
 	if b.RootPage() == 0 {
 		return
@@ -81,37 +178,174 @@ func (tx *Tx) checkBucket(b *Bucket, reachable map[common.Pgid]*common.Page, fre
 	// Check every page used by this bucket.
 	b.tx.forEachPage(b.RootPage(), func(p *common.Page, _ int, stack []common.Pgid) {
 		if p.Id() > tx.meta.Pgid() {
-			ch <- fmt.Errorf("page %d: out of bounds: %d (stack: %v)", int(p.Id()), int(b.tx.meta.Pgid()), stack)
+			c.ch <- fmt.Errorf("page %d: out of bounds: %d (stack: %v)", int(p.Id()), int(b.tx.meta.Pgid()), stack)
 		}
 
-		// Ensure each page is only referenced once.
-		for i := common.Pgid(0); i <= common.Pgid(p.Overflow()); i++ {
-			var id = p.Id() + i
-			if _, ok := reachable[id]; ok {
-				ch <- fmt.Errorf("page %d: multiple references (stack: %v)", int(id), stack)
-			}
-			reachable[id] = p
-		}
+		c.markReachable(p, stack)
 
 		// We should only encounter un-freed leaf and branch pages.
-		if freed[p.Id()] {
-			ch <- fmt.Errorf("page %d: reachable freed", int(p.Id()))
+		if c.freed[p.Id()] {
+			c.ch <- fmt.Errorf("page %d: reachable freed", int(p.Id()))
 		} else if !p.IsBranchPage() && !p.IsLeafPage() {
-			ch <- fmt.Errorf("page %d: invalid type: %s (stack: %v)", int(p.Id()), p.Typ(), stack)
+			c.ch <- fmt.Errorf("page %d: invalid type: %s (stack: %v)", int(p.Id()), p.Typ(), stack)
+		} else if p.IsLeafPage() && b.ext.HasBlobs() {
+			for i := range p.LeafPageElements() {
+				if elem := p.LeafPageElement(uint16(i)); elem.IsBlobEntry() {
+					c.checkBlob(elem.Value(), stack)
+				}
+			}
 		}
 	})
 
-	tx.recursivelyCheckPages(b.RootPage(), kvStringer.KeyToString, ch)
+	tx.recursivelyCheckPages(b.RootPage(), c.kvStringer.KeyToString, c.ch)
 
 	// Check each bucket within this bucket.
 	_ = b.ForEachBucket(func(k []byte) error {
+		if c.canceled() {
+			return c.ctx.Err()
+		}
 		if child := b.Bucket(k); child != nil {
-			tx.checkBucket(child, reachable, freed, kvStringer, ch)
+			c.spawn(func() { c.checkBucket(child) })
 		}
 		return nil
 	})
 }
 
+// markReachable records that p is referenced, and reports it if it was
+// already.
+func (c *checker) markReachable(p *common.Page, stack []common.Pgid) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := common.Pgid(0); i <= common.Pgid(p.Overflow()); i++ {
+		var id = p.Id() + i
+		if _, ok := c.reachable[id]; ok {
+			c.ch <- fmt.Errorf("page %d: multiple references (stack: %v)", int(id), stack)
+		}
+		c.reachable[id] = p
+	}
+}
+
+// checkBlob checks the chunks of a value streamed with PutReader, whose
+// reference is in a leaf at the top of stack.
+func (c *checker) checkBlob(ref []byte, stack []common.Pgid) {
+	tx := c.tx
+	if len(ref) == 0 || len(ref)%blobRefSize != 0 {
+		c.ch <- fmt.Errorf("invalid blob reference size: %d (stack: %v)", len(ref), stack)
+		return
+	}
+	for ; len(ref) > 0; ref = ref[blobRefSize:] {
+		id, left := blobRef(ref)
+		for left > 0 {
+			if id < 2 || id >= tx.meta.Pgid() {
+				c.ch <- fmt.Errorf("page %d: blob out of bounds: %d (stack: %v)", int(id), int(tx.meta.Pgid()), stack)
+				return
+			}
+			p := tx.page(id)
+			if !p.IsBlobPage() {
+				c.ch <- fmt.Errorf("page %d: invalid blob type: %s (stack: %v)", int(id), p.Typ(), stack)
+				return
+			}
+			c.markReachable(p, stack)
+			if c.freed[id] {
+				c.ch <- fmt.Errorf("page %d: reachable freed", int(id))
+			}
+			left -= int64(len(tx.blobData(p)))
+			id = blobNext(p)
+		}
+	}
+}
+
+// checkPageChecksums verifies the checksum of the freelist page and of every
+// page reachable from the root bucket, and reports all mismatches. It returns
+// false if any page failed verification.
+func (c *checker) checkPageChecksums() bool {
+	tx := c.tx
+	var failed atomic.Bool
+	if tx.meta.Freelist() != common.PgidNoFreelist {
+		if _, err := tx.checkedPage(tx.meta.Freelist()); err != nil {
+			c.ch <- err
+			failed.Store(true)
+		} else {
+			// The pages of the segments are listed by the freelist page.
+			for _, p := range tx.freelistPages()[1:] {
+				if _, err := tx.checkedPage(p.Id()); err != nil {
+					c.ch <- err
+					failed.Store(true)
+				}
+			}
+		}
+	}
+	c.checkTreeChecksums(tx.meta.RootBucket().RootPage(), &failed)
+	c.wg.Wait()
+	return !failed.Load()
+}
+
+// checkTreeChecksums verifies the checksums of the pages in the subtree
+// rooted at pgId, including the pages of nested buckets, and sets failed if
+// any page failed verification.
+func (c *checker) checkTreeChecksums(pgId common.Pgid, failed *atomic.Bool) {
+	if c.canceled() {
+		return
+	}
+	p, err := c.tx.checkedPage(pgId)
+	if err != nil {
+		c.ch <- err
+		failed.Store(true)
+		return
+	}
+
+	switch {
+	case p.IsBranchPage():
+		for i := range p.BranchPageElements() {
+			c.checkTreeChecksums(p.BranchPageElement(uint16(i)).Pgid(), failed)
+		}
+	case p.IsLeafPage():
+		for i := range p.LeafPageElements() {
+			elem := p.LeafPageElement(uint16(i))
+			if elem.IsBlobEntry() {
+				c.checkBlobChecksums(elem.Value(), failed)
+			}
+			if !elem.IsBucketEntry() {
+				continue
+			}
+			// Inline buckets are stored within the leaf page.
+			if root := elem.Bucket().RootPage(); root != 0 {
+				c.spawn(func() { c.checkTreeChecksums(root, failed) })
+			}
+		}
+	}
+}
+
+// checkBlobChecksums verifies the checksums of the chunks of a streamed
+// value, and sets failed if any chunk failed verification.
+func (c *checker) checkBlobChecksums(ref []byte, failed *atomic.Bool) {
+	for ; len(ref) >= blobRefSize; ref = ref[blobRefSize:] {
+		id, left := blobRef(ref)
+		for left > 0 {
+			p, err := c.tx.checkedPage(id)
+			if err != nil {
+				c.ch <- err
+				failed.Store(true)
+				return
+			} else if !p.IsBlobPage() {
+				// The type is reported by checkBucket.
+				return
+			}
+			left -= int64(len(c.tx.blobData(p)))
+			id = blobNext(p)
+		}
+	}
+}
+
+// checkedPage returns the page with a given id, verifying its checksum
+// unless it's a dirty page of this transaction.
+func (tx *Tx) checkedPage(id common.Pgid) (*common.Page, error) {
+	if p, ok := tx.pages[id]; ok {
+		return p, nil
+	}
+	return tx.verifiedPage(id)
+}
+
 // recursivelyCheckPages confirms database consistency with respect to b-tree
 // key order constraints:
 //   - keys on pages must be sorted
@@ -192,7 +426,9 @@ func verifyKeyOrder(pgId common.Pgid, pageType string, index int, key []byte, pr
 // ===========================================================================================
 
 type checkConfig struct {
-	kvStringer KVStringer
+	kvStringer  KVStringer
+	ctx         context.Context
+	parallelism int
 }
 
 type CheckOption func(options *checkConfig)
@@ -203,6 +439,22 @@ func WithKVStringer(kvStringer KVStringer) CheckOption {
 	}
 }
 
+// WithContext aborts the check once ctx is done, in which case the last
+// error reported is ctx.Err().
+func WithContext(ctx context.Context) CheckOption {
+	return func(c *checkConfig) {
+		c.ctx = ctx
+	}
+}
+
+// WithParallelism checks up to n buckets at the same time. Values below 2
+// check the buckets one at a time, which is the default.
+func WithParallelism(n int) CheckOption {
+	return func(c *checkConfig) {
+		c.parallelism = n
+	}
+}
+
 // KVStringer allows to prepare human-readable diagnostic messages.
 type KVStringer interface {
 	KeyToString([]byte) string
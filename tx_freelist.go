@@ -0,0 +1,102 @@
+package boltdb
+
+import (
+	"sort"
+
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// FreePage describes free or pending pages, see Tx.FreePages and
+// Tx.PendingPages.
+type FreePage struct {
+	ID    int // the first page
+	Count int // the number of pages, 1 unless they're returned as spans
+
+	// Txid is the transaction which freed the pages, or 0 once they're free
+	// since it's not tracked anymore. AllocTxid is the transaction which
+	// allocated them, or 0 if it's unknown. A pending page is free once no
+	// read transaction at or after AllocTxid and before Txid is open.
+	Txid      int
+	AllocTxid int
+}
+
+// FreePages returns the free pages of the database in ascending order, which
+// are reused by the next allocations. With spans, each contiguous range of
+// free pages is returned once, otherwise each page is.
+//
+// Like Page, it describes the freelist of the database rather than the
+// snapshot of tx, as of the last commit and the allocations of tx if it's
+// writable, and it's only safe for concurrent use when used by a writable
+// transaction. It returns the error why the freelist was ignored if it's
+// corrupted, see DB.RepairFreelist.
+func (tx *Tx) FreePages(spans bool) ([]FreePage, error) {
+	f, err := tx.loadedFreelist()
+	if err != nil {
+		return nil, err
+	}
+	var pages []FreePage
+	f.spanTree.ascend(func(start common.Pgid, size uint64) bool {
+		if spans {
+			pages = append(pages, FreePage{ID: int(start), Count: int(size)})
+			return true
+		}
+		for i := uint64(0); i < size; i++ {
+			pages = append(pages, FreePage{ID: int(start) + int(i), Count: 1})
+		}
+		return true
+	})
+	return pages, nil
+}
+
+// PendingPages returns the pages freed by write transactions which may still
+// be visible to open read transactions, in ascending order. They keep the
+// file from shrinking until they're free. With spans, the contiguous pages
+// freed and allocated by the same transactions are returned once.
+//
+// Like FreePages, it describes the freelist of the database, which includes
+// the pages freed by tx if it's writable.
+func (tx *Tx) PendingPages(spans bool) ([]FreePage, error) {
+	f, err := tx.loadedFreelist()
+	if err != nil {
+		return nil, err
+	}
+	var pages []FreePage
+	for txid, txp := range f.pending {
+		for i, id := range txp.ids {
+			pages = append(pages, FreePage{ID: int(id), Count: 1, Txid: int(txid), AllocTxid: int(txp.alloctx[i])})
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].ID < pages[j].ID })
+	if !spans {
+		return pages, nil
+	}
+
+	var merged []FreePage
+	for _, p := range pages {
+		if l := len(merged) - 1; l >= 0 && merged[l].ID+merged[l].Count == p.ID &&
+			merged[l].Txid == p.Txid && merged[l].AllocTxid == p.AllocTxid {
+			merged[l].Count++
+			continue
+		}
+		merged = append(merged, p)
+	}
+	return merged, nil
+}
+
+// loadedFreelist returns the freelist for FreePages and PendingPages.
+func (tx *Tx) loadedFreelist() (*freelist, error) {
+	if tx.db == nil {
+		return nil, berrors.ErrTxClosed
+	}
+	if tx.writable {
+		tx.db.installFreelist()
+	}
+	if tx.db.freelist == nil {
+		return nil, berrors.ErrFreePagesNotLoaded
+	}
+	if err := tx.db.freelistErr.Load(); err != nil {
+		return nil, *err
+	}
+	return tx.db.freelist, nil
+}
@@ -0,0 +1,92 @@
+package boltdb_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestTx_FreePages(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	putBigBucket(t, db, "kept", 100)
+	putBigBucket(t, db, "deleted", 500)
+
+	// The pages of the deleted bucket stay pending while rtx is open.
+	rtx, err := db.Begin(false)
+	require.NoError(t, err)
+	var deleteTxid int
+	err = db.Update(func(tx *bolt.Tx) error {
+		deleteTxid = tx.ID()
+		return tx.DeleteBucket([]byte("deleted"))
+	})
+	require.NoError(t, err)
+
+	var pending []bolt.FreePage
+	err = db.Update(func(tx *bolt.Tx) error {
+		pending, err = tx.PendingPages(false)
+		require.NoError(t, err)
+		var n int
+		for _, p := range pending {
+			require.Equal(t, 1, p.Count)
+			if p.Txid == deleteTxid {
+				require.LessOrEqual(t, p.AllocTxid, rtx.ID())
+				n++
+			}
+		}
+		require.Greater(t, n, 100)
+
+		spans, err := tx.PendingPages(true)
+		require.NoError(t, err)
+		require.Less(t, len(spans), len(pending))
+		requireSpansOf(t, pending, spans)
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, rtx.Rollback())
+
+	// They're free once rtx is closed.
+	err = db.Update(func(tx *bolt.Tx) error {
+		free, err := tx.FreePages(false)
+		require.NoError(t, err)
+		ids := make(map[int]bool)
+		for _, p := range free {
+			require.Zero(t, p.Txid)
+			ids[p.ID] = true
+		}
+		for _, p := range pending {
+			if p.Txid == deleteTxid {
+				require.True(t, ids[p.ID], "page %d isn't free", p.ID)
+			}
+		}
+
+		spans, err := tx.FreePages(true)
+		require.NoError(t, err)
+		require.Less(t, len(spans), len(free))
+		requireSpansOf(t, free, spans)
+		return nil
+	})
+	require.NoError(t, err)
+
+	tx, err := db.Begin(false)
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+	_, err = tx.FreePages(false)
+	require.ErrorIs(t, err, berrors.ErrTxClosed)
+	_, err = tx.PendingPages(false)
+	require.ErrorIs(t, err, berrors.ErrTxClosed)
+}
+
+// requireSpansOf requires spans to be the pages merged into spans.
+func requireSpansOf(t *testing.T, pages, spans []bolt.FreePage) {
+	var expanded []bolt.FreePage
+	for _, s := range spans {
+		for i := 0; i < s.Count; i++ {
+			expanded = append(expanded, bolt.FreePage{ID: s.ID + i, Count: 1, Txid: s.Txid, AllocTxid: s.AllocTxid})
+		}
+	}
+	require.Equal(t, pages, expanded)
+}
@@ -21,6 +21,11 @@ func TestTxStats_add(t *testing.T) {
 		SpillTime:     10001 * time.Second,
 		Write:         100000,
 		WriteTime:     100001 * time.Second,
+		WriteBytes:    100002,
+		PageRead:      1000000,
+		ReadBytes:     1000001,
+		Sync:          10000000,
+		SyncTime:      10000001 * time.Second,
 	}
 
 	statsB := TxStats{
@@ -36,6 +41,11 @@ func TestTxStats_add(t *testing.T) {
 		SpillTime:     11002 * time.Second,
 		Write:         110001,
 		WriteTime:     110010 * time.Second,
+		WriteBytes:    110011,
+		PageRead:      1100000,
+		ReadBytes:     1100002,
+		Sync:          11000000,
+		SyncTime:      11000003 * time.Second,
 	}
 
 	statsB.add(&statsA)
@@ -51,4 +61,9 @@ func TestTxStats_add(t *testing.T) {
 	assert.Equal(t, 21003*time.Second, statsB.GetSpillTime())
 	assert.Equal(t, int64(210001), statsB.GetWrite())
 	assert.Equal(t, 210011*time.Second, statsB.GetWriteTime())
+	assert.Equal(t, int64(210013), statsB.GetWriteBytes())
+	assert.Equal(t, int64(2100000), statsB.GetPageRead())
+	assert.Equal(t, int64(2100003), statsB.GetReadBytes())
+	assert.Equal(t, int64(21000000), statsB.GetSync())
+	assert.Equal(t, 21000004*time.Second, statsB.GetSyncTime())
 }
@@ -2,10 +2,13 @@ package boltdb_test
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
 	"testing"
 	"time"
@@ -69,6 +72,196 @@ func TestTx_Check_ReadOnly(t *testing.T) {
 	}
 }
 
+// TestTx_Check_PageChecksums tests that a corrupted page is reported by Check
+// and detected when it's read.
+func TestTx_Check_PageChecksums(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db, err := bolt.Open(path, 0600, &bolt.Options{PageChecksums: true, PageSize: 4096})
+	require.NoError(t, err)
+
+	var root uint64
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	err = db.View(func(tx *bolt.Tx) error {
+		root = uint64(tx.Bucket([]byte("widgets")).RootPage())
+		return <-tx.Check()
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// The format is kept without passing the option again.
+	db, err = bolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar"))
+	})
+	require.NoError(t, err)
+	err = db.View(func(tx *bolt.Tx) error {
+		root = uint64(tx.Bucket([]byte("widgets")).RootPage())
+		return <-tx.Check()
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// Flip a bit in the bucket's root page.
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	require.NoError(t, err)
+	b := make([]byte, 1)
+	off := int64(root)*4096 + 200
+	_, err = f.ReadAt(b, off)
+	require.NoError(t, err)
+	b[0] ^= 0x01
+	_, err = f.WriteAt(b, off)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	db, err = bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer db.Close()
+	err = db.View(func(tx *bolt.Tx) error {
+		var errs []error
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		require.Len(t, errs, 1)
+		require.ErrorIs(t, errs[0], berrors.ErrPageChecksum)
+
+		require.Panics(t, func() {
+			tx.Bucket([]byte("widgets")).Get([]byte("0001"))
+		})
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+// Ensure that buckets can be checked in parallel, and that the check can be
+// canceled.
+func TestTx_Check_Parallel(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageChecksums: true})
+	err := db.Update(func(tx *bolt.Tx) error {
+		for i := 0; i < 20; i++ {
+			b, err := tx.CreateBucket([]byte(fmt.Sprintf("bucket%02d", i)))
+			if err != nil {
+				return err
+			}
+			for j := 0; j < 5; j++ {
+				child, err := b.CreateBucket([]byte(fmt.Sprintf("child%d", j)))
+				if err != nil {
+					return err
+				}
+				for k := 0; k < 100; k++ {
+					if err := child.Put([]byte(fmt.Sprintf("%04d", k)), make([]byte, 100)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	check := func(tx *bolt.Tx) error {
+		return <-tx.Check(bolt.WithParallelism(4))
+	}
+	require.NoError(t, db.View(check))
+	require.NoError(t, db.Update(check))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = db.View(func(tx *bolt.Tx) error {
+		var errs []error
+		for err := range tx.Check(bolt.WithContext(ctx), bolt.WithParallelism(4)) {
+			errs = append(errs, err)
+		}
+		require.Len(t, errs, 1)
+		return errs[0]
+	})
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+// Ensure that a corrupted page makes the transaction fail with ErrCorrupted
+// instead of panicking in strict error mode.
+func TestTx_StrictErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db, err := bolt.Open(path, 0600, &bolt.Options{PageSize: 4096})
+	require.NoError(t, err)
+	var root uint64
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	err = db.View(func(tx *bolt.Tx) error {
+		root = uint64(tx.Bucket([]byte("widgets")).RootPage())
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// Overwrite the page type of the bucket's root page.
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0xff, 0xff}, int64(root)*4096+8)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	db, err = bolt.Open(path, 0600, &bolt.Options{StrictErrors: true})
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket([]byte("widgets")).Get([]byte("0001")))
+		return nil
+	})
+	require.ErrorIs(t, err, berrors.ErrCorrupted)
+
+	var putErr error
+	err = db.Update(func(tx *bolt.Tx) error {
+		putErr = tx.Bucket([]byte("widgets")).Put([]byte("0001"), []byte("bar"))
+		return nil
+	})
+	require.ErrorIs(t, putErr, berrors.ErrCorrupted)
+	require.ErrorIs(t, err, berrors.ErrCorrupted)
+
+	// Other buckets can still be used.
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("other"))
+		return err
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = bolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+	err = db.View(func(tx *bolt.Tx) error {
+		require.NotNil(t, tx.Bucket([]byte("other")))
+		require.Panics(t, func() {
+			tx.Bucket([]byte("widgets")).Get([]byte("0001"))
+		})
+		return nil
+	})
+	require.NoError(t, err)
+}
+
 // Ensure that committing a closed transaction returns an error.
 func TestTx_Commit_ErrTxClosed(t *testing.T) {
 	db := btesting.MustCreateDB(t)
@@ -519,6 +712,80 @@ func TestTx_OnCommit_Rollback(t *testing.T) {
 	}
 }
 
+// Ensure that Tx rollback handlers are called after a transaction rolls back,
+// and not after it commits.
+func TestTx_OnRollback(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	var x int
+	err := db.Update(func(tx *bolt.Tx) error {
+		tx.OnRollback(func() { x += 1 })
+		tx.OnRollback(func() { x += 2 })
+		return errors.New("rollback this commit")
+	})
+	require.EqualError(t, err, "rollback this commit")
+	require.Equal(t, 3, x)
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		tx.OnRollback(func() { x += 4 })
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, x)
+
+	tx, err := db.Begin(false)
+	require.NoError(t, err)
+	tx.OnRollback(func() { x += 8 })
+	require.NoError(t, tx.Rollback())
+	require.Equal(t, 11, x)
+}
+
+// Ensure that the close handlers run after the commit or rollback handlers,
+// whatever the outcome of the transaction.
+func TestTx_OnClose(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	var calls []string
+	handlers := func(tx *bolt.Tx) {
+		tx.OnClose(func() { calls = append(calls, "close") })
+		tx.OnCommit(func() { calls = append(calls, "commit") })
+		tx.OnRollback(func() { calls = append(calls, "rollback") })
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		handlers(tx)
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"commit", "close"}, calls)
+
+	calls = nil
+	err = db.Update(func(tx *bolt.Tx) error {
+		handlers(tx)
+		return errors.New("rollback this commit")
+	})
+	require.Error(t, err)
+	require.Equal(t, []string{"rollback", "close"}, calls)
+
+	calls = nil
+	err = db.View(func(tx *bolt.Tx) error {
+		handlers(tx)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"rollback", "close"}, calls)
+
+	// A read-only transaction can't be committed, it must be rolled back.
+	calls = nil
+	tx, err := db.Begin(false)
+	require.NoError(t, err)
+	handlers(tx)
+	require.ErrorIs(t, tx.Commit(), berrors.ErrTxNotWritable)
+	require.NoError(t, tx.Rollback())
+	require.Equal(t, []string{"rollback", "close"}, calls)
+}
+
 // Ensure that the database can be copied to a file path.
 func TestTx_CopyFile(t *testing.T) {
 	db := btesting.MustCreateDB(t)
@@ -641,6 +908,102 @@ func TestTx_CopyFile_Error_Normal(t *testing.T) {
 	}
 }
 
+// Ensure that WriteToWithOptions limits the rate and reports progress.
+func TestTx_WriteToWithOptions(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	err := db.Fill([]byte("widgets"), 1, 2000, func(tx int, k int) []byte { return []byte(fmt.Sprintf("%04d", k)) },
+		func(tx int, k int) []byte { return make([]byte, 500) })
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	var reports []bolt.WriteToProgress
+	var size int64
+	start := time.Now()
+	err = db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		n, err := tx.WriteToWithOptions(&buf, &bolt.WriteToOptions{
+			BytesPerSecond: 4 * size,
+			Progress: func(p bolt.WriteToProgress) error {
+				reports = append(reports, p)
+				return nil
+			},
+		})
+		require.Equal(t, size, n)
+		return err
+	})
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	require.Equal(t, size, int64(buf.Len()))
+
+	require.Greater(t, len(reports), 2)
+	for i := 1; i < len(reports); i++ {
+		require.Greater(t, reports[i].PagesWritten, reports[i-1].PagesWritten)
+	}
+	last := reports[len(reports)-1]
+	require.Equal(t, last.PagesTotal, last.PagesWritten)
+	require.Equal(t, size, last.BytesWritten)
+
+	// An error returned by the callback aborts the copy.
+	errStop := errors.New("stop")
+	err = db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteToWithOptions(io.Discard, &bolt.WriteToOptions{
+			Progress: func(p bolt.WriteToProgress) error {
+				if p.PagesWritten > 2 {
+					return errStop
+				}
+				return nil
+			},
+		})
+		return err
+	})
+	require.ErrorIs(t, err, errStop)
+}
+
+// Ensure that CopyFileWithOptions reports the progress of each page, can be
+// paused, and can leave the freelist out.
+func TestTx_CopyFileWithOptions(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	putWidgets(t, db, 0, 100)
+
+	path := filepath.Join(t.TempDir(), "copy.db")
+	ctrl := &bolt.CopyControl{}
+	var reports []bolt.WriteToProgress
+	var paused time.Duration
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFileWithOptions(path, 0600, &bolt.WriteToOptions{
+			ProgressPages: 1,
+			Control:       ctrl,
+			SkipFreelist:  true,
+			Progress: func(p bolt.WriteToProgress) error {
+				reports = append(reports, p)
+				if len(reports) == 3 {
+					ctrl.Pause()
+					go func(start time.Time) {
+						time.Sleep(100 * time.Millisecond)
+						paused = time.Since(start)
+						ctrl.Resume()
+					}(time.Now())
+				}
+				return nil
+			},
+		})
+	})
+	require.NoError(t, err)
+	require.False(t, ctrl.Paused())
+	require.GreaterOrEqual(t, paused, 100*time.Millisecond)
+
+	last := reports[len(reports)-1]
+	require.Equal(t, last.PagesTotal, last.PagesWritten)
+	require.Equal(t, int(last.PagesTotal), len(reports))
+
+	// The freelist is rebuilt when the copy is opened.
+	cdb := btesting.MustOpenDBWithOption(t, path, nil)
+	requireWidgets(t, cdb.DB, 100)
+	cdb.MustCheck()
+	putWidgets(t, cdb, 100, 110)
+	cdb.MustCheck()
+}
+
 // TestTx_Rollback ensures there is no error when tx rollback whether we sync freelist or not.
 func TestTx_Rollback(t *testing.T) {
 	for _, isSyncFreelist := range []bool{false, true} {
@@ -918,6 +1281,9 @@ func TestTxStats_GetAndIncAtomically(t *testing.T) {
 	stats.IncPageAlloc(2)
 	assert.Equal(t, int64(2), stats.GetPageAlloc())
 
+	stats.IncAllocFallback(4)
+	assert.Equal(t, int64(4), stats.GetAllocFallback())
+
 	stats.IncCursorCount(3)
 	assert.Equal(t, int64(3), stats.GetCursorCount())
 
@@ -942,16 +1308,35 @@ func TestTxStats_GetAndIncAtomically(t *testing.T) {
 	stats.IncSpillTime(10001 * time.Second)
 	assert.Equal(t, 10001*time.Second, stats.GetSpillTime())
 
+	stats.IncDirtySpill(3)
+	assert.Equal(t, int64(3), stats.GetDirtySpill())
+
 	stats.IncWrite(100000)
 	assert.Equal(t, int64(100000), stats.GetWrite())
 
 	stats.IncWriteTime(100001 * time.Second)
 	assert.Equal(t, 100001*time.Second, stats.GetWriteTime())
 
+	stats.IncWriteBytes(100002)
+	assert.Equal(t, int64(100002), stats.GetWriteBytes())
+
+	stats.IncPageRead(1000000)
+	assert.Equal(t, int64(1000000), stats.GetPageRead())
+
+	stats.IncReadBytes(1000001)
+	assert.Equal(t, int64(1000001), stats.GetReadBytes())
+
+	stats.IncSync(10000000)
+	assert.Equal(t, int64(10000000), stats.GetSync())
+
+	stats.IncSyncTime(10000001 * time.Second)
+	assert.Equal(t, 10000001*time.Second, stats.GetSyncTime())
+
 	assert.Equal(t,
 		bolt.TxStats{
 			PageCount:     1,
 			PageAlloc:     2,
+			AllocFallback: 4,
 			CursorCount:   3,
 			NodeCount:     100,
 			NodeDeref:     101,
@@ -960,8 +1345,14 @@ func TestTxStats_GetAndIncAtomically(t *testing.T) {
 			Split:         10000,
 			Spill:         10001,
 			SpillTime:     10001 * time.Second,
+			DirtySpill:    3,
 			Write:         100000,
 			WriteTime:     100001 * time.Second,
+			WriteBytes:    100002,
+			PageRead:      1000000,
+			ReadBytes:     1000001,
+			Sync:          10000000,
+			SyncTime:      10000001 * time.Second,
 		},
 		stats,
 	)
@@ -971,6 +1362,7 @@ func TestTxStats_Sub(t *testing.T) {
 	statsA := bolt.TxStats{
 		PageCount:     1,
 		PageAlloc:     2,
+		AllocFallback: 4,
 		CursorCount:   3,
 		NodeCount:     100,
 		NodeDeref:     101,
@@ -979,13 +1371,20 @@ func TestTxStats_Sub(t *testing.T) {
 		Split:         10000,
 		Spill:         10001,
 		SpillTime:     10001 * time.Second,
+		DirtySpill:    3,
 		Write:         100000,
 		WriteTime:     100001 * time.Second,
+		WriteBytes:    100002,
+		PageRead:      1000000,
+		ReadBytes:     1000001,
+		Sync:          10000000,
+		SyncTime:      10000001 * time.Second,
 	}
 
 	statsB := bolt.TxStats{
 		PageCount:     2,
 		PageAlloc:     3,
+		AllocFallback: 7,
 		CursorCount:   4,
 		NodeCount:     101,
 		NodeDeref:     102,
@@ -994,13 +1393,20 @@ func TestTxStats_Sub(t *testing.T) {
 		Split:         11001,
 		Spill:         11002,
 		SpillTime:     11002 * time.Second,
+		DirtySpill:    5,
 		Write:         110001,
 		WriteTime:     110010 * time.Second,
+		WriteBytes:    110011,
+		PageRead:      1100000,
+		ReadBytes:     1100002,
+		Sync:          11000000,
+		SyncTime:      11000003 * time.Second,
 	}
 
 	diff := statsB.Sub(&statsA)
 	assert.Equal(t, int64(1), diff.GetPageCount())
 	assert.Equal(t, int64(1), diff.GetPageAlloc())
+	assert.Equal(t, int64(3), diff.GetAllocFallback())
 	assert.Equal(t, int64(1), diff.GetCursorCount())
 	assert.Equal(t, int64(1), diff.GetNodeCount())
 	assert.Equal(t, int64(1), diff.GetNodeDeref())
@@ -1009,8 +1415,259 @@ func TestTxStats_Sub(t *testing.T) {
 	assert.Equal(t, int64(1001), diff.GetSplit())
 	assert.Equal(t, int64(1001), diff.GetSpill())
 	assert.Equal(t, 1001*time.Second, diff.GetSpillTime())
+	assert.Equal(t, int64(2), diff.GetDirtySpill())
 	assert.Equal(t, int64(10001), diff.GetWrite())
 	assert.Equal(t, 10009*time.Second, diff.GetWriteTime())
+	assert.Equal(t, int64(10009), diff.GetWriteBytes())
+	assert.Equal(t, int64(100000), diff.GetPageRead())
+	assert.Equal(t, int64(100001), diff.GetReadBytes())
+	assert.Equal(t, int64(1000000), diff.GetSync())
+	assert.Equal(t, 1000002*time.Second, diff.GetSyncTime())
+}
+
+// Ensure that the stats of a transaction account for its I/O.
+func TestTx_Stats_IO(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	pageSize := int64(db.Info().PageSize)
+
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	b, err := tx.CreateBucket([]byte("widgets"))
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)))
+	}
+	require.NoError(t, tx.Commit())
+
+	// The stats remain accessible after the commit.
+	stats := tx.Stats()
+	require.GreaterOrEqual(t, stats.GetWriteBytes(), stats.GetPageAlloc()+pageSize)
+	require.Equal(t, int64(2), stats.GetSync())
+	require.Greater(t, stats.GetSyncTime(), time.Duration(0))
+
+	err = db.View(func(tx *bolt.Tx) error {
+		require.NotNil(t, tx.Bucket([]byte("widgets")).Get([]byte("0050")))
+		stats := tx.Stats()
+		require.Greater(t, stats.GetPageRead(), int64(0))
+		require.Equal(t, stats.GetPageRead()*pageSize, stats.GetReadBytes())
+		require.Zero(t, stats.GetWriteBytes())
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+// Ensure that the allocations which grow the file while there are enough
+// scattered free pages are counted.
+func TestTx_Stats_AllocFallback(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	pageSize := db.Info().PageSize
+
+	// Rewrite every other leaf, each holding two values on two pages, which
+	// frees scattered spans of two pages.
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 100; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, pageSize*3/4)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for i := 0; i < 100; i += 4 {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, pageSize*3/4)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	require.NoError(t, tx.Bucket([]byte("widgets")).Put([]byte("big"), make([]byte, 16*pageSize)))
+	require.NoError(t, tx.Commit())
+	stats := tx.Stats()
+	require.Equal(t, int64(1), stats.GetAllocFallback())
+}
+
+// Ensure that the runs of contiguous dirty pages are written together with
+// vectored writes.
+func TestTx_Commit_VectoredWrites(t *testing.T) {
+	for _, pageChecksums := range []bool{false, true} {
+		t.Run(fmt.Sprint(pageChecksums), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{PageChecksums: pageChecksums})
+			var stats bolt.TxStats
+			err := db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucket([]byte("widgets"))
+				if err != nil {
+					return err
+				}
+				for i := 0; i < 2000; i++ {
+					if err := b.Put([]byte(fmt.Sprintf("%08d", i)), make([]byte, 1024)); err != nil {
+						return err
+					}
+				}
+				require.NoError(t, b.Put([]byte("big"), bytes.Repeat([]byte("x"), 100000)))
+				tx.OnCommit(func() { stats = tx.Stats() })
+				return nil
+			})
+			require.NoError(t, err)
+
+			pages := stats.GetPageCount()
+			require.Greater(t, pages, int64(1024))
+			if runtime.GOOS == "linux" {
+				// The pages are allocated at the end of the file, so they're
+				// contiguous.
+				require.Less(t, stats.GetWrite(), pages/100)
+			}
+
+			db.MustClose()
+			db.MustReopen()
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				require.Equal(t, 2001, b.Stats().KeyN)
+				require.Equal(t, bytes.Repeat([]byte("x"), 100000), b.Get([]byte("big")))
+				return nil
+			}))
+			db.MustCheck()
+		})
+	}
+}
+
+// Ensure that the result of a commit reports its pages, growth and remapping.
+func TestTx_CommitResult(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	// A large commit grows and remaps the data file.
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	require.Nil(t, tx.CommitResult())
+	b, err := tx.CreateBucket([]byte("widgets"))
+	require.NoError(t, err)
+	require.NoError(t, b.Put([]byte("foo"), make([]byte, 1<<20)))
+	require.NoError(t, tx.Commit())
+
+	res := tx.CommitResult()
+	require.NotNil(t, res)
+	require.Positive(t, res.Txid)
+	require.Greater(t, res.PageN, (1<<20)/db.Info().PageSize)
+	require.Positive(t, res.Grown)
+	require.True(t, res.Remapped)
+
+	// A small one doesn't, and the result of a managed transaction is
+	// available to its commit handlers.
+	var managed *bolt.CommitResult
+	err = db.Update(func(tx *bolt.Tx) error {
+		tx.OnCommit(func() {
+			managed = tx.CommitResult()
+		})
+		b, err := tx.CreateBucket([]byte("woojits"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("bar"), []byte("baz"))
+	})
+	require.NoError(t, err)
+	require.NotNil(t, managed)
+	require.Equal(t, res.Txid+1, managed.Txid)
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, managed.Txid, tx.ID())
+		return nil
+	}))
+	require.Positive(t, managed.PageN)
+	require.Zero(t, managed.Grown)
+	require.False(t, managed.Remapped)
+
+	// Rolled back and read-only transactions have no result.
+	tx, err = db.Begin(true)
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+	require.Nil(t, tx.CommitResult())
+	tx, err = db.Begin(false)
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+	require.Nil(t, tx.CommitResult())
+}
+
+// Ensure that ForEachPage and PageInfo attribute pages to their buckets.
+func TestTx_ForEachPage(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		child, err := b.CreateBucket([]byte("child"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := child.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		childRoot := int(tx.Bucket([]byte("widgets")).Bucket([]byte("child")).RootPage())
+
+		pages := make(map[int]*bolt.PageInfo)
+		err := tx.ForEachPage(int(tx.Cursor().Bucket().RootPage()), func(info *bolt.PageInfo) error {
+			require.NotContains(t, pages, info.ID)
+			pages[info.ID] = info
+			return nil
+		})
+		require.NoError(t, err)
+
+		root := pages[childRoot]
+		require.Equal(t, "branch", root.Type)
+		require.Equal(t, [][]byte{[]byte("widgets"), []byte("child")}, root.Bucket)
+		require.Zero(t, root.Depth)
+		require.Equal(t, "leaf", pages[root.ParentID].Type)
+		require.Equal(t, [][]byte{[]byte("widgets")}, pages[root.ParentID].Bucket)
+
+		var leaves, elements int
+		for _, info := range pages {
+			if info.Type == "leaf" && len(info.Bucket) == 2 {
+				require.Equal(t, 1, info.Depth)
+				require.Equal(t, childRoot, info.ParentID)
+				leaves++
+				elements += info.Count
+			}
+		}
+		require.Equal(t, root.Count, leaves)
+		require.Equal(t, 1000, elements)
+
+		info, err := tx.PageInfo(childRoot)
+		require.NoError(t, err)
+		require.Equal(t, root, info)
+		info, err = tx.PageInfo(0)
+		require.NoError(t, err)
+		require.Equal(t, "meta", info.Type)
+
+		// The iteration stops at the first error.
+		errStop := errors.New("stop")
+		var n int
+		err = tx.ForEachPage(childRoot, func(info *bolt.PageInfo) error {
+			require.Empty(t, info.Bucket)
+			n++
+			return errStop
+		})
+		require.ErrorIs(t, err, errStop)
+		require.Equal(t, 1, n)
+
+		require.ErrorIs(t, tx.ForEachPage(1, func(*bolt.PageInfo) error { return nil }), berrors.ErrInvalid)
+		return nil
+	})
+	require.NoError(t, err)
 }
 
 // TestTx_TruncateBeforeWrite ensures the file is truncated ahead whether we sync freelist or not.
@@ -0,0 +1,128 @@
+package boltdb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"unsafe"
+
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// VerifyTouchedPages checks the structure of the pages read by the write
+// transaction and of the nodes it changed: that the elements of the pages
+// are within their bounds and refer to pages below the high water mark, and
+// that the keys of the pages and nodes are in order. Unlike Check it only
+// looks at what the transaction touched, so its cost is bounded by the size
+// of the transaction. The pages read are only recorded when
+// Options.VerifyTouchedPages is set, which also makes Commit call it.
+//
+// It returns an error wrapping ErrCorrupted for the first problem found.
+func (tx *Tx) VerifyTouchedPages() error {
+	if tx.db == nil {
+		return berrors.ErrTxClosed
+	} else if !tx.writable {
+		return berrors.ErrTxNotWritable
+	}
+
+	ids := make(common.Pgids, 0, len(tx.touched))
+	for id := range tx.touched {
+		ids = append(ids, id)
+	}
+	sort.Sort(ids)
+	for _, id := range ids {
+		if err := tx.verifyPage(id); err != nil {
+			return fmt.Errorf("%w: %v", berrors.ErrCorrupted, err)
+		}
+	}
+
+	if err := tx.root.verifyNodes(); err != nil {
+		return fmt.Errorf("%w: %v", berrors.ErrCorrupted, err)
+	}
+	return nil
+}
+
+// verifyPage checks the structure of a branch, leaf or blob page in the mmap.
+func (tx *Tx) verifyPage(id common.Pgid) error {
+	hwm := tx.meta.Pgid()
+	if id >= hwm {
+		return fmt.Errorf("page %d: out of bounds: %d", id, hwm)
+	}
+	p := tx.db.page(id)
+	if p.Id() != id {
+		return fmt.Errorf("page %d: identifies as page %d", id, p.Id())
+	}
+	if uint64(id)+uint64(p.Overflow()) >= uint64(hwm) {
+		return fmt.Errorf("page %d: overflow out of bounds: %d", id, p.Overflow())
+	}
+
+	var elemSize uintptr
+	switch {
+	case p.IsBlobPage():
+		if next := blobNext(p); next != 0 && (next < 2 || next >= hwm) {
+			return fmt.Errorf("page %d: refers to invalid page %d", id, next)
+		}
+		return nil
+	case p.IsLeafPage():
+		elemSize = common.LeafPageElementSize
+	case p.IsBranchPage():
+		elemSize = common.BranchPageElementSize
+	default:
+		return fmt.Errorf("page %d: invalid type: %s", id, p.Typ())
+	}
+
+	size := (uintptr(p.Overflow()) + 1) * uintptr(tx.db.pageSize)
+	if common.PageHeaderSize+uintptr(p.Count())*elemSize > size {
+		return fmt.Errorf("page %d: %d elements overflow the page", id, p.Count())
+	}
+
+	var prev []byte
+	for i := uint16(0); i < p.Count(); i++ {
+		var key []byte
+		if p.IsLeafPage() {
+			e := p.LeafPageElement(i)
+			off := uintptr(unsafe.Pointer(e)) - uintptr(unsafe.Pointer(p))
+			if off+uintptr(e.Pos())+uintptr(e.Ksize())+uintptr(e.Vsize()) > size {
+				return fmt.Errorf("page %d: element %d out of bounds", id, i)
+			}
+			key = e.Key()
+		} else {
+			e := p.BranchPageElement(i)
+			off := uintptr(unsafe.Pointer(e)) - uintptr(unsafe.Pointer(p))
+			if off+uintptr(e.Pos())+uintptr(e.Ksize()) > size {
+				return fmt.Errorf("page %d: element %d out of bounds", id, i)
+			}
+			if e.Pgid() < 2 || e.Pgid() >= hwm {
+				return fmt.Errorf("page %d: element %d refers to invalid page %d", id, i, e.Pgid())
+			}
+			key = e.Key()
+		}
+		if i > 0 && bytes.Compare(prev, key) >= 0 {
+			return fmt.Errorf("page %d: element %d is out of order", id, i)
+		}
+		prev = key
+	}
+	return nil
+}
+
+// verifyNodes checks that the keys of the materialized nodes of the bucket
+// and its nested buckets are in order.
+func (b *Bucket) verifyNodes() error {
+	for _, n := range b.nodes {
+		for i := range n.inodes {
+			if len(n.inodes[i].Key()) == 0 {
+				return fmt.Errorf("node of page %d: element %d has no key", n.pgid, i)
+			}
+			if i > 0 && bytes.Compare(n.inodes[i-1].Key(), n.inodes[i].Key()) >= 0 {
+				return fmt.Errorf("node of page %d: element %d is out of order", n.pgid, i)
+			}
+		}
+	}
+	for _, child := range b.buckets {
+		if err := child.verifyNodes(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
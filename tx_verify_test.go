@@ -0,0 +1,65 @@
+package boltdb_test
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func TestTx_VerifyTouchedPages(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{VerifyTouchedPages: true})
+	putWidgets(t, db, 0, 100)
+
+	var root int
+	err := db.View(func(tx *bolt.Tx) error {
+		root = int(tx.Bucket([]byte("widgets")).Root())
+		require.ErrorIs(t, tx.VerifyTouchedPages(), berrors.ErrTxNotWritable)
+		return nil
+	})
+	require.NoError(t, err)
+	pageSize := db.Info().PageSize
+	path := db.Path()
+	db.MustClose()
+
+	// Move the first key of the leaf after the last one.
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	require.NoError(t, err)
+	buf := make([]byte, pageSize)
+	_, err = f.ReadAt(buf, int64(root*pageSize))
+	require.NoError(t, err)
+	require.Equal(t, uint16(100), binary.LittleEndian.Uint16(buf[10:]))
+	pos := binary.LittleEndian.Uint32(buf[16+4:])
+	copy(buf[16+int(pos):], "9999")
+	_, err = f.WriteAt(buf, int64(root*pageSize))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	db.MustReopen()
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	require.NoError(t, tx.VerifyTouchedPages())
+	require.NotNil(t, tx.Bucket([]byte("widgets")).Get([]byte("0050")))
+	require.ErrorIs(t, tx.VerifyTouchedPages(), berrors.ErrCorrupted)
+	require.NoError(t, tx.Rollback())
+
+	// The commit of a transaction which touched the page fails.
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("0100"), []byte("value-100"))
+	})
+	require.ErrorIs(t, err, berrors.ErrCorrupted)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket([]byte("widgets")).Get([]byte("0100")))
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Skip the consistency check of the corrupted database.
+	db.MustClose()
+}
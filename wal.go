@@ -0,0 +1,317 @@
+package boltdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+	"unsafe"
+
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/common"
+)
+
+// walMagic marks the start of every record in the write-ahead log.
+const walMagic uint32 = 0xED0CDAEE
+
+// walHeaderSize is the size of a record header: magic, page size, number of
+// pages, padding and txid.
+const walHeaderSize = 24
+
+// walTrailerSize is the size of the CRC32C at the end of each record.
+const walTrailerSize = 4
+
+var walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// wal is the write-ahead log used in WAL commit mode.
+//
+// Every commit appends a record with the dirty pages and the new meta page of
+// the transaction, and only the log is synced. The pages are also written to
+// the data file, without syncing it, so that they're visible through the mmap.
+// A checkpoint syncs the data file and truncates the log.
+//
+// If the process crashes, the records in the log are replayed into the data
+// file the next time it's opened.
+type wal struct {
+	file           *os.File
+	size           int64
+	checkpointSize int64
+}
+
+func walPath(path string) string {
+	return path + "-wal"
+}
+
+// openWAL creates an empty write-ahead log next to the data file.
+func (db *DB) openWAL(checkpointSize int) error {
+	if checkpointSize <= 0 {
+		checkpointSize = common.DefaultWALCheckpointSize
+	}
+	info, err := db.file.Stat()
+	if err != nil {
+		return fmt.Errorf("file stat error: %w", err)
+	}
+	f, err := db.openFile(walPath(db.path), os.O_RDWR|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("open wal: %w", err)
+	}
+	db.wal = &wal{file: f, checkpointSize: int64(checkpointSize)}
+	return nil
+}
+
+// closeWAL checkpoints and removes the write-ahead log. The log is kept if
+// the checkpoint fails, so that it's replayed on the next open.
+func (db *DB) closeWAL() error {
+	err := db.checkpoint()
+	if cerr := db.wal.file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Remove(walPath(db.path))
+	}
+	db.wal = nil
+	return err
+}
+
+// Checkpoint syncs all commits in the write-ahead log into the data file
+// and truncates the log. It's a no-op when WAL mode isn't enabled.
+func (db *DB) Checkpoint() error {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+
+	if !db.opened {
+		return berrors.ErrDatabaseNotOpen
+	}
+	return db.checkpoint()
+}
+
+// checkpoint syncs the data file and truncates the write-ahead log.
+// The caller must hold the writer lock.
+func (db *DB) checkpoint() error {
+	if db.wal == nil || db.wal.size == 0 {
+		return nil
+	}
+	if err := fdatasync(db); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	if err := db.wal.file.Truncate(0); err != nil {
+		return fmt.Errorf("checkpoint: truncate wal: %w", err)
+	}
+	db.wal.size = 0
+
+	db.statlock.Lock()
+	db.stats.WALCheckpointN++
+	db.statlock.Unlock()
+	return nil
+}
+
+// full returns true once the log has grown past the checkpoint size.
+func (w *wal) full() bool {
+	return w.size >= w.checkpointSize
+}
+
+// append writes a record with the given pages and meta to the log and syncs
+// it, accounting for the sync in stats. Once append returns, the transaction
+// is durable.
+func (w *wal) append(db *DB, pages common.Pages, meta *common.Meta, stats *TxStats) error {
+	// Generate the meta page the same way as Tx.writeMeta.
+	mbuf := make([]byte, db.pageSize)
+	mp := db.pageInBuffer(mbuf, 0)
+	meta.Write(mp)
+
+	count := 1
+	for _, p := range pages {
+		count += int(p.Overflow()) + 1
+	}
+
+	var hdr [walHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:], walMagic)
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(db.pageSize))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(count))
+	binary.LittleEndian.PutUint64(hdr[16:], uint64(meta.Txid()))
+
+	h := crc32.New(walCRCTable)
+	bw := bufio.NewWriterSize(io.NewOffsetWriter(w.file, w.size), 1<<20)
+	mw := io.MultiWriter(bw, h)
+	if _, err := mw.Write(hdr[:]); err != nil {
+		return fmt.Errorf("wal write: %w", err)
+	}
+	for _, p := range pages {
+		buf := common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, (int(p.Overflow())+1)*db.pageSize)
+		for i := 0; i <= int(p.Overflow()); i++ {
+			if err := db.writeWALEntry(mw, p.Id()+common.Pgid(i), buf[i*db.pageSize:(i+1)*db.pageSize]); err != nil {
+				return err
+			}
+		}
+	}
+	if err := db.writeWALEntry(mw, mp.Id(), mbuf); err != nil {
+		return err
+	}
+
+	var trailer [walTrailerSize]byte
+	binary.LittleEndian.PutUint32(trailer[:], h.Sum32())
+	if _, err := bw.Write(trailer[:]); err != nil {
+		return fmt.Errorf("wal write: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("wal write: %w", err)
+	}
+
+	if !db.NoSync || common.IgnoreNoSync {
+		start := time.Now()
+		if err := w.file.Sync(); err != nil {
+			return fmt.Errorf("wal sync: %w", err)
+		}
+		stats.IncSync(1)
+		stats.IncSyncTime(time.Since(start))
+	}
+	w.size += walRecordSize(db.walEntrySize(db.pageSize), count)
+	return nil
+}
+
+// writeWALEntry writes a page id followed by the page, encrypting the page
+// if the database is encrypted.
+func (db *DB) writeWALEntry(w io.Writer, id common.Pgid, page []byte) error {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(id))
+	if db.cipher != nil {
+		page = db.cipher.Seal(nil, page, uint64(id))
+	}
+	if _, err := w.Write(b[:]); err != nil {
+		return fmt.Errorf("wal write: %w", err)
+	}
+	if _, err := w.Write(page); err != nil {
+		return fmt.Errorf("wal write: %w", err)
+	}
+	return nil
+}
+
+// walEntrySize returns the size of a page entry in the log.
+func (db *DB) walEntrySize(pageSize int) int {
+	if db.cipher != nil {
+		pageSize += db.cipher.Overhead()
+	}
+	return 8 + pageSize
+}
+
+func walRecordSize(entrySize, count int) int64 {
+	return walHeaderSize + int64(entrySize)*int64(count) + walTrailerSize
+}
+
+// recoverWAL replays the write-ahead log left behind by a process which
+// didn't close the database, and removes it.
+func (db *DB) recoverWAL() error {
+	path := walPath(db.path)
+	f, err := db.openFile(path, os.O_RDONLY, 0)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("open wal: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("wal stat: %w", err)
+	}
+	if info.Size() > 0 && db.readOnly {
+		_ = f.Close()
+		return berrors.ErrWALNotRecovered
+	}
+
+	var off int64
+	var replayed bool
+	db.reportOpenProgress(OpenStageRecover, 0, info.Size())
+	for {
+		n, err := db.replayWALRecord(f, off, info.Size())
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		// Stop at the end of the log, or at a record which wasn't
+		// completely written.
+		if n == 0 {
+			break
+		}
+		off += n
+		replayed = true
+		db.reportOpenProgress(OpenStageRecover, off, info.Size())
+	}
+	if off < info.Size() {
+		db.reportOpenProgress(OpenStageRecover, info.Size(), info.Size())
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if replayed {
+		if err := fdatasync(db); err != nil {
+			return fmt.Errorf("wal recovery: %w", err)
+		}
+	}
+	if db.readOnly {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// replayWALRecord writes the pages of the record at off into the data file.
+// It returns the size of the record, or 0 if there is no valid record at off.
+func (db *DB) replayWALRecord(f *os.File, off, size int64) (int64, error) {
+	var hdr [walHeaderSize]byte
+	if off+walHeaderSize > size {
+		return 0, nil
+	}
+	if _, err := f.ReadAt(hdr[:], off); err != nil {
+		return 0, fmt.Errorf("wal read: %w", err)
+	}
+	if binary.LittleEndian.Uint32(hdr[0:]) != walMagic {
+		return 0, nil
+	}
+	pageSize := int(binary.LittleEndian.Uint32(hdr[4:]))
+	count := int(binary.LittleEndian.Uint32(hdr[8:]))
+	if pageSize < 1024 {
+		return 0, nil
+	}
+	entrySize := db.walEntrySize(pageSize)
+	recSize := walRecordSize(entrySize, count)
+	if off+recSize > size {
+		return 0, nil
+	}
+
+	// Verify the checksum before touching the data file.
+	h := crc32.New(walCRCTable)
+	if _, err := io.Copy(h, io.NewSectionReader(f, off, recSize-walTrailerSize)); err != nil {
+		return 0, fmt.Errorf("wal read: %w", err)
+	}
+	var trailer [walTrailerSize]byte
+	if _, err := f.ReadAt(trailer[:], off+recSize-walTrailerSize); err != nil {
+		return 0, fmt.Errorf("wal read: %w", err)
+	}
+	if binary.LittleEndian.Uint32(trailer[:]) != h.Sum32() {
+		return 0, nil
+	}
+
+	db.pageSize = pageSize
+	entry := make([]byte, entrySize)
+	for i := 0; i < count; i++ {
+		if _, err := f.ReadAt(entry, off+walHeaderSize+int64(i*entrySize)); err != nil {
+			return 0, fmt.Errorf("wal read: %w", err)
+		}
+		id := binary.LittleEndian.Uint64(entry)
+		page := entry[8:]
+		if db.cipher != nil {
+			var err error
+			if page, err = db.cipher.Open(nil, page, id); err != nil {
+				return 0, fmt.Errorf("wal page %d: %w", id, err)
+			}
+		}
+		if _, err := db.ops.writeAt(page, int64(id)*int64(pageSize)); err != nil {
+			return 0, fmt.Errorf("wal replay: %w", err)
+		}
+	}
+	return recSize, nil
+}
@@ -0,0 +1,113 @@
+package boltdb_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	berrors "github.com/openkvlab/boltdb/errors"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+func putWidgets(t *testing.T, db *btesting.DB, from, to int) {
+	for i := from; i < to; i++ {
+		err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(fmt.Sprintf("%04d", i)), []byte(fmt.Sprintf("value-%d", i)))
+		})
+		require.NoError(t, err)
+	}
+}
+
+func requireWidgets(t *testing.T, db *bolt.DB, n int) {
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.NotNil(t, b)
+		require.Equal(t, n, b.Stats().KeyN)
+		require.Equal(t, []byte(fmt.Sprintf("value-%d", n-1)), b.Get([]byte(fmt.Sprintf("%04d", n-1))))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestDB_WAL(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{WAL: true})
+	walPath := db.Path() + "-wal"
+
+	putWidgets(t, db, 0, 100)
+	requireWidgets(t, db.DB, 100)
+	info, err := os.Stat(walPath)
+	require.NoError(t, err)
+	require.NotZero(t, info.Size())
+
+	require.NoError(t, db.Checkpoint())
+	info, err = os.Stat(walPath)
+	require.NoError(t, err)
+	require.Zero(t, info.Size())
+	require.Equal(t, 1, db.Stats().WALCheckpointN)
+
+	// The log is checkpointed and removed on close.
+	putWidgets(t, db, 100, 200)
+	db.MustClose()
+	_, err = os.Stat(walPath)
+	require.True(t, os.IsNotExist(err))
+
+	db.SetOptions(nil)
+	db.MustReopen()
+	requireWidgets(t, db.DB, 200)
+}
+
+func TestDB_WAL_CheckpointSize(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{WAL: true, WALCheckpointSize: 64 * 1024})
+	putWidgets(t, db, 0, 200)
+	require.Greater(t, db.Stats().WALCheckpointN, 0)
+
+	info, err := os.Stat(db.Path() + "-wal")
+	require.NoError(t, err)
+	require.LessOrEqual(t, info.Size(), int64(64*1024+4*db.Info().PageSize+1024))
+}
+
+func TestDB_WAL_Recovery(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		t.Run(fmt.Sprintf("encrypted=%v", encrypted), func(t *testing.T) {
+			o := &bolt.Options{WAL: true}
+			if encrypted {
+				o.Encryption = mustAESGCMCipher(t, "0123456789abcdef")
+			}
+			db := btesting.MustCreateDBWithOption(t, o)
+
+			putWidgets(t, db, 0, 10)
+			require.NoError(t, db.Checkpoint())
+			data, err := os.ReadFile(db.Path())
+			require.NoError(t, err)
+
+			// Simulate a crash which loses the unsynced writes to the data
+			// file, but not the synced log.
+			putWidgets(t, db, 10, 50)
+			log, err := os.ReadFile(db.Path() + "-wal")
+			require.NoError(t, err)
+			// Leave a partially written record at the end of the log.
+			log = append(log, log[:100]...)
+
+			path := filepath.Join(t.TempDir(), "db")
+			require.NoError(t, os.WriteFile(path, data, 0600))
+			require.NoError(t, os.WriteFile(path+"-wal", log, 0600))
+
+			// The log can't be replayed by a read-only process.
+			_, err = bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Encryption: o.Encryption})
+			require.ErrorIs(t, err, berrors.ErrWALNotRecovered)
+
+			rdb := btesting.MustOpenDBWithOption(t, path, &bolt.Options{Encryption: o.Encryption})
+			requireWidgets(t, rdb.DB, 50)
+			_, err = os.Stat(path + "-wal")
+			require.True(t, os.IsNotExist(err))
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package boltdb
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// watchBufferSize is the number of events the channel of a watcher holds.
+const watchBufferSize = 1024
+
+// ChangeOp is the kind of change reported by DB.Watch.
+type ChangeOp int
+
+const (
+	// OpPut reports a key which was put, whether it existed or not.
+	OpPut ChangeOp = iota + 1
+	// OpDelete reports a key which was deleted.
+	OpDelete
+	// OpCreateBucket reports a nested bucket which was created, renamed to
+	// the key or copied to it.
+	OpCreateBucket
+	// OpDeleteBucket reports a nested bucket which was deleted or renamed.
+	OpDeleteBucket
+)
+
+func (op ChangeOp) String() string {
+	switch op {
+	case OpPut:
+		return "put"
+	case OpDelete:
+		return "delete"
+	case OpCreateBucket:
+		return "create bucket"
+	case OpDeleteBucket:
+		return "delete bucket"
+	}
+	return fmt.Sprintf("ChangeOp(%d)", int(op))
+}
+
+// ChangeEvent is a committed change of a key, see DB.Watch. Its slices are
+// shared with the other watchers and must not be modified.
+type ChangeEvent struct {
+	// Bucket is the path of the bucket of the key, empty for the top-level
+	// buckets.
+	Bucket [][]byte
+	Key    []byte
+	Op     ChangeOp
+	Txid   uint64
+}
+
+// watcher is a subscription of DB.Watch.
+type watcher struct {
+	path   [][]byte
+	prefix []byte
+	ch     chan ChangeEvent
+}
+
+// matches returns whether a change of key in the bucket at path is reported
+// to the watcher.
+func (w *watcher) matches(path [][]byte, key []byte) bool {
+	if len(path) != len(w.path) || !bytes.HasPrefix(key, w.prefix) {
+		return false
+	}
+	for i := range path {
+		if !bytes.Equal(path[i], w.path[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// watchedChanges are the changes of a committed transaction which weren't
+// reported yet.
+type watchedChanges struct {
+	txid    uint64
+	changes []ChangeEvent
+}
+
+// Watch returns a channel receiving the changes committed to the keys which
+// start with prefix in the bucket at bucketPath, or to the top-level buckets
+// if bucketPath is empty, and a function which stops watching and closes the
+// channel. Nested buckets are reported by the bucket containing them, and
+// deleting a bucket doesn't report its keys. A bucket which is copied into
+// another one may be reported without its keys.
+//
+// The changes are sent in the order of their transactions once those are
+// durable, without waiting for the receiver: the channel is closed once the
+// receiver falls too far behind, in which case it should read the bucket
+// again and call Watch again. It's also closed when the database is closed.
+func (db *DB) Watch(bucketPath [][]byte, prefix []byte) (<-chan ChangeEvent, func()) {
+	w := &watcher{prefix: cloneBytes(prefix), ch: make(chan ChangeEvent, watchBufferSize)}
+	for _, name := range bucketPath {
+		w.path = append(w.path, cloneBytes(name))
+	}
+
+	db.watchlock.Lock()
+	if db.watchers == nil {
+		db.watchers = make(map[*watcher]struct{})
+	}
+	db.watchers[w] = struct{}{}
+	db.watchCount.Add(1)
+	db.watchlock.Unlock()
+
+	return w.ch, func() {
+		db.watchlock.Lock()
+		defer db.watchlock.Unlock()
+		db.removeWatcher(w)
+	}
+}
+
+// removeWatcher closes the channel of a watcher unless it's already closed.
+// The caller must hold watchlock.
+func (db *DB) removeWatcher(w *watcher) {
+	if _, ok := db.watchers[w]; !ok {
+		return
+	}
+	delete(db.watchers, w)
+	db.watchCount.Add(-1)
+	close(w.ch)
+}
+
+// closeWatchers closes the channels of all the watchers.
+func (db *DB) closeWatchers() {
+	db.watchlock.Lock()
+	defer db.watchlock.Unlock()
+	for w := range db.watchers {
+		db.removeWatcher(w)
+	}
+	db.watchPending = nil
+}
+
+// queueChanges queues the changes of a transaction being committed. The
+// caller must hold the writer lock, so that the changes are queued in the
+// order of their transactions.
+func (db *DB) queueChanges(txid uint64, changes []ChangeEvent) {
+	db.watchlock.Lock()
+	defer db.watchlock.Unlock()
+	db.watchPending = append(db.watchPending, watchedChanges{txid: txid, changes: changes})
+}
+
+// flushChanges reports the queued changes of the transactions up to txid,
+// which are durable, to the watchers.
+func (db *DB) flushChanges(txid uint64) {
+	db.watchlock.Lock()
+	defer db.watchlock.Unlock()
+	n := 0
+	for ; n < len(db.watchPending) && db.watchPending[n].txid <= txid; n++ {
+		pending := &db.watchPending[n]
+		for w := range db.watchers {
+			for _, e := range pending.changes {
+				if !w.matches(e.Bucket, e.Key) {
+					continue
+				}
+				e.Txid = pending.txid
+				select {
+				case w.ch <- e:
+					continue
+				default:
+				}
+				db.removeWatcher(w)
+				break
+			}
+		}
+	}
+	db.watchPending = db.watchPending[n:]
+}
+
+// recordChange records a change of a key of the bucket if it's watched, to
+// report it once the transaction is committed.
+func (b *Bucket) recordChange(key []byte, op ChangeOp) {
+	db := b.tx.db
+	if db.watchCount.Load() == 0 {
+		return
+	}
+	path := b.watchPath()
+	db.watchlock.Lock()
+	var watched bool
+	for w := range db.watchers {
+		if watched = w.matches(path, key); watched {
+			break
+		}
+	}
+	db.watchlock.Unlock()
+	if watched {
+		b.tx.changes = append(b.tx.changes, ChangeEvent{Bucket: path, Key: cloneBytes(key), Op: op})
+	}
+}
+
+// watched returns whether changes may have to be recorded.
+func (b *Bucket) watched() bool {
+	return b.tx.db.watchCount.Load() > 0
+}
+
+// watchPath returns the path of a bucket of a write transaction.
+func (b *Bucket) watchPath() [][]byte {
+	var path [][]byte
+	for c := b; c.parent != nil; c = c.parent {
+		path = append(path, []byte(c.name))
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// setParent records the parent and the name of a nested bucket of a write
+// transaction, from which its path is reported to the watchers.
+func (b *Bucket) setParent(name []byte, child *Bucket) {
+	if b.tx.writable {
+		child.parent, child.name = b, string(name)
+	}
+}
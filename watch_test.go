@@ -0,0 +1,140 @@
+package boltdb_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+// receive returns the events buffered in ch.
+func receive(ch <-chan bolt.ChangeEvent) []bolt.ChangeEvent {
+	var events []bolt.ChangeEvent
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return events
+			}
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func TestDB_Watch(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		_, err = b.CreateBucket([]byte("nested"))
+		return err
+	})
+	require.NoError(t, err)
+
+	users, cancelUsers := db.Watch([][]byte{[]byte("widgets")}, []byte("user/"))
+	defer cancelUsers()
+	nested, cancelNested := db.Watch([][]byte{[]byte("widgets"), []byte("nested")}, nil)
+	defer cancelNested()
+	top, cancelTop := db.Watch(nil, nil)
+	defer cancelTop()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.NoError(t, b.Put([]byte("user/1"), []byte("a")))
+		require.NoError(t, b.Put([]byte("user/2"), []byte("b")))
+		require.NoError(t, b.Put([]byte("order/1"), []byte("c")))
+		require.NoError(t, b.Delete([]byte("user/1")))
+		require.NoError(t, b.Delete([]byte("user/3")))
+		require.NoError(t, b.Bucket([]byte("nested")).Put([]byte("key"), []byte("d")))
+		_, err := tx.CreateBucket([]byte("gadgets"))
+		return err
+	})
+	require.NoError(t, err)
+	var txid uint64
+	err = db.View(func(tx *bolt.Tx) error {
+		txid = uint64(tx.ID())
+		return nil
+	})
+	require.NoError(t, err)
+
+	widgets := [][]byte{[]byte("widgets")}
+	require.Equal(t, []bolt.ChangeEvent{
+		{Bucket: widgets, Key: []byte("user/1"), Op: bolt.OpPut, Txid: txid},
+		{Bucket: widgets, Key: []byte("user/2"), Op: bolt.OpPut, Txid: txid},
+		{Bucket: widgets, Key: []byte("user/1"), Op: bolt.OpDelete, Txid: txid},
+	}, receive(users))
+	require.Equal(t, []bolt.ChangeEvent{
+		{Bucket: [][]byte{[]byte("widgets"), []byte("nested")}, Key: []byte("key"), Op: bolt.OpPut, Txid: txid},
+	}, receive(nested))
+	require.Equal(t, []bolt.ChangeEvent{
+		{Key: []byte("gadgets"), Op: bolt.OpCreateBucket, Txid: txid},
+	}, receive(top))
+
+	// Rolled back changes aren't reported.
+	err = db.Update(func(tx *bolt.Tx) error {
+		require.NoError(t, tx.Bucket([]byte("widgets")).Put([]byte("user/4"), []byte("e")))
+		return errors.New("rollback")
+	})
+	require.Error(t, err)
+	require.Empty(t, receive(users))
+
+	// Renaming a bucket reports it as deleted and created again.
+	err = db.Update(func(tx *bolt.Tx) error {
+		require.NoError(t, tx.RenameBucket([]byte("gadgets"), []byte("tools")))
+		require.NoError(t, tx.DeleteBucket([]byte("widgets")))
+		return tx.Bucket([]byte("tools")).Put([]byte("key"), []byte("f"))
+	})
+	require.NoError(t, err)
+	events := receive(top)
+	require.Len(t, events, 3)
+	require.Equal(t, []bolt.ChangeOp{bolt.OpDeleteBucket, bolt.OpCreateBucket, bolt.OpDeleteBucket}, []bolt.ChangeOp{events[0].Op, events[1].Op, events[2].Op})
+	require.Equal(t, []string{"gadgets", "tools", "widgets"}, []string{string(events[0].Key), string(events[1].Key), string(events[2].Key)})
+	require.Empty(t, receive(users))
+
+	// Canceling closes the channel.
+	cancelUsers()
+	cancelUsers()
+	_, ok := <-users
+	require.False(t, ok)
+	db.MustCheck()
+}
+
+// Ensure a watcher which falls too far behind is closed.
+func TestDB_Watch_Overflow(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	ch, cancel := db.Watch([][]byte{[]byte("widgets")}, nil)
+	defer cancel()
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 2000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	var n int
+	for range ch {
+		n++
+	}
+	require.Less(t, n, 2000)
+
+	// Closing the database closes the watchers.
+	ch, _ = db.Watch(nil, nil)
+	db.MustClose()
+	_, ok := <-ch
+	require.False(t, ok)
+}
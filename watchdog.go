@@ -0,0 +1,119 @@
+package boltdb
+
+import (
+	"sync"
+	"time"
+)
+
+// minWatchdogInterval is the shortest interval between two checks of the read
+// transaction watchdog.
+const minWatchdogInterval = 10 * time.Millisecond
+
+// readTxWatchdog periodically looks for read transactions which have been open
+// for longer than maxAge, see Options.MaxReadTxDuration.
+type readTxWatchdog struct {
+	db     *DB
+	maxAge time.Duration
+	onLong func(txid int, age time.Duration)
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (db *DB) startReadTxWatchdog(options *Options) {
+	w := &readTxWatchdog{
+		db:     db,
+		maxAge: options.MaxReadTxDuration,
+		onLong: options.OnLongReadTx,
+		stop:   make(chan struct{}),
+	}
+	db.watchdog = w
+
+	w.wg.Add(1)
+	go w.run()
+}
+
+// stopReadTxWatchdog stops the watchdog and waits for the handlers it's
+// calling to return.
+func (db *DB) stopReadTxWatchdog() {
+	if db.watchdog == nil {
+		return
+	}
+	close(db.watchdog.stop)
+	db.watchdog.wg.Wait()
+	db.watchdog = nil
+}
+
+func (w *readTxWatchdog) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(max(w.maxAge/4, minWatchdogInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+		w.check()
+	}
+}
+
+// check reports the read transactions which became too old since the last
+// check.
+func (w *readTxWatchdog) check() {
+	db := w.db
+	now := time.Now()
+
+	type longTx struct {
+		txid int
+		age  time.Duration
+	}
+	var found []longTx
+
+	db.metalock.Lock()
+	for _, t := range db.txs {
+		age := now.Sub(t.started)
+		if age > w.maxAge && !t.overdue {
+			t.overdue = true
+			found = append(found, longTx{int(t.meta.Txid()), age})
+		}
+	}
+	db.metalock.Unlock()
+
+	if len(found) == 0 {
+		return
+	}
+	db.statlock.Lock()
+	db.stats.LongReadTxN += len(found)
+	db.statlock.Unlock()
+
+	if w.onLong != nil {
+		for _, t := range found {
+			w.onLong(t.txid, t.age)
+		}
+	}
+}
+
+// oldestReadTx returns the id and start time of the read transaction which
+// has been open the longest, or 0 and the zero time. The caller must hold the
+// meta lock, since the transactions are closed concurrently.
+func (db *DB) oldestReadTx() (int, time.Time) {
+	var oldest *Tx
+	for _, t := range db.txs {
+		if oldest == nil || t.started.Before(oldest.started) {
+			oldest = t
+		}
+	}
+	if oldest == nil {
+		return 0, time.Time{}
+	}
+	return int(oldest.meta.Txid()), oldest.started
+}
+
+// setOldestReadTx records the oldest read transaction for Stats. The caller
+// must hold the stat lock.
+func (db *DB) setOldestReadTx(txid int, started time.Time) {
+	db.stats.OldestReadTxID = txid
+	db.oldestReadTxStart = started
+}
@@ -0,0 +1,64 @@
+package boltdb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "github.com/openkvlab/boltdb"
+	"github.com/openkvlab/boltdb/internal/btesting"
+)
+
+// Ensure that Stats reports the oldest open read transaction.
+func TestDB_Stats_OldestReadTx(t *testing.T) {
+	// Writes must not remap the database while the read transaction is open.
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{InitialMmapSize: 1 << 20})
+	putWidgets(t, db, 0, 10)
+
+	rtx, err := db.Begin(false)
+	require.NoError(t, err)
+	putWidgets(t, db, 10, 20)
+	rtx2, err := db.Begin(false)
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	stats := db.Stats()
+	require.Equal(t, rtx.ID(), stats.OldestReadTxID)
+	require.GreaterOrEqual(t, stats.OldestReadTxAge, 10*time.Millisecond)
+
+	require.NoError(t, rtx.Rollback())
+	require.Equal(t, rtx2.ID(), db.Stats().OldestReadTxID)
+	require.NoError(t, rtx2.Rollback())
+	stats = db.Stats()
+	require.Zero(t, stats.OldestReadTxID)
+	require.Zero(t, stats.OldestReadTxAge)
+}
+
+// Ensure that the watchdog reports a read transaction open for too long once.
+func TestDB_MaxReadTxDuration(t *testing.T) {
+	reported := make(chan int, 10)
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{
+		MaxReadTxDuration: 50 * time.Millisecond,
+		OnLongReadTx: func(txid int, age time.Duration) {
+			require.Greater(t, age, 50*time.Millisecond)
+			reported <- txid
+		},
+	})
+
+	rtx, err := db.Begin(false)
+	require.NoError(t, err)
+	select {
+	case txid := <-reported:
+		require.Equal(t, rtx.ID(), txid)
+	case <-time.After(5 * time.Second):
+		t.Fatal("long read transaction isn't reported")
+	}
+
+	// The transaction is still open, but it's only reported once.
+	time.Sleep(100 * time.Millisecond)
+	require.Empty(t, reported)
+	require.Equal(t, 1, db.Stats().OpenTxN)
+	require.Equal(t, 1, db.Stats().LongReadTxN)
+	require.NoError(t, rtx.Rollback())
+}
@@ -0,0 +1,22 @@
+package boltdb
+
+// maxWriteIovecs is the largest number of buffers written by a single
+// vectored write, IOV_MAX on Linux.
+const maxWriteIovecs = 1024
+
+// writeBuffers writes bufs to the data file at offset off, back to back, with
+// a single vectored write if the data file supports it, and returns the
+// number of system calls.
+func (db *DB) writeBuffers(bufs [][]byte, off int64) (int, error) {
+	if db.ops.writev != nil && len(bufs) > 1 {
+		_, err := db.ops.writev(bufs, off)
+		return 1, err
+	}
+	for _, b := range bufs {
+		if _, err := db.ops.writeAt(b, off); err != nil {
+			return 0, err
+		}
+		off += int64(len(b))
+	}
+	return len(bufs), nil
+}
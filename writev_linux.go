@@ -0,0 +1,35 @@
+package boltdb
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// pwritev returns the function which writes buffers to the data file with
+// pwritev(2).
+func pwritev(db *DB) func(bufs [][]byte, off int64) (int, error) {
+	return func(bufs [][]byte, off int64) (int, error) {
+		var written int
+		for len(bufs) > 0 {
+			n, err := unix.Pwritev(int(db.file.Fd()), bufs, off)
+			if err == syscall.EINTR {
+				continue
+			} else if err != nil {
+				return written, err
+			}
+			written += n
+			off += int64(n)
+
+			// Skip the buffers written by a short write.
+			for len(bufs) > 0 && n >= len(bufs[0]) {
+				n -= len(bufs[0])
+				bufs = bufs[1:]
+			}
+			if n > 0 {
+				bufs = append([][]byte{bufs[0][n:]}, bufs[1:]...)
+			}
+		}
+		return written, nil
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package boltdb
+
+// pwritev returns nil, so that each page run is written on its own. Vectored
+// writes are only used on Linux, e.g. WriteFileGather on Windows requires
+// the file to be opened without buffering and buffers of one system page.
+func pwritev(db *DB) func(bufs [][]byte, off int64) (int, error) {
+	return nil
+}